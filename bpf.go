@@ -5,15 +5,23 @@ package exectrace
 
 import (
 	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
 	"runtime"
 	"sync"
 
 	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+	"github.com/cilium/ebpf/features"
 	"github.com/cilium/ebpf/rlimit"
 	"github.com/hashicorp/go-multierror"
 	"golang.org/x/xerrors"
 )
 
+const kernelBTFPath = "/sys/kernel/btf/vmlinux"
+
 var (
 	errObjectsClosed  = xerrors.New("objects are closed")
 	removeMemlockOnce sync.Once
@@ -28,9 +36,70 @@ var (
 	}
 )
 
+// haveRingbuf returns false if the kernel doesn't support
+// BPF_MAP_TYPE_RINGBUF, which was added in 5.8. Other probe errors are ignored
+// so loading the ringbuf program fails with a more useful error instead.
+func haveRingbuf() bool {
+	err := features.HaveMapType(ebpf.RingBuf)
+	return !xerrors.Is(err, ebpf.ErrNotSupported)
+}
+
+// loadKernelBTF returns the kernel BTF to use for CO-RE relocations. If path
+// is empty and the kernel has no BTF of its own, DefaultKernelBTFDir is
+// searched. A nil spec means the kernel's own BTF should be used.
+func loadKernelBTF(path string) (*btf.Spec, error) {
+	if path == "" {
+		_, err := os.Stat(kernelBTFPath)
+		if err == nil {
+			return nil, nil
+		}
+
+		release, err := kernelRelease()
+		if err != nil {
+			return nil, err
+		}
+		candidate := filepath.Join(DefaultKernelBTFDir, release+".btf")
+		_, err = os.Stat(candidate)
+		if err != nil {
+			// Let the library search the usual vmlinux locations.
+			_, err = btf.LoadKernelSpec()
+			if err != nil {
+				return nil, withKind(ErrBTFUnavailable, fmt.Sprintf("no BTF found for kernel %s, set KernelBTFPath or add %s", release, candidate), err)
+			}
+			return nil, nil
+		}
+		path = candidate
+	}
+
+	spec, err := btf.LoadSpec(path)
+	if err != nil {
+		return nil, withKind(ErrBTFUnavailable, fmt.Sprintf("load kernel BTF from %q", path), err)
+	}
+	return spec, nil
+}
+
+// bpfLoadOpts configures loadBPFObjects.
+type bpfLoadOpts struct {
+	// Perf loads the variant of the program that outputs through perf event
+	// arrays instead of ringbufs.
+	Perf bool
+	// KernelBTFPath is passed to loadKernelBTF.
+	KernelBTFPath string
+	// PinPath pins the program and maps there, or reuses the ones already
+	// pinned there. The program isn't pinned when Maps is set.
+	PinPath string
+	// Generation is the generation of the program. Only the program matching
+	// the active generation in the filters map emits events.
+	Generation uint32
+	// Maps contains the events, logs, filters, tracked PIDs and exec
+	// failures maps to use instead of creating new ones, when reloading the
+	// program.
+	Maps *bpfObjects
+}
+
 // loadBPFObjects reads and parses the programs and maps out of the embedded
 // BPF program.
-func loadBPFObjects() (*bpfObjects, error) {
+func loadBPFObjects(lopts bpfLoadOpts) (*bpfObjects, error) {
 	// Allow the current process to lock memory for eBPF resources. This does
 	// nothing on 5.11+ kernels which don't need this.
 	var err error
@@ -38,38 +107,129 @@ func loadBPFObjects() (*bpfObjects, error) {
 		err = rlimit.RemoveMemlock()
 	})
 	if err != nil {
+		if xerrors.Is(err, os.ErrPermission) {
+			return nil, withKind(ErrPermission, "remove kernel memlock", err)
+		}
 		return nil, xerrors.Errorf("remove kernel memlock: %w", err)
 	}
 
-	r := bytes.NewReader(bpfProgram)
+	program := bpfProgram
+	if lopts.Perf {
+		program = bpfProgramPerf
+	}
+	r := bytes.NewReader(program)
 	spec, err := ebpf.LoadCollectionSpecFromReader(r)
 	if err != nil {
 		return nil, xerrors.Errorf("load collection from reader: %w", err)
 	}
 
+	err = spec.RewriteConstants(map[string]interface{}{
+		"generation": lopts.Generation,
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("set program generation: %w", err)
+	}
+
+	if lopts.Perf {
+		// The scratch maps have an entry for each CPU.
+		cpus, err := ebpf.PossibleCPU()
+		if err != nil {
+			return nil, xerrors.Errorf("get possible CPUs: %w", err)
+		}
+		for _, name := range []string{"event_scratch", "log_scratch"} {
+			m, ok := spec.Maps[name]
+			if !ok {
+				return nil, xerrors.Errorf("missing map %q in perf event array program", name)
+			}
+			m.MaxEntries = uint32(cpus)
+		}
+	}
+
+	kernelTypes, err := loadKernelBTF(lopts.KernelBTFPath)
+	if err != nil {
+		return nil, err
+	}
+	opts := *collectionOpts
+	opts.Programs.KernelTypes = kernelTypes
+	if lopts.PinPath != "" {
+		err = pinMaps(spec, &opts, lopts.PinPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if lopts.Maps != nil {
+		opts.MapReplacements = map[string]*ebpf.Map{
+			"events":        lopts.Maps.EventsMap,
+			"logs":          lopts.Maps.LogsMap,
+			"filters":       lopts.Maps.FiltersMap,
+			"tracked_pids":  lopts.Maps.TrackedPidsMap,
+			"exec_failures": lopts.Maps.ExecFailuresMap,
+		}
+	}
+
 	objs := &bpfObjects{
 		closeLock: sync.Mutex{},
 		closed:    make(chan struct{}),
 	}
-	err = spec.LoadAndAssign(objs, collectionOpts)
+	err = spec.LoadAndAssign(objs, &opts)
 	if err != nil {
-		var ve *ebpf.VerifierError
-		if xerrors.As(err, &ve) {
-			// It's better to use %+v for this as it forces the error to contain
-			// all lines from the verifier log.
-			return nil, xerrors.Errorf("load and assign specs: verifier error: %+v", ve)
+		return nil, xerrors.Errorf("load and assign specs: %w", classifyLoadError(err))
+	}
+	if lopts.PinPath != "" && lopts.Maps == nil {
+		err = objs.pinProgram(lopts.PinPath)
+		if err != nil {
+			_ = objs.Close()
+			return nil, err
 		}
-		return nil, xerrors.Errorf("load and assign specs: %w", err)
 	}
 
 	return objs, nil
 }
 
+// loadErrorProgramRegex matches the name of the program that failed to load
+// in errors from ebpf.CollectionSpec.LoadAndAssign.
+var loadErrorProgramRegex = regexp.MustCompile(`program (\w+):`)
+
+// classifyLoadError wraps an error from loading the eBPF objects into the
+// kernel with the matching exported error, if any.
+func classifyLoadError(err error) error {
+	// Permission errors also come with a verifier log, so check them first.
+	if xerrors.Is(err, os.ErrPermission) {
+		return withKind(ErrPermission, "load eBPF objects, run as root or with CAP_BPF and CAP_PERFMON", err)
+	}
+	var ve *ebpf.VerifierError
+	if xerrors.As(err, &ve) {
+		program := "enter_execve"
+		if m := loadErrorProgramRegex.FindStringSubmatch(err.Error()); m != nil {
+			program = m[1]
+		}
+		return &VerifierError{
+			Program:   program,
+			Log:       ve.Log,
+			Truncated: ve.Truncated,
+			err:       ve,
+		}
+	}
+	if xerrors.Is(err, ebpf.ErrNotSupported) {
+		return withKind(ErrUnsupportedKernel, "load eBPF objects", err)
+	}
+	return err
+}
+
 type bpfObjects struct {
-	EnterExecveProg *ebpf.Program `ebpf:"enter_execve"`
-	EventsMap       *ebpf.Map     `ebpf:"events"`
-	LogsMap         *ebpf.Map     `ebpf:"logs"`
-	FiltersMap      *ebpf.Map     `ebpf:"filters"`
+	EnterExecveProg      *ebpf.Program `ebpf:"enter_execve"`
+	ExitExecveProg       *ebpf.Program `ebpf:"exit_execve"`
+	SchedProcessForkProg *ebpf.Program `ebpf:"sched_process_fork"`
+	SchedProcessExitProg *ebpf.Program `ebpf:"sched_process_exit"`
+	EventsMap            *ebpf.Map     `ebpf:"events"`
+	LogsMap              *ebpf.Map     `ebpf:"logs"`
+	FiltersMap           *ebpf.Map     `ebpf:"filters"`
+	TrackedPidsMap       *ebpf.Map     `ebpf:"tracked_pids"`
+	ExecFailuresMap      *ebpf.Map     `ebpf:"exec_failures"`
+
+	// pinnedProgram is true if EnterExecveProg was loaded from a pin instead
+	// of the embedded program, so its generation is unknown.
+	pinnedProgram bool
 
 	closeLock sync.Mutex
 	closed    chan struct{}
@@ -93,6 +253,24 @@ func (o *bpfObjects) Close() error {
 			merr = multierror.Append(merr, xerrors.Errorf(`close BPF program "enter_execve": %w`, err))
 		}
 	}
+	if o.ExitExecveProg != nil {
+		err := o.ExitExecveProg.Close()
+		if err != nil {
+			merr = multierror.Append(merr, xerrors.Errorf(`close BPF program "exit_execve": %w`, err))
+		}
+	}
+	if o.SchedProcessForkProg != nil {
+		err := o.SchedProcessForkProg.Close()
+		if err != nil {
+			merr = multierror.Append(merr, xerrors.Errorf(`close BPF program "sched_process_fork": %w`, err))
+		}
+	}
+	if o.SchedProcessExitProg != nil {
+		err := o.SchedProcessExitProg.Close()
+		if err != nil {
+			merr = multierror.Append(merr, xerrors.Errorf(`close BPF program "sched_process_exit": %w`, err))
+		}
+	}
 	if o.EventsMap != nil {
 		err := o.EventsMap.Close()
 		if err != nil {
@@ -111,6 +289,18 @@ func (o *bpfObjects) Close() error {
 			merr = multierror.Append(merr, xerrors.Errorf(`close BPF map "filters": %w`, err))
 		}
 	}
+	if o.TrackedPidsMap != nil {
+		err := o.TrackedPidsMap.Close()
+		if err != nil {
+			merr = multierror.Append(merr, xerrors.Errorf(`close BPF map "tracked_pids": %w`, err))
+		}
+	}
+	if o.ExecFailuresMap != nil {
+		err := o.ExecFailuresMap.Close()
+		if err != nil {
+			merr = multierror.Append(merr, xerrors.Errorf(`close BPF map "exec_failures": %w`, err))
+		}
+	}
 
 	return merr
 }
@@ -11,11 +11,36 @@ u8 __license[] SEC("license") = "Dual MIT/GPL"; // NOLINT
 // world.
 //#define DEBUG
 
+// EXECTRACE_PERF_EVENT_ARRAY is defined when building the variant of this
+// program for kernels without BPF_MAP_TYPE_RINGBUF (older than 5.8). Events and
+// logs are built in scratch maps and sent to userspace with
+// bpf_perf_event_output() instead, and only helpers available in 5.4 are used.
+//#define EXECTRACE_PERF_EVENT_ARRAY
+
+#ifdef EXECTRACE_PERF_EVENT_ARRAY
+// The bpf_probe_read_{kernel,user}* helpers were added in 5.5, so use the
+// generic versions, including for CO-RE reads.
+#define probe_read_kernel     bpf_probe_read
+#define probe_read_kernel_str bpf_probe_read_str
+#define probe_read_user       bpf_probe_read
+#define probe_read_user_str   bpf_probe_read_str
+#undef bpf_core_read
+#define bpf_core_read(dst, sz, src) \
+	bpf_probe_read(dst, sz, (const void *)__builtin_preserve_access_index(src))
+#else
+#define probe_read_kernel     bpf_probe_read_kernel
+#define probe_read_kernel_str bpf_probe_read_kernel_str
+#define probe_read_user       bpf_probe_read_user
+#define probe_read_user_str   bpf_probe_read_user_str
+#endif
+
 // These constants must be kept in sync with Go.
 #define ARGLEN    32    // maximum amount of args in argv we'll copy
 #define ARGSIZE   1024  // maximum byte length of each arg in argv we'll copy
-#define LOGFMTSIZE 1024 // maximum length of log fmt str sent back to userspace
-#define LOGARGLEN 3     // maximum amount of fmt arguments to a log entry
+#define LOGARGLEN 3     // maximum amount of arguments to a log entry
+
+// Maximum number of processes tracked by the PID filter at once.
+#define MAX_TRACKED_PIDS 65536
 
 // Maximum levels of PID namespace nesting. PID namespaces have a hierarchy
 // limit of 32 since kernel 3.7.
@@ -36,6 +61,19 @@ struct exec_info {
 	const u8 *const *envp;      // offset=32, size=8 (ptr)
 };
 
+// This struct is defined according to
+// /sys/kernel/debug/tracing/events/syscalls/sys_exit_execve/format
+struct exec_exit_info {
+	u16 common_type;            // offset=0,  size=2
+	u8  common_flags;           // offset=2,  size=1
+	u8  common_preempt_count;   // offset=3,  size=1
+	s32 common_pid;             // offset=4,  size=4
+
+	s32 syscall_nr;             // offset=8,  size=4
+	u32 pad;                    // offset=12, size=4 (pad)
+	s64 ret;                    // offset=16, size=8
+};
+
 // The event struct. This struct must be kept in sync with the Golang
 // counterpart.
 struct event_t {
@@ -46,6 +84,8 @@ struct event_t {
 	u32 uid;
 	u32 gid;
 	u32 pid;
+	u32 ppid; // TGID of the parent of the calling process
+	u32 pidns; // inum of the PID namespace of the calling process
 
 	// Name of the calling process.
 	u8  comm[ARGSIZE];
@@ -58,29 +98,109 @@ static struct event_t zero_event SEC(".rodata") = {
 	.uid = 0,
 	.gid = 0,
 	.pid = 0,
+	.ppid = 0,
+	.pidns = 0,
 	.comm = {0},
 };
 
+// Log levels. These must be kept in sync with LogLevel in Go.
+enum log_level {
+	LOG_LEVEL_DEBUG = 0,
+	LOG_LEVEL_INFO  = 1,
+	LOG_LEVEL_WARN  = 2,
+	LOG_LEVEL_ERROR = 3,
+};
+
+// Log message codes. Codes are stable and must be kept in sync with LogCode in
+// Go, which also defines the message and the names and types of the arguments
+// of each code. Never reuse or renumber a code.
+enum log_code {
+	LOG_READ_PIDNS        = 1,
+	LOG_READ_PARENT_PIDNS = 2,
+	LOG_PIDNS_END         = 3,
+	LOG_READ_PIDNS_INUM   = 4,
+	LOG_PIDNS_ITERATION   = 5,
+	LOG_PIDNS_NO_MATCH    = 6,
+	LOG_READ_FORK_PIDS    = 7,
+	LOG_TRACK_CHILD       = 8,
+	LOG_READ_EXIT_PIDS    = 9,
+	LOG_RESERVE_EVENT     = 10,
+	LOG_ZERO_EVENT        = 11,
+	LOG_READ_PPID         = 12,
+	LOG_GET_COMM          = 13,
+	LOG_READ_FILENAME     = 14,
+	LOG_READ_ARGV         = 15,
+	LOG_READ_EVENT_PIDNS  = 16,
+};
+
+// The programs that log entries. These must be kept in sync with logPrograms
+// in Go.
+enum log_prog {
+	LOG_PROG_ENTER_EXECVE       = 1,
+	LOG_PROG_EXIT_EXECVE        = 2,
+	LOG_PROG_SCHED_PROCESS_FORK = 3,
+	LOG_PROG_SCHED_PROCESS_EXIT = 4,
+};
+
 // Log entry from eBPF to userspace. This struct must be kept in sync with the
 // Golang counterpart.
 struct log_entry_t {
+	// Time of the entry from bpf_ktime_get_ns().
+	u64 ktime;
 	u32 uid;
 	u32 gid;
 	u32 pid;
-	// fmt contains a format string that only contains "%d" and "%u" directives.
-	// In userspace we will replace these with the arguments in `args`.
-	u8  fmt[LOGFMTSIZE];
+	u32 level; // enum log_level
+	u32 code;  // enum log_code
+	u32 prog;  // enum log_prog
+	// The number of arguments that were set.
+	u32 argc;
 	// These are communicated back to userspace as unsigned 32-bit integers, but
-	// depending on the format string, they could be treated as signed or
-	// unsigned.
+	// depending on the code, they could be treated as signed or unsigned.
 	u32 args[LOGARGLEN];
 };
 
 static struct log_entry_t zero_log SEC(".rodata") = {
-	.fmt = {0},
+	.ktime = 0,
 	.args = {},
 };
 
+#ifdef EXECTRACE_PERF_EVENT_ARRAY
+// This is the perf event array we'll output events data to. The Go program
+// reads from the per-CPU perf buffers and reads the data into a Go struct for
+// easy usage. max_entries is set to the number of CPUs when loading.
+struct {
+	__uint(type, BPF_MAP_TYPE_PERF_EVENT_ARRAY);
+	__uint(key_size, sizeof(u32));
+	__uint(value_size, sizeof(u32));
+} events SEC(".maps");
+
+// The perf event array we will output log entries to.
+struct {
+	__uint(type, BPF_MAP_TYPE_PERF_EVENT_ARRAY);
+	__uint(key_size, sizeof(u32));
+	__uint(value_size, sizeof(u32));
+} logs SEC(".maps");
+
+// Scratch space to build events and log entries in before they're copied to
+// the perf buffers, as they're too large for the stack. Each CPU uses the
+// entry at its CPU index. These aren't per-CPU arrays because event_t is larger
+// than the per-CPU value size limit. max_entries is set to the number of CPUs
+// by userspace when loading.
+struct {
+	__uint(type, BPF_MAP_TYPE_ARRAY);
+	__uint(key_size, sizeof(u32));
+	__uint(value_size, sizeof(struct event_t));
+	__uint(max_entries, 1);
+} event_scratch SEC(".maps");
+
+struct {
+	__uint(type, BPF_MAP_TYPE_ARRAY);
+	__uint(key_size, sizeof(u32));
+	__uint(value_size, sizeof(struct log_entry_t));
+	__uint(max_entries, 1);
+} log_scratch SEC(".maps");
+#else
 // This is the ring buffer we'll output events data to. The Go program reads
 // from this ring buffer and reads the data into a Go struct for easy usage.
 struct {
@@ -93,32 +213,125 @@ struct {
 	__uint(type, BPF_MAP_TYPE_RINGBUF);
 	__uint(max_entries, 1 << 24);
 } logs SEC(".maps");
+#endif
 
 // The map we'll use to retrieve the configuration about the given filters.
 struct {
 	__uint(type, BPF_MAP_TYPE_ARRAY);
 	__uint(key_size, sizeof(u32));
 	__uint(value_size, sizeof(u32));
-	__uint(max_entries, 1);
+	__uint(max_entries, 3);
 } filters SEC(".maps");
 
 // Indexes in the `filters` map for each configuration option.
 static u32 filter_pidns_idx SEC(".rodata") = 0;
+static u32 filter_generation_idx SEC(".rodata") = 1;
+static u32 filter_tracked_idx SEC(".rodata") = 2;
+
+// The processes traced when the PID filter is enabled, keyed by TGID.
+// Userspace adds the target process and its existing descendants, and the
+// programs below add new children on fork and remove processes on exit.
+struct {
+	__uint(type, BPF_MAP_TYPE_HASH);
+	__uint(key_size, sizeof(u32));
+	__uint(value_size, sizeof(u8));
+	__uint(max_entries, MAX_TRACKED_PIDS);
+} tracked_pids SEC(".maps");
+
+// The number of execve() calls that failed, e.g. because the file doesn't
+// exist, counted per CPU. Only calls that pass the filters above are counted.
+struct {
+	__uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+	__uint(key_size, sizeof(u32));
+	__uint(value_size, sizeof(u64));
+	__uint(max_entries, 1);
+} exec_failures SEC(".maps");
+
+// The generation of this program, set by userspace when loading. When the
+// program is reloaded, the new program is attached alongside the old one and
+// only the program matching the active generation in the `filters` map emits
+// events, which avoids a blind window. Execs during the switch may be dropped
+// or duplicated as each program checks the generation separately.
+volatile const u32 generation = 0;
+
+// reserve_event returns memory to build an event in, or NULL on failure. The
+// event must be passed to submit_event or discard_event afterwards.
+static struct event_t *reserve_event(void) {
+#ifdef EXECTRACE_PERF_EVENT_ARRAY
+	u32 cpu = bpf_get_smp_processor_id();
+	return bpf_map_lookup_elem(&event_scratch, &cpu);
+#else
+	return bpf_ringbuf_reserve(&events, sizeof(struct event_t), 0);
+#endif
+}
+
+// submit_event sends the event to userspace.
+static void submit_event(void *ctx, struct event_t *event) {
+#ifdef EXECTRACE_PERF_EVENT_ARRAY
+	bpf_perf_event_output(ctx, &events, BPF_F_CURRENT_CPU, event, sizeof(struct event_t));
+#else
+	(void)ctx;
+	bpf_ringbuf_submit(event, 0);
+#endif
+}
+
+// discard_event releases the event without sending it to userspace.
+static void discard_event(struct event_t *event) {
+#ifdef EXECTRACE_PERF_EVENT_ARRAY
+	(void)event;
+#else
+	bpf_ringbuf_discard(event, 0);
+#endif
+}
+
+// reserve_log, submit_log and discard_log are the log_entry_t equivalents of
+// the functions above.
+static struct log_entry_t *reserve_log(void) {
+#ifdef EXECTRACE_PERF_EVENT_ARRAY
+	u32 cpu = bpf_get_smp_processor_id();
+	return bpf_map_lookup_elem(&log_scratch, &cpu);
+#else
+	return bpf_ringbuf_reserve(&logs, sizeof(struct log_entry_t), 0);
+#endif
+}
+
+static void submit_log(void *ctx, struct log_entry_t *entry) {
+#ifdef EXECTRACE_PERF_EVENT_ARRAY
+	bpf_perf_event_output(ctx, &logs, BPF_F_CURRENT_CPU, entry, sizeof(struct log_entry_t));
+#else
+	(void)ctx;
+	bpf_ringbuf_submit(entry, 0);
+#endif
+}
+
+static void discard_log(struct log_entry_t *entry) {
+#ifdef EXECTRACE_PERF_EVENT_ARRAY
+	(void)entry;
+#else
+	bpf_ringbuf_discard(entry, 0);
+#endif
+}
 
 // LOG[N] calls log() with the unused parameters zeroed out. `N` is the amount
-// of fmt args you want to use.
-#define LOG0(fmt) LOG3(fmt, 0, 0, 0)
-#define LOG1(fmt, arg0) LOG3(fmt, arg0, 0, 0)
-#define LOG2(fmt, arg0, arg1) LOG3(fmt, arg0, arg1, 0)
-#define LOG3(fmt, arg0, arg1, arg2) log(fmt, sizeof(fmt), arg0, arg1, arg2)
-
-// log logs to bpf_trace_printk() and sends the formatted log string to the logs
-// ringbuf. Call LOG[N]() instead of calling this directly.
-static void log(const char *fmt, u32 fmt_size, u32 arg0, u32 arg1, u32 arg2) {
+// of args you want to use. `level` is a log_level and `code` is a log_code.
+// The fmt string is only used for bpf_trace_printk(), userspace formats
+// messages by code. The program context and log_prog must be available as
+// `ctx` and `prog`.
+#define LOG0(level, code, fmt) LOGN(level, code, fmt, 0, 0, 0, 0)
+#define LOG1(level, code, fmt, arg0) LOGN(level, code, fmt, 1, arg0, 0, 0)
+#define LOG2(level, code, fmt, arg0, arg1) LOGN(level, code, fmt, 2, arg0, arg1, 0)
+#define LOG3(level, code, fmt, arg0, arg1, arg2) LOGN(level, code, fmt, 3, arg0, arg1, arg2)
+#define LOGN(level, code, fmt, argc, arg0, arg1, arg2) \
+	log(ctx, prog, level, code, fmt, sizeof(fmt), argc, arg0, arg1, arg2)
+
+// log logs to bpf_trace_printk() and sends a log entry to the logs ringbuf.
+// Call LOG[N]() instead of calling this directly. It is always inlined as BPF
+// function calls are limited to 5 arguments.
+static __always_inline void log(void *ctx, u32 prog, u32 level, u32 code, const char *fmt, u32 fmt_size, u32 argc, u32 arg0, u32 arg1, u32 arg2) {
 	bpf_trace_printk(fmt, fmt_size, arg0, arg1, arg2);
 
 	struct log_entry_t *entry;
-	entry = bpf_ringbuf_reserve(&logs, sizeof(struct log_entry_t), 0);
+	entry = reserve_log();
 	if (!entry) {
 		bpf_printk("could not reserve logs ringbuf memory");
 		return;
@@ -126,43 +339,38 @@ static void log(const char *fmt, u32 fmt_size, u32 arg0, u32 arg1, u32 arg2) {
 
 	// Zero out the log entry for safety. If we don't do this, we risk sending
 	// random kernel memory back to userspace.
-	s32 ret = bpf_probe_read_kernel(entry, sizeof(struct log_entry_t), &zero_log);
+	s32 ret = probe_read_kernel(entry, sizeof(struct log_entry_t), &zero_log);
 	if (ret < 0) {
 		bpf_printk("zero out log: %d", ret);
-		bpf_ringbuf_discard(entry, 0);
-		return;
-	}
-
-	// Copy the fmt string into the log entry.
-	// NOTE: bpf_snprintf is not supported in some of the lower kernel versions
-	// we claim to support, so we have to do it this way.
-	ret = bpf_probe_read_kernel_str(&entry->fmt, sizeof(entry->fmt), fmt);
-	if (ret < 0) {
-		bpf_printk("could not read fmt into log struct: %d", ret);
-		bpf_ringbuf_discard(entry, 0);
+		discard_log(entry);
 		return;
 	}
 
+	entry->ktime = bpf_ktime_get_ns();
 	entry->uid = bpf_get_current_uid_gid();
 	entry->gid = bpf_get_current_uid_gid() >> 32; // NOLINT(readability-magic-numbers)
 	entry->pid = bpf_get_current_pid_tgid();
+	entry->level = level;
+	entry->code = code;
+	entry->prog = prog;
+	entry->argc = argc;
 	entry->args[0] = arg0;
 	entry->args[1] = arg1;
 	entry->args[2] = arg2;
 
-	bpf_ringbuf_submit(entry, 0);
+	submit_log(ctx, entry);
 }
 
 // filter_pidns checks if the current task is in a PID namespace equal to or
 // under the given target_pidns. Returns a 0 if successful, or a negative error
 // on failure.
-s32 filter_pidns(u32 target_pidns) {
+static s32 filter_pidns(void *ctx, u32 prog, u32 target_pidns) {
 	struct task_struct___exectrace *task = (void *)bpf_get_current_task(); // NOLINT(performance-no-int-to-ptr)
 
 	struct pid_namespace___exectrace *pidns;
 	s32 ret = BPF_CORE_READ_INTO(&pidns, task, nsproxy, pid_ns_for_children);
 	if (ret) {
-		LOG1("could not read current task pidns: %d", ret);
+		LOG1(LOG_LEVEL_ERROR, LOG_READ_PIDNS, "could not read current task pidns: %d", ret);
 		return ret;
 	}
 
@@ -174,25 +382,25 @@ s32 filter_pidns(u32 target_pidns) {
 		if (i != 0) {
 			ret = BPF_CORE_READ_INTO(&pidns, pidns, parent);
 			if (ret) {
-				LOG2("could not read parent pidns on iteration %u: %d", i, ret);
+				LOG2(LOG_LEVEL_ERROR, LOG_READ_PARENT_PIDNS, "could not read parent pidns on iteration %u: %d", i, ret);
 				return ret;
 			}
 		}
 		if (!pidns) {
 			#ifdef DEBUG
-			LOG1("no more pidns after %u iterations", i);
+			LOG1(LOG_LEVEL_DEBUG, LOG_PIDNS_END, "no more pidns after %u iterations", i);
 			#endif
 			return -1;
 		}
 
 		ret = BPF_CORE_READ_INTO(&inum, pidns, ns.inum);
 		if (ret) {
-			LOG2("could not read pidns common on iteration %u: %d", i, ret);
+			LOG2(LOG_LEVEL_ERROR, LOG_READ_PIDNS_INUM, "could not read pidns common on iteration %u: %d", i, ret);
 			return ret;
 		}
 
 		#ifdef DEBUG
-		LOG3("got pidns on iteration %u: %u (target=%u)", i, inum, target_pidns);
+		LOG3(LOG_LEVEL_DEBUG, LOG_PIDNS_ITERATION, "got pidns on iteration %u: %u (target=%u)", i, inum, target_pidns);
 		#endif
 
 		if (inum == target_pidns) {
@@ -204,33 +412,144 @@ s32 filter_pidns(u32 target_pidns) {
 	// Iterated through all 32 parent PID namespaces and couldn't find what we
 	// were looking for.
 	#ifdef DEBUG
-	LOG1("does not match pidns filter after %u iterations", i);
+	LOG1(LOG_LEVEL_DEBUG, LOG_PIDNS_NO_MATCH, "does not match pidns filter after %u iterations", i);
 	#endif
 	return -1;
 }
 
+// tracking_enabled returns true if the PID filter is enabled.
+static bool tracking_enabled(void) {
+	u32 *tracked = bpf_map_lookup_elem(&filters, &filter_tracked_idx);
+	return tracked && *tracked;
+}
+
+// Raw tracepoint after a new task is created. Raw tracepoints are used for
+// sched events as the layout of the regular tracepoints varies between kernel
+// versions. The arguments are the parent and child task_structs.
+SEC("raw_tracepoint/sched_process_fork")
+s32 sched_process_fork(struct bpf_raw_tracepoint_args *ctx) {
+	const u32 prog = LOG_PROG_SCHED_PROCESS_FORK;
+	if (!tracking_enabled()) {
+		return 0;
+	}
+
+	struct task_struct___exectrace *parent = (void *)ctx->args[0]; // NOLINT(performance-no-int-to-ptr)
+	struct task_struct___exectrace *child = (void *)ctx->args[1]; // NOLINT(performance-no-int-to-ptr)
+	s32 parent_tgid = 0;
+	s32 child_pid = 0;
+	s32 child_tgid = 0;
+	if (BPF_CORE_READ_INTO(&parent_tgid, parent, tgid) ||
+	    BPF_CORE_READ_INTO(&child_pid, child, pid) ||
+	    BPF_CORE_READ_INTO(&child_tgid, child, tgid)) {
+		LOG0(LOG_LEVEL_ERROR, LOG_READ_FORK_PIDS, "could not read forked task pids");
+		return 1;
+	}
+	if (child_pid != child_tgid) {
+		// New thread, not a new process.
+		return 0;
+	}
+
+	u32 key = parent_tgid;
+	if (!bpf_map_lookup_elem(&tracked_pids, &key)) {
+		return 0;
+	}
+	key = child_tgid;
+	u8 value = 1;
+	s32 ret = bpf_map_update_elem(&tracked_pids, &key, &value, BPF_ANY);
+	if (ret) {
+		LOG2(LOG_LEVEL_ERROR, LOG_TRACK_CHILD, "could not track child %u: %d", key, ret);
+		return 1;
+	}
+	return 0;
+}
+
+// Raw tracepoint when a task exits. The first argument is the task_struct.
+SEC("raw_tracepoint/sched_process_exit")
+s32 sched_process_exit(struct bpf_raw_tracepoint_args *ctx) {
+	const u32 prog = LOG_PROG_SCHED_PROCESS_EXIT;
+	if (!tracking_enabled()) {
+		return 0;
+	}
+
+	struct task_struct___exectrace *task = (void *)ctx->args[0]; // NOLINT(performance-no-int-to-ptr)
+	s32 pid = 0;
+	s32 tgid = 0;
+	if (BPF_CORE_READ_INTO(&pid, task, pid) || BPF_CORE_READ_INTO(&tgid, task, tgid)) {
+		LOG0(LOG_LEVEL_ERROR, LOG_READ_EXIT_PIDS, "could not read exiting task pids");
+		return 1;
+	}
+	if (pid != tgid) {
+		// Exiting thread, the process is still running.
+		return 0;
+	}
+
+	u32 key = tgid;
+	bpf_map_delete_elem(&tracked_pids, &key);
+	return 0;
+}
+
+// filter_current returns true if the current task passes the PID namespace and
+// PID filters. prog is the log_prog of the calling program.
+static bool filter_current(void *ctx, u32 prog) {
+	u32 *target_pidns = bpf_map_lookup_elem(&filters, &filter_pidns_idx);
+	if (target_pidns && *target_pidns && filter_pidns(ctx, prog, *target_pidns)) {
+		return false;
+	}
+
+	if (tracking_enabled()) {
+		u32 tgid = bpf_get_current_pid_tgid() >> 32; // NOLINT(readability-magic-numbers)
+		if (!bpf_map_lookup_elem(&tracked_pids, &tgid)) {
+			return false;
+		}
+	}
+	return true;
+}
+
+// Tracepoint at the end of execve() syscall. Events are sent when the syscall
+// starts, so failed calls are only counted. This program is attached once and
+// kept when reloading, so it doesn't check the generation.
+SEC("tracepoint/syscalls/sys_exit_execve")
+s32 exit_execve(struct exec_exit_info *ctx) {
+	if (ctx->ret >= 0 || !filter_current(ctx, LOG_PROG_EXIT_EXECVE)) {
+		return 0;
+	}
+
+	u32 key = 0;
+	u64 *failures = bpf_map_lookup_elem(&exec_failures, &key);
+	if (failures) {
+		(*failures)++;
+	}
+	return 0;
+}
+
 // Tracepoint at the top of execve() syscall.
 SEC("tracepoint/syscalls/sys_enter_execve")
 s32 enter_execve(struct exec_info *ctx) {
-	u32 *target_pidns = bpf_map_lookup_elem(&filters, &filter_pidns_idx);
-	if (target_pidns && *target_pidns && filter_pidns(*target_pidns)) {
-		return 1;
+	const u32 prog = LOG_PROG_ENTER_EXECVE;
+	u32 *active_generation = bpf_map_lookup_elem(&filters, &filter_generation_idx);
+	if (active_generation && *active_generation != generation) {
+		return 0;
+	}
+	if (!filter_current(ctx, prog)) {
+		return 0;
 	}
 
-	// Reserve memory for our event on the `events` ring buffer defined above.
+	// Reserve memory for our event on the `events` ring buffer defined above
+	// (or in the scratch map for the perf event array variant).
 	struct event_t *event;
-	event = bpf_ringbuf_reserve(&events, sizeof(struct event_t), 0);
+	event = reserve_event();
 	if (!event) {
-		LOG0("could not reserve events ringbuf memory");
+		LOG0(LOG_LEVEL_ERROR, LOG_RESERVE_EVENT, "could not reserve events ringbuf memory");
 		return 1;
 	}
 
 	// Zero out the event for safety. If we don't do this, we risk sending
-	// random kernel memory back to userspace.
-	s32 ret = bpf_probe_read_kernel(event, sizeof(event), &zero_event);
+	// random kernel memory (or a previous event from the scratch map) back to
+	// userspace.
+	s32 ret = probe_read_kernel(event, sizeof(struct event_t), &zero_event);
 	if (ret) {
-		LOG1("zero out event: %d", ret);
-		bpf_ringbuf_discard(event, 0);
+		LOG1(LOG_LEVEL_ERROR, LOG_ZERO_EVENT, "zero out event: %d", ret);
+		discard_event(event);
 		return 1;
 	}
 
@@ -238,19 +557,38 @@ s32 enter_execve(struct exec_info *ctx) {
 	event->uid = bpf_get_current_uid_gid();
 	event->gid = bpf_get_current_uid_gid() >> 32; // NOLINT(readability-magic-numbers)
 	event->pid = bpf_get_current_pid_tgid();
+
+	// The parent PID is best effort, so it's left as 0 if it can't be read.
+	struct task_struct___exectrace *task = (void *)bpf_get_current_task(); // NOLINT(performance-no-int-to-ptr)
+	s32 ppid = 0;
+	ret = BPF_CORE_READ_INTO(&ppid, task, real_parent, tgid);
+	if (ret) {
+		LOG1(LOG_LEVEL_WARN, LOG_READ_PPID, "could not read parent pid: %d", ret);
+	}
+	event->ppid = ppid;
+
+	// The PID namespace is the same one the PID namespace filter starts from,
+	// and is also best effort.
+	u32 pidns = 0;
+	ret = BPF_CORE_READ_INTO(&pidns, task, nsproxy, pid_ns_for_children, ns.inum);
+	if (ret) {
+		LOG1(LOG_LEVEL_WARN, LOG_READ_EVENT_PIDNS, "could not read pidns: %d", ret);
+	}
+	event->pidns = pidns;
+
 	ret = bpf_get_current_comm(&event->comm, sizeof(event->comm));
 	if (ret) {
-		LOG1("could not get current comm: %d", ret);
-		bpf_ringbuf_discard(event, 0);
+		LOG1(LOG_LEVEL_ERROR, LOG_GET_COMM, "could not get current comm: %d", ret);
+		discard_event(event);
 		return 1;
 	}
 
 	// Write the filename in addition to argv[0] because the filename contains
 	// the full path to the file which could be more useful in some situations.
-	ret = bpf_probe_read_user_str(&event->filename, sizeof(event->filename), ctx->filename);
+	ret = probe_read_user_str(&event->filename, sizeof(event->filename), ctx->filename);
 	if (ret < 0) {
-		LOG1("could not read filename into event struct: %d", ret);
-		bpf_ringbuf_discard(event, 0);
+		LOG1(LOG_LEVEL_ERROR, LOG_READ_FILENAME, "could not read filename into event struct: %d", ret);
+		discard_event(event);
 		return 1;
 	}
 
@@ -264,15 +602,15 @@ s32 enter_execve(struct exec_info *ctx) {
 		// Copying the arg into it's own variable before copying it into
 		// event->argv[i] prevents memory corruption.
 		const u8 *argp = NULL;
-		ret = bpf_probe_read_user(&argp, sizeof(argp), &ctx->argv[i]);
+		ret = probe_read_user(&argp, sizeof(argp), &ctx->argv[i]);
 		if (ret || !argp) {
 			goto out;
 		}
 
 		// Copy argp to event->argv[i].
-		ret = bpf_probe_read_user_str(event->argv[i], sizeof(event->argv[i]), argp);
+		ret = probe_read_user_str(event->argv[i], sizeof(event->argv[i]), argp);
 		if (ret < 0) {
-			LOG2("read argv %u: %d", i, ret);
+			LOG2(LOG_LEVEL_WARN, LOG_READ_ARGV, "read argv %u: %d", i, ret);
 			goto out;
 		}
 
@@ -287,7 +625,7 @@ s32 enter_execve(struct exec_info *ctx) {
 out:
 	// Write the event to the ring buffer and notify userspace. This will cause
 	// the `Read()` call in userspace to return if it was blocked.
-	bpf_ringbuf_submit(event, 0);
+	submit_event(ctx, event);
 
 	return 0;
 }
@@ -7,6 +7,9 @@
 #define __VMLINUX_CORE_H__
 
 struct task_struct___exectrace {
+	__s32 pid;
+	__s32 tgid;
+	struct task_struct___exectrace *real_parent;
 	struct nsproxy___exectrace *nsproxy;
 } __attribute__((preserve_access_index));
 
@@ -15,3 +15,9 @@ var NativeEndian = binary.BigEndian
 //
 //go:embed bpf/handler-bpfeb.o
 var bpfProgram []byte
+
+// The compiled BPF program using perf event arrays instead of ringbufs, for
+// kernels older than 5.8 on big endian processors.
+//
+//go:embed bpf/handler-perf-bpfeb.o
+var bpfProgramPerf []byte
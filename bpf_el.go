@@ -15,3 +15,9 @@ var NativeEndian = binary.LittleEndian
 //
 //go:embed bpf/handler-bpfel.o
 var bpfProgram []byte
+
+// The compiled BPF program using perf event arrays instead of ringbufs, for
+// kernels older than 5.8 on little endian processors.
+//
+//go:embed bpf/handler-perf-bpfel.o
+var bpfProgramPerf []byte
@@ -0,0 +1,56 @@
+package exectrace
+
+// CheckStatus is the result of a single support check.
+type CheckStatus string
+
+const (
+	// CheckOK means the requirement is met.
+	CheckOK CheckStatus = "ok"
+	// CheckWarning means the requirement is only partially met. The eBPF
+	// backend still works but with reduced functionality or performance.
+	CheckWarning CheckStatus = "warning"
+	// CheckFailed means the requirement isn't met and the eBPF backend will
+	// fail to start.
+	CheckFailed CheckStatus = "failed"
+)
+
+// Check is the result of checking a single requirement of the eBPF backend.
+type Check struct {
+	Name   string      `json:"name"`
+	Status CheckStatus `json:"status"`
+	// Detail describes what was found, e.g. the kernel version.
+	Detail string `json:"detail"`
+	// Remediation suggests how to fix a failed check or warning. It is empty
+	// if the check passed.
+	Remediation string `json:"remediation,omitempty"`
+}
+
+// SupportReport describes whether the current environment can run the eBPF
+// backend, and why not. See CheckSupport.
+type SupportReport struct {
+	// KernelRelease is the release of the running kernel, as in `uname -r`.
+	KernelRelease string `json:"kernel_release"`
+
+	Kernel       Check `json:"kernel"`
+	BTF          Check `json:"btf"`
+	Ringbuf      Check `json:"ringbuf"`
+	Tracefs      Check `json:"tracefs"`
+	Capabilities Check `json:"capabilities"`
+	Tracepoint   Check `json:"tracepoint"`
+}
+
+// Checks returns all of the checks in the report in a stable order.
+func (r *SupportReport) Checks() []Check {
+	return []Check{r.Kernel, r.BTF, r.Ringbuf, r.Tracefs, r.Capabilities, r.Tracepoint}
+}
+
+// OK returns true if none of the checks failed, meaning the eBPF backend is
+// expected to start.
+func (r *SupportReport) OK() bool {
+	for _, c := range r.Checks() {
+		if c.Status == CheckFailed {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,265 @@
+//go:build linux
+// +build linux
+
+package exectrace
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/features"
+	"golang.org/x/sys/unix"
+	"golang.org/x/xerrors"
+)
+
+// Tracefs can be mounted in either of these locations. Older kernels only
+// expose it through debugfs.
+var tracefsPaths = []string{
+	"/sys/kernel/tracing",
+	"/sys/kernel/debug/tracing",
+}
+
+const execveTracepoint = "events/syscalls/sys_enter_execve"
+
+// proc connector hint appended to the remediation of checks that can't be
+// fixed without changing the kernel.
+const procConnectorHint = "Alternatively, use the proc connector backend (--backend proc-connector) which has weaker guarantees."
+
+// CheckSupport inspects the running kernel and the current process to
+// determine whether the eBPF backend can be started, with remediation hints
+// for anything that's missing. It never loads any eBPF programs, so it's safe
+// to call before New to explain why it would fail.
+func CheckSupport() *SupportReport {
+	r := &SupportReport{}
+	// An empty release fails the kernel check below.
+	r.KernelRelease, _ = kernelRelease()
+
+	r.Kernel = checkKernel(r.KernelRelease)
+	r.BTF = checkBTF(r.KernelRelease)
+	r.Ringbuf = checkRingbuf()
+	var tracefs string
+	r.Tracefs, tracefs = checkTracefs()
+	r.Capabilities = checkCapabilities(r.KernelRelease)
+	r.Tracepoint = checkTracepoint(tracefs)
+
+	return r
+}
+
+// kernelRelease returns the release of the running kernel, as in `uname -r`.
+func kernelRelease() (string, error) {
+	var uname unix.Utsname
+	err := unix.Uname(&uname)
+	if err != nil {
+		return "", xerrors.Errorf("get kernel release: %w", err)
+	}
+	return unix.ByteSliceToString(uname.Release[:]), nil
+}
+
+// parseKernelVersion returns the major and minor version from a kernel
+// release string such as "5.15.0-91-generic".
+func parseKernelVersion(release string) (major, minor int, err error) {
+	parts := strings.SplitN(release, ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, xerrors.Errorf("invalid kernel release %q", release)
+	}
+	major, err = strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, xerrors.Errorf("invalid kernel major version in %q: %w", release, err)
+	}
+	minorStr := parts[1]
+	if i := strings.IndexFunc(minorStr, func(r rune) bool { return r < '0' || r > '9' }); i >= 0 {
+		minorStr = minorStr[:i]
+	}
+	minor, err = strconv.Atoi(minorStr)
+	if err != nil {
+		return 0, 0, xerrors.Errorf("invalid kernel minor version in %q: %w", release, err)
+	}
+	return major, minor, nil
+}
+
+// kernelAtLeast returns true if the kernel release is at least major.minor.
+// Unparseable releases are assumed to be new enough.
+func kernelAtLeast(release string, major, minor int) bool {
+	gotMajor, gotMinor, err := parseKernelVersion(release)
+	if err != nil {
+		return true
+	}
+	return gotMajor > major || (gotMajor == major && gotMinor >= minor)
+}
+
+func checkKernel(release string) Check {
+	c := Check{Name: "kernel"}
+	major, minor, err := parseKernelVersion(release)
+	if err != nil {
+		c.Status = CheckWarning
+		c.Detail = fmt.Sprintf("could not determine kernel version: %v", err)
+		return c
+	}
+
+	c.Detail = fmt.Sprintf("kernel %d.%d (%s)", major, minor, release)
+	if !kernelAtLeast(release, 5, 4) {
+		c.Status = CheckFailed
+		c.Remediation = "Upgrade to kernel 5.4 or newer. " + procConnectorHint
+		return c
+	}
+	c.Status = CheckOK
+	return c
+}
+
+func checkBTF(release string) Check {
+	c := Check{Name: "btf"}
+	_, err := os.Stat(kernelBTFPath)
+	if err == nil {
+		c.Status = CheckOK
+		c.Detail = "kernel BTF is available at " + kernelBTFPath
+		return c
+	}
+
+	external := filepath.Join(DefaultKernelBTFDir, release+".btf")
+	_, err = os.Stat(external)
+	if err == nil {
+		c.Status = CheckOK
+		c.Detail = "external BTF is available at " + external
+		return c
+	}
+
+	c.Status = CheckFailed
+	c.Detail = fmt.Sprintf("%s does not exist, the kernel was built without CONFIG_DEBUG_INFO_BTF", kernelBTFPath)
+	c.Remediation = fmt.Sprintf("Download the BTF file for kernel %s from BTFHub (https://github.com/aquasecurity/btfhub-archive) to %s or pass it with --kernel-btf. %s", release, external, procConnectorHint)
+	return c
+}
+
+func checkRingbuf() Check {
+	c := Check{Name: "ringbuf"}
+	err := features.HaveMapType(ebpf.RingBuf)
+	switch {
+	case err == nil:
+		c.Status = CheckOK
+		c.Detail = "BPF_MAP_TYPE_RINGBUF is supported"
+	case xerrors.Is(err, ebpf.ErrNotSupported):
+		c.Status = CheckWarning
+		c.Detail = "BPF_MAP_TYPE_RINGBUF is not supported, the slower perf event array variant will be used"
+		c.Remediation = "Upgrade to kernel 5.8 or newer for ringbuf support."
+	default:
+		c.Status = CheckWarning
+		c.Detail = fmt.Sprintf("could not probe for BPF_MAP_TYPE_RINGBUF support: %v", err)
+		c.Remediation = "Run the check with the same privileges as the tracer, usually as root."
+	}
+	return c
+}
+
+// checkTracefs returns the check result and the path tracefs is mounted at,
+// if any.
+func checkTracefs() (Check, string) {
+	c := Check{Name: "tracefs"}
+	mounts := tracefsMounts()
+	for _, path := range tracefsPaths {
+		_, err := os.Stat(filepath.Join(path, "events"))
+		if err == nil {
+			c.Status = CheckOK
+			c.Detail = "tracefs is mounted at " + path
+			return c, path
+		}
+	}
+
+	c.Status = CheckFailed
+	if len(mounts) > 0 {
+		c.Detail = fmt.Sprintf("tracefs/debugfs is mounted at %s but tracing events are not accessible", strings.Join(mounts, ", "))
+		c.Remediation = "Check that the tracer has permission to read tracefs, usually by running it as root."
+	} else {
+		c.Detail = "neither tracefs nor debugfs is mounted"
+		c.Remediation = "Mount tracefs with `mount -t tracefs tracefs /sys/kernel/tracing`, or for containers, bind mount /sys/kernel/tracing from the host."
+	}
+	return c, ""
+}
+
+// tracefsMounts returns the mount points of all tracefs and debugfs mounts.
+func tracefsMounts() []string {
+	f, err := os.Open("/proc/self/mounts")
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var mounts []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) >= 3 && (fields[2] == "tracefs" || fields[2] == "debugfs") {
+			mounts = append(mounts, fields[1])
+		}
+	}
+	return mounts
+}
+
+func checkCapabilities(release string) Check {
+	c := Check{Name: "capabilities"}
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	err := unix.Capget(&hdr, &data[0])
+	if err != nil {
+		c.Status = CheckWarning
+		c.Detail = fmt.Sprintf("could not read capabilities: %v", err)
+		return c
+	}
+	has := func(capability int) bool {
+		return data[capability/32].Effective&(1<<(uint(capability)%32)) != 0
+	}
+
+	var held []string
+	for _, capability := range []struct {
+		name string
+		cap  int
+	}{
+		{"CAP_SYS_ADMIN", unix.CAP_SYS_ADMIN},
+		{"CAP_BPF", unix.CAP_BPF},
+		{"CAP_PERFMON", unix.CAP_PERFMON},
+	} {
+		if has(capability.cap) {
+			held = append(held, capability.name)
+		}
+	}
+	c.Detail = "effective capabilities: none of CAP_SYS_ADMIN, CAP_BPF or CAP_PERFMON"
+	if len(held) > 0 {
+		c.Detail = "effective capabilities: " + strings.Join(held, ", ")
+	}
+
+	// CAP_BPF and CAP_PERFMON were split out of CAP_SYS_ADMIN in 5.8.
+	switch {
+	case has(unix.CAP_SYS_ADMIN):
+		c.Status = CheckOK
+	case kernelAtLeast(release, 5, 8) && has(unix.CAP_BPF) && has(unix.CAP_PERFMON):
+		c.Status = CheckOK
+	default:
+		c.Status = CheckFailed
+		c.Remediation = "Run as root, or grant CAP_SYS_ADMIN (or CAP_BPF and CAP_PERFMON on 5.8+ kernels), e.g. with `setcap cap_bpf,cap_perfmon+ep` or the container's securityContext."
+	}
+	return c
+}
+
+func checkTracepoint(tracefs string) Check {
+	c := Check{Name: "tracepoint"}
+	if tracefs == "" {
+		c.Status = CheckFailed
+		c.Detail = "cannot check for the sys_enter_execve tracepoint without tracefs"
+		c.Remediation = "Fix the tracefs check first."
+		return c
+	}
+
+	path := filepath.Join(tracefs, execveTracepoint)
+	_, err := os.Stat(path)
+	if err != nil {
+		c.Status = CheckFailed
+		c.Detail = fmt.Sprintf("%s does not exist", path)
+		c.Remediation = "Use a kernel built with CONFIG_FTRACE_SYSCALLS. " + procConnectorHint
+		return c
+	}
+	c.Status = CheckOK
+	c.Detail = "syscalls:sys_enter_execve tracepoint exists"
+	return c
+}
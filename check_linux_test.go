@@ -0,0 +1,33 @@
+//go:build linux
+// +build linux
+
+package exectrace_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/coder/exectrace"
+)
+
+//nolint:paralleltest
+func TestCheckSupport(t *testing.T) {
+	// This test must be run as root, same as the eBPF tests, so the report
+	// should say the eBPF backend is supported.
+	if os.Geteuid() != 0 {
+		t.Fatal("must be run as root")
+	}
+
+	report := exectrace.CheckSupport()
+	require.NotEmpty(t, report.KernelRelease)
+	for _, c := range report.Checks() {
+		require.NotEmpty(t, c.Name)
+		require.NotEqual(t, exectrace.CheckFailed, c.Status, "check %q failed: %s", c.Name, c.Detail)
+		require.NotEmpty(t, c.Detail)
+	}
+	require.True(t, report.OK())
+	require.Equal(t, exectrace.CheckOK, report.Capabilities.Status)
+	require.Equal(t, exectrace.CheckOK, report.Tracepoint.Status)
+}
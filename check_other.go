@@ -0,0 +1,31 @@
+//go:build !linux
+// +build !linux
+
+package exectrace
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// CheckSupport reports that the eBPF backend is not supported on OSes other
+// than Linux.
+func CheckSupport() *SupportReport {
+	unsupported := Check{
+		Status: CheckFailed,
+		Detail: fmt.Sprintf(`%q is an unsupported OS, only "linux" is supported`, runtime.GOOS),
+	}
+	r := &SupportReport{}
+	for name, c := range map[string]*Check{
+		"kernel":       &r.Kernel,
+		"btf":          &r.BTF,
+		"ringbuf":      &r.Ringbuf,
+		"tracefs":      &r.Tracefs,
+		"capabilities": &r.Capabilities,
+		"tracepoint":   &r.Tracepoint,
+	} {
+		*c = unsupported
+		c.Name = name
+	}
+	return r
+}
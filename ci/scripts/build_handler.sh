@@ -4,6 +4,7 @@
 # output file is put into the `bpf` directory.
 #
 # Usage: build_handler.sh handler-bpfel.o
+#        build_handler.sh handler-perf-bpfel.o
 
 set -euo pipefail
 cd "$(dirname "$0")"
@@ -12,6 +13,14 @@ output="$(basename "$1")"
 target="${output#handler-}"
 target="${target%.o}"
 
+# The perf variant outputs through BPF_MAP_TYPE_PERF_EVENT_ARRAY for kernels
+# without BPF_MAP_TYPE_RINGBUF.
+variant_flags=()
+if [[ "$target" == perf-* ]]; then
+    target="${target#perf-}"
+    variant_flags+=("-DEXECTRACE_PERF_EVENT_ARRAY")
+fi
+
 if [[ "$target" != "bpfeb" ]] && [[ "$target" != "bpfel" ]]; then
     echo "Sniffed build target '$target' from input '$output' is invalid"
     exit 1
@@ -35,6 +44,7 @@ fi
 # -target
 #   This is set to bpfeb or bpfel based on the build target.
 ./clang.sh clang-13 \
+	${variant_flags[@]+"${variant_flags[@]}"} \
 	-O2 \
 	-mcpu=v1 \
 	-g \
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kballard/go-shellquote"
+
+	"github.com/coder/exectrace"
+)
+
+const (
+	cefVendor       = "Coder"
+	cefProduct      = "exectrace"
+	cefEventClassID = "exec"
+	cefEventName    = "Process executed"
+	// cefSeverity is on a scale of 0-10, 3 is "low".
+	cefSeverity = 3
+)
+
+// cefFormatter formats events as ArcSight Common Event Format (CEF) messages.
+type cefFormatter struct {
+	hostname string
+	version  string
+}
+
+func newCEFFormatter() *cefFormatter {
+	return &cefFormatter{
+		hostname: hostname(),
+		version:  version(),
+	}
+}
+
+// formatEvent formats the event as a CEF message:
+//
+//	CEF:0|Vendor|Product|Version|EventClassID|Name|Severity|Extension
+func (f *cefFormatter) formatEvent(event *exectrace.Event, now time.Time) ([]byte, error) {
+	var b strings.Builder
+	b.WriteString("CEF:0")
+	for _, field := range []string{
+		cefVendor, cefProduct, f.version, cefEventClassID, cefEventName, strconv.Itoa(cefSeverity),
+	} {
+		b.WriteString("|" + escapeCEFHeader(field))
+	}
+	b.WriteString("|")
+
+	ext := []struct {
+		key   string
+		value string
+	}{
+		{"rt", strconv.FormatInt(now.UnixMilli(), 10)},
+		{"dvchost", f.hostname},
+		{"dpid", strconv.FormatUint(uint64(event.PID), 10)},
+		{"duid", strconv.FormatUint(uint64(event.UID), 10)},
+		{"dproc", filepath.Base(event.Filename)},
+		{"filePath", event.Filename},
+		{"sproc", event.Comm},
+		{"cs1Label", "cmdline"},
+		{"cs1", shellquote.Join(event.Argv...)},
+		{"cn1Label", "gid"},
+		{"cn1", strconv.FormatUint(uint64(event.GID), 10)},
+		{"cs2Label", "truncated"},
+		{"cs2", strconv.FormatBool(event.Truncated)},
+		{"cs3Label", "redacted"},
+		{"cs3", strconv.FormatBool(event.Redacted)},
+	}
+	for i, e := range ext {
+		if i > 0 {
+			b.WriteString(" ")
+		}
+		b.WriteString(e.key + "=" + escapeCEFExtension(e.value))
+	}
+
+	return []byte(b.String()), nil
+}
+
+// escapeCEFHeader escapes a CEF header field. Pipes and backslashes must be
+// escaped, and newlines are not allowed so they're replaced with spaces.
+var escapeCEFHeader = strings.NewReplacer(
+	`\`, `\\`,
+	`|`, `\|`,
+	"\n", " ",
+	"\r", " ",
+).Replace
+
+// escapeCEFExtension escapes a CEF extension value. Equals signs and
+// backslashes must be escaped, and newlines are encoded as \n and \r.
+var escapeCEFExtension = strings.NewReplacer(
+	`\`, `\\`,
+	`=`, `\=`,
+	"\n", `\n`,
+	"\r", `\r`,
+).Replace
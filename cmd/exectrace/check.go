@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/xerrors"
+
+	"github.com/coder/exectrace"
+)
+
+func checkCmd() *cobra.Command {
+	var jsonOutput bool
+
+	cmd := &cobra.Command{
+		Use:   "check",
+		Short: "Check whether this system can run the eBPF tracer.",
+		Long: "Check whether this system can run the eBPF tracer, including the kernel version, BTF, ringbuf support, " +
+			"tracefs, capabilities and the execve tracepoint. Exits with status 1 if any check failed.",
+		Args: cobra.NoArgs,
+		Run: func(_ *cobra.Command, _ []string) {
+			report := exectrace.CheckSupport()
+			err := writeReport(os.Stdout, report, jsonOutput)
+			if err != nil {
+				//nolint:revive
+				log.Fatalf("write report: %+v", err)
+			}
+			if !report.OK() {
+				//nolint:revive
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Print the report as JSON")
+
+	return cmd
+}
+
+func writeReport(w io.Writer, report *exectrace.SupportReport, jsonOutput bool) error {
+	if jsonOutput {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err := enc.Encode(report)
+		if err != nil {
+			return xerrors.Errorf("encode report: %w", err)
+		}
+		return nil
+	}
+
+	for _, c := range report.Checks() {
+		_, err := fmt.Fprintf(w, "[%s] %s: %s\n", c.Status, c.Name, c.Detail)
+		if err != nil {
+			return err
+		}
+		if c.Remediation != "" {
+			_, err = fmt.Fprintf(w, "    hint: %s\n", c.Remediation)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	summary := "All checks passed, the eBPF tracer is supported."
+	if !report.OK() {
+		summary = "Some checks failed, the eBPF tracer will not start."
+	}
+	_, err := fmt.Fprintln(w, summary)
+	return err
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/coder/exectrace"
+)
+
+func TestWriteReport(t *testing.T) {
+	t.Parallel()
+
+	report := &exectrace.SupportReport{
+		KernelRelease: "5.4.0-generic",
+		Kernel:        exectrace.Check{Name: "kernel", Status: exectrace.CheckOK, Detail: "kernel 5.4"},
+		BTF: exectrace.Check{
+			Name:        "btf",
+			Status:      exectrace.CheckFailed,
+			Detail:      "no BTF",
+			Remediation: "download it",
+		},
+		Ringbuf:      exectrace.Check{Name: "ringbuf", Status: exectrace.CheckWarning, Detail: "no ringbuf"},
+		Tracefs:      exectrace.Check{Name: "tracefs", Status: exectrace.CheckOK, Detail: "mounted"},
+		Capabilities: exectrace.Check{Name: "capabilities", Status: exectrace.CheckOK, Detail: "root"},
+		Tracepoint:   exectrace.Check{Name: "tracepoint", Status: exectrace.CheckOK, Detail: "exists"},
+	}
+
+	t.Run("Text", func(t *testing.T) {
+		t.Parallel()
+
+		var buf bytes.Buffer
+		err := writeReport(&buf, report, false)
+		require.NoError(t, err)
+		require.Equal(t, `[ok] kernel: kernel 5.4
+[failed] btf: no BTF
+    hint: download it
+[warning] ringbuf: no ringbuf
+[ok] tracefs: mounted
+[ok] capabilities: root
+[ok] tracepoint: exists
+Some checks failed, the eBPF tracer will not start.
+`, buf.String())
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		t.Parallel()
+
+		var buf bytes.Buffer
+		err := writeReport(&buf, report, true)
+		require.NoError(t, err)
+
+		var got exectrace.SupportReport
+		err = json.Unmarshal(buf.Bytes(), &got)
+		require.NoError(t, err)
+		require.Equal(t, *report, got)
+	})
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coder/exectrace"
+)
+
+// ecsVersion is the version of the Elastic Common Schema that events are
+// mapped to.
+const ecsVersion = "8.11.0"
+
+// ecsEvent is an exec event mapped onto Elastic Common Schema fields. Fields
+// without an ECS equivalent are put in the custom "exectrace" namespace.
+//
+// See https://www.elastic.co/guide/en/ecs/current/ecs-process.html
+type ecsEvent struct {
+	Timestamp string `json:"@timestamp"`
+	ECS       struct {
+		Version string `json:"version"`
+	} `json:"ecs"`
+	Event struct {
+		Kind     string   `json:"kind"`
+		Category []string `json:"category"`
+		Type     []string `json:"type"`
+		Action   string   `json:"action"`
+		Module   string   `json:"module"`
+		Dataset  string   `json:"dataset"`
+	} `json:"event"`
+	Host struct {
+		Hostname string `json:"hostname"`
+	} `json:"host"`
+	Process struct {
+		Executable  string   `json:"executable"`
+		Name        string   `json:"name"`
+		Args        []string `json:"args"`
+		ArgsCount   int      `json:"args_count"`
+		CommandLine string   `json:"command_line"`
+		PID         uint32   `json:"pid"`
+		Parent      struct {
+			Name string `json:"name"`
+			PID  uint32 `json:"pid,omitempty"`
+		} `json:"parent"`
+		User struct {
+			ID string `json:"id"`
+		} `json:"user"`
+		Group struct {
+			ID string `json:"id"`
+		} `json:"group"`
+	} `json:"process"`
+	User struct {
+		ID string `json:"id"`
+	} `json:"user"`
+	Group struct {
+		ID string `json:"id"`
+	} `json:"group"`
+	Exectrace struct {
+		Truncated   bool   `json:"truncated"`
+		Redacted    bool   `json:"redacted"`
+		Reliability string `json:"reliability,omitempty"`
+	} `json:"exectrace"`
+}
+
+type ecsFormatter struct {
+	hostname string
+}
+
+func newECSFormatter() *ecsFormatter {
+	return &ecsFormatter{
+		hostname: hostname(),
+	}
+}
+
+// formatEvent formats the event as a single line of ECS JSON.
+func (f *ecsFormatter) formatEvent(event *exectrace.Event, now time.Time) ([]byte, error) {
+	var (
+		e   ecsEvent
+		uid = strconv.FormatUint(uint64(event.UID), 10)
+		gid = strconv.FormatUint(uint64(event.GID), 10)
+	)
+	e.Timestamp = now.UTC().Format(time.RFC3339Nano)
+	e.ECS.Version = ecsVersion
+
+	e.Event.Kind = "event"
+	e.Event.Category = []string{"process"}
+	e.Event.Type = []string{"start"}
+	e.Event.Action = "exec"
+	e.Event.Module = "exectrace"
+	e.Event.Dataset = "exectrace.exec"
+
+	e.Host.Hostname = f.hostname
+
+	e.Process.Executable = event.Filename
+	e.Process.Name = filepath.Base(event.Filename)
+	e.Process.Args = event.Argv
+	if e.Process.Args == nil {
+		e.Process.Args = []string{}
+	}
+	e.Process.ArgsCount = len(event.Argv)
+	e.Process.CommandLine = strings.Join(event.Argv, " ")
+	e.Process.PID = event.PID
+	e.Process.Parent.Name = event.Comm
+	e.Process.Parent.PID = event.PPID
+	e.Process.User.ID = uid
+	e.Process.Group.ID = gid
+	e.User.ID = uid
+	e.Group.ID = gid
+
+	e.Exectrace.Truncated = event.Truncated
+	e.Exectrace.Redacted = event.Redacted
+	e.Exectrace.Reliability = string(event.Reliability)
+
+	return json.Marshal(e)
+}
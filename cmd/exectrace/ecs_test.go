@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatECS(t *testing.T) {
+	t.Parallel()
+
+	f := &ecsFormatter{hostname: "host"}
+	msg, err := f.formatEvent(testEvent, testTime)
+	require.NoError(t, err)
+
+	var got map[string]interface{}
+	err = json.Unmarshal(msg, &got)
+	require.NoError(t, err)
+
+	require.Equal(t, "2024-05-06T07:08:09.123456Z", got["@timestamp"])
+	require.Equal(t, ecsVersion, get(t, got, "ecs", "version"))
+	require.Equal(t, []interface{}{"process"}, get(t, got, "event", "category"))
+	require.Equal(t, []interface{}{"start"}, get(t, got, "event", "type"))
+	require.Equal(t, "host", get(t, got, "host", "hostname"))
+	require.Equal(t, "/usr/bin/echo", get(t, got, "process", "executable"))
+	require.Equal(t, "echo", get(t, got, "process", "name"))
+	require.Len(t, get(t, got, "process", "args"), len(testEvent.Argv))
+	require.EqualValues(t, len(testEvent.Argv), get(t, got, "process", "args_count"))
+	require.EqualValues(t, 1234, get(t, got, "process", "pid"))
+	require.Equal(t, "bash", get(t, got, "process", "parent", "name"))
+	require.Equal(t, "1000", get(t, got, "process", "user", "id"))
+	require.Equal(t, "1000", get(t, got, "user", "id"))
+	require.Equal(t, "1001", get(t, got, "group", "id"))
+	require.Equal(t, true, get(t, got, "exectrace", "truncated"))
+}
+
+// get returns the value at the given path in a decoded JSON object.
+func get(t *testing.T, obj map[string]interface{}, path ...string) interface{} {
+	t.Helper()
+
+	var v interface{} = obj
+	for _, key := range path {
+		m, ok := v.(map[string]interface{})
+		require.Truef(t, ok, "%q is not an object", key)
+		v, ok = m[key]
+		require.Truef(t, ok, "key %q not found", key)
+	}
+	return v
+}
@@ -1,16 +1,18 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"os/signal"
+	"regexp"
+	"strings"
 	"syscall"
+	"time"
 
-	"github.com/kballard/go-shellquote"
 	"github.com/spf13/cobra"
 	"golang.org/x/xerrors"
 
@@ -26,20 +28,34 @@ func main() {
 
 func rootCmd() *cobra.Command {
 	var (
-		pidNS        uint32
-		outputFormat string
+		tracer      tracerFlags
+		output      outputFlags
+		metricsAddr string
+		labelLimit  int
 	)
 
 	var cmd = &cobra.Command{
 		Use:   "exectrace",
 		Short: "exectrace logs all exec calls on the system.",
 		Run: func(_ *cobra.Command, _ []string) {
-			if outputFormat != "text" && outputFormat != "json" {
+			tracerOpts, err := tracer.tracerOpts()
+			if err != nil {
+				//nolint:revive
+				log.Fatalf("invalid tracer options: %+v", err)
+			}
+
+			outputOpts, err := output.outputOptions()
+			if err != nil {
 				//nolint:revive
-				log.Fatalf(`output format must be "text" or "json", got %q`, outputFormat)
+				log.Fatalf("invalid output options: %+v", err)
 			}
 
-			err := run(pidNS, outputFormat)
+			err = run(runOptions{
+				TracerOpts:        tracerOpts,
+				Output:            outputOpts,
+				MetricsAddr:       metricsAddr,
+				MetricsLabelLimit: labelLimit,
+			})
 			if err != nil {
 				//nolint:revive
 				log.Fatalf("run exectrace: %+v", err)
@@ -47,37 +63,191 @@ func rootCmd() *cobra.Command {
 		},
 	}
 
-	cmd.Flags().Uint32VarP(&pidNS, "pid-ns", "p", 0, "PID NS ID to filter events from, you can get this by doing `readlink /proc/self/ns/pid`")
-	cmd.Flags().StringVarP(&outputFormat, "output", "f", "text", "Output format, text or json")
+	tracer.register(cmd)
+	output.register(cmd)
+	cmd.Flags().StringVar(&metricsAddr, "metrics-addr", "", "Serve Prometheus metrics on this address at /metrics, e.g. :9102")
+	cmd.Flags().IntVar(&labelLimit, "metrics-label-limit", 1000, "Maximum number of distinct comm, uid and filename label values in metrics, further values are counted as \""+metricsOtherLabel+"\"")
+
+	cmd.AddCommand(serveCmd(), recordCmd(), replayCmd(), checkCmd(), unpinCmd(), runCmd(), runShimCmd(), topCmd(), reportCmd(), storeCmd(), queryCmd())
 
 	return cmd
 }
 
-func run(pidNS uint32, outputFormat string) error {
-	t, err := exectrace.New(&exectrace.TracerOpts{
-		PidNS: pidNS,
-		// We use the default LogFn since it logs all the details to stderr.
-	})
+// tracerFlags contains the flags used to configure the tracer, which are
+// shared between commands.
+type tracerFlags struct {
+	backend        string
+	kernelBTF      string
+	pinPath        string
+	pidNS          uint32
+	pidNSOf        uint32
+	pid            uint32
+	redact         bool
+	redactPatterns []string
+	filterExpr     string
+}
+
+func (f *tracerFlags) register(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&f.backend, "backend", string(exectrace.BackendEBPF), `Tracer backend, one of "ebpf", "proc-connector" or "auto" (auto falls back to proc-connector if eBPF is unavailable)`)
+	cmd.Flags().StringVar(&f.kernelBTF, "kernel-btf", "", "Path to an external BTF file for the running kernel, for kernels without /sys/kernel/btf/vmlinux (defaults to searching "+exectrace.DefaultKernelBTFDir+")")
+	cmd.Flags().StringVar(&f.pinPath, "pin-path", "", "Pin the eBPF program and maps to this directory on a BPF filesystem so unread events survive restarts, e.g. "+exectrace.DefaultPinPath+"/sidecar (remove with \"exectrace unpin\")")
+	cmd.Flags().Uint32VarP(&f.pidNS, "pid-ns", "p", 0, "PID NS ID to filter events from, you can get this by doing `readlink /proc/self/ns/pid`")
+	cmd.Flags().Uint32Var(&f.pidNSOf, "pid-ns-of", 0, "Filter events from the PID NS of this process, like --pid-ns")
+	cmd.Flags().Uint32Var(&f.pid, "pid", 0, "Only log events from this process and its descendants, including ones started later")
+	cmd.Flags().StringVar(&f.filterExpr, "filter", "", `Only log events matching this expression, e.g. 'uid >= 1000 && filename matches "^/tmp/"'`)
+	cmd.Flags().BoolVar(&f.redact, "redact", false, "Redact secrets such as passwords and tokens from argv")
+	cmd.Flags().StringArrayVar(&f.redactPatterns, "redact-pattern", nil, "Additional regex of secrets to redact from argv, enables --redact. If it contains a capture group named \"secret\" only that group is redacted (can be specified multiple times)")
+}
+
+func (f *tracerFlags) tracerOpts() (*exectrace.TracerOpts, error) {
+	// Custom patterns imply --redact, as they'd be ignored otherwise.
+	var redactOpts *exectrace.RedactOpts
+	if f.redact || len(f.redactPatterns) > 0 {
+		redactOpts = &exectrace.RedactOpts{}
+		for i, pattern := range f.redactPatterns {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, xerrors.Errorf("invalid redact pattern %q: %w", pattern, err)
+			}
+			redactOpts.Rules = append(redactOpts.Rules, exectrace.RedactRule{
+				Name:    fmt.Sprintf("custom-%d", i),
+				Pattern: re,
+			})
+		}
+	}
+
+	var filter *exectrace.Filter
+	if f.filterExpr != "" {
+		var err error
+		filter, err = exectrace.ParseFilter(f.filterExpr)
+		if err != nil {
+			return nil, xerrors.Errorf("invalid filter: %w", err)
+		}
+	}
+
+	pidNS := f.pidNS
+	if f.pidNSOf != 0 {
+		if pidNS != 0 {
+			return nil, xerrors.New("--pid-ns and --pid-ns-of can't be used together")
+		}
+		var err error
+		pidNS, err = exectrace.GetPidNSOf(f.pidNSOf)
+		if err != nil {
+			return nil, xerrors.Errorf("get PID NS of process %d: %w", f.pidNSOf, err)
+		}
+	}
+
+	backend := exectrace.Backend(f.backend)
+	switch backend {
+	case exectrace.BackendAuto, exectrace.BackendEBPF, exectrace.BackendProcConnector:
+	default:
+		return nil, xerrors.Errorf("invalid backend %q", f.backend)
+	}
+
+	return &exectrace.TracerOpts{
+		Backend:       backend,
+		KernelBTFPath: f.kernelBTF,
+		PinPath:       f.pinPath,
+		PidNS:         pidNS,
+		PID:           f.pid,
+		Redact:        redactOpts,
+		Filter:        filter,
+		// We use the default LogHandler since it logs all the details to stderr.
+	}, nil
+}
+
+// outputFlags contains the flags used to configure where and how events are
+// written, which are shared between commands.
+type outputFlags struct {
+	opts         outputOptions
+	fileMaxSize  string
+	templateFile string
+}
+
+func (f *outputFlags) register(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&f.opts.Format, "output", "f", outputText, "Output format, one of "+strings.Join(outputFormats, ", "))
+	cmd.Flags().StringVar(&f.opts.Template, "template", "", `Go text/template to format each event with in the template output format, e.g. '{{.PID}} {{.Comm}} {{join .Argv " "}}'`)
+	cmd.Flags().StringVar(&f.templateFile, "template-file", "", "Read the template for the template output format from this file")
+	cmd.Flags().StringVar(&f.opts.SyslogAddr, "syslog-addr", "", "Send events to this syslog server instead of stdout, e.g. udp://host:514, tcp://host:601 or unix:///dev/log (syslog and cef output formats only)")
+	cmd.Flags().StringVar(&f.opts.SyslogFacility, "syslog-facility", "user", "Syslog facility used in the syslog and cef output formats")
+	cmd.Flags().StringVar(&f.opts.OTLPEndpoint, "otlp-endpoint", "", "Export events to this OTLP/HTTP collector instead of stdout, e.g. http://localhost:4318 (otlp output format only)")
+	cmd.Flags().StringArrayVar(&f.opts.OTLPHeaders, "otlp-header", nil, "Extra HTTP header to send with OTLP export requests in the form key=value (can be specified multiple times)")
+	cmd.Flags().StringVar(&f.opts.File, "output-file", "", "Write events to this file instead of stdout, the file is appended to if it exists")
+	cmd.Flags().StringVar(&f.fileMaxSize, "output-file-max-size", "100MiB", "Rotate the output file when it would exceed this size, e.g. 512KiB, 100MiB or 1GiB (0 disables size-based rotation)")
+	cmd.Flags().DurationVar(&f.opts.FileRotate.MaxAge, "output-file-max-age", 0, "Rotate the output file when it is older than this duration, e.g. 24h (0 disables time-based rotation)")
+	cmd.Flags().IntVar(&f.opts.FileRotate.MaxSegments, "output-file-max-segments", 10, "Maximum number of rotated output files to keep, the oldest are deleted first (0 keeps all)")
+	cmd.Flags().BoolVar(&f.opts.FileRotate.Compress, "output-file-compress", false, "Gzip rotated output files")
+}
+
+func (f *outputFlags) outputOptions() (outputOptions, error) {
+	opts := f.opts
+	var err error
+	opts.FileRotate.MaxSize, err = parseByteSize(f.fileMaxSize)
 	if err != nil {
-		return xerrors.Errorf("start tracer: %w", err)
+		return outputOptions{}, xerrors.Errorf("invalid --output-file-max-size: %w", err)
 	}
-	defer t.Close()
+	if f.templateFile != "" {
+		if opts.Template != "" {
+			return outputOptions{}, xerrors.New("--template and --template-file can't be used together")
+		}
+		b, err := os.ReadFile(f.templateFile)
+		if err != nil {
+			return outputOptions{}, xerrors.Errorf("read --template-file: %w", err)
+		}
+		opts.Template = string(b)
+	}
+	return opts, nil
+}
 
-	// When we get a SIGTERM we should close the tracer so the loop exits.
-	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-signals
+type runOptions struct {
+	TracerOpts *exectrace.TracerOpts
+	Output     outputOptions
+	// MetricsAddr is the address to serve Prometheus metrics on, metrics are
+	// disabled if empty.
+	MetricsAddr       string
+	MetricsLabelLimit int
+}
 
-		log.Print("signal received, closing tracer")
-		err := t.Close()
+func run(opts runOptions) error {
+	w, err := newEventWriter(opts.Output, os.Stdout)
+	if err != nil {
+		return xerrors.Errorf("create output writer: %w", err)
+	}
+	defer func() {
+		err := w.Close()
 		if err != nil {
-			//nolint:revive
-			log.Fatalf("error closing tracer: %+v", err)
+			log.Printf("error closing output writer: %+v", err)
 		}
 	}()
 
-	enc := json.NewEncoder(os.Stdout)
+	var m *metrics
+	if opts.MetricsAddr != "" {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		m = newMetrics(opts.MetricsLabelLimit)
+		err = serveMetrics(ctx, opts.MetricsAddr, m)
+		if err != nil {
+			return xerrors.Errorf("serve metrics: %w", err)
+		}
+
+		handler := opts.TracerOpts.LogHandler
+		if handler == nil {
+			handler = exectrace.DefaultLogHandler
+		}
+		opts.TracerOpts.LogHandler = m.wrapLogHandler(handler)
+	}
+
+	t, err := exectrace.New(opts.TracerOpts)
+	if err != nil {
+		return xerrors.Errorf("start tracer: %w", err)
+	}
+	defer t.Close()
+	if m != nil {
+		m.registerLostSamples(t)
+	}
+
+	closeOnSignal(t)
 
 	log.Println("Waiting for events..")
 	for {
@@ -86,27 +256,40 @@ func run(pidNS uint32, outputFormat string) error {
 			if errors.Is(err, io.EOF) {
 				return nil
 			}
+			if m != nil {
+				m.readErrors.Inc()
+			}
 			log.Printf("error reading from reader: %+v", err)
 			continue
 		}
+		if m != nil {
+			m.observeEvent(event)
+		}
 
-		if outputFormat == "text" {
-			ellipsis := ""
-			if event.Truncated {
-				ellipsis = "..."
+		err = w.WriteEvent(event, time.Now())
+		if err != nil {
+			if m != nil {
+				m.writeErrors.Inc()
 			}
-
-			_, _ = fmt.Printf(
-				"[%v, comm=%q, uid=%v, gid=%v, filename=%v] %v%v\n",
-				event.PID, event.Comm, event.UID, event.GID, event.Filename,
-				shellquote.Join(event.Argv...), ellipsis,
-			)
+			log.Printf("error writing event: %+v", err)
 			continue
 		}
-		err = enc.Encode(event)
+	}
+}
+
+// closeOnSignal closes the tracer when a SIGINT or SIGTERM is received so any
+// read loops exit.
+func closeOnSignal(t exectrace.Tracer) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+
+		log.Print("signal received, closing tracer")
+		err := t.Close()
 		if err != nil {
-			log.Printf("error writing event as JSON: %+v", err)
-			continue
+			//nolint:revive
+			log.Fatalf("error closing tracer: %+v", err)
 		}
-	}
+	}()
 }
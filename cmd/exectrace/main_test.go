@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTracerFlagsRedact(t *testing.T) {
+	t.Parallel()
+
+	opts, err := (&tracerFlags{backend: "ebpf"}).tracerOpts()
+	require.NoError(t, err)
+	require.Nil(t, opts.Redact)
+
+	opts, err = (&tracerFlags{backend: "ebpf", redact: true}).tracerOpts()
+	require.NoError(t, err)
+	require.NotNil(t, opts.Redact)
+	require.Empty(t, opts.Redact.Rules)
+
+	// Custom patterns enable redaction without --redact.
+	opts, err = (&tracerFlags{backend: "ebpf", redactPatterns: []string{`secret=(?P<secret>\S+)`}}).tracerOpts()
+	require.NoError(t, err)
+	require.NotNil(t, opts.Redact)
+	require.Len(t, opts.Redact.Rules, 1)
+
+	_, err = (&tracerFlags{backend: "ebpf", redactPatterns: []string{`(`}}).tracerOpts()
+	require.Error(t, err)
+}
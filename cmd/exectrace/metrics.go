@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/xerrors"
+
+	"github.com/coder/exectrace"
+)
+
+const (
+	metricsNamespace = "exectrace"
+	// metricsOtherLabel replaces label values once a label's cardinality cap
+	// has been reached.
+	metricsOtherLabel = "__other__"
+)
+
+// metrics contains the Prometheus metrics exported by exectrace.
+type metrics struct {
+	registry *prometheus.Registry
+
+	execs           prometheus.Counter
+	execsByComm     *cappedCounterVec
+	execsByUID      *cappedCounterVec
+	execsByFilename *cappedCounterVec
+	truncated       prometheus.Counter
+	redacted        prometheus.Counter
+	readErrors      prometheus.Counter
+	writeErrors     prometheus.Counter
+	kernelLogLines  prometheus.Counter
+	dropped         prometheus.Counter
+}
+
+// newMetrics creates and registers all metrics. labelLimit is the maximum
+// number of distinct values tracked for each of the comm, uid and filename
+// labels.
+func newMetrics(labelLimit int) *metrics {
+	reg := prometheus.NewRegistry()
+	counter := func(name, help string) prometheus.Counter {
+		c := prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      name,
+			Help:      help,
+		})
+		reg.MustRegister(c)
+		return c
+	}
+	counterVec := func(name, help, label string) *cappedCounterVec {
+		c := prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      name,
+			Help:      help + " Once " + strconv.Itoa(labelLimit) + " distinct values have been seen, new values are counted as \"" + metricsOtherLabel + "\".",
+		}, []string{label})
+		reg.MustRegister(c)
+		return newCappedCounterVec(c, labelLimit)
+	}
+
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	return &metrics{
+		registry:        reg,
+		execs:           counter("execs_total", "Total number of exec events."),
+		execsByComm:     counterVec("execs_by_comm_total", "Number of exec events by the name of the calling process.", "comm"),
+		execsByUID:      counterVec("execs_by_uid_total", "Number of exec events by UID.", "uid"),
+		execsByFilename: counterVec("execs_by_filename_total", "Number of exec events by executed filename.", "filename"),
+		truncated:       counter("truncated_events_total", "Number of exec events with truncated arguments."),
+		redacted:        counter("redacted_events_total", "Number of exec events with redacted arguments."),
+		readErrors:      counter("read_errors_total", "Number of errors reading events from the tracer."),
+		writeErrors:     counter("write_errors_total", "Number of errors writing events to the output."),
+		kernelLogLines:  counter("kernel_log_lines_total", "Number of log lines emitted by the eBPF program."),
+		dropped:         counter("dropped_events_total", "Number of exec events dropped by the kernel because the events ringbuf was full."),
+	}
+}
+
+// observeEvent records an event read from the tracer.
+func (m *metrics) observeEvent(event *exectrace.Event) {
+	m.execs.Inc()
+	m.execsByComm.inc(event.Comm)
+	m.execsByUID.inc(strconv.FormatUint(uint64(event.UID), 10))
+	m.execsByFilename.inc(event.Filename)
+	if event.Truncated {
+		m.truncated.Inc()
+	}
+	if event.Redacted {
+		m.redacted.Inc()
+	}
+}
+
+// wrapLogHandler returns a TracerOpts.LogHandler that counts log entries (and
+// dropped events) before calling handler.
+func (m *metrics) wrapLogHandler(handler exectrace.LogHandler) exectrace.LogHandler {
+	return func(entry exectrace.LogEntry) {
+		m.kernelLogLines.Inc()
+		switch entry.Code {
+		case exectrace.LogCodeReserveEvent, exectrace.LogCodeProcConnectorOverflow:
+			m.dropped.Inc()
+		}
+		handler(entry)
+	}
+}
+
+// registerLostSamples exports the per-CPU lost sample counts of the tracer, if
+// it tracks them. This is the case for the perf event array variant of the
+// eBPF program, which is used on kernels without ringbuf support.
+func (m *metrics) registerLostSamples(t exectrace.Tracer) {
+	counter, ok := t.(exectrace.LostSampleCounter)
+	if !ok || counter.LostSamples() == nil {
+		return
+	}
+	m.registry.MustRegister(&lostSamplesCollector{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "", "lost_samples_total"),
+			"Number of exec events lost by the kernel because the perf buffer for a CPU was full.",
+			[]string{"cpu"}, nil,
+		),
+		counter: counter,
+	})
+}
+
+// lostSamplesCollector collects the lost sample counts from a tracer at scrape
+// time.
+type lostSamplesCollector struct {
+	desc    *prometheus.Desc
+	counter exectrace.LostSampleCounter
+}
+
+func (c *lostSamplesCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *lostSamplesCollector) Collect(ch chan<- prometheus.Metric) {
+	for cpu, lost := range c.counter.LostSamples() {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.CounterValue, float64(lost), strconv.Itoa(cpu))
+	}
+}
+
+// cappedCounterVec is a single label counter vector that limits the number of
+// distinct label values to avoid unbounded memory usage and cardinality.
+type cappedCounterVec struct {
+	vec   *prometheus.CounterVec
+	limit int
+
+	mu    sync.Mutex
+	seen  map[string]prometheus.Counter
+	other prometheus.Counter
+}
+
+func newCappedCounterVec(vec *prometheus.CounterVec, limit int) *cappedCounterVec {
+	return &cappedCounterVec{
+		vec:   vec,
+		limit: limit,
+		seen:  map[string]prometheus.Counter{},
+	}
+}
+
+func (c *cappedCounterVec) inc(value string) {
+	c.mu.Lock()
+	counter, ok := c.seen[value]
+	if !ok {
+		if len(c.seen) < c.limit {
+			counter = c.vec.WithLabelValues(value)
+			c.seen[value] = counter
+		} else {
+			if c.other == nil {
+				c.other = c.vec.WithLabelValues(metricsOtherLabel)
+			}
+			counter = c.other
+		}
+	}
+	c.mu.Unlock()
+
+	counter.Inc()
+}
+
+// handler returns an HTTP handler that serves the metrics in the Prometheus
+// exposition format.
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{
+		Registry: m.registry,
+	})
+}
+
+// serveMetrics serves the metrics on /metrics at addr until ctx is canceled.
+// The listener is opened before returning so bind errors are reported
+// immediately.
+func serveMetrics(ctx context.Context, addr string, m *metrics) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return xerrors.Errorf("listen on %q: %w", addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.handler())
+	srv := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+	go func() {
+		err := srv.Serve(l)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("error serving metrics: %+v", err)
+		}
+	}()
+
+	log.Printf("Serving metrics on http://%s/metrics", l.Addr())
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/coder/exectrace"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+
+	m := newMetrics(2)
+	for _, ev := range []*exectrace.Event{
+		{Filename: "/usr/bin/a", Comm: "bash", UID: 1000, Truncated: true},
+		{Filename: "/usr/bin/b", Comm: "bash", UID: 1000, Redacted: true},
+		{Filename: "/usr/bin/c", Comm: "sh", UID: 0},
+		{Filename: "/usr/bin/d", Comm: "zsh", UID: 1},
+		{Filename: "/usr/bin/a", Comm: "fish", UID: 2},
+	} {
+		m.observeEvent(ev)
+	}
+
+	var logged []exectrace.LogCode
+	handler := m.wrapLogHandler(func(entry exectrace.LogEntry) {
+		logged = append(logged, entry.Code)
+	})
+	handler(exectrace.LogEntry{Code: exectrace.LogCodeReadPidNS})
+	handler(exectrace.LogEntry{Code: exectrace.LogCodeReserveEvent})
+	m.registerLostSamples(lostSamplesTracer{lost: []uint64{0, 3}})
+	// Tracers that don't track lost samples don't export the metric.
+	m.registerLostSamples(lostSamplesTracer{})
+
+	srv := httptest.NewServer(m.handler())
+	defer srv.Close()
+	res, err := http.Get(srv.URL)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	expected := []string{
+		`exectrace_execs_total 5`,
+		`exectrace_execs_by_comm_total{comm="bash"} 2`,
+		`exectrace_execs_by_comm_total{comm="sh"} 1`,
+		`exectrace_execs_by_comm_total{comm="__other__"} 2`,
+		`exectrace_execs_by_uid_total{uid="1000"} 2`,
+		`exectrace_execs_by_uid_total{uid="0"} 1`,
+		`exectrace_execs_by_uid_total{uid="__other__"} 2`,
+		`exectrace_execs_by_filename_total{filename="/usr/bin/a"} 2`,
+		`exectrace_execs_by_filename_total{filename="/usr/bin/b"} 1`,
+		`exectrace_execs_by_filename_total{filename="__other__"} 2`,
+		`exectrace_truncated_events_total 1`,
+		`exectrace_redacted_events_total 1`,
+		`exectrace_kernel_log_lines_total 2`,
+		`exectrace_dropped_events_total 1`,
+		`exectrace_read_errors_total 0`,
+		`exectrace_write_errors_total 0`,
+		`exectrace_lost_samples_total{cpu="0"} 0`,
+		`exectrace_lost_samples_total{cpu="1"} 3`,
+	}
+	for _, line := range expected {
+		require.Contains(t, string(body), line+"\n")
+	}
+	require.Len(t, logged, 2)
+
+	// Label values past the cap don't create new series.
+	require.Equal(t, 3, testutil.CollectAndCount(m.execsByComm.vec))
+}
+
+// lostSamplesTracer is a tracer that only reports lost samples.
+type lostSamplesTracer struct {
+	exectrace.Tracer
+	lost []uint64
+}
+
+func (t lostSamplesTracer) LostSamples() []uint64 {
+	return t.lost
+}
+
+func TestServeMetrics(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Reserve a free port.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	m := newMetrics(10)
+	m.readErrors.Inc()
+	err = serveMetrics(ctx, addr, m)
+	require.NoError(t, err)
+
+	res, err := http.Get("http://" + addr + "/metrics")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.True(t, strings.Contains(string(body), "exectrace_read_errors_total 1\n"))
+	require.Contains(t, string(body), "go_goroutines")
+
+	// The address is in use now.
+	err = serveMetrics(ctx, addr, newMetrics(10))
+	require.Error(t, err)
+}
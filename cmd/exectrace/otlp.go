@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/coder/exectrace"
+)
+
+const (
+	otlpScopeName = "github.com/coder/exectrace"
+	// otlpSeverityInfo is the OpenTelemetry severity number for INFO.
+	otlpSeverityInfo = 9
+
+	// otlpBatchSize is the maximum number of log records sent in a single
+	// export request.
+	otlpBatchSize = 512
+	// otlpFlushInterval is the maximum amount of time a log record is buffered
+	// before being exported.
+	otlpFlushInterval = 5 * time.Second
+	// otlpExportTimeout is the timeout for each export request.
+	otlpExportTimeout = 10 * time.Second
+	// otlpQueueSize is the maximum number of batches waiting to be exported.
+	otlpQueueSize = 16
+)
+
+// The following types are the OTLP/JSON encoding of an
+// ExportLogsServiceRequest. Only the fields used by exectrace are included.
+//
+// See https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+
+type otlpLogsRequest struct {
+	ResourceLogs []otlpResourceLogs `json:"resourceLogs"`
+}
+
+type otlpResourceLogs struct {
+	Resource  otlpResource    `json:"resource"`
+	ScopeLogs []otlpScopeLogs `json:"scopeLogs"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeLogs struct {
+	Scope      otlpScope       `json:"scope"`
+	LogRecords []otlpLogRecord `json:"logRecords"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type otlpLogRecord struct {
+	TimeUnixNano         string         `json:"timeUnixNano"`
+	ObservedTimeUnixNano string         `json:"observedTimeUnixNano"`
+	SeverityNumber       int            `json:"severityNumber"`
+	SeverityText         string         `json:"severityText"`
+	Body                 otlpAnyValue   `json:"body"`
+	Attributes           []otlpKeyValue `json:"attributes"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+// otlpAnyValue is a union, only one field should be set. 64-bit integers are
+// encoded as strings in OTLP/JSON.
+type otlpAnyValue struct {
+	StringValue *string         `json:"stringValue,omitempty"`
+	BoolValue   *bool           `json:"boolValue,omitempty"`
+	IntValue    *string         `json:"intValue,omitempty"`
+	ArrayValue  *otlpArrayValue `json:"arrayValue,omitempty"`
+}
+
+type otlpArrayValue struct {
+	Values []otlpAnyValue `json:"values"`
+}
+
+func otlpString(s string) otlpAnyValue {
+	return otlpAnyValue{StringValue: &s}
+}
+
+func otlpBool(b bool) otlpAnyValue {
+	return otlpAnyValue{BoolValue: &b}
+}
+
+func otlpInt(i uint32) otlpAnyValue {
+	s := strconv.FormatUint(uint64(i), 10)
+	return otlpAnyValue{IntValue: &s}
+}
+
+func otlpStrings(strs []string) otlpAnyValue {
+	values := make([]otlpAnyValue, len(strs))
+	for i, s := range strs {
+		values[i] = otlpString(s)
+	}
+	return otlpAnyValue{ArrayValue: &otlpArrayValue{Values: values}}
+}
+
+// otlpFormatter maps events onto OpenTelemetry log records using the process
+// semantic conventions. Fields without a semantic convention are put in the
+// "exectrace." namespace.
+//
+// See https://opentelemetry.io/docs/specs/semconv/attributes-registry/process/
+type otlpFormatter struct {
+	resource otlpResource
+	scope    otlpScope
+}
+
+func newOTLPFormatter() *otlpFormatter {
+	return &otlpFormatter{
+		resource: otlpResource{
+			Attributes: []otlpKeyValue{
+				{Key: "service.name", Value: otlpString("exectrace")},
+				{Key: "host.name", Value: otlpString(hostname())},
+			},
+		},
+		scope: otlpScope{
+			Name:    otlpScopeName,
+			Version: version(),
+		},
+	}
+}
+
+func (*otlpFormatter) logRecord(event *exectrace.Event, now time.Time) otlpLogRecord {
+	ts := strconv.FormatInt(now.UnixNano(), 10)
+	argv := event.Argv
+	if argv == nil {
+		argv = []string{}
+	}
+	return otlpLogRecord{
+		TimeUnixNano:         ts,
+		ObservedTimeUnixNano: ts,
+		SeverityNumber:       otlpSeverityInfo,
+		SeverityText:         "INFO",
+		Body:                 otlpString(strings.Join(event.Argv, " ")),
+		Attributes: []otlpKeyValue{
+			{Key: "event.name", Value: otlpString("exectrace.exec")},
+			{Key: "process.executable.path", Value: otlpString(event.Filename)},
+			{Key: "process.executable.name", Value: otlpString(filepath.Base(event.Filename))},
+			{Key: "process.command_args", Value: otlpStrings(argv)},
+			{Key: "process.command_line", Value: otlpString(strings.Join(event.Argv, " "))},
+			{Key: "process.pid", Value: otlpInt(event.PID)},
+			{Key: "process.parent_pid", Value: otlpInt(event.PPID)},
+			{Key: "process.user.id", Value: otlpInt(event.UID)},
+			{Key: "exectrace.gid", Value: otlpInt(event.GID)},
+			{Key: "exectrace.comm", Value: otlpString(event.Comm)},
+			{Key: "exectrace.truncated", Value: otlpBool(event.Truncated)},
+			{Key: "exectrace.redacted", Value: otlpBool(event.Redacted)},
+			{Key: "exectrace.reliability", Value: otlpString(string(event.Reliability))},
+		},
+	}
+}
+
+func (f *otlpFormatter) request(records []otlpLogRecord) otlpLogsRequest {
+	return otlpLogsRequest{
+		ResourceLogs: []otlpResourceLogs{{
+			Resource: f.resource,
+			ScopeLogs: []otlpScopeLogs{{
+				Scope:      f.scope,
+				LogRecords: records,
+			}},
+		}},
+	}
+}
+
+// formatEvent formats the event as a single line of OTLP/JSON containing an
+// ExportLogsServiceRequest with one log record. This is the same format the
+// OpenTelemetry Collector file exporter and receiver use.
+func (f *otlpFormatter) formatEvent(event *exectrace.Event, now time.Time) ([]byte, error) {
+	return json.Marshal(f.request([]otlpLogRecord{f.logRecord(event, now)}))
+}
+
+// otlpExporter batches events and exports them to an OTLP/HTTP collector.
+// Batches are exported by a background goroutine so a slow collector doesn't
+// block reading events. If the collector falls too far behind, batches are
+// dropped and counted instead.
+type otlpExporter struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+	format  *otlpFormatter
+
+	batchSize int
+
+	mu       sync.Mutex
+	pending  []otlpLogRecord
+	isClosed bool
+
+	queue chan []otlpLogRecord
+	// dropped is the number of records that were not exported because the
+	// queue was full or the export failed.
+	dropped atomic.Uint64
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	tickDone  chan struct{}
+	sendDone  chan struct{}
+}
+
+var _ eventWriter = &otlpExporter{}
+
+// newOTLPExporter creates an exporter that sends logs to the /v1/logs path of
+// the given endpoint. Records are exported when batchSize records are pending
+// or every flushInterval, whichever comes first. At most queueSize batches wait
+// to be exported at a time.
+func newOTLPExporter(endpoint string, headers map[string]string, batchSize int, flushInterval time.Duration, queueSize int) (*otlpExporter, error) {
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		return nil, xerrors.Errorf("OTLP endpoint %q must start with http:// or https://", endpoint)
+	}
+
+	e := &otlpExporter{
+		url:       strings.TrimSuffix(endpoint, "/") + "/v1/logs",
+		headers:   headers,
+		client:    &http.Client{Timeout: otlpExportTimeout},
+		format:    newOTLPFormatter(),
+		batchSize: batchSize,
+		queue:     make(chan []otlpLogRecord, queueSize),
+		closed:    make(chan struct{}),
+		tickDone:  make(chan struct{}),
+		sendDone:  make(chan struct{}),
+	}
+	go e.flushLoop(flushInterval)
+	go e.sendLoop()
+
+	return e, nil
+}
+
+func (e *otlpExporter) flushLoop(interval time.Duration) {
+	defer close(e.tickDone)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.closed:
+			return
+		case <-ticker.C:
+		}
+
+		e.flushPending()
+	}
+}
+
+func (e *otlpExporter) sendLoop() {
+	defer close(e.sendDone)
+
+	for records := range e.queue {
+		err := e.export(context.Background(), records)
+		if err != nil {
+			// The records are dropped, but we keep going so a temporary
+			// collector outage doesn't stop the exporter.
+			e.dropped.Add(uint64(len(records)))
+			log.Printf("error exporting events to OTLP collector: %+v", err)
+		}
+	}
+}
+
+// flushPending queues the pending records for export.
+func (e *otlpExporter) flushPending() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.enqueueLocked()
+}
+
+// enqueueLocked hands the pending records to the send loop without blocking.
+// The records are dropped if the queue is full. e.mu must be held.
+func (e *otlpExporter) enqueueLocked() {
+	records := e.pending
+	if len(records) == 0 || e.isClosed {
+		return
+	}
+	e.pending = nil
+	select {
+	case e.queue <- records:
+	default:
+		total := e.dropped.Add(uint64(len(records)))
+		log.Printf("OTLP export queue is full, dropped %d events (%d in total)", len(records), total)
+	}
+}
+
+// Dropped returns the number of events that were not exported because the
+// queue was full or the export failed.
+func (e *otlpExporter) Dropped() uint64 {
+	return e.dropped.Load()
+}
+
+// WriteEvent buffers the event and queues the batch for export if it is full.
+func (e *otlpExporter) WriteEvent(event *exectrace.Event, ts time.Time) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.isClosed {
+		return xerrors.New("exporter is closed")
+	}
+	e.pending = append(e.pending, e.format.logRecord(event, ts))
+	if len(e.pending) >= e.batchSize {
+		e.enqueueLocked()
+	}
+	return nil
+}
+
+// export sends records to the collector.
+func (e *otlpExporter) export(ctx context.Context, records []otlpLogRecord) error {
+	body, err := json.Marshal(e.format.request(records))
+	if err != nil {
+		return xerrors.Errorf("marshal OTLP request: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, otlpExportTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return xerrors.Errorf("create OTLP request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := e.client.Do(req)
+	if err != nil {
+		return xerrors.Errorf("export %d records to %q: %w", len(records), e.url, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return xerrors.Errorf("export %d records to %q: unexpected status code %d: %s", len(records), e.url, res.StatusCode, msg)
+	}
+	// Drain the body so the connection can be reused.
+	_, _ = io.Copy(io.Discard, res.Body)
+
+	return nil
+}
+
+// Close stops the flush loop and waits until all queued and pending records
+// are exported.
+func (e *otlpExporter) Close() error {
+	e.closeOnce.Do(func() {
+		close(e.closed)
+		<-e.tickDone
+
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		e.isClosed = true
+		// Nothing else sends to the queue after this, so the pending records
+		// can wait for space instead of being dropped.
+		if len(e.pending) > 0 {
+			e.queue <- e.pending
+			e.pending = nil
+		}
+		close(e.queue)
+	})
+	<-e.sendDone
+	if dropped := e.Dropped(); dropped > 0 {
+		return xerrors.Errorf("%d events could not be exported to the OTLP collector", dropped)
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatOTLP(t *testing.T) {
+	t.Parallel()
+
+	msg, err := newOTLPFormatter().formatEvent(testEvent, testTime)
+	require.NoError(t, err)
+
+	var req otlpLogsRequest
+	err = json.Unmarshal(msg, &req)
+	require.NoError(t, err)
+	require.Len(t, req.ResourceLogs, 1)
+	require.Len(t, req.ResourceLogs[0].ScopeLogs, 1)
+	records := req.ResourceLogs[0].ScopeLogs[0].LogRecords
+	require.Len(t, records, 1)
+	record := records[0]
+
+	require.Equal(t, "1714979289123456000", record.TimeUnixNano)
+	require.Equal(t, otlpSeverityInfo, record.SeverityNumber)
+	attrs := map[string]otlpAnyValue{}
+	for _, kv := range record.Attributes {
+		attrs[kv.Key] = kv.Value
+	}
+	require.Equal(t, "/usr/bin/echo", *attrs["process.executable.path"].StringValue)
+	require.Equal(t, "echo", *attrs["process.executable.name"].StringValue)
+	require.Equal(t, "1234", *attrs["process.pid"].IntValue)
+	require.Equal(t, "1000", *attrs["process.user.id"].IntValue)
+	require.True(t, *attrs["exectrace.truncated"].BoolValue)
+	require.Len(t, attrs["process.command_args"].ArrayValue.Values, len(testEvent.Argv))
+	require.Equal(t, testEvent.Argv[1], *attrs["process.command_args"].ArrayValue.Values[1].StringValue)
+}
+
+// fakeCollector is a stand-in for an OpenTelemetry Collector's OTLP/HTTP
+// receiver.
+type fakeCollector struct {
+	mu       sync.Mutex
+	requests []otlpLogsRequest
+	headers  []http.Header
+	status   int
+}
+
+func (c *fakeCollector) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if r.Method != http.MethodPost || r.URL.Path != "/v1/logs" || r.Header.Get("Content-Type") != "application/json" {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if c.status != 0 {
+		rw.WriteHeader(c.status)
+		return
+	}
+
+	var req otlpLogsRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	c.requests = append(c.requests, req)
+	c.headers = append(c.headers, r.Header.Clone())
+	rw.Header().Set("Content-Type", "application/json")
+	_, _ = rw.Write([]byte("{}"))
+}
+
+func (c *fakeCollector) records() []otlpLogRecord {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var records []otlpLogRecord
+	for _, req := range c.requests {
+		for _, rl := range req.ResourceLogs {
+			for _, sl := range rl.ScopeLogs {
+				records = append(records, sl.LogRecords...)
+			}
+		}
+	}
+	return records
+}
+
+func TestOTLPExporter(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Batch", func(t *testing.T) {
+		t.Parallel()
+
+		collector := &fakeCollector{}
+		srv := httptest.NewServer(collector)
+		defer srv.Close()
+
+		e, err := newOTLPExporter(srv.URL, map[string]string{"Authorization": "Bearer abc"}, 3, time.Hour, 1)
+		require.NoError(t, err)
+
+		for i := 0; i < 4; i++ {
+			ev := *testEvent
+			ev.PID = uint32(i)
+			err = e.WriteEvent(&ev, testTime)
+			require.NoError(t, err)
+		}
+		// The first 3 events should have been sent as a batch.
+		require.Eventually(t, func() bool {
+			return len(collector.records()) == 3
+		}, 5*time.Second, 10*time.Millisecond)
+		collector.mu.Lock()
+		require.Len(t, collector.requests, 1)
+		require.Equal(t, "Bearer abc", collector.headers[0].Get("Authorization"))
+		collector.mu.Unlock()
+
+		// Closing flushes the rest.
+		err = e.Close()
+		require.NoError(t, err)
+		records := collector.records()
+		require.Len(t, records, 4)
+		for i, record := range records {
+			for _, kv := range record.Attributes {
+				if kv.Key == "process.pid" {
+					require.Equal(t, strconv.Itoa(i), *kv.Value.IntValue)
+				}
+			}
+		}
+	})
+
+	t.Run("Interval", func(t *testing.T) {
+		t.Parallel()
+
+		collector := &fakeCollector{}
+		srv := httptest.NewServer(collector)
+		defer srv.Close()
+
+		e, err := newOTLPExporter(srv.URL+"/", nil, 100, 10*time.Millisecond, 1)
+		require.NoError(t, err)
+		defer e.Close()
+
+		err = e.WriteEvent(testEvent, testTime)
+		require.NoError(t, err)
+		require.Eventually(t, func() bool {
+			return len(collector.records()) == 1
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		t.Parallel()
+
+		collector := &fakeCollector{status: http.StatusServiceUnavailable}
+		srv := httptest.NewServer(collector)
+		defer srv.Close()
+
+		e, err := newOTLPExporter(srv.URL, nil, 1, time.Hour, 1)
+		require.NoError(t, err)
+
+		// Export errors don't fail writes, the records are counted as dropped.
+		err = e.WriteEvent(testEvent, testTime)
+		require.NoError(t, err)
+		err = e.Close()
+		require.ErrorContains(t, err, "1 events could not be exported")
+		require.EqualValues(t, 1, e.Dropped())
+	})
+
+	t.Run("QueueFull", func(t *testing.T) {
+		t.Parallel()
+
+		collector := &fakeCollector{}
+		started := make(chan struct{}, 10)
+		unblock := make(chan struct{})
+		srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			started <- struct{}{}
+			<-unblock
+			collector.ServeHTTP(rw, r)
+		}))
+		defer srv.Close()
+
+		e, err := newOTLPExporter(srv.URL, nil, 1, time.Hour, 1)
+		require.NoError(t, err)
+
+		// The first batch is being exported and blocks, the second batch waits
+		// in the queue and the third is dropped without blocking the writer.
+		require.NoError(t, e.WriteEvent(testEvent, testTime))
+		<-started
+		require.NoError(t, e.WriteEvent(testEvent, testTime))
+		require.NoError(t, e.WriteEvent(testEvent, testTime))
+		require.EqualValues(t, 1, e.Dropped())
+
+		close(unblock)
+		err = e.Close()
+		require.ErrorContains(t, err, "1 events could not be exported")
+		require.Len(t, collector.records(), 2)
+
+		err = e.WriteEvent(testEvent, testTime)
+		require.Error(t, err)
+	})
+
+	t.Run("Options", func(t *testing.T) {
+		t.Parallel()
+
+		w, err := newEventWriter(outputOptions{
+			Format:         outputOTLP,
+			SyslogFacility: "user",
+			OTLPEndpoint:   "http://localhost:4318",
+			OTLPHeaders:    []string{"Authorization=Bearer abc"},
+		}, nil)
+		require.NoError(t, err)
+		e, ok := w.(*otlpExporter)
+		require.True(t, ok)
+		require.Equal(t, "http://localhost:4318/v1/logs", e.url)
+		require.Equal(t, map[string]string{"Authorization": "Bearer abc"}, e.headers)
+		require.NoError(t, e.Close())
+
+		_, err = newOTLPExporter("localhost:4318", nil, 1, time.Hour, 1)
+		require.Error(t, err)
+		_, err = newEventWriter(outputOptions{
+			Format:         outputJSON,
+			SyslogFacility: "user",
+			OTLPEndpoint:   "http://localhost:4318",
+		}, nil)
+		require.Error(t, err)
+		_, err = newEventWriter(outputOptions{
+			Format:         outputOTLP,
+			SyslogFacility: "user",
+			OTLPEndpoint:   "http://localhost:4318",
+			OTLPHeaders:    []string{"invalid"},
+		}, nil)
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/kballard/go-shellquote"
+	"golang.org/x/xerrors"
+
+	"github.com/coder/exectrace"
+)
+
+const (
+	outputText   = "text"
+	outputJSON   = "json"
+	outputSyslog = "syslog"
+	outputCEF    = "cef"
+	outputECS    = "ecs"
+	outputOTLP   = "otlp"
+	// outputTemplate formats events with the Go text/template in
+	// outputOptions.Template.
+	outputTemplate = "template"
+)
+
+// outputFormats contains all supported values for the --output flag.
+var outputFormats = []string{outputText, outputJSON, outputSyslog, outputCEF, outputECS, outputOTLP, outputTemplate}
+
+// eventFormatter formats a single event as a message. The returned message
+// must not contain a trailing newline, as framing is handled by the writer.
+type eventFormatter func(event *exectrace.Event, now time.Time) ([]byte, error)
+
+// eventWriter writes events to an output.
+type eventWriter interface {
+	// WriteEvent writes an event that occurred at the given time.
+	WriteEvent(event *exectrace.Event, ts time.Time) error
+	// Close flushes any buffered events and closes the output.
+	Close() error
+}
+
+// formatWriter formats events and writes each one to w with a single call to
+// w.Write, so message-oriented writers (e.g. syslog over UDP) receive exactly
+// one event per message.
+type formatWriter struct {
+	format  eventFormatter
+	w       io.Writer
+	closeFn func() error
+}
+
+var _ eventWriter = &formatWriter{}
+
+func (e *formatWriter) WriteEvent(event *exectrace.Event, ts time.Time) error {
+	msg, err := e.format(event, ts)
+	if err != nil {
+		return xerrors.Errorf("format event: %w", err)
+	}
+	_, err = e.w.Write(msg)
+	if err != nil {
+		return xerrors.Errorf("write event: %w", err)
+	}
+	return nil
+}
+
+func (e *formatWriter) Close() error {
+	if e.closeFn == nil {
+		return nil
+	}
+	return e.closeFn()
+}
+
+// lineWriter appends a newline to each write.
+type lineWriter struct {
+	w io.Writer
+}
+
+func (l lineWriter) Write(p []byte) (int, error) {
+	n, err := l.w.Write(append(p, '\n'))
+	if n > len(p) {
+		n = len(p)
+	}
+	return n, err
+}
+
+// outputOptions configures how and where events are written.
+type outputOptions struct {
+	// Format is one of outputFormats.
+	Format string
+	// Template is the text/template used by the template format.
+	Template string
+	// SyslogAddr is the address of a syslog server to send events to instead
+	// of stdout. Only valid with the syslog and cef formats.
+	SyslogAddr string
+	// SyslogFacility is the syslog facility name used in syslog headers.
+	SyslogFacility string
+	// OTLPEndpoint is the base URL of an OTLP/HTTP collector to export events
+	// to instead of stdout. Only valid with the otlp format.
+	OTLPEndpoint string
+	// OTLPHeaders are extra HTTP headers sent with each OTLP export request,
+	// in the form key=value.
+	OTLPHeaders []string
+	// File is the path of a file to write events to instead of stdout.
+	File string
+	// FileRotate configures rotation of File.
+	FileRotate rotateOptions
+}
+
+// newEventWriter creates an eventWriter for the given options. Events are
+// written to stdout unless the options specify a different destination. The
+// returned writer must be closed when finished.
+func newEventWriter(opts outputOptions, stdout io.Writer) (eventWriter, error) {
+	facility, ok := syslogFacilities[opts.SyslogFacility]
+	if !ok {
+		return nil, xerrors.Errorf("unknown syslog facility %q", opts.SyslogFacility)
+	}
+	sys := newSyslogFormatter(facility)
+
+	destinations := 0
+	for _, d := range []string{opts.SyslogAddr, opts.OTLPEndpoint, opts.File} {
+		if d != "" {
+			destinations++
+		}
+	}
+	if destinations > 1 {
+		return nil, xerrors.New("only one of --syslog-addr, --otlp-endpoint and --output-file can be used")
+	}
+
+	var format eventFormatter
+	switch opts.Format {
+	case outputText:
+		format = formatText
+	case outputJSON:
+		format = formatJSON
+	case outputSyslog:
+		format = sys.formatEvent
+	case outputCEF:
+		format = newCEFFormatter().formatEvent
+	case outputECS:
+		format = newECSFormatter().formatEvent
+	case outputOTLP:
+		format = newOTLPFormatter().formatEvent
+	case outputTemplate:
+		if opts.Template == "" {
+			return nil, xerrors.Errorf("--template or --template-file is required with the %q output format", outputTemplate)
+		}
+		f, err := newTemplateFormatter(opts.Template)
+		if err != nil {
+			return nil, err
+		}
+		format = f.formatEvent
+	default:
+		return nil, xerrors.Errorf("output format must be one of %q, got %q", outputFormats, opts.Format)
+	}
+	if opts.Template != "" && opts.Format != outputTemplate {
+		return nil, xerrors.Errorf("--template and --template-file can only be used with the %q output format", outputTemplate)
+	}
+
+	if opts.OTLPEndpoint != "" {
+		if opts.Format != outputOTLP {
+			return nil, xerrors.Errorf("--otlp-endpoint can only be used with the %q output format", outputOTLP)
+		}
+		headers, err := parseHeaders(opts.OTLPHeaders)
+		if err != nil {
+			return nil, err
+		}
+		return newOTLPExporter(opts.OTLPEndpoint, headers, otlpBatchSize, otlpFlushInterval, otlpQueueSize)
+	}
+
+	if opts.File != "" {
+		f, err := openRotatingFile(opts.File, opts.FileRotate)
+		if err != nil {
+			return nil, err
+		}
+		return &formatWriter{format: format, w: lineWriter{w: f}, closeFn: f.Close}, nil
+	}
+
+	if opts.SyslogAddr == "" {
+		return &formatWriter{format: format, w: lineWriter{w: stdout}}, nil
+	}
+
+	switch opts.Format {
+	case outputSyslog:
+	case outputCEF:
+		// CEF is conventionally carried in the message of a syslog header.
+		format = sys.wrap(format)
+	default:
+		return nil, xerrors.Errorf("--syslog-addr can only be used with the %q and %q output formats", outputSyslog, outputCEF)
+	}
+	w, err := dialSyslog(opts.SyslogAddr)
+	if err != nil {
+		return nil, err
+	}
+	return &formatWriter{format: format, w: w, closeFn: w.Close}, nil
+}
+
+// parseHeaders parses a list of key=value pairs.
+func parseHeaders(raw []string) (map[string]string, error) {
+	headers := make(map[string]string, len(raw))
+	for _, h := range raw {
+		parts := strings.SplitN(h, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, xerrors.Errorf("invalid header %q, must be in the form key=value", h)
+		}
+		headers[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return headers, nil
+}
+
+func formatText(event *exectrace.Event, _ time.Time) ([]byte, error) {
+	ellipsis := ""
+	if event.Truncated {
+		ellipsis = "..."
+	}
+
+	return []byte(fmt.Sprintf(
+		"[%v, comm=%q, uid=%v, gid=%v, filename=%v] %v%v",
+		event.PID, event.Comm, event.UID, event.GID, event.Filename,
+		shellquote.Join(event.Argv...), ellipsis,
+	)), nil
+}
+
+// formatJSON formats the event as JSON with the time it was read in the "time"
+// field, which is the same as {{json .}} in the template format.
+func formatJSON(event *exectrace.Event, now time.Time) ([]byte, error) {
+	return json.Marshal(templateEvent{Event: event, Time: now})
+}
+
+// escapeControl replaces newlines, carriage returns and tabs with their
+// escaped forms so they can't break line-oriented output.
+var escapeControl = strings.NewReplacer(
+	"\n", `\n`,
+	"\r", `\r`,
+	"\t", `\t`,
+).Replace
+
+// hostname returns the hostname of the machine, or "-" if it can't be
+// determined.
+func hostname() string {
+	h, err := os.Hostname()
+	if err != nil || h == "" {
+		return "-"
+	}
+	return h
+}
+
+// version returns the module version of the binary, or "dev".
+func version() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok || info.Main.Version == "" || info.Main.Version == "(devel)" {
+		return "dev"
+	}
+	return info.Main.Version
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/spf13/cobra"
+	"golang.org/x/xerrors"
+
+	"github.com/coder/exectrace"
+)
+
+func recordCmd() *cobra.Command {
+	var (
+		tracer tracerFlags
+		path   string
+		format string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "record",
+		Short: "Record exec events with timestamps to a trace file that can be replayed later.",
+		Args:  cobra.NoArgs,
+		Run: func(_ *cobra.Command, _ []string) {
+			tracerOpts, err := tracer.tracerOpts()
+			if err != nil {
+				//nolint:revive
+				log.Fatalf("invalid tracer options: %+v", err)
+			}
+
+			err = record(tracerOpts, path, exectrace.TraceFormat(format))
+			if err != nil {
+				//nolint:revive
+				log.Fatalf("record: %+v", err)
+			}
+		},
+	}
+
+	tracer.register(cmd)
+	cmd.Flags().StringVarP(&path, "output", "o", "", "Path of the trace file to write, the file is truncated if it exists")
+	cmd.Flags().StringVar(&format, "format", string(exectrace.TraceFormatBinary), `Trace file format, either "binary" or "ndjson"`)
+	_ = cmd.MarkFlagRequired("output")
+
+	return cmd
+}
+
+func record(tracerOpts *exectrace.TracerOpts, path string, format exectrace.TraceFormat) (err error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o640)
+	if err != nil {
+		return xerrors.Errorf("create trace file: %w", err)
+	}
+	defer func() {
+		closeErr := f.Close()
+		if closeErr != nil {
+			err = multierror.Append(err, xerrors.Errorf("close trace file: %w", closeErr))
+		}
+	}()
+
+	w, err := exectrace.NewTraceWriter(f, format)
+	if err != nil {
+		return xerrors.Errorf("create trace writer: %w", err)
+	}
+
+	t, err := exectrace.New(tracerOpts)
+	if err != nil {
+		return xerrors.Errorf("start tracer: %w", err)
+	}
+	defer t.Close()
+	closeOnSignal(t)
+
+	log.Printf("Recording events to %s..", path)
+	count := 0
+	for {
+		event, err := t.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			log.Printf("error reading from reader: %+v", err)
+			continue
+		}
+
+		err = w.WriteRecord(exectrace.TraceRecord{
+			Time:  time.Now(),
+			Event: event,
+		})
+		if err == nil {
+			// Flush each record so the trace is usable if we're killed.
+			err = w.Flush()
+		}
+		if err != nil {
+			return xerrors.Errorf("write trace record: %w", err)
+		}
+		count++
+	}
+
+	log.Printf("Recorded %d events", count)
+	return nil
+}
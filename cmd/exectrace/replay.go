@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/xerrors"
+
+	"github.com/coder/exectrace"
+)
+
+func replayCmd() *cobra.Command {
+	var (
+		output     outputFlags
+		speed      float64
+		filterExpr string
+		redact     bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "replay <trace file>",
+		Short: "Replay events from a trace file recorded with \"exectrace record\" through the output formats.",
+		Long: "Replay events from a trace file recorded with \"exectrace record\" through the output formats. " +
+			"Events are written with the time they were recorded. Root and eBPF support are not required.",
+		Args: cobra.ExactArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			opts := &exectrace.ReplayOpts{
+				Speed: speed,
+			}
+			if filterExpr != "" {
+				filter, err := exectrace.ParseFilter(filterExpr)
+				if err != nil {
+					//nolint:revive
+					log.Fatalf("invalid filter: %+v", err)
+				}
+				opts.Filter = filter
+			}
+			if redact {
+				opts.Redact = &exectrace.RedactOpts{}
+			}
+
+			outputOpts, err := output.outputOptions()
+			if err != nil {
+				//nolint:revive
+				log.Fatalf("invalid output options: %+v", err)
+			}
+
+			err = replay(args[0], opts, outputOpts, os.Stdout)
+			if err != nil {
+				//nolint:revive
+				log.Fatalf("replay: %+v", err)
+			}
+		},
+	}
+
+	output.register(cmd)
+	cmd.Flags().Float64Var(&speed, "speed", 0, "Replay speed relative to the recording, e.g. 1 for real time or 10 for ten times faster (0 replays as fast as possible)")
+	cmd.Flags().StringVar(&filterExpr, "filter", "", `Only replay events matching this expression, e.g. 'uid >= 1000 && filename matches "^/tmp/"'`)
+	cmd.Flags().BoolVar(&redact, "redact", false, "Redact secrets such as passwords and tokens from argv (use this if the trace was recorded without redaction)")
+
+	return cmd
+}
+
+func replay(path string, opts *exectrace.ReplayOpts, output outputOptions, stdout io.Writer) error {
+	t, err := exectrace.OpenReplayTracer(path, opts)
+	if err != nil {
+		return xerrors.Errorf("open trace: %w", err)
+	}
+	defer t.Close()
+	closeOnSignal(t)
+
+	w, err := newEventWriter(output, stdout)
+	if err != nil {
+		return xerrors.Errorf("create output writer: %w", err)
+	}
+	defer func() {
+		err := w.Close()
+		if err != nil {
+			log.Printf("error closing output writer: %+v", err)
+		}
+	}()
+
+	for {
+		rec, err := t.ReadRecord()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		err = w.WriteEvent(rec.Event, rec.Time)
+		if err != nil {
+			log.Printf("error writing event: %+v", err)
+			continue
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/coder/exectrace"
+)
+
+func TestReplay(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "trace.bin")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	w, err := exectrace.NewTraceWriter(f, exectrace.TraceFormatBinary)
+	require.NoError(t, err)
+	other := *testEvent
+	other.Comm = "sh"
+	for i, ev := range []*exectrace.Event{testEvent, &other} {
+		err = w.WriteRecord(exectrace.TraceRecord{
+			Time:  testTime.Add(time.Duration(i) * time.Hour),
+			Event: ev,
+		})
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Flush())
+	require.NoError(t, f.Close())
+
+	var buf bytes.Buffer
+	err = replay(path, &exectrace.ReplayOpts{
+		Filter: exectrace.MustParseFilter(`comm == "sh"`),
+	}, outputOptions{
+		Format:         outputECS,
+		SyslogFacility: "user",
+	}, &buf)
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	require.Len(t, lines, 1)
+	var got map[string]interface{}
+	err = json.Unmarshal([]byte(lines[0]), &got)
+	require.NoError(t, err)
+	// Events are written with the time they were recorded.
+	require.Equal(t, "2024-05-06T08:08:09.123456Z", got["@timestamp"])
+	require.Equal(t, "sh", get(t, got, "process", "parent", "name"))
+
+	err = replay(filepath.Join(t.TempDir(), "missing.bin"), nil, outputOptions{
+		Format:         outputJSON,
+		SyslogFacility: "user",
+	}, &buf)
+	require.Error(t, err)
+}
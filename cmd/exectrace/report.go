@@ -0,0 +1,645 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kballard/go-shellquote"
+	"github.com/spf13/cobra"
+	"golang.org/x/xerrors"
+
+	"github.com/coder/exectrace"
+)
+
+const (
+	reportMarkdown = "markdown"
+	reportHTML     = "html"
+	reportJSON     = "json"
+
+	// reportMaxBuckets is the maximum number of buckets in the timeline,
+	// which limits the output size when the bucket size is set explicitly.
+	reportMaxBuckets = 10000
+	// reportBarWidth is the width of the longest timeline bar in the
+	// Markdown output.
+	reportBarWidth = 40
+)
+
+// reportFormats contains all supported values for the --format flag of the
+// report command.
+var reportFormats = []string{reportMarkdown, reportHTML, reportJSON}
+
+// reportBucketSizes are the timeline bucket sizes chosen from automatically,
+// the smallest one with at most reportAutoBuckets buckets is used.
+var reportBucketSizes = []time.Duration{
+	time.Minute,
+	5 * time.Minute,
+	15 * time.Minute,
+	30 * time.Minute,
+	time.Hour,
+	3 * time.Hour,
+	6 * time.Hour,
+	12 * time.Hour,
+	24 * time.Hour,
+	7 * 24 * time.Hour,
+}
+
+const reportAutoBuckets = 48
+
+func reportCmd() *cobra.Command {
+	var opts reportOptions
+
+	cmd := &cobra.Command{
+		Use:   "report <file>...",
+		Short: "Summarize recorded events in a Markdown, HTML or JSON report.",
+		Long: "Summarize events from files written with \"exectrace --output json\" or \"exectrace record\" in a " +
+			"Markdown, HTML or JSON report, with the top binaries and command lines, execs per user, first-seen " +
+			"binaries, truncated events and a timeline. Use - to read from stdin. Gzipped files are decompressed " +
+			"automatically.\n\n" +
+			"The timeline and event times use the \"time\" field of JSON lines and the record times of trace files. " +
+			"Events from older JSON logs without timestamps are only counted.",
+		Args: cobra.MinimumNArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			err := runReport(args, opts, os.Stdin, os.Stdout)
+			if err != nil {
+				//nolint:revive
+				log.Fatalf("report: %+v", err)
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.Format, "format", reportMarkdown, "Report format, one of "+strings.Join(reportFormats, ", "))
+	cmd.Flags().IntVar(&opts.Limit, "limit", 25, "Maximum number of rows in each list (0 shows all rows)")
+	cmd.Flags().DurationVar(&opts.Bucket, "bucket", 0, "Size of each timeline bucket, e.g. 1h (chosen automatically by default)")
+
+	return cmd
+}
+
+// reportOptions configures the report command.
+type reportOptions struct {
+	// Format is one of reportFormats.
+	Format string
+	// Limit is the maximum number of rows in each list, or 0 for no limit.
+	Limit int
+	// Bucket is the size of each timeline bucket, or 0 to choose one
+	// automatically.
+	Bucket time.Duration
+}
+
+func runReport(paths []string, opts reportOptions, stdin io.Reader, stdout io.Writer) error {
+	var write func(io.Writer, *execReport) error
+	switch opts.Format {
+	case reportMarkdown:
+		write = writeReportMarkdown
+	case reportHTML:
+		write = writeReportHTML
+	case reportJSON:
+		write = writeReportJSON
+	default:
+		return xerrors.Errorf("report format must be one of %q, got %q", reportFormats, opts.Format)
+	}
+	if opts.Limit < 0 {
+		return xerrors.New("--limit must not be negative")
+	}
+
+	b := newReportBuilder()
+	for _, path := range paths {
+		var err error
+		if path == "-" {
+			err = b.Read(stdin)
+		} else {
+			err = b.ReadFile(path)
+		}
+		if err != nil {
+			return xerrors.Errorf("read %q: %w", path, err)
+		}
+	}
+
+	report, err := b.Build(paths, opts, time.Now())
+	if err != nil {
+		return err
+	}
+	return write(stdout, report)
+}
+
+// execReport is the summary produced by the report command. It's written as
+// is in the JSON format.
+type execReport struct {
+	GeneratedAt time.Time `json:"generated_at"`
+	Sources     []string  `json:"sources"`
+	Events      int       `json:"events"`
+	// SkippedLines is the number of lines that couldn't be parsed.
+	SkippedLines int `json:"skipped_lines"`
+	// Start and End are the times of the first and last event, if any
+	// events have timestamps.
+	Start *time.Time `json:"start,omitempty"`
+	End   *time.Time `json:"end,omitempty"`
+
+	Binaries        int             `json:"binaries"`
+	TopBinaries     []reportCount   `json:"top_binaries"`
+	CommandLines    int             `json:"command_lines"`
+	TopCommandLines []reportCount   `json:"top_command_lines"`
+	Users           []reportCount   `json:"users"`
+	FirstSeen       []reportEvent   `json:"first_seen"`
+	Truncated       int             `json:"truncated"`
+	TruncatedEvents []reportEvent   `json:"truncated_events"`
+	Timeline        *reportTimeline `json:"timeline,omitempty"`
+}
+
+// reportCount is a value and the number of events with it.
+type reportCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// reportEvent is a single event in a report.
+type reportEvent struct {
+	Time        *time.Time `json:"time,omitempty"`
+	Binary      string     `json:"binary"`
+	PID         uint32     `json:"pid"`
+	UID         uint32     `json:"uid"`
+	CommandLine string     `json:"command_line"`
+
+	// seq is the position of the event in the input, used to order events
+	// without timestamps.
+	seq int
+}
+
+// reportTimeline is a histogram of the number of events over time.
+type reportTimeline struct {
+	Bucket  string         `json:"bucket"`
+	Buckets []reportBucket `json:"buckets"`
+}
+
+type reportBucket struct {
+	Start time.Time `json:"start"`
+	Count int       `json:"count"`
+	// Percent is the count relative to the largest bucket, used to draw
+	// bars.
+	Percent int `json:"-"`
+}
+
+// reportBuilder aggregates events into an execReport.
+type reportBuilder struct {
+	events       int
+	skipped      int
+	binaries     map[string]int
+	commandLines map[string]int
+	users        map[uint32]int
+	firstSeen    map[string]reportEvent
+	truncated    []reportEvent
+	times        []time.Time
+}
+
+func newReportBuilder() *reportBuilder {
+	return &reportBuilder{
+		binaries:     map[string]int{},
+		commandLines: map[string]int{},
+		users:        map[uint32]int{},
+		firstSeen:    map[string]reportEvent{},
+	}
+}
+
+// Add adds an event to the report. ts is nil if the event has no timestamp.
+func (b *reportBuilder) Add(event *exectrace.Event, ts *time.Time) {
+	b.events++
+	ev := reportEvent{
+		Time:        ts,
+		Binary:      binaryName(event),
+		PID:         event.PID,
+		UID:         event.UID,
+		CommandLine: shellquote.Join(event.Argv...),
+		seq:         b.events,
+	}
+	if event.Truncated {
+		ev.CommandLine += "..."
+	}
+
+	b.binaries[ev.Binary]++
+	b.commandLines[ev.CommandLine]++
+	b.users[event.UID]++
+	// Files aren't necessarily read in order, so earlier events replace
+	// later ones.
+	if first, ok := b.firstSeen[ev.Binary]; !ok || ts != nil && first.Time != nil && ts.Before(*first.Time) {
+		b.firstSeen[ev.Binary] = ev
+	}
+	if event.Truncated {
+		b.truncated = append(b.truncated, ev)
+	}
+	if ts != nil {
+		b.times = append(b.times, *ts)
+	}
+}
+
+// ReadFile adds the events in a file, see Read.
+func (b *reportBuilder) ReadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return xerrors.Errorf("open file: %w", err)
+	}
+	defer f.Close()
+	return b.Read(f)
+}
+
+// reportLine is a line of NDJSON input. It's either an event written by the
+// json output format (optionally with a time), a record from an NDJSON trace
+// file with the event nested in "event", or the header of a trace file.
+type reportLine struct {
+	exectrace.Event
+	Time   *time.Time       `json:"time"`
+	Nested *exectrace.Event `json:"event"`
+	Format string           `json:"format"`
+}
+
+// Read adds the events from r, which contains NDJSON events or a binary trace
+// file, optionally gzipped. Lines that can't be parsed are counted and
+// skipped.
+func (b *reportBuilder) Read(r io.Reader) error {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(2)
+	if bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return xerrors.Errorf("open gzip reader: %w", err)
+		}
+		defer gz.Close()
+		br = bufio.NewReader(gz)
+	}
+
+	// NDJSON input starts with an object, anything else must be a binary
+	// trace file.
+	first, _ := br.Peek(1)
+	if len(first) == 1 && first[0] != '{' {
+		return b.readTrace(br)
+	}
+
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			var parsed reportLine
+			jsonErr := json.Unmarshal(line, &parsed)
+			switch {
+			case jsonErr != nil:
+				b.skipped++
+			case parsed.Nested != nil:
+				b.Add(parsed.Nested, parsed.Time)
+			case parsed.Format != "":
+				// Trace file header.
+			default:
+				b.Add(&parsed.Event, parsed.Time)
+			}
+		}
+		if err != nil {
+			if xerrors.Is(err, io.EOF) {
+				return nil
+			}
+			return xerrors.Errorf("read line: %w", err)
+		}
+	}
+}
+
+func (b *reportBuilder) readTrace(r io.Reader) error {
+	tr, err := exectrace.NewTraceReader(r)
+	if err != nil {
+		return xerrors.Errorf("open trace: %w", err)
+	}
+	for {
+		rec, err := tr.Next()
+		if err != nil {
+			if xerrors.Is(err, io.EOF) {
+				return nil
+			}
+			return xerrors.Errorf("read trace record: %w", err)
+		}
+		ts := rec.Time
+		b.Add(rec.Event, &ts)
+	}
+}
+
+// Build returns the report of all events added so far.
+func (b *reportBuilder) Build(sources []string, opts reportOptions, now time.Time) (*execReport, error) {
+	report := &execReport{
+		GeneratedAt:     now.UTC(),
+		Sources:         sources,
+		Events:          b.events,
+		SkippedLines:    b.skipped,
+		Binaries:        len(b.binaries),
+		TopBinaries:     topCounts(b.binaries, opts.Limit),
+		CommandLines:    len(b.commandLines),
+		TopCommandLines: topCounts(b.commandLines, opts.Limit),
+		FirstSeen:       []reportEvent{},
+		Truncated:       len(b.truncated),
+		TruncatedEvents: latestEvents(b.truncated, opts.Limit),
+	}
+
+	users := make(map[string]int, len(b.users))
+	for uid, count := range b.users {
+		users[strconv.FormatUint(uint64(uid), 10)] = count
+	}
+	report.Users = topCounts(users, opts.Limit)
+
+	firstSeen := make([]reportEvent, 0, len(b.firstSeen))
+	for _, ev := range b.firstSeen {
+		firstSeen = append(firstSeen, ev)
+	}
+	// The binaries that appeared last are the most interesting.
+	report.FirstSeen = latestEvents(firstSeen, opts.Limit)
+
+	if len(b.times) > 0 {
+		sort.Slice(b.times, func(i, j int) bool {
+			return b.times[i].Before(b.times[j])
+		})
+		start, end := b.times[0].UTC(), b.times[len(b.times)-1].UTC()
+		report.Start, report.End = &start, &end
+
+		timeline, err := buildTimeline(b.times, opts.Bucket)
+		if err != nil {
+			return nil, err
+		}
+		report.Timeline = timeline
+	}
+	return report, nil
+}
+
+// topCounts returns the values with the highest counts, sorted by count and
+// then value.
+func topCounts(counts map[string]int, limit int) []reportCount {
+	out := make([]reportCount, 0, len(counts))
+	for value, count := range counts {
+		out = append(out, reportCount{Value: value, Count: count})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Value < out[j].Value
+	})
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+// latestEvents returns the latest events, newest first. Events without
+// timestamps are ordered by their position in the input, before events with
+// timestamps.
+func latestEvents(events []reportEvent, limit int) []reportEvent {
+	out := append([]reportEvent{}, events...)
+	sort.SliceStable(out, func(i, j int) bool {
+		ti, tj := out[i].Time, out[j].Time
+		if ti != nil && tj != nil && !ti.Equal(*tj) {
+			return ti.After(*tj)
+		}
+		if (ti == nil) != (tj == nil) {
+			return ti != nil
+		}
+		return out[i].seq > out[j].seq
+	})
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+// buildTimeline counts the sorted times in buckets of the given size, or of
+// the smallest size in reportBucketSizes that fits in reportAutoBuckets.
+func buildTimeline(times []time.Time, bucket time.Duration) (*reportTimeline, error) {
+	start, end := times[0].UTC(), times[len(times)-1].UTC()
+	if bucket <= 0 {
+		for _, size := range reportBucketSizes {
+			bucket = size
+			if end.Truncate(size).Sub(start.Truncate(size))/size < reportAutoBuckets {
+				break
+			}
+		}
+	}
+	start = start.Truncate(bucket)
+	n := int(end.Sub(start)/bucket) + 1
+	if n > reportMaxBuckets {
+		return nil, xerrors.Errorf("timeline would have %d buckets of %s, use a larger --bucket", n, bucket)
+	}
+
+	timeline := &reportTimeline{
+		Bucket:  bucket.String(),
+		Buckets: make([]reportBucket, n),
+	}
+	for i := range timeline.Buckets {
+		timeline.Buckets[i].Start = start.Add(time.Duration(i) * bucket)
+	}
+	max := 0
+	for _, t := range times {
+		b := &timeline.Buckets[int(t.Sub(start)/bucket)]
+		b.Count++
+		if b.Count > max {
+			max = b.Count
+		}
+	}
+	for i := range timeline.Buckets {
+		timeline.Buckets[i].Percent = timeline.Buckets[i].Count * 100 / max
+	}
+	return timeline, nil
+}
+
+func writeReportJSON(w io.Writer, report *execReport) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(report)
+	if err != nil {
+		return xerrors.Errorf("encode report: %w", err)
+	}
+	return nil
+}
+
+// reportTime formats an optional time in reports.
+func reportTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// escapeMarkdown escapes text so it's shown as is in a Markdown table cell.
+var escapeMarkdown = strings.NewReplacer(
+	`\`, `\\`,
+	"|", `\|`,
+	"`", "\\`",
+	"*", `\*`,
+	"_", `\_`,
+	"[", `\[`,
+	"]", `\]`,
+	"<", "&lt;",
+	">", "&gt;",
+	"\n", `\n`,
+	"\r", `\r`,
+	"\t", `\t`,
+).Replace
+
+func writeReportMarkdown(w io.Writer, report *execReport) error {
+	var b strings.Builder
+	b.WriteString("# exectrace report\n\n")
+	fmt.Fprintf(&b, "- Generated: %s\n", reportTime(&report.GeneratedAt))
+	fmt.Fprintf(&b, "- Sources: %s\n", escapeMarkdown(strings.Join(report.Sources, ", ")))
+	fmt.Fprintf(&b, "- Events: %d\n", report.Events)
+	if report.SkippedLines > 0 {
+		fmt.Fprintf(&b, "- Skipped lines: %d\n", report.SkippedLines)
+	}
+	fmt.Fprintf(&b, "- Period: %s to %s\n", reportTime(report.Start), reportTime(report.End))
+	fmt.Fprintf(&b, "- Unique binaries: %d\n", report.Binaries)
+	fmt.Fprintf(&b, "- Unique command lines: %d\n", report.CommandLines)
+	fmt.Fprintf(&b, "- Truncated events: %d\n", report.Truncated)
+
+	writeCounts := func(title, column string, counts []reportCount) {
+		fmt.Fprintf(&b, "\n## %s\n\n", title)
+		if len(counts) == 0 {
+			b.WriteString("None.\n")
+			return
+		}
+		fmt.Fprintf(&b, "| Execs | %s |\n| ---: | --- |\n", column)
+		for _, c := range counts {
+			fmt.Fprintf(&b, "| %d | %s |\n", c.Count, escapeMarkdown(c.Value))
+		}
+	}
+	writeEvents := func(title string, events []reportEvent) {
+		fmt.Fprintf(&b, "\n## %s\n\n", title)
+		if len(events) == 0 {
+			b.WriteString("None.\n")
+			return
+		}
+		b.WriteString("| Time | Binary | PID | UID | Command line |\n| --- | --- | ---: | ---: | --- |\n")
+		for _, ev := range events {
+			fmt.Fprintf(&b, "| %s | %s | %d | %d | %s |\n", reportTime(ev.Time), escapeMarkdown(ev.Binary), ev.PID, ev.UID, escapeMarkdown(ev.CommandLine))
+		}
+	}
+
+	writeCounts("Top binaries", "Binary", report.TopBinaries)
+	writeCounts("Top command lines", "Command line", report.TopCommandLines)
+	writeCounts("Execs per user", "UID", report.Users)
+	writeEvents("First-seen binaries", report.FirstSeen)
+	writeEvents("Truncated events", report.TruncatedEvents)
+
+	b.WriteString("\n## Timeline\n\n")
+	if report.Timeline == nil {
+		b.WriteString("No events have timestamps.\n")
+	} else {
+		fmt.Fprintf(&b, "Buckets of %s.\n\n| Start | Execs | |\n| --- | ---: | --- |\n", report.Timeline.Bucket)
+		for _, bucket := range report.Timeline.Buckets {
+			bar := strings.Repeat("█", bucket.Percent*reportBarWidth/100)
+			fmt.Fprintf(&b, "| %s | %d | %s |\n", reportTime(&bucket.Start), bucket.Count, bar)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+var reportHTMLTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	// time formats a time or an optional time.
+	"time": func(v interface{}) string {
+		if t, ok := v.(time.Time); ok {
+			return reportTime(&t)
+		}
+		t, _ := v.(*time.Time)
+		return reportTime(t)
+	},
+	// counts passes a table's column name and rows to the counts template.
+	"counts": func(column string, counts []reportCount) interface{} {
+		return struct {
+			Column string
+			Counts []reportCount
+		}{column, counts}
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>exectrace report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 0.25em 0.5em; text-align: left; vertical-align: top; }
+td.num { text-align: right; }
+td.cmd { font-family: monospace; white-space: pre-wrap; word-break: break-all; }
+.bar { background: #4a7fd4; height: 1em; }
+</style>
+</head>
+<body>
+<h1>exectrace report</h1>
+<ul>
+<li>Generated: {{time .GeneratedAt}}</li>
+<li>Sources: {{range $i, $s := .Sources}}{{if $i}}, {{end}}{{$s}}{{end}}</li>
+<li>Events: {{.Events}}</li>
+{{- if .SkippedLines}}
+<li>Skipped lines: {{.SkippedLines}}</li>
+{{- end}}
+<li>Period: {{time .Start}} to {{time .End}}</li>
+<li>Unique binaries: {{.Binaries}}</li>
+<li>Unique command lines: {{.CommandLines}}</li>
+<li>Truncated events: {{.Truncated}}</li>
+</ul>
+{{- define "counts"}}
+{{- if .Counts}}
+<table>
+<tr><th>Execs</th><th>{{.Column}}</th></tr>
+{{- range .Counts}}
+<tr><td class="num">{{.Count}}</td><td class="cmd">{{.Value}}</td></tr>
+{{- end}}
+</table>
+{{- else}}
+<p>None.</p>
+{{- end}}
+{{end}}
+{{- define "events"}}
+{{- if .}}
+<table>
+<tr><th>Time</th><th>Binary</th><th>PID</th><th>UID</th><th>Command line</th></tr>
+{{- range .}}
+<tr><td>{{time .Time}}</td><td class="cmd">{{.Binary}}</td><td class="num">{{.PID}}</td><td class="num">{{.UID}}</td><td class="cmd">{{.CommandLine}}</td></tr>
+{{- end}}
+</table>
+{{- else}}
+<p>None.</p>
+{{- end}}
+{{end}}
+<h2>Top binaries</h2>
+{{- template "counts" (counts "Binary" .TopBinaries)}}
+<h2>Top command lines</h2>
+{{- template "counts" (counts "Command line" .TopCommandLines)}}
+<h2>Execs per user</h2>
+{{- template "counts" (counts "UID" .Users)}}
+<h2>First-seen binaries</h2>
+{{- template "events" .FirstSeen}}
+<h2>Truncated events</h2>
+{{- template "events" .TruncatedEvents}}
+<h2>Timeline</h2>
+{{- with .Timeline}}
+<p>Buckets of {{.Bucket}}.</p>
+<table>
+<tr><th>Start</th><th>Execs</th><th style="width: 50%"></th></tr>
+{{- range .Buckets}}
+<tr><td>{{time .Start}}</td><td class="num">{{.Count}}</td><td><div class="bar" style="width: {{.Percent}}%"></div></td></tr>
+{{- end}}
+</table>
+{{- else}}
+<p>No events have timestamps.</p>
+{{- end}}
+</body>
+</html>
+`))
+
+func writeReportHTML(w io.Writer, report *execReport) error {
+	err := reportHTMLTemplate.Execute(w, report)
+	if err != nil {
+		return xerrors.Errorf("execute HTML template: %w", err)
+	}
+	return nil
+}
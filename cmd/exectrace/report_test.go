@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testReportLog = `{"format":"ndjson","version":1}
+{"time":"2024-05-06T07:08:09Z","event":{"filename":"/usr/bin/git","argv":["git","status"],"pid":5,"uid":1000}}
+{"filename":"/bin/sh","argv":["sh","-c","a|b"],"pid":6,"uid":0,"truncated":true}
+not json
+{"filename":"/usr/bin/git","argv":["git","status"],"pid":7,"uid":1000,"time":"2024-05-06T07:30:00Z"}
+{"filename":"/usr/bin/curl","argv":["curl","example.com"],"pid":8,"uid":1000,"time":"2024-05-06T07:10:00Z"}
+`
+
+func TestReport(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 5, 7, 0, 0, 0, 0, time.UTC)
+	build := func(t *testing.T, opts reportOptions) *execReport {
+		t.Helper()
+
+		b := newReportBuilder()
+		require.NoError(t, b.Read(strings.NewReader(testReportLog)))
+		report, err := b.Build([]string{"logs.ndjson"}, opts, now)
+		require.NoError(t, err)
+		return report
+	}
+
+	t.Run("Summary", func(t *testing.T) {
+		t.Parallel()
+
+		report := build(t, reportOptions{})
+		require.Equal(t, 4, report.Events)
+		require.Equal(t, 1, report.SkippedLines)
+		require.Equal(t, time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC), *report.Start)
+		require.Equal(t, time.Date(2024, 5, 6, 7, 30, 0, 0, time.UTC), *report.End)
+		require.Equal(t, 3, report.Binaries)
+		require.Equal(t, []reportCount{
+			{Value: "/usr/bin/git", Count: 2},
+			{Value: "/bin/sh", Count: 1},
+			{Value: "/usr/bin/curl", Count: 1},
+		}, report.TopBinaries)
+		require.Equal(t, 3, report.CommandLines)
+		require.Equal(t, reportCount{Value: "git status", Count: 2}, report.TopCommandLines[0])
+		require.Equal(t, []reportCount{
+			{Value: "1000", Count: 3},
+			{Value: "0", Count: 1},
+		}, report.Users)
+
+		// Newest first, events without timestamps last.
+		require.Len(t, report.FirstSeen, 3)
+		require.Equal(t, "/usr/bin/curl", report.FirstSeen[0].Binary)
+		require.Equal(t, uint32(5), report.FirstSeen[1].PID)
+		require.Equal(t, "/bin/sh", report.FirstSeen[2].Binary)
+		require.Nil(t, report.FirstSeen[2].Time)
+
+		require.Equal(t, 1, report.Truncated)
+		require.Equal(t, `sh -c a\|b...`, report.TruncatedEvents[0].CommandLine)
+
+		// 07:08 to 07:30 fits in 1 minute buckets, including empty ones.
+		require.Equal(t, "1m0s", report.Timeline.Bucket)
+		require.Len(t, report.Timeline.Buckets, 23)
+		require.Equal(t, reportBucket{
+			Start:   time.Date(2024, 5, 6, 7, 8, 0, 0, time.UTC),
+			Count:   1,
+			Percent: 100,
+		}, report.Timeline.Buckets[0])
+		require.Equal(t, 0, report.Timeline.Buckets[1].Count)
+		require.Equal(t, 1, report.Timeline.Buckets[22].Count)
+	})
+
+	t.Run("Limit", func(t *testing.T) {
+		t.Parallel()
+
+		report := build(t, reportOptions{Limit: 1, Bucket: time.Hour})
+		require.Equal(t, 3, report.Binaries)
+		require.Len(t, report.TopBinaries, 1)
+		require.Len(t, report.FirstSeen, 1)
+		require.Equal(t, []reportBucket{{
+			Start:   time.Date(2024, 5, 6, 7, 0, 0, 0, time.UTC),
+			Count:   3,
+			Percent: 100,
+		}}, report.Timeline.Buckets)
+
+		_, err := buildTimeline([]time.Time{now, now.Add(24 * time.Hour)}, time.Second)
+		require.ErrorContains(t, err, "--bucket")
+	})
+
+	t.Run("Formats", func(t *testing.T) {
+		t.Parallel()
+
+		report := build(t, reportOptions{})
+
+		var buf bytes.Buffer
+		require.NoError(t, writeReportMarkdown(&buf, report))
+		require.Contains(t, buf.String(), "| 2 | /usr/bin/git |\n")
+		require.Contains(t, buf.String(), `| sh -c a\\\|b... |`)
+		require.Contains(t, buf.String(), "| 2024-05-06T07:08:00Z | 1 | "+strings.Repeat("█", reportBarWidth)+" |\n")
+
+		buf.Reset()
+		require.NoError(t, writeReportHTML(&buf, report))
+		require.Contains(t, buf.String(), `<td class="cmd">/usr/bin/git</td>`)
+		require.Contains(t, buf.String(), `<li>Period: 2024-05-06T07:08:09Z to 2024-05-06T07:30:00Z</li>`)
+		require.Contains(t, buf.String(), `style="width: 100%"`)
+
+		buf.Reset()
+		require.NoError(t, writeReportJSON(&buf, report))
+		var decoded execReport
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+		require.Equal(t, report.TopBinaries, decoded.TopBinaries)
+		require.Len(t, decoded.Timeline.Buckets, 23)
+	})
+
+	t.Run("NoTimestamps", func(t *testing.T) {
+		t.Parallel()
+
+		var gz bytes.Buffer
+		w := gzip.NewWriter(&gz)
+		_, err := w.Write([]byte(`{"filename":"/bin/true","argv":["true"]}` + "\n"))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		var buf bytes.Buffer
+		err = runReport([]string{"-"}, reportOptions{Format: reportMarkdown}, &gz, &buf)
+		require.NoError(t, err)
+		require.Contains(t, buf.String(), "- Events: 1\n")
+		require.Contains(t, buf.String(), "- Period: - to -\n")
+		require.Contains(t, buf.String(), "No events have timestamps.")
+
+		err = runReport([]string{"-"}, reportOptions{Format: "pdf"}, &gz, &buf)
+		require.ErrorContains(t, err, "report format")
+	})
+	t.Run("JSONOutput", func(t *testing.T) {
+		t.Parallel()
+
+		// Logs written with --output json have timestamps.
+		var log bytes.Buffer
+		w, err := newEventWriter(outputOptions{Format: outputJSON, SyslogFacility: "user"}, &log)
+		require.NoError(t, err)
+		require.NoError(t, w.WriteEvent(testEvent, testTime))
+		require.NoError(t, w.Close())
+
+		b := newReportBuilder()
+		require.NoError(t, b.Read(&log))
+		report, err := b.Build([]string{"-"}, reportOptions{}, now)
+		require.NoError(t, err)
+		require.Equal(t, 1, report.Events)
+		require.Equal(t, testTime, *report.Start)
+		require.NotNil(t, report.Timeline)
+		require.Equal(t, testTime, *report.FirstSeen[0].Time)
+	})
+}
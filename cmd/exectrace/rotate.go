@@ -0,0 +1,450 @@
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"golang.org/x/xerrors"
+)
+
+// rotatedTimeFormat is the timestamp format appended to rotated segments. It
+// sorts lexically in chronological order.
+const rotatedTimeFormat = "20060102T150405.000000000Z"
+
+// rotateOptions configures a rotatingFile.
+type rotateOptions struct {
+	// MaxSize is the size in bytes after which the file is rotated. Zero
+	// disables size-based rotation.
+	MaxSize int64
+	// MaxAge is the duration after which the file is rotated. Zero disables
+	// time-based rotation.
+	MaxAge time.Duration
+	// MaxSegments is the maximum number of rotated segments to keep, the
+	// oldest segments are deleted first. Zero keeps all segments.
+	MaxSegments int
+	// Compress gzips rotated segments.
+	Compress bool
+}
+
+// rotatingFile is an io.WriteCloser that writes to a file and rotates it
+// based on size and age. Rotated segments are renamed to
+// "<path>.<timestamp>" (with a ".gz" suffix if compressed).
+//
+// Each call to Write is written to a single segment, so callers that write
+// whole events never have an event split across segments. It is safe for
+// concurrent use.
+type rotatingFile struct {
+	path string
+	opts rotateOptions
+	now  func() time.Time
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	closed   bool
+	// ageTimer rotates the file once it's older than MaxAge, even if nothing
+	// is written to it.
+	ageTimer *time.Timer
+	// lastCompress is closed when the most recently started background
+	// compression and pruning is done. Each one waits for the previous one,
+	// so segments are never pruned while they're being compressed.
+	lastCompress chan struct{}
+
+	// compressWG tracks background compression of rotated segments.
+	compressWG sync.WaitGroup
+}
+
+var _ io.WriteCloser = &rotatingFile{}
+
+// openRotatingFile opens (or creates) the file at path for appending.
+func openRotatingFile(path string, opts rotateOptions) (*rotatingFile, error) {
+	if opts.MaxSize < 0 || opts.MaxAge < 0 || opts.MaxSegments < 0 {
+		return nil, xerrors.New("rotation options must not be negative")
+	}
+
+	r := &rotatingFile{
+		path: path,
+		opts: opts,
+		now:  time.Now,
+	}
+	err := r.open()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return xerrors.Errorf("open output file %q: %w", r.path, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return xerrors.Errorf("stat output file %q: %w", r.path, err)
+	}
+
+	r.file = f
+	r.size = info.Size()
+	r.openedAt = r.now()
+	if r.opts.MaxAge > 0 {
+		if r.ageTimer == nil {
+			r.ageTimer = time.AfterFunc(r.opts.MaxAge, r.rotateIfOld)
+		} else {
+			r.ageTimer.Reset(r.opts.MaxAge)
+		}
+	}
+	return nil
+}
+
+// rotateIfOld is called by the age timer to rotate files that are older than
+// MaxAge but aren't being written to.
+func (r *rotatingFile) rotateIfOld() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return
+	}
+	if r.shouldRotate(0) {
+		err := r.rotate()
+		if err != nil {
+			log.Printf("error rotating output file %q: %+v", r.path, err)
+		}
+	}
+
+	// Check again once the current segment reaches MaxAge, or after MaxAge if
+	// the segment is empty or couldn't be rotated.
+	next := r.opts.MaxAge - r.now().Sub(r.openedAt)
+	if next <= 0 {
+		next = r.opts.MaxAge
+	}
+	r.ageTimer.Reset(next)
+}
+
+// Write writes p to the current segment, rotating first if writing p would
+// exceed the maximum size or the segment is older than the maximum age.
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return 0, os.ErrClosed
+	}
+
+	if r.file == nil {
+		// The last rotation couldn't open a new file, so try again.
+		err := r.open()
+		if err != nil {
+			return 0, err
+		}
+	}
+	if r.shouldRotate(int64(len(p))) {
+		err := r.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) shouldRotate(n int64) bool {
+	// Never rotate an empty file, otherwise a single write larger than
+	// MaxSize would produce empty segments.
+	if r.size == 0 {
+		return false
+	}
+	if r.opts.MaxSize > 0 && r.size+n > r.opts.MaxSize {
+		return true
+	}
+	if r.opts.MaxAge > 0 && r.now().Sub(r.openedAt) >= r.opts.MaxAge {
+		return true
+	}
+	return false
+}
+
+// Rotate rotates the file regardless of its size and age.
+func (r *rotatingFile) Rotate() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return os.ErrClosed
+	}
+	return r.rotate()
+}
+
+// rotate syncs and closes the current segment, renames it and opens a new
+// file in its place. If the new file can't be opened, r.file is left nil so
+// the next write tries again. r.mu must be held.
+func (r *rotatingFile) rotate() error {
+	if r.file == nil {
+		return r.open()
+	}
+	err := r.file.Sync()
+	if err != nil {
+		return xerrors.Errorf("sync output file: %w", err)
+	}
+	err = r.file.Close()
+	r.file = nil
+	r.size = 0
+	if err != nil {
+		return xerrors.Errorf("close output file: %w", err)
+	}
+
+	// Avoid overwriting an existing segment if the clock is coarse or has gone
+	// backwards.
+	ts := r.now().UTC()
+	rotated := r.path + "." + ts.Format(rotatedTimeFormat)
+	for fileExists(rotated) || fileExists(rotated+".gz") {
+		ts = ts.Add(time.Nanosecond)
+		rotated = r.path + "." + ts.Format(rotatedTimeFormat)
+	}
+	err = os.Rename(r.path, rotated)
+	if err != nil {
+		// Keep writing to the old file rather than losing events.
+		log.Printf("error renaming output file %q to %q, continuing to write to it: %+v", r.path, rotated, err)
+		return r.open()
+	}
+
+	err = r.open()
+	if err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(r.path))
+
+	if r.opts.Compress {
+		// Compress in the background so writes aren't blocked. Segments are
+		// pruned after compression so the count includes the new segment.
+		prev := r.lastCompress
+		done := make(chan struct{})
+		r.lastCompress = done
+		r.compressWG.Add(1)
+		go func() {
+			defer r.compressWG.Done()
+			defer close(done)
+			if prev != nil {
+				<-prev
+			}
+
+			err := compressFile(rotated)
+			// The segment may have been pruned while waiting if more than
+			// MaxSegments segments were rotated in quick succession.
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				log.Printf("error compressing rotated output file %q: %+v", rotated, err)
+			}
+			r.prune()
+		}()
+		return nil
+	}
+	r.prune()
+	return nil
+}
+
+// prune deletes the oldest rotated segments so that at most MaxSegments
+// remain. Errors are logged as they shouldn't stop events from being written.
+func (r *rotatingFile) prune() {
+	if r.opts.MaxSegments == 0 {
+		return
+	}
+
+	segments, err := r.segments()
+	if err != nil {
+		log.Printf("error listing rotated output files: %+v", err)
+		return
+	}
+	if len(segments) <= r.opts.MaxSegments {
+		return
+	}
+	for _, s := range segments[:len(segments)-r.opts.MaxSegments] {
+		err := os.Remove(s)
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("error removing rotated output file %q: %+v", s, err)
+		}
+	}
+}
+
+// segments returns the paths of all rotated segments, oldest first.
+// Segments that are still being compressed are counted once.
+func (r *rotatingFile) segments() ([]string, error) {
+	dir, base := filepath.Split(r.path)
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, xerrors.Errorf("read dir %q: %w", dir, err)
+	}
+
+	seen := map[string]string{}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, base+".") {
+			continue
+		}
+		ts := strings.TrimPrefix(name, base+".")
+		ts = strings.TrimSuffix(ts, ".gz")
+		if _, err := time.Parse(rotatedTimeFormat, ts); err != nil {
+			continue
+		}
+		// Prefer the uncompressed segment so an in-progress compression
+		// isn't deleted from under the compressor.
+		if _, ok := seen[ts]; !ok || !strings.HasSuffix(name, ".gz") {
+			seen[ts] = filepath.Join(dir, name)
+		}
+	}
+
+	timestamps := make([]string, 0, len(seen))
+	for ts := range seen {
+		timestamps = append(timestamps, ts)
+	}
+	sort.Strings(timestamps)
+	segments := make([]string, len(timestamps))
+	for i, ts := range timestamps {
+		segments[i] = seen[ts]
+	}
+	return segments, nil
+}
+
+// Close syncs and closes the current segment and waits for any background
+// compression to finish.
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.closed = true
+	if r.ageTimer != nil {
+		r.ageTimer.Stop()
+	}
+
+	var merr error
+	if r.file != nil {
+		err := r.file.Sync()
+		if err != nil {
+			merr = multierror.Append(merr, xerrors.Errorf("sync output file: %w", err))
+		}
+		err = r.file.Close()
+		if err != nil {
+			merr = multierror.Append(merr, xerrors.Errorf("close output file: %w", err))
+		}
+	}
+	r.mu.Unlock()
+
+	r.compressWG.Wait()
+	return merr
+}
+
+// compressFile gzips path to path+".gz" and removes path. The compressed file
+// is synced before the original is removed so a crash can't lose the segment.
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return xerrors.Errorf("open: %w", err)
+	}
+	defer src.Close()
+
+	tmp := path + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o640)
+	if err != nil {
+		return xerrors.Errorf("create: %w", err)
+	}
+	defer func() {
+		_ = dst.Close()
+		_ = os.Remove(tmp)
+	}()
+
+	gw := gzip.NewWriter(dst)
+	gw.Name = filepath.Base(path)
+	_, err = io.Copy(gw, src)
+	if err != nil {
+		return xerrors.Errorf("compress: %w", err)
+	}
+	err = gw.Close()
+	if err != nil {
+		return xerrors.Errorf("compress: %w", err)
+	}
+	err = dst.Sync()
+	if err != nil {
+		return xerrors.Errorf("sync: %w", err)
+	}
+	err = dst.Close()
+	if err != nil {
+		return xerrors.Errorf("close: %w", err)
+	}
+
+	err = os.Rename(tmp, path+".gz")
+	if err != nil {
+		return xerrors.Errorf("rename: %w", err)
+	}
+	syncDir(filepath.Dir(path))
+	err = os.Remove(path)
+	if err != nil {
+		return xerrors.Errorf("remove: %w", err)
+	}
+	return nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// syncDir fsyncs a directory so renames within it are durable. Errors are
+// ignored as not all filesystems support syncing directories.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	_ = d.Sync()
+	_ = d.Close()
+}
+
+// parseByteSize parses a size such as "512", "10K", "100MB" or "1GiB". Units
+// are powers of 1024.
+func parseByteSize(s string) (int64, error) {
+	orig := s
+	s = strings.ToUpper(strings.TrimSpace(s))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+
+	multiplier := int64(1)
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		case 'T':
+			multiplier = 1 << 40
+		}
+		if multiplier != 1 {
+			s = s[:len(s)-1]
+		}
+	}
+
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil || n < 0 {
+		return 0, xerrors.Errorf("invalid size %q", orig)
+	}
+	return n * multiplier, nil
+}
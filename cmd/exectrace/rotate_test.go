@@ -0,0 +1,353 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotatingFile(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Size", func(t *testing.T) {
+		t.Parallel()
+
+		path := filepath.Join(t.TempDir(), "events.log")
+		r, err := openRotatingFile(path, rotateOptions{MaxSize: 10})
+		require.NoError(t, err)
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		r.now = func() time.Time {
+			now = now.Add(time.Second)
+			return now
+		}
+
+		for _, line := range []string{"aaaa\n", "bbbb\n", "cccc\n", "this line is too long\n", "dddd\n"} {
+			_, err = r.Write([]byte(line))
+			require.NoError(t, err)
+		}
+		require.NoError(t, r.Close())
+
+		segments, err := r.segments()
+		require.NoError(t, err)
+		require.Len(t, segments, 3)
+		require.Equal(t, "aaaa\nbbbb\n", readFile(t, segments[0]))
+		require.Equal(t, "cccc\n", readFile(t, segments[1]))
+		require.Equal(t, "this line is too long\n", readFile(t, segments[2]))
+		require.Equal(t, "dddd\n", readFile(t, path))
+	})
+
+	t.Run("Age", func(t *testing.T) {
+		t.Parallel()
+
+		path := filepath.Join(t.TempDir(), "events.log")
+		r, err := openRotatingFile(path, rotateOptions{MaxAge: time.Hour})
+		require.NoError(t, err)
+		now := time.Now()
+		r.now = func() time.Time { return now }
+
+		_, err = r.Write([]byte("first\n"))
+		require.NoError(t, err)
+		_, err = r.Write([]byte("second\n"))
+		require.NoError(t, err)
+		now = now.Add(time.Hour)
+		_, err = r.Write([]byte("third\n"))
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+
+		segments, err := r.segments()
+		require.NoError(t, err)
+		require.Len(t, segments, 1)
+		require.Equal(t, "first\nsecond\n", readFile(t, segments[0]))
+		require.Equal(t, "third\n", readFile(t, path))
+	})
+
+	t.Run("IdleAge", func(t *testing.T) {
+		t.Parallel()
+
+		// Files are rotated once they're too old even if nothing else is
+		// written.
+		path := filepath.Join(t.TempDir(), "events.log")
+		r, err := openRotatingFile(path, rotateOptions{MaxAge: 50 * time.Millisecond})
+		require.NoError(t, err)
+		defer r.Close()
+
+		_, err = r.Write([]byte("idle\n"))
+		require.NoError(t, err)
+		require.Eventually(t, func() bool {
+			segments, err := r.segments()
+			return err == nil && len(segments) == 1
+		}, 5*time.Second, 10*time.Millisecond)
+
+		segments, err := r.segments()
+		require.NoError(t, err)
+		require.Equal(t, "idle\n", readFile(t, segments[0]))
+		require.Empty(t, readFile(t, path))
+	})
+
+	t.Run("RenameError", func(t *testing.T) {
+		t.Parallel()
+
+		// If the file can't be renamed, events are still written to a file
+		// at the same path.
+		path := filepath.Join(t.TempDir(), "events.log")
+		r, err := openRotatingFile(path, rotateOptions{MaxSize: 10})
+		require.NoError(t, err)
+		defer r.Close()
+
+		_, err = r.Write([]byte("aaaa\n"))
+		require.NoError(t, err)
+		require.NoError(t, os.Remove(path))
+		_, err = r.Write([]byte("bbbbbbbb\n"))
+		require.NoError(t, err)
+		require.Equal(t, "bbbbbbbb\n", readFile(t, path))
+	})
+
+	t.Run("OpenError", func(t *testing.T) {
+		t.Parallel()
+
+		// If a new file can't be opened after rotating, later writes try
+		// again.
+		dir := filepath.Join(t.TempDir(), "logs")
+		require.NoError(t, os.Mkdir(dir, 0o700))
+		path := filepath.Join(dir, "events.log")
+		r, err := openRotatingFile(path, rotateOptions{MaxSize: 10})
+		require.NoError(t, err)
+		defer r.Close()
+
+		_, err = r.Write([]byte("aaaa\n"))
+		require.NoError(t, err)
+		require.NoError(t, os.RemoveAll(dir))
+		_, err = r.Write([]byte("bbbbbbbb\n"))
+		require.Error(t, err)
+
+		require.NoError(t, os.Mkdir(dir, 0o700))
+		_, err = r.Write([]byte("cccc\n"))
+		require.NoError(t, err)
+		require.NoError(t, r.Rotate())
+		segments, err := r.segments()
+		require.NoError(t, err)
+		require.Len(t, segments, 1)
+		require.Equal(t, "cccc\n", readFile(t, segments[0]))
+	})
+
+	t.Run("Append", func(t *testing.T) {
+		t.Parallel()
+
+		path := filepath.Join(t.TempDir(), "events.log")
+		err := os.WriteFile(path, []byte("existing\n"), 0o600)
+		require.NoError(t, err)
+
+		r, err := openRotatingFile(path, rotateOptions{MaxSize: 12})
+		require.NoError(t, err)
+		_, err = r.Write([]byte("new\n"))
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+
+		segments, err := r.segments()
+		require.NoError(t, err)
+		require.Len(t, segments, 1)
+		require.Equal(t, "existing\n", readFile(t, segments[0]))
+		require.Equal(t, "new\n", readFile(t, path))
+	})
+
+	t.Run("CompressAndPrune", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		path := filepath.Join(dir, "events.log")
+		// Files that aren't segments must not be touched.
+		other := filepath.Join(dir, "events.log.old")
+		err := os.WriteFile(other, nil, 0o600)
+		require.NoError(t, err)
+
+		r, err := openRotatingFile(path, rotateOptions{MaxSegments: 2, Compress: true})
+		require.NoError(t, err)
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		r.now = func() time.Time {
+			now = now.Add(time.Second)
+			return now
+		}
+
+		for _, line := range []string{"1\n", "2\n", "3\n", "4\n"} {
+			_, err = r.Write([]byte(line))
+			require.NoError(t, err)
+			require.NoError(t, r.Rotate())
+			// Wait for compression so pruning is deterministic.
+			r.compressWG.Wait()
+		}
+		require.NoError(t, r.Close())
+
+		segments, err := r.segments()
+		require.NoError(t, err)
+		require.Len(t, segments, 2)
+		for i, expected := range []string{"3\n", "4\n"} {
+			require.True(t, strings.HasSuffix(segments[i], ".gz"), segments[i])
+			require.Equal(t, expected, readGzip(t, segments[i]))
+		}
+		require.FileExists(t, other)
+		require.Empty(t, readFile(t, path))
+
+		matches, err := filepath.Glob(filepath.Join(dir, "*.tmp"))
+		require.NoError(t, err)
+		require.Empty(t, matches)
+	})
+
+	t.Run("CompressBurst", func(t *testing.T) {
+		t.Parallel()
+
+		// Rotating faster than segments are compressed must not prune
+		// segments while they're being compressed.
+		dir := t.TempDir()
+		path := filepath.Join(dir, "events.log")
+		r, err := openRotatingFile(path, rotateOptions{MaxSegments: 2, Compress: true})
+		require.NoError(t, err)
+
+		for i := 0; i < 10; i++ {
+			_, err = r.Write([]byte(strings.Repeat("x", 1<<16) + "\n"))
+			require.NoError(t, err)
+			require.NoError(t, r.Rotate())
+		}
+		_, err = r.Write([]byte("last\n"))
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+
+		segments, err := r.segments()
+		require.NoError(t, err)
+		require.Len(t, segments, 2)
+		for _, s := range segments {
+			require.True(t, strings.HasSuffix(s, ".gz"), s)
+		}
+		matches, err := filepath.Glob(filepath.Join(dir, "*.tmp"))
+		require.NoError(t, err)
+		require.Empty(t, matches)
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		t.Parallel()
+
+		path := filepath.Join(t.TempDir(), "events.log")
+		r, err := openRotatingFile(path, rotateOptions{MaxSize: 100})
+		require.NoError(t, err)
+
+		const (
+			writers = 4
+			lines   = 100
+		)
+		line := "0123456789\n"
+		var wg sync.WaitGroup
+		for i := 0; i < writers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < lines; j++ {
+					_, err := r.Write([]byte(line))
+					require.NoError(t, err)
+				}
+			}()
+		}
+		wg.Wait()
+		require.NoError(t, r.Close())
+
+		_, err = r.Write([]byte(line))
+		require.ErrorIs(t, err, os.ErrClosed)
+
+		segments, err := r.segments()
+		require.NoError(t, err)
+		var total string
+		for _, s := range append(segments, path) {
+			content := readFile(t, s)
+			require.LessOrEqual(t, len(content), 100)
+			total += content
+		}
+		require.Equal(t, strings.Repeat(line, writers*lines), total)
+	})
+}
+
+func TestEventWriterFile(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "events.json")
+	w, err := newEventWriter(outputOptions{
+		Format:         outputJSON,
+		SyslogFacility: "user",
+		File:           path,
+		FileRotate:     rotateOptions{MaxSize: 1},
+	}, nil)
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		err = w.WriteEvent(testEvent, testTime)
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+
+	// Each event is bigger than the max size so each is in its own segment.
+	matches, err := filepath.Glob(path + "*")
+	require.NoError(t, err)
+	require.Len(t, matches, 3)
+	for _, m := range matches {
+		lines := strings.Split(strings.TrimSuffix(readFile(t, m), "\n"), "\n")
+		require.Len(t, lines, 1)
+		require.Contains(t, lines[0], `"filename":"/usr/bin/echo"`)
+	}
+
+	_, err = newEventWriter(outputOptions{
+		Format:         outputSyslog,
+		SyslogFacility: "user",
+		SyslogAddr:     "udp://127.0.0.1:514",
+		File:           path,
+	}, nil)
+	require.Error(t, err)
+}
+
+func TestParseByteSize(t *testing.T) {
+	t.Parallel()
+
+	for input, expected := range map[string]int64{
+		"0":       0,
+		"512":     512,
+		"512B":    512,
+		"10K":     10 << 10,
+		"10kb":    10 << 10,
+		"100MiB":  100 << 20,
+		" 1 GiB ": 1 << 30,
+		"2T":      2 << 40,
+	} {
+		n, err := parseByteSize(input)
+		require.NoError(t, err, input)
+		require.Equal(t, expected, n, input)
+	}
+
+	for _, input := range []string{"", "MB", "-1", "1.5G", "10X"} {
+		_, err := parseByteSize(input)
+		require.Error(t, err, input)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func readGzip(t *testing.T, path string) string {
+	t.Helper()
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	b, err := io.ReadAll(gr)
+	require.NoError(t, err)
+	return string(b)
+}
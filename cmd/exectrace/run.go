@@ -0,0 +1,297 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/xerrors"
+
+	"github.com/coder/exectrace"
+)
+
+const (
+	// runShimCommand is the hidden subcommand that run starts in a new PID
+	// namespace to run the traced command.
+	runShimCommand = "run-shim"
+	// runDrainTimeout is how long run waits for more events after the command
+	// exits before it stops reading. Events are sent to userspace during the
+	// exec, but may not have been read yet.
+	runDrainTimeout = 200 * time.Millisecond
+)
+
+func runCmd() *cobra.Command {
+	var (
+		tracer tracerFlags
+		output outputFlags
+		tree   bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "run [flags] -- <command> [args...]",
+		Short: "Run a command and log the execs of it and all of its descendants.",
+		Long: "Run a command in a new PID namespace and log the execs of it and all of its descendants, similar to " +
+			"`strace -f -e execve`. Events are written to stderr unless an output destination is set, and exectrace " +
+			"exits with the exit code of the command.\n\n" +
+			"Any processes the command leaves running are killed when it exits. /proc is not remounted in the new " +
+			"namespace, so tools such as ps still show all processes on the host.",
+		Args: cobra.MinimumNArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			if tracer.pidNS != 0 || tracer.pidNSOf != 0 || tracer.pid != 0 {
+				//nolint:revive
+				log.Fatal("--pid-ns, --pid-ns-of and --pid can't be used with run")
+			}
+			tracerOpts, err := tracer.tracerOpts()
+			if err != nil {
+				//nolint:revive
+				log.Fatalf("invalid tracer options: %+v", err)
+			}
+
+			outputOpts, err := output.outputOptions()
+			if err != nil {
+				//nolint:revive
+				log.Fatalf("invalid output options: %+v", err)
+			}
+
+			code, err := runCommand(runCommandOptions{
+				TracerOpts: tracerOpts,
+				Output:     outputOpts,
+				Tree:       tree,
+				Args:       args,
+			})
+			if err != nil {
+				//nolint:revive
+				log.Fatalf("run: %+v", err)
+			}
+			os.Exit(code)
+		},
+	}
+
+	tracer.register(cmd)
+	output.register(cmd)
+	cmd.Flags().BoolVar(&tree, "tree", false, "Print a tree of all execs to stderr when the command exits")
+
+	return cmd
+}
+
+// runShimCmd is started by run in a new PID namespace. It waits until the
+// tracer is ready, then runs the command and exits with its exit code.
+func runShimCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:    runShimCommand + " -- <command> [args...]",
+		Hidden: true,
+		Args:   cobra.MinimumNArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			code, err := runShim(args)
+			if err != nil {
+				//nolint:revive
+				log.Fatalf("run: %+v", err)
+			}
+			os.Exit(code)
+		},
+	}
+}
+
+type runCommandOptions struct {
+	TracerOpts *exectrace.TracerOpts
+	Output     outputOptions
+	Tree       bool
+	Args       []string
+}
+
+func runCommand(opts runCommandOptions) (int, error) {
+	w, err := newEventWriter(opts.Output, os.Stderr)
+	if err != nil {
+		return 0, xerrors.Errorf("create output writer: %w", err)
+	}
+	defer func() {
+		err := w.Close()
+		if err != nil {
+			log.Printf("error closing output writer: %+v", err)
+		}
+	}()
+
+	// The shim blocks until something is written to the pipe, so the tracer
+	// can be started with the PID namespace of the shim before the command is
+	// exec'd.
+	ready, readyWriter, err := os.Pipe()
+	if err != nil {
+		return 0, xerrors.Errorf("create pipe: %w", err)
+	}
+	defer ready.Close()
+	defer readyWriter.Close()
+
+	self, err := os.Executable()
+	if err != nil {
+		return 0, xerrors.Errorf("get executable path: %w", err)
+	}
+	//nolint:gosec
+	shim := exec.Command(self, append([]string{runShimCommand, "--"}, opts.Args...)...)
+	shim.Stdin = os.Stdin
+	shim.Stdout = os.Stdout
+	shim.Stderr = os.Stderr
+	shim.ExtraFiles = []*os.File{ready}
+	err = newPIDNamespace(shim)
+	if err != nil {
+		return 0, err
+	}
+	err = shim.Start()
+	if err != nil {
+		return 0, xerrors.Errorf("start command in new PID namespace: %w", err)
+	}
+	shimDone := make(chan struct{})
+	go func() {
+		// The exit status is read from ProcessState below.
+		_ = shim.Wait()
+		close(shimDone)
+	}()
+	_ = ready.Close()
+
+	// Interrupts from the terminal are delivered to the command directly, but
+	// other signals are only sent to us.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	go func() {
+		for sig := range signals {
+			if sig != os.Interrupt {
+				_ = shim.Process.Signal(sig)
+			}
+		}
+	}()
+
+	pidNS, err := exectrace.GetPidNSOf(uint32(shim.Process.Pid))
+	if err != nil {
+		_ = shim.Process.Kill()
+		return 0, xerrors.Errorf("get PID namespace of command: %w", err)
+	}
+	opts.TracerOpts.PidNS = pidNS
+	t, err := exectrace.New(opts.TracerOpts)
+	if err != nil {
+		_ = shim.Process.Kill()
+		return 0, xerrors.Errorf("start tracer: %w", err)
+	}
+	defer t.Close()
+
+	_, err = readyWriter.Write([]byte{0})
+	if err != nil {
+		_ = shim.Process.Kill()
+		return 0, xerrors.Errorf("start command: %w", err)
+	}
+	_ = readyWriter.Close()
+
+	events := make(chan *exectrace.Event)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			event, err := t.Read()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					return
+				}
+				log.Printf("error reading from reader: %+v", err)
+				continue
+			}
+			select {
+			case events <- event:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	tree := newProcessTree()
+	exited := shimDone
+	var drain <-chan time.Time
+loop:
+	for {
+		select {
+		case event := <-events:
+			if opts.Tree {
+				tree.Add(event)
+			}
+			err = w.WriteEvent(event, time.Now())
+			if err != nil {
+				log.Printf("error writing event: %+v", err)
+			}
+			if drain != nil {
+				drain = time.After(runDrainTimeout)
+			}
+		case <-exited:
+			// Read the remaining events until none arrive for a while.
+			exited = nil
+			drain = time.After(runDrainTimeout)
+		case <-drain:
+			break loop
+		}
+	}
+
+	if opts.Tree {
+		err = tree.Write(os.Stderr)
+		if err != nil {
+			log.Printf("error writing tree: %+v", err)
+		}
+	}
+	return exitCode(shim.ProcessState), nil
+}
+
+// runShim waits for run to start the tracer, then runs the command. It's the
+// init process of the new PID namespace, so any processes left running when
+// it exits are killed.
+func runShim(args []string) (int, error) {
+	ready := os.NewFile(3, "ready")
+	_, err := ready.Read(make([]byte, 1))
+	if err != nil {
+		return 0, xerrors.Errorf("wait for tracer: %w", err)
+	}
+	_ = ready.Close()
+
+	//nolint:gosec
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// As the init process of the namespace, signals without a handler are
+	// ignored, so forward the ones run sends us. Interrupts from the
+	// terminal are delivered to the command directly.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	err = cmd.Start()
+	if err != nil {
+		// Use the same exit codes as shells.
+		log.Printf("start command: %v", err)
+		if errors.Is(err, exec.ErrNotFound) {
+			return 127, nil
+		}
+		return 126, nil
+	}
+	go func() {
+		for sig := range signals {
+			if sig != os.Interrupt {
+				_ = cmd.Process.Signal(sig)
+			}
+		}
+	}()
+
+	_ = cmd.Wait()
+	return exitCode(cmd.ProcessState), nil
+}
+
+// exitCode returns the exit code of a process, following the shell
+// convention of 128+n for processes killed by signal n.
+func exitCode(state *os.ProcessState) int {
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return state.ExitCode()
+}
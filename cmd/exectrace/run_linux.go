@@ -0,0 +1,19 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// newPIDNamespace configures cmd to start in a new PID namespace. The process
+// is killed if exectrace dies so it doesn't wait for the tracer forever.
+func newPIDNamespace(cmd *exec.Cmd) error {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWPID,
+		Pdeathsig:  syscall.SIGKILL,
+	}
+	return nil
+}
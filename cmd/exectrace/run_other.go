@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"os/exec"
+	"runtime"
+
+	"golang.org/x/xerrors"
+)
+
+// newPIDNamespace is not supported on OSes other than Linux.
+func newPIDNamespace(_ *exec.Cmd) error {
+	return xerrors.Errorf(`%q is an unsupported OS, only "linux" is supported`, runtime.GOOS)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/xerrors"
+	"google.golang.org/grpc"
+
+	"github.com/coder/exectrace"
+	"github.com/coder/exectrace/remote"
+)
+
+// serveTokenEnv is the environment variable the auth token is read from, so
+// it doesn't have to be passed on the command line where other users can see
+// it.
+const serveTokenEnv = "EXECTRACE_AUTH_TOKEN"
+
+func serveCmd() *cobra.Command {
+	var (
+		tracer     tracerFlags
+		grpcAddr   string
+		httpAddr   string
+		bufferSize int
+		insecure   bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Stream exec events to remote consumers over gRPC and Server-Sent Events.",
+		Long: "Stream exec events from a single tracer to remote consumers over gRPC and Server-Sent Events (SSE).\n\n" +
+			"gRPC clients can use the github.com/coder/exectrace/remote package. SSE clients can connect to /events on " +
+			"the HTTP address, optionally with a filter expression in the \"filter\" query parameter.\n\n" +
+			"Clients must send the token in the " + serveTokenEnv + " environment variable as a bearer token in the " +
+			"\"authorization\" header. The command refuses to start without a token unless --insecure-no-auth is passed.",
+		Run: func(_ *cobra.Command, _ []string) {
+			tracerOpts, err := tracer.tracerOpts()
+			if err != nil {
+				//nolint:revive
+				log.Fatalf("invalid tracer options: %+v", err)
+			}
+
+			err = serve(serveOptions{
+				TracerOpts: tracerOpts,
+				GRPCAddr:   grpcAddr,
+				HTTPAddr:   httpAddr,
+				NoAuth:     insecure,
+				ServerOpts: &remote.ServerOpts{
+					BufferSize: bufferSize,
+					Token:      os.Getenv(serveTokenEnv),
+				},
+			})
+			if err != nil {
+				//nolint:revive
+				log.Fatalf("serve: %+v", err)
+			}
+		},
+	}
+
+	tracer.register(cmd)
+	cmd.Flags().StringVar(&grpcAddr, "grpc-addr", "127.0.0.1:9103", "Address to serve the gRPC stream on, empty to disable")
+	cmd.Flags().StringVar(&httpAddr, "http-addr", "127.0.0.1:9104", "Address to serve the SSE stream on at /events, empty to disable")
+	cmd.Flags().IntVar(&bufferSize, "buffer-size", remote.DefaultBufferSize, "Number of events buffered for each client before events are dropped for that client")
+	cmd.Flags().BoolVar(&insecure, "insecure-no-auth", false, "Allow starting without "+serveTokenEnv+", so any client that can connect receives all events")
+
+	return cmd
+}
+
+type serveOptions struct {
+	TracerOpts *exectrace.TracerOpts
+	GRPCAddr   string
+	HTTPAddr   string
+	// NoAuth allows serving without a token.
+	NoAuth     bool
+	ServerOpts *remote.ServerOpts
+}
+
+func serve(opts serveOptions) error {
+	if opts.GRPCAddr == "" && opts.HTTPAddr == "" {
+		return xerrors.New("at least one of --grpc-addr and --http-addr must be set")
+	}
+	if opts.ServerOpts.Token == "" {
+		if !opts.NoAuth {
+			return xerrors.Errorf("%s must be set, or pass --insecure-no-auth to let any client that can connect receive all events", serveTokenEnv)
+		}
+		log.Printf("%s is not set, any client that can connect will receive all events", serveTokenEnv)
+	}
+
+	// Listen before loading the eBPF program so address errors are reported
+	// without touching the kernel.
+	var grpcListener, httpListener net.Listener
+	if opts.GRPCAddr != "" {
+		l, err := net.Listen("tcp", opts.GRPCAddr)
+		if err != nil {
+			return xerrors.Errorf("listen on %q: %w", opts.GRPCAddr, err)
+		}
+		defer l.Close()
+		grpcListener = l
+	}
+	if opts.HTTPAddr != "" {
+		l, err := net.Listen("tcp", opts.HTTPAddr)
+		if err != nil {
+			return xerrors.Errorf("listen on %q: %w", opts.HTTPAddr, err)
+		}
+		defer l.Close()
+		httpListener = l
+	}
+
+	t, err := exectrace.New(opts.TracerOpts)
+	if err != nil {
+		return xerrors.Errorf("start tracer: %w", err)
+	}
+	defer t.Close()
+	closeOnSignal(t)
+
+	srv := remote.NewServer(t, opts.ServerOpts)
+	defer srv.Close()
+
+	errs := make(chan error, 2)
+	if grpcListener != nil {
+		gs := grpc.NewServer()
+		srv.RegisterGRPC(gs)
+		defer gs.Stop()
+		go func() {
+			errs <- gs.Serve(grpcListener)
+		}()
+		log.Printf("Serving gRPC on %s", grpcListener.Addr())
+	}
+	if httpListener != nil {
+		mux := http.NewServeMux()
+		mux.Handle("/events", srv)
+		hs := &http.Server{
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		defer hs.Close()
+		go func() {
+			errs <- hs.Serve(httpListener)
+		}()
+		log.Printf("Serving SSE on http://%s/events", httpListener.Addr())
+	}
+
+	// Wait until the tracer is closed or a server fails.
+	select {
+	case <-srv.Done():
+		return nil
+	case err := <-errs:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return xerrors.Errorf("serve: %w", err)
+	}
+}
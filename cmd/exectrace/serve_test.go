@@ -0,0 +1,22 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/coder/exectrace/remote"
+)
+
+func TestServeRequiresToken(t *testing.T) {
+	t.Parallel()
+
+	// The token is checked before the tracer is started, so this doesn't
+	// need root.
+	err := serve(serveOptions{
+		GRPCAddr:   "127.0.0.1:0",
+		ServerOpts: &remote.ServerOpts{},
+	})
+	require.ErrorContains(t, err, serveTokenEnv+" must be set")
+	require.ErrorContains(t, err, "--insecure-no-auth")
+}
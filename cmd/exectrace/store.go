@@ -0,0 +1,374 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/xerrors"
+
+	"github.com/coder/exectrace"
+	"github.com/coder/exectrace/store"
+)
+
+const (
+	// storeBatchSize is the number of pending events that triggers a write
+	// before the flush interval.
+	storeBatchSize = 1000
+	// storeMaxPending is the number of events kept in memory while the
+	// database can't be written. Further events are dropped.
+	storeMaxPending = 100 * storeBatchSize
+	// storeLockTimeout is how long commands wait for another process to
+	// release the database.
+	storeLockTimeout = 5 * time.Second
+	// storeReleaseInterval is how long the store command keeps the database
+	// open before closing it until the next flush interval, so queries
+	// waiting for it can run. It's shorter than storeLockTimeout so queries
+	// don't time out with the default flush interval.
+	storeReleaseInterval = 2 * time.Second
+)
+
+func storeCmd() *cobra.Command {
+	var (
+		tracer  tracerFlags
+		opts    storeOptions
+		maxSize string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "store",
+		Short: "Store exec events in an embedded database that can be searched with \"exectrace query\".",
+		Long: "Store exec events in an embedded database that can be searched with \"exectrace query\". Events are " +
+			"indexed by time, PID, UID, PID namespace and filename. The database is closed for a flush interval every " +
+			"few seconds, so it can be queried while events are being stored.",
+		Args: cobra.NoArgs,
+		Run: func(_ *cobra.Command, _ []string) {
+			tracerOpts, err := tracer.tracerOpts()
+			if err != nil {
+				//nolint:revive
+				log.Fatalf("invalid tracer options: %+v", err)
+			}
+			opts.Store.MaxSize, err = parseByteSize(maxSize)
+			if err != nil {
+				//nolint:revive
+				log.Fatalf("invalid --max-size: %+v", err)
+			}
+
+			err = runStore(tracerOpts, opts)
+			if err != nil {
+				//nolint:revive
+				log.Fatalf("store: %+v", err)
+			}
+		},
+	}
+
+	tracer.register(cmd)
+	cmd.Flags().StringVar(&opts.Path, "db", "", "Path of the database file, it is created if it doesn't exist")
+	cmd.Flags().DurationVar(&opts.Store.MaxAge, "max-age", 0, "Delete events older than this duration, e.g. 720h (0 keeps events regardless of their age)")
+	cmd.Flags().StringVar(&maxSize, "max-size", "1GiB", "Delete the oldest events when the stored events and indexes would exceed this size, e.g. 512MiB or 10GiB (0 disables size-based retention)")
+	cmd.Flags().DurationVar(&opts.FlushInterval, "flush-interval", time.Second, "How often pending events are written to the database")
+	_ = cmd.MarkFlagRequired("db")
+
+	return cmd
+}
+
+// storeOptions configures the store command.
+type storeOptions struct {
+	// Path is the path of the database file.
+	Path string
+	// Store configures retention. ReadOnly and Timeout are ignored.
+	Store store.Options
+	// FlushInterval is how often pending events are written.
+	FlushInterval time.Duration
+}
+
+func runStore(tracerOpts *exectrace.TracerOpts, opts storeOptions) error {
+	t, err := exectrace.New(tracerOpts)
+	if err != nil {
+		return xerrors.Errorf("start tracer: %w", err)
+	}
+	defer t.Close()
+	closeOnSignal(t)
+
+	return storeEvents(t, opts)
+}
+
+// storeEvents reads events from t and writes them to the database until t is
+// closed.
+func storeEvents(t exectrace.Tracer, opts storeOptions) error {
+	if opts.FlushInterval <= 0 {
+		return xerrors.New("flush interval must be positive")
+	}
+	w := &storeWriter{path: opts.Path, opts: opts.Store, maxPending: storeMaxPending}
+	w.opts.ReadOnly = false
+	w.opts.Timeout = storeLockTimeout
+	// Fail early if the database can't be opened.
+	err := w.flush(time.Now())
+	if err != nil {
+		return err
+	}
+
+	records := make(chan *store.Record, storeBatchSize)
+	go func() {
+		defer close(records)
+		for {
+			event, err := t.Read()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					return
+				}
+				log.Printf("error reading from reader: %+v", err)
+				continue
+			}
+
+			records <- &store.Record{
+				Time:  time.Now(),
+				Event: event,
+			}
+		}
+	}()
+
+	log.Printf("Storing events in %s..", opts.Path)
+	ticker := time.NewTicker(opts.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case r, ok := <-records:
+			if !ok {
+				err := w.flush(time.Now())
+				closeErr := w.release()
+				if err != nil {
+					return err
+				}
+				if closeErr != nil {
+					return closeErr
+				}
+				log.Printf("Stored %d events", w.stored)
+				return nil
+			}
+			w.add(r)
+			// Full batches are written early while the database is open.
+			// Otherwise it was released or the last write failed, so the
+			// events wait for the next tick.
+			if len(w.pending) < storeBatchSize || !w.isOpen() {
+				continue
+			}
+		case now := <-ticker.C:
+			if w.isOpen() && now.Sub(w.openedAt) >= storeReleaseInterval {
+				err := w.release()
+				if err != nil {
+					log.Printf("error closing database: %+v", err)
+				}
+				continue
+			}
+			if len(w.pending) == 0 {
+				continue
+			}
+		}
+
+		err := w.flush(time.Now())
+		if err != nil {
+			// Keep the events and try again on the next tick, e.g. if the
+			// database is locked by a long query.
+			log.Printf("error writing events: %+v", err)
+		}
+	}
+}
+
+// storeWriter writes batches of records to the database. The database is kept
+// open between batches and released regularly so "exectrace query" can read
+// it in between.
+type storeWriter struct {
+	path string
+	opts store.Options
+	// s is the open database, or nil if it's released.
+	s        *store.Store
+	openedAt time.Time
+
+	pending    []*store.Record
+	maxPending int
+	// dropped is the number of records dropped since the last successful
+	// write because too many were pending.
+	dropped int
+	// stored is the number of records written so far.
+	stored int
+}
+
+func (w *storeWriter) isOpen() bool {
+	return w.s != nil
+}
+
+// add queues a record for the next write, or drops it if too many records are
+// pending.
+func (w *storeWriter) add(r *store.Record) {
+	if len(w.pending) >= w.maxPending {
+		w.dropped++
+		return
+	}
+	w.pending = append(w.pending, r)
+}
+
+// flush opens the database if it's released, writes the pending records and
+// applies retention. The database is released if anything fails.
+func (w *storeWriter) flush(now time.Time) error {
+	if w.s == nil {
+		s, err := store.Open(w.path, &w.opts)
+		if err != nil {
+			return err
+		}
+		w.s, w.openedAt = s, now
+	}
+
+	if len(w.pending) > 0 {
+		err := w.s.Add(w.pending...)
+		if err != nil {
+			_ = w.release()
+			return xerrors.Errorf("add events: %w", err)
+		}
+		w.stored += len(w.pending)
+		w.pending = w.pending[:0]
+	}
+	if w.dropped > 0 {
+		log.Printf("Dropped %d events because the database couldn't be written", w.dropped)
+		w.dropped = 0
+	}
+
+	deleted, err := w.s.Prune(now)
+	if err != nil {
+		_ = w.release()
+		return err
+	}
+	if deleted > 0 {
+		log.Printf("Deleted %d events because of retention", deleted)
+	}
+	return nil
+}
+
+// release closes the database if it's open.
+func (w *storeWriter) release() error {
+	if w.s == nil {
+		return nil
+	}
+	err := w.s.Close()
+	w.s = nil
+	if err != nil {
+		return xerrors.Errorf("close database: %w", err)
+	}
+	return nil
+}
+
+func queryCmd() *cobra.Command {
+	var (
+		output outputFlags
+		path   string
+		since  string
+		until  string
+		uid    uint32
+		q      store.Query
+	)
+
+	cmd := &cobra.Command{
+		Use:   "query",
+		Short: "Search events stored with \"exectrace store\" and write them in any output format.",
+		Long: "Search events stored with \"exectrace store\" and write them in any output format. All filters must " +
+			"match. Events are written with the time they were stored, use --output template to include it, e.g. " +
+			`--template '{{timefmt "rfc3339" .Time}} {{.PID}} {{shellquote .Argv}}'.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, _ []string) {
+			outputOpts, err := output.outputOptions()
+			if err != nil {
+				//nolint:revive
+				log.Fatalf("invalid output options: %+v", err)
+			}
+
+			now := time.Now()
+			q.Since, err = parseQueryTime(since, now)
+			if err != nil {
+				//nolint:revive
+				log.Fatalf("invalid --since: %+v", err)
+			}
+			q.Until, err = parseQueryTime(until, now)
+			if err != nil {
+				//nolint:revive
+				log.Fatalf("invalid --until: %+v", err)
+			}
+			if cmd.Flags().Changed("uid") {
+				q.UID = &uid
+			}
+
+			err = queryEvents(path, q, outputOpts, os.Stdout)
+			if err != nil {
+				//nolint:revive
+				log.Fatalf("query: %+v", err)
+			}
+		},
+	}
+
+	output.register(cmd)
+	cmd.Flags().StringVar(&path, "db", "", "Path of the database file written by \"exectrace store\"")
+	cmd.Flags().StringVar(&since, "since", "", "Only show events stored at or after this time, either RFC 3339 or a duration before now, e.g. 2024-05-06T07:00:00Z or 1h")
+	cmd.Flags().StringVar(&until, "until", "", "Only show events stored before this time, in the same format as --since")
+	cmd.Flags().Uint32Var(&q.PID, "pid", 0, "Only show events of the process with this PID")
+	cmd.Flags().Uint32Var(&uid, "uid", 0, "Only show events of processes run by this user")
+	cmd.Flags().Uint32Var(&q.PidNS, "pid-ns", 0, "Only show events of processes in the PID NS with this ID")
+	cmd.Flags().StringVar(&q.Filename, "filename", "", "Only show events with exactly this filename, e.g. /usr/bin/curl")
+	cmd.Flags().IntVarP(&q.Limit, "limit", "n", 0, "Maximum number of events to show (0 shows all events)")
+	cmd.Flags().BoolVarP(&q.Reverse, "reverse", "r", false, "Show the newest events first, e.g. with --limit to show the latest events")
+	_ = cmd.MarkFlagRequired("db")
+
+	return cmd
+}
+
+// parseQueryTime parses a time in RFC 3339 format or a duration before now.
+// An empty string returns the zero time.
+func parseQueryTime(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err == nil {
+		return now.Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, xerrors.Errorf("%q is neither an RFC 3339 time nor a duration", s)
+	}
+	return t, nil
+}
+
+func queryEvents(path string, q store.Query, output outputOptions, stdout io.Writer) error {
+	if q.Limit < 0 {
+		return xerrors.New("--limit must not be negative")
+	}
+
+	s, err := store.Open(path, &store.Options{
+		ReadOnly: true,
+		Timeout:  storeLockTimeout,
+	})
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	w, err := newEventWriter(output, stdout)
+	if err != nil {
+		return xerrors.Errorf("create output writer: %w", err)
+	}
+	defer func() {
+		err := w.Close()
+		if err != nil {
+			log.Printf("error closing output writer: %+v", err)
+		}
+	}()
+
+	return s.Query(q, func(r *store.Record) error {
+		err := w.WriteEvent(r.Event, r.Time)
+		if err != nil {
+			log.Printf("error writing event: %+v", err)
+		}
+		return nil
+	})
+}
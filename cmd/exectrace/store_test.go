@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/coder/exectrace"
+	"github.com/coder/exectrace/exectracetest"
+	"github.com/coder/exectrace/store"
+)
+
+func TestStoreQuery(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "events.db")
+	tracer := exectracetest.New()
+	tracer.Push(
+		&exectrace.Event{PID: 1, UID: 0, Filename: "/usr/bin/git", Argv: []string{"git", "status"}},
+		&exectrace.Event{PID: 2, UID: 1000, Filename: "/usr/bin/curl", Argv: []string{"curl", "example.com"}},
+		&exectrace.Event{PID: 3, UID: 1000, Filename: "/usr/bin/git", Argv: []string{"git", "log"}},
+	)
+	tracer.Finish()
+	err := storeEvents(tracer, storeOptions{Path: path, FlushInterval: time.Hour})
+	require.NoError(t, err)
+
+	query := func(q store.Query) string {
+		var buf bytes.Buffer
+		err := queryEvents(path, q, outputOptions{
+			Format:         outputTemplate,
+			Template:       "{{.PID}} {{shellquote .Argv}}",
+			SyslogFacility: "user",
+		}, &buf)
+		require.NoError(t, err)
+		return buf.String()
+	}
+
+	uid := uint32(1000)
+	require.Equal(t, "1 git status\n2 curl example.com\n3 git log\n", query(store.Query{}))
+	require.Equal(t, "3 git log\n1 git status\n", query(store.Query{Filename: "/usr/bin/git", Reverse: true}))
+	require.Equal(t, "2 curl example.com\n", query(store.Query{UID: &uid, Limit: 1}))
+	require.Empty(t, query(store.Query{Since: time.Now().Add(time.Hour)}))
+
+	err = queryEvents(filepath.Join(t.TempDir(), "missing.db"), store.Query{}, outputOptions{Format: outputText, SyslogFacility: "user"}, &bytes.Buffer{})
+	require.Error(t, err)
+}
+
+func TestStoreWriter(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "events.db")
+	w := &storeWriter{
+		path:       path,
+		opts:       store.Options{Timeout: 10 * time.Millisecond},
+		maxPending: 2,
+	}
+	record := func(pid uint32) *store.Record {
+		return &store.Record{Time: time.Now(), Event: &exectrace.Event{PID: pid, Argv: []string{}}}
+	}
+
+	// The database stays open between writes.
+	require.NoError(t, w.flush(time.Now()))
+	require.True(t, w.isOpen())
+	s := w.s
+	w.add(record(1))
+	require.NoError(t, w.flush(time.Now()))
+	require.Same(t, s, w.s)
+	require.Equal(t, 1, w.stored)
+	require.NoError(t, w.release())
+	require.False(t, w.isOpen())
+
+	// Pending records are kept while the database is locked, up to the
+	// limit.
+	other, err := store.Open(path, nil)
+	require.NoError(t, err)
+	for pid := uint32(2); pid <= 4; pid++ {
+		w.add(record(pid))
+	}
+	require.Error(t, w.flush(time.Now()))
+	require.False(t, w.isOpen())
+	require.Len(t, w.pending, 2)
+	require.Equal(t, 1, w.dropped)
+
+	require.NoError(t, other.Close())
+	require.NoError(t, w.flush(time.Now()))
+	require.Empty(t, w.pending)
+	require.Zero(t, w.dropped)
+	require.Equal(t, 3, w.stored)
+	require.NoError(t, w.release())
+}
+
+func TestParseQueryTime(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	for _, tc := range []struct {
+		in       string
+		expected time.Time
+	}{
+		{"", time.Time{}},
+		{"90m", now.Add(-90 * time.Minute)},
+		{"2024-05-01T00:00:00Z", time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)},
+		{"2024-05-01T00:00:00.5+02:00", time.Date(2024, 4, 30, 22, 0, 0, 500000000, time.UTC)},
+	} {
+		got, err := parseQueryTime(tc.in, now)
+		require.NoError(t, err, tc.in)
+		require.True(t, tc.expected.Equal(got), "%q: got %s", tc.in, got)
+	}
+
+	_, err := parseQueryTime("yesterday", now)
+	require.ErrorContains(t, err, "RFC 3339")
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kballard/go-shellquote"
+	"golang.org/x/xerrors"
+
+	"github.com/coder/exectrace"
+)
+
+const (
+	// syslogSeverityInfo is the "informational" severity.
+	syslogSeverityInfo = 6
+
+	syslogAppName = "exectrace"
+	syslogMsgID   = "exec"
+	// syslogSDID is the structured data ID for exec events. IDs without an
+	// "@" are reserved for IANA, so we use the example private enterprise
+	// number from RFC 5424.
+	syslogSDID = "exec@32473"
+
+	syslogTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+// syslogFacilities maps facility names to their RFC 5424 codes.
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20,
+	"local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogFormatter formats RFC 5424 syslog messages.
+type syslogFormatter struct {
+	facility int
+	hostname string
+	procID   string
+}
+
+func newSyslogFormatter(facility int) *syslogFormatter {
+	return &syslogFormatter{
+		facility: facility,
+		hostname: syslogHeaderField(hostname(), 255),
+		procID:   strconv.Itoa(os.Getpid()),
+	}
+}
+
+// header returns the RFC 5424 header for a message, up to and including the
+// space before STRUCTURED-DATA.
+func (f *syslogFormatter) header(now time.Time) string {
+	pri := f.facility*8 + syslogSeverityInfo
+	return fmt.Sprintf("<%d>1 %s %s %s %s %s ",
+		pri, now.Format(syslogTimeFormat), f.hostname, syslogAppName, f.procID, syslogMsgID,
+	)
+}
+
+// formatEvent formats the event as an RFC 5424 message with the event fields
+// in structured data and the command line as the message.
+func (f *syslogFormatter) formatEvent(event *exectrace.Event, now time.Time) ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString(f.header(now))
+
+	b.WriteString("[" + syslogSDID)
+	params := []struct {
+		name  string
+		value string
+	}{
+		{"filename", event.Filename},
+		{"argv", shellquote.Join(event.Argv...)},
+		{"pid", strconv.FormatUint(uint64(event.PID), 10)},
+		{"uid", strconv.FormatUint(uint64(event.UID), 10)},
+		{"gid", strconv.FormatUint(uint64(event.GID), 10)},
+		{"comm", event.Comm},
+		{"truncated", strconv.FormatBool(event.Truncated)},
+		{"redacted", strconv.FormatBool(event.Redacted)},
+	}
+	for _, p := range params {
+		b.WriteString(" " + p.name + `="` + escapeSyslogParam(p.value) + `"`)
+	}
+	b.WriteString("] ")
+
+	b.WriteString(escapeControl(strings.ToValidUTF8(shellquote.Join(event.Argv...), "\uFFFD")))
+	return b.Bytes(), nil
+}
+
+// wrap returns a formatter that prefixes messages from fn with an RFC 5424
+// header and no structured data.
+func (f *syslogFormatter) wrap(fn eventFormatter) eventFormatter {
+	return func(event *exectrace.Event, now time.Time) ([]byte, error) {
+		msg, err := fn(event, now)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(f.header(now)+"- "), msg...), nil
+	}
+}
+
+// escapeSyslogParam escapes an SD-PARAM value according to RFC 5424 section
+// 6.3.3. Control characters are also escaped so messages stay on one line.
+func escapeSyslogParam(s string) string {
+	s = strings.ToValidUTF8(s, "\uFFFD")
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(s)
+	return escapeControl(s)
+}
+
+// syslogHeaderField sanitizes a header field so it only contains printable
+// US-ASCII and is at most maxLen characters long, as required by RFC 5424.
+func syslogHeaderField(s string, maxLen int) string {
+	s = strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return -1
+		}
+		return r
+	}, s)
+	if len(s) > maxLen {
+		s = s[:maxLen]
+	}
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// syslogWriter sends each write as a single syslog message to a remote or
+// local syslog server. Datagram transports send one message per packet, TCP
+// uses octet-counting framing (RFC 6587) and unix stream sockets use newline
+// framing.
+type syslogWriter struct {
+	network string
+	addr    string
+
+	mu   sync.Mutex
+	conn net.Conn
+	// connNetwork is the network conn was dialed with, which differs from
+	// network for unix addresses.
+	connNetwork string
+}
+
+// dialSyslog parses addr and connects to the syslog server. The address must
+// be in the form udp://host:port, tcp://host:port, unix:///path or
+// unixgram:///path. A bare host:port is treated as UDP. Like log/syslog, unix
+// addresses are dialed as datagram sockets first, as /dev/log usually is one,
+// and as stream sockets otherwise.
+func dialSyslog(addr string) (*syslogWriter, error) {
+	network, address, err := parseSyslogAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &syslogWriter{
+		network: network,
+		addr:    address,
+	}
+	err = w.connect()
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func parseSyslogAddr(addr string) (network string, address string, err error) {
+	if !strings.Contains(addr, "://") {
+		return "udp", addr, nil
+	}
+
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", "", xerrors.Errorf("parse syslog address %q: %w", addr, err)
+	}
+	switch u.Scheme {
+	case "udp", "udp4", "udp6", "tcp", "tcp4", "tcp6":
+		if u.Host == "" {
+			return "", "", xerrors.Errorf("syslog address %q is missing a host", addr)
+		}
+		return u.Scheme, u.Host, nil
+	case "unix", "unixgram":
+		if u.Path == "" {
+			return "", "", xerrors.Errorf("syslog address %q is missing a path", addr)
+		}
+		return u.Scheme, u.Path, nil
+	default:
+		return "", "", xerrors.Errorf("unsupported syslog address scheme %q, must be udp, tcp, unix or unixgram", u.Scheme)
+	}
+}
+
+func (w *syslogWriter) connect() error {
+	networks := []string{w.network}
+	if w.network == "unix" {
+		networks = []string{"unixgram", "unix"}
+	}
+
+	var err error
+	for _, network := range networks {
+		var conn net.Conn
+		conn, err = net.DialTimeout(network, w.addr, 10*time.Second)
+		if err == nil {
+			w.conn = conn
+			w.connNetwork = network
+			return nil
+		}
+	}
+	return xerrors.Errorf("dial syslog %s://%s: %w", w.network, w.addr, err)
+}
+
+func (w *syslogWriter) frame(p []byte) []byte {
+	switch w.connNetwork {
+	case "tcp", "tcp4", "tcp6":
+		return append([]byte(strconv.Itoa(len(p))+" "), p...)
+	case "unix":
+		return append(p, '\n')
+	default:
+		return p
+	}
+}
+
+// Write sends p as a single message. If the write fails, the connection is
+// re-established and the write is retried once.
+func (w *syslogWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	msg := w.frame(p)
+	if w.conn != nil {
+		_, err := w.conn.Write(msg)
+		if err == nil {
+			return len(p), nil
+		}
+		_ = w.conn.Close()
+		w.conn = nil
+	}
+
+	err := w.connect()
+	if err != nil {
+		return 0, err
+	}
+	_, err = w.conn.Write(msg)
+	if err != nil {
+		return 0, xerrors.Errorf("write to syslog: %w", err)
+	}
+	return len(p), nil
+}
+
+func (w *syslogWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/coder/exectrace"
+)
+
+var testEvent = &exectrace.Event{
+	Filename:  "/usr/bin/echo",
+	Argv:      []string{"echo", `a"b]c\d`, "x|y=z", "line1\nline2"},
+	Truncated: true,
+	PID:       1234,
+	UID:       1000,
+	GID:       1001,
+	Comm:      "bash",
+}
+
+var testTime = time.Date(2024, 5, 6, 7, 8, 9, 123456000, time.UTC)
+
+func TestFormatSyslog(t *testing.T) {
+	t.Parallel()
+
+	f := &syslogFormatter{facility: syslogFacilities["authpriv"], hostname: "host", procID: "99"}
+	msg, err := f.formatEvent(testEvent, testTime)
+	require.NoError(t, err)
+
+	expected := `<86>1 2024-05-06T07:08:09.123456Z host exectrace 99 exec ` +
+		`[exec@32473 filename="/usr/bin/echo" argv="echo a\\\"b\]c\\\\d x\\|y=z 'line1\nline2'" ` +
+		`pid="1234" uid="1000" gid="1001" comm="bash" truncated="true" redacted="false"] ` +
+		`echo a\"b]c\\d x\|y=z 'line1\nline2'`
+	require.Equal(t, expected, string(msg))
+	require.NotContains(t, string(msg), "\n")
+}
+
+func TestFormatCEF(t *testing.T) {
+	t.Parallel()
+
+	f := &cefFormatter{hostname: "host", version: "v1|2"}
+	msg, err := f.formatEvent(testEvent, testTime)
+	require.NoError(t, err)
+
+	expected := `CEF:0|Coder|exectrace|v1\|2|exec|Process executed|3|` +
+		`rt=1714979289123 dvchost=host dpid=1234 duid=1000 dproc=echo filePath=/usr/bin/echo sproc=bash ` +
+		`cs1Label=cmdline cs1=echo a\\"b]c\\\\d x\\|y\=z 'line1\nline2' ` +
+		`cn1Label=gid cn1=1001 cs2Label=truncated cs2=true cs3Label=redacted cs3=false`
+	require.Equal(t, expected, string(msg))
+}
+
+func TestSyslogWriter(t *testing.T) {
+	t.Parallel()
+
+	t.Run("UDP", func(t *testing.T) {
+		t.Parallel()
+
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer conn.Close()
+
+		w, err := dialSyslog("udp://" + conn.LocalAddr().String())
+		require.NoError(t, err)
+		defer w.Close()
+
+		for _, msg := range []string{"first message", "second message"} {
+			_, err = w.Write([]byte(msg))
+			require.NoError(t, err)
+
+			buf := make([]byte, 1024)
+			_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			n, _, err := conn.ReadFrom(buf)
+			require.NoError(t, err)
+			require.Equal(t, msg, string(buf[:n]))
+		}
+	})
+
+	t.Run("TCP", func(t *testing.T) {
+		t.Parallel()
+
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer l.Close()
+
+		w, err := dialSyslog("tcp://" + l.Addr().String())
+		require.NoError(t, err)
+		defer w.Close()
+
+		conn, err := l.Accept()
+		require.NoError(t, err)
+		defer conn.Close()
+
+		msgs := []string{"first message", "second\nmessage"}
+		for _, msg := range msgs {
+			_, err = w.Write([]byte(msg))
+			require.NoError(t, err)
+		}
+
+		// Parse octet-counting framing.
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		r := bufio.NewReader(conn)
+		for _, msg := range msgs {
+			rawLen, err := r.ReadString(' ')
+			require.NoError(t, err)
+			n, err := strconv.Atoi(strings.TrimSpace(rawLen))
+			require.NoError(t, err)
+			buf := make([]byte, n)
+			_, err = io.ReadFull(r, buf)
+			require.NoError(t, err)
+			require.Equal(t, msg, string(buf))
+		}
+	})
+
+	t.Run("Unix", func(t *testing.T) {
+		t.Parallel()
+
+		path := filepath.Join(t.TempDir(), "syslog.sock")
+		l, err := net.Listen("unix", path)
+		require.NoError(t, err)
+		defer l.Close()
+
+		w, err := dialSyslog("unix://" + path)
+		require.NoError(t, err)
+		defer w.Close()
+
+		conn, err := l.Accept()
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = w.Write([]byte("unix message"))
+		require.NoError(t, err)
+
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		require.NoError(t, err)
+		require.Equal(t, "unix message\n", line)
+	})
+
+	t.Run("UnixDatagram", func(t *testing.T) {
+		t.Parallel()
+
+		// unix:// addresses also work with datagram sockets like /dev/log.
+		path := filepath.Join(t.TempDir(), "log")
+		conn, err := net.ListenPacket("unixgram", path)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		w, err := dialSyslog("unix://" + path)
+		require.NoError(t, err)
+		defer w.Close()
+
+		_, err = w.Write([]byte("datagram message"))
+		require.NoError(t, err)
+
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		buf := make([]byte, 1024)
+		n, _, err := conn.ReadFrom(buf)
+		require.NoError(t, err)
+		require.Equal(t, "datagram message", string(buf[:n]))
+	})
+
+	t.Run("InvalidAddr", func(t *testing.T) {
+		t.Parallel()
+
+		for _, addr := range []string{"http://localhost:514", "tcp://", "unix://"} {
+			_, err := dialSyslog(addr)
+			require.Error(t, err, addr)
+		}
+	})
+}
+
+func TestEventWriterSyslogCEF(t *testing.T) {
+	t.Parallel()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	w, err := newEventWriter(outputOptions{
+		Format:         outputCEF,
+		SyslogAddr:     "udp://" + conn.LocalAddr().String(),
+		SyslogFacility: "local0",
+	}, nil)
+	require.NoError(t, err)
+	defer w.Close()
+
+	err = w.WriteEvent(testEvent, testTime)
+	require.NoError(t, err)
+
+	buf := make([]byte, 4096)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	msg := string(buf[:n])
+	require.True(t, strings.HasPrefix(msg, "<134>1 "), msg)
+	require.Contains(t, msg, " exectrace ")
+	require.Contains(t, msg, " exec - CEF:0|Coder|exectrace|")
+
+	// Text output can't be sent to syslog.
+	_, err = newEventWriter(outputOptions{
+		Format:         outputText,
+		SyslogAddr:     "udp://" + conn.LocalAddr().String(),
+		SyslogFacility: "user",
+	}, nil)
+	require.Error(t, err)
+}
+
+func TestEventWriterStdout(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	w, err := newEventWriter(outputOptions{
+		Format:         outputSyslog,
+		SyslogFacility: "user",
+	}, &buf)
+	require.NoError(t, err)
+	defer w.Close()
+
+	err = w.WriteEvent(testEvent, testTime)
+	require.NoError(t, err)
+	err = w.WriteEvent(testEvent, testTime)
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	require.Len(t, lines, 2)
+	require.True(t, strings.HasPrefix(lines[0], "<14>1 "), lines[0])
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/kballard/go-shellquote"
+	"golang.org/x/xerrors"
+
+	"github.com/coder/exectrace"
+)
+
+// templateEvent is the data passed to output templates. The fields of the
+// event can be used directly, e.g. {{.PID}}.
+type templateEvent struct {
+	*exectrace.Event
+	// Time is when the event was read.
+	Time time.Time `json:"time"`
+}
+
+// templateTimeLayouts are the layout names accepted by the timefmt template
+// function in addition to Go layouts.
+var templateTimeLayouts = map[string]string{
+	"rfc3339":     time.RFC3339,
+	"rfc3339nano": time.RFC3339Nano,
+	"datetime":    "2006-01-02 15:04:05",
+	"kitchen":     time.Kitchen,
+	"stamp":       time.StampMilli,
+}
+
+// templateFuncs are the functions available in output templates.
+var templateFuncs = template.FuncMap{
+	// join joins a list of strings with a separator.
+	"join": func(list []string, sep string) string {
+		return strings.Join(list, sep)
+	},
+	// shellquote quotes a string or a list of strings so it can be pasted
+	// into a shell.
+	"shellquote": func(v interface{}) (string, error) {
+		switch v := v.(type) {
+		case string:
+			return shellquote.Join(v), nil
+		case []string:
+			return shellquote.Join(v...), nil
+		default:
+			return "", xerrors.Errorf("shellquote: unsupported type %T", v)
+		}
+	},
+	// json encodes a value as JSON, e.g. {{json .}} for the whole event.
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", xerrors.Errorf("json: %w", err)
+		}
+		return string(b), nil
+	},
+	// timefmt formats a time with a Go layout or one of
+	// templateTimeLayouts. "unix" and "unixmilli" format it as a Unix
+	// timestamp.
+	"timefmt": func(layout string, t time.Time) string {
+		switch layout {
+		case "unix":
+			return fmt.Sprint(t.Unix())
+		case "unixmilli":
+			return fmt.Sprint(t.UnixMilli())
+		}
+		if named, ok := templateTimeLayouts[layout]; ok {
+			layout = named
+		}
+		return t.Format(layout)
+	},
+	// ellipsis returns "..." if the argument is true, which matches the
+	// marker for truncated events in the text output.
+	"ellipsis": func(truncated bool) string {
+		if truncated {
+			return "..."
+		}
+		return ""
+	},
+	// trunc shortens a string to at most n characters, replacing the end
+	// with "..." if it's too long.
+	"trunc": func(n int, s string) string {
+		if utf8.RuneCountInString(s) <= n {
+			return s
+		}
+		if n <= 3 {
+			return strings.Repeat(".", n)
+		}
+		return string([]rune(s)[:n-3]) + "..."
+	},
+}
+
+// templateFormatter formats events with a Go text/template. Newlines, carriage
+// returns and tabs in the output are escaped like in the text format, so argv
+// can't inject fake events into line-oriented output.
+type templateFormatter struct {
+	tmpl *template.Template
+}
+
+// newTemplateFormatter parses the template text. A single trailing newline is
+// ignored as each event is already written on its own line.
+func newTemplateFormatter(text string) (*templateFormatter, error) {
+	tmpl, err := template.New("output").Funcs(templateFuncs).Parse(strings.TrimSuffix(text, "\n"))
+	if err != nil {
+		return nil, xerrors.Errorf("parse output template: %w", err)
+	}
+	return &templateFormatter{tmpl: tmpl}, nil
+}
+
+func (f *templateFormatter) formatEvent(event *exectrace.Event, now time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	err := f.tmpl.Execute(&buf, templateEvent{Event: event, Time: now})
+	if err != nil {
+		return nil, xerrors.Errorf("execute output template: %w", err)
+	}
+	return []byte(escapeControl(buf.String())), nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatTemplate(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name     string
+		template string
+		expected string
+	}{
+		// Control characters are always escaped so argv can't inject lines.
+		{"Fields", `{{.PID}} {{.Comm}} {{join .Argv " "}}`, `1234 bash echo a"b]c\d x|y=z line1\nline2`},
+		{"ShellQuote", `{{shellquote .Argv}}{{ellipsis .Truncated}} {{shellquote .Filename}}`, `echo a\"b]c\\d x\|y=z 'line1\nline2'... /usr/bin/echo`},
+		{"JSON", `{{json .Comm}} {{json .Argv}}`, `"bash" ["echo","a\"b]c\\d","x|y=z","line1\nline2"]`},
+		{"JSONEvent", `{{json .}}`, `{"filename":"/usr/bin/echo","argv":["echo","a\"b]c\\d","x|y=z","line1\nline2"],"truncated":true,"redacted":false,"pid":1234,"uid":1000,"gid":1001,"comm":"bash","time":"2024-05-06T07:08:09.123456Z"}`},
+		{"Time", `{{timefmt "rfc3339" .Time}} {{.Time | timefmt "15:04:05"}} {{timefmt "unixmilli" .Time}}`, "2024-05-06T07:08:09Z 07:08:09 1714979289123"},
+		{"Trunc", `{{trunc 6 .Filename}}|{{trunc 20 .Filename}}|{{trunc 2 .Filename}}`, "/us...|/usr/bin/echo|.."},
+		{"TrailingNewline", "{{.UID}}:{{.GID}}\n", "1000:1001"},
+		{"Newline", "{{.UID}}\n{{.GID}}", `1000\n1001`},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			f, err := newTemplateFormatter(tc.template)
+			require.NoError(t, err)
+			msg, err := f.formatEvent(testEvent, testTime)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, string(msg))
+		})
+	}
+
+	t.Run("Invalid", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := newTemplateFormatter(`{{.PID`)
+		require.ErrorContains(t, err, "parse output template")
+
+		f, err := newTemplateFormatter(`{{.Nope}}`)
+		require.NoError(t, err)
+		_, err = f.formatEvent(testEvent, testTime)
+		require.ErrorContains(t, err, "execute output template")
+	})
+}
+
+func TestEventWriterTemplate(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	w, err := newEventWriter(outputOptions{
+		Format:         outputTemplate,
+		Template:       "{{.PID}} {{.Comm}}\n",
+		SyslogFacility: "user",
+	}, &buf)
+	require.NoError(t, err)
+	defer w.Close()
+
+	err = w.WriteEvent(testEvent, testTime)
+	require.NoError(t, err)
+	require.Equal(t, "1234 bash\n", buf.String())
+
+	// The template is required, and only used by the template format.
+	_, err = newEventWriter(outputOptions{
+		Format:         outputTemplate,
+		SyslogFacility: "user",
+	}, &buf)
+	require.ErrorContains(t, err, "--template")
+	_, err = newEventWriter(outputOptions{
+		Format:         outputText,
+		Template:       "{{.PID}}",
+		SyslogFacility: "user",
+	}, &buf)
+	require.ErrorContains(t, err, "--template")
+}
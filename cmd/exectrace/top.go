@@ -0,0 +1,680 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/kballard/go-shellquote"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+	"golang.org/x/xerrors"
+
+	"github.com/coder/exectrace"
+)
+
+const (
+	// topRefreshInterval is how often the screen is redrawn when no keys are
+	// pressed.
+	topRefreshInterval = time.Second
+	// topRateWindow is the window the execs per second rate is averaged over.
+	topRateWindow = 5 * time.Second
+	// topMaxBinaries and topMaxTruncated are the number of lines shown in the
+	// top binaries and truncated execs sections.
+	topMaxBinaries  = 5
+	topMaxTruncated = 3
+
+	// topMaxPending is the number of events kept while paused, newer events
+	// are dropped.
+	topMaxPending = 100000
+	// topMaxProcesses is the number of processes kept in the exec tree, the
+	// oldest processes are removed first.
+	topMaxProcesses = 10000
+	// topMaxBinaryNames is the number of binaries counted, the least exec'd
+	// binaries are removed first.
+	topMaxBinaryNames = 10000
+)
+
+func topCmd() *cobra.Command {
+	var tracer tracerFlags
+
+	cmd := &cobra.Command{
+		Use:   "top",
+		Short: "Show a live view of the exec tree and the most frequently exec'd binaries.",
+		Long: "Show a live view of all execs in the terminal, with the exec tree and the number of execs under each " +
+			"process, the most frequently exec'd binaries, the exec rate, and the truncated and failed execs.\n\n" +
+			"Press p to pause the view, / to only show processes and binaries matching a search, c to clear the " +
+			"statistics and q to quit. Statistics are kept in memory until they are cleared, up to " +
+			strconv.Itoa(topMaxProcesses) + " processes in the exec tree.",
+		Args: cobra.NoArgs,
+		Run: func(_ *cobra.Command, _ []string) {
+			tracerOpts, err := tracer.tracerOpts()
+			if err != nil {
+				//nolint:revive
+				log.Fatalf("invalid tracer options: %+v", err)
+			}
+
+			err = runTop(tracerOpts)
+			if err != nil {
+				//nolint:revive
+				log.Fatalf("top: %+v", err)
+			}
+		},
+	}
+
+	tracer.register(cmd)
+	return cmd
+}
+
+// topStats aggregates the events shown by top.
+type topStats struct {
+	count int
+	roots []*topProcess
+	// byPID contains the latest process with each PID.
+	byPID map[uint32]*topProcess
+	// processes contains all processes in the tree, oldest first.
+	processes      []*topProcess
+	maxProcesses   int
+	binaries       map[string]int
+	maxBinaries    int
+	truncated      []*exectrace.Event
+	truncatedCount int
+
+	started time.Time
+	// recent contains the times of the events in the last topRateWindow.
+	recent []time.Time
+}
+
+func newTopStats(now time.Time) *topStats {
+	return &topStats{
+		byPID:        map[uint32]*topProcess{},
+		maxProcesses: topMaxProcesses,
+		binaries:     map[string]int{},
+		maxBinaries:  topMaxBinaryNames,
+		started:      now,
+	}
+}
+
+// Add adds an event received at the given time. Events must be added in the
+// order they happened.
+func (s *topStats) Add(event *exectrace.Event, now time.Time) {
+	s.count++
+	s.addProcess(event)
+	s.addBinary(binaryName(event))
+	if event.Truncated {
+		s.truncatedCount++
+		s.truncated = append(s.truncated, event)
+		if len(s.truncated) > topMaxTruncated {
+			s.truncated = s.truncated[1:]
+		}
+	}
+	s.recent = append(s.recent, now)
+}
+
+// topProcess is a node of the exec tree. Unlike the tree printed by run, execs
+// of the same process are combined, as shells and execvp() try to exec each
+// directory in PATH until one succeeds.
+type topProcess struct {
+	// event is the latest exec of the process.
+	event    *exectrace.Event
+	execs    int
+	parent   *topProcess
+	children []*topProcess
+}
+
+// addProcess adds the event to the process that exec'd, or to a new child of
+// its parent. Events whose parent didn't exec are added at the top level.
+func (s *topStats) addProcess(event *exectrace.Event) {
+	p, ok := s.byPID[event.PID]
+	// The PID may have been reused by a new process.
+	if ok && (event.PPID == 0 || event.PPID == p.event.PPID) {
+		p.event = event
+		p.execs++
+		return
+	}
+
+	p = &topProcess{event: event, execs: 1}
+	parent, ok := s.byPID[event.PPID]
+	if ok && event.PPID != 0 {
+		p.parent = parent
+		parent.children = append(parent.children, p)
+	} else {
+		s.roots = append(s.roots, p)
+	}
+	s.byPID[event.PID] = p
+	s.processes = append(s.processes, p)
+
+	for len(s.processes) > s.maxProcesses {
+		s.removeProcess(s.processes[0])
+		s.processes = s.processes[1:]
+	}
+}
+
+// removeProcess removes p from the tree. Its children are moved to the top
+// level.
+func (s *topStats) removeProcess(p *topProcess) {
+	if s.byPID[p.event.PID] == p {
+		delete(s.byPID, p.event.PID)
+	}
+	if p.parent != nil {
+		p.parent.children = removeTopProcess(p.parent.children, p)
+	} else {
+		s.roots = removeTopProcess(s.roots, p)
+	}
+	for _, child := range p.children {
+		child.parent = nil
+		s.roots = append(s.roots, child)
+	}
+	p.children = nil
+}
+
+func removeTopProcess(processes []*topProcess, p *topProcess) []*topProcess {
+	for i, other := range processes {
+		if other == p {
+			return append(processes[:i], processes[i+1:]...)
+		}
+	}
+	return processes
+}
+
+// addBinary counts an exec of the binary. When too many binaries are counted,
+// the least exec'd half is removed.
+func (s *topStats) addBinary(name string) {
+	s.binaries[name]++
+	if len(s.binaries) <= s.maxBinaries {
+		return
+	}
+
+	counts := make([]int, 0, len(s.binaries))
+	for _, count := range s.binaries {
+		counts = append(counts, count)
+	}
+	sort.Ints(counts)
+	cutoff := counts[len(counts)/2]
+	for other, count := range s.binaries {
+		if count <= cutoff && other != name {
+			delete(s.binaries, other)
+		}
+	}
+}
+
+// Rate returns the average number of execs per second over the last
+// topRateWindow, or since the stats were created if that's shorter.
+func (s *topStats) Rate(now time.Time) float64 {
+	cutoff := now.Add(-topRateWindow)
+	i := sort.Search(len(s.recent), func(i int) bool {
+		return s.recent[i].After(cutoff)
+	})
+	s.recent = s.recent[i:]
+
+	window := now.Sub(s.started)
+	if window > topRateWindow {
+		window = topRateWindow
+	}
+	if window < time.Second {
+		window = time.Second
+	}
+	return float64(len(s.recent)) / window.Seconds()
+}
+
+// binaryName returns the name that execs of the same binary are grouped by.
+func binaryName(event *exectrace.Event) string {
+	if event.Filename != "" {
+		return event.Filename
+	}
+	if len(event.Argv) > 0 {
+		return event.Argv[0]
+	}
+	return "?"
+}
+
+// topView contains the state of the screen that isn't part of the stats.
+type topView struct {
+	Width  int
+	Height int
+	Now    time.Time
+	// Failed is the number of failed execs, or -1 if the tracer doesn't count
+	// them.
+	Failed int64
+	// Paused is true if new events aren't added to the stats. Pending is the
+	// number of events received while paused, and Dropped is the number of
+	// those that were dropped because too many events were received.
+	Paused  bool
+	Pending int
+	Dropped int
+	// Search only shows the processes and binaries containing it, ignoring
+	// case. Typing is true while the search is being entered.
+	Search string
+	Typing bool
+}
+
+// Render returns the lines to show on the screen, without trailing newlines.
+func (s *topStats) Render(v topView) []string {
+	search := strings.ToLower(v.Search)
+
+	header := fmt.Sprintf("exectrace top: %d execs, %.1f/s, %d truncated", s.count, s.Rate(v.Now), s.truncatedCount)
+	if v.Failed >= 0 {
+		header += fmt.Sprintf(", %d failed", v.Failed)
+	}
+	if v.Paused {
+		header += fmt.Sprintf(" [paused, %d new", v.Pending)
+		if v.Dropped > 0 {
+			header += fmt.Sprintf(", %d dropped", v.Dropped)
+		}
+		header += "]"
+	}
+
+	var binaries []string
+	for name := range s.binaries {
+		if matchesSearch(search, name) {
+			binaries = append(binaries, name)
+		}
+	}
+	sort.Slice(binaries, func(i, j int) bool {
+		ci, cj := s.binaries[binaries[i]], s.binaries[binaries[j]]
+		if ci != cj {
+			return ci > cj
+		}
+		return binaries[i] < binaries[j]
+	})
+	if len(binaries) > topMaxBinaries {
+		binaries = binaries[:topMaxBinaries]
+	}
+
+	var truncated []string
+	for i := len(s.truncated) - 1; i >= 0; i-- {
+		line := eventLabel(s.truncated[i])
+		if matchesSearch(search, line) {
+			truncated = append(truncated, "  "+line)
+		}
+	}
+
+	footer := "q quit  p pause  / search  c clear"
+	switch {
+	case v.Typing:
+		footer = "/" + v.Search
+	case v.Search != "":
+		footer += fmt.Sprintf("  search: %q (esc clears)", v.Search)
+	}
+
+	lines := []string{header, "", "Top binaries"}
+	for _, name := range binaries {
+		lines = append(lines, fmt.Sprintf("%7d  %s", s.binaries[name], name))
+	}
+	lines = append(lines, "", "Exec tree")
+
+	// The tree gets the lines that are left.
+	treeHeight := v.Height - len(lines) - 1
+	if len(truncated) > 0 {
+		treeHeight -= len(truncated) + 2
+	}
+	lines = append(lines, s.treeLines(search, treeHeight)...)
+
+	if len(truncated) > 0 {
+		lines = append(lines, "", "Recently truncated")
+		lines = append(lines, truncated...)
+	}
+	if len(lines) > v.Height-1 {
+		lines = lines[:v.Height-1]
+	}
+	for len(lines) < v.Height-1 {
+		lines = append(lines, "")
+	}
+	lines = append(lines, footer)
+
+	for i, line := range lines {
+		lines[i] = truncateLine(sanitizeTerminal(line), v.Width)
+	}
+	return lines
+}
+
+// topNode is a process in the rendered exec tree with the number of execs of
+// it and its descendants.
+type topNode struct {
+	process  *topProcess
+	count    int
+	children []*topNode
+}
+
+// treeLines returns up to max lines of the exec tree, with the subtrees that
+// have the most execs first. If search is set, only the processes matching it
+// and their ancestors are included.
+func (s *topStats) treeLines(search string, max int) []string {
+	if max <= 0 {
+		return nil
+	}
+	roots := topNodes(s.roots, search)
+
+	var lines []string
+	var walk func(n *topNode, prefix, childPrefix string)
+	walk = func(n *topNode, prefix, childPrefix string) {
+		if len(lines) == max {
+			return
+		}
+		lines = append(lines, fmt.Sprintf("%7d  %s%s", n.count, prefix, eventLabel(n.process.event)))
+		for i, child := range n.children {
+			if i == len(n.children)-1 {
+				walk(child, childPrefix+"└── ", childPrefix+"    ")
+			} else {
+				walk(child, childPrefix+"├── ", childPrefix+"│   ")
+			}
+		}
+	}
+	for _, root := range roots {
+		walk(root, "", "")
+	}
+	return lines
+}
+
+// topNodes converts the nodes and their descendants to topNodes sorted by
+// their number of execs. Nodes that don't match search and have no matching
+// descendants are left out.
+func topNodes(processes []*topProcess, search string) []*topNode {
+	var out []*topNode
+	for _, process := range processes {
+		n, _ := newTopNode(process, search)
+		if n != nil {
+			out = append(out, n)
+		}
+	}
+	sortTopNodes(out)
+	return out
+}
+
+// newTopNode returns the topNode for process, or nil if it's filtered out by
+// search, and the number of execs of it and its descendants in both cases.
+func newTopNode(process *topProcess, search string) (*topNode, int) {
+	n := &topNode{process: process, count: process.execs}
+	for _, child := range process.children {
+		c, count := newTopNode(child, search)
+		n.count += count
+		if c != nil {
+			n.children = append(n.children, c)
+		}
+	}
+	if len(n.children) == 0 && !matchesSearch(search, eventLabel(process.event)) {
+		return nil, n.count
+	}
+	sortTopNodes(n.children)
+	return n, n.count
+}
+
+func sortTopNodes(nodes []*topNode) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return nodes[i].count > nodes[j].count
+	})
+}
+
+// eventLabel returns the PID and command line of an event.
+func eventLabel(event *exectrace.Event) string {
+	ellipsis := ""
+	if event.Truncated {
+		ellipsis = "..."
+	}
+	return fmt.Sprintf("[%d] %s%s", event.PID, shellquote.Join(event.Argv...), ellipsis)
+}
+
+// matchesSearch returns true if s contains the lowercase search, ignoring
+// case. Everything matches an empty search.
+func matchesSearch(search, s string) bool {
+	return search == "" || strings.Contains(strings.ToLower(s), search)
+}
+
+// sanitizeTerminal escapes control characters in s so argv can't move the
+// cursor or change the terminal state.
+func sanitizeTerminal(s string) string {
+	s = escapeControl(s)
+	if strings.IndexFunc(s, unicode.IsControl) == -1 {
+		return s
+	}
+	var b strings.Builder
+	for _, r := range s {
+		if unicode.IsControl(r) {
+			// Strip the quotes from e.g. '\x1b'.
+			quoted := strconv.QuoteRune(r)
+			b.WriteString(quoted[1 : len(quoted)-1])
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// truncateLine shortens s to width runes.
+func truncateLine(s string, width int) string {
+	if width <= 0 || utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:width])
+}
+
+// Escape sequences used by top.
+const (
+	ansiEnterAltScreen = "\x1b[?1049h\x1b[?25l"
+	ansiLeaveAltScreen = "\x1b[?25h\x1b[?1049l"
+	ansiHome           = "\x1b[H"
+	ansiClearLine      = "\x1b[K"
+	ansiClearBelow     = "\x1b[J"
+)
+
+func runTop(opts *exectrace.TracerOpts) error {
+	stdin, stdout := int(os.Stdin.Fd()), int(os.Stdout.Fd())
+	if !term.IsTerminal(stdin) || !term.IsTerminal(stdout) {
+		return xerrors.New("stdin and stdout must be a terminal")
+	}
+
+	t, err := exectrace.New(opts)
+	if err != nil {
+		return xerrors.Errorf("start tracer: %w", err)
+	}
+	defer t.Close()
+	counter, _ := t.(exectrace.ExecFailureCounter)
+
+	// Logs would be drawn over the screen, so they're printed after it's
+	// closed.
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		_, _ = io.Copy(os.Stderr, &logs)
+	}()
+
+	state, err := term.MakeRaw(stdin)
+	if err != nil {
+		return xerrors.Errorf("make terminal raw: %w", err)
+	}
+	defer func() {
+		_ = term.Restore(stdin, state)
+	}()
+	_, _ = os.Stdout.WriteString(ansiEnterAltScreen)
+	defer func() {
+		_, _ = os.Stdout.WriteString(ansiLeaveAltScreen)
+	}()
+
+	events := make(chan *exectrace.Event)
+	readErr := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			event, err := t.Read()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					readErr <- nil
+					return
+				}
+				log.Printf("error reading from reader: %+v", err)
+				continue
+			}
+			select {
+			case events <- event:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	keys := make(chan []byte)
+	go func() {
+		for {
+			buf := make([]byte, 64)
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				close(keys)
+				return
+			}
+			keys <- buf[:n]
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, append([]os.Signal{os.Interrupt, syscall.SIGTERM}, resizeSignals...)...)
+	defer signal.Stop(signals)
+
+	ticker := time.NewTicker(topRefreshInterval)
+	defer ticker.Stop()
+
+	var (
+		stats   = newTopStats(time.Now())
+		view    topView
+		pending []*exectrace.Event
+		// pendingTimes are the times the pending events were received.
+		pendingTimes []time.Time
+		// pendingDropped is the number of events dropped while paused.
+		pendingDropped int
+	)
+	// The tracer counts all failed execs since it started, or since its maps
+	// were pinned, so the count is shown relative to when the stats were last
+	// cleared.
+	failedExecs := func() int64 {
+		if counter == nil {
+			return -1
+		}
+		failed, err := counter.FailedExecs()
+		if err != nil {
+			return -1
+		}
+		return int64(failed)
+	}
+	failedBase := failedExecs()
+
+	draw := func() {
+		width, height, err := term.GetSize(stdout)
+		if err != nil {
+			width, height = 80, 24
+		}
+		view.Width, view.Height, view.Now = width, height, time.Now()
+		view.Pending = len(pending) + pendingDropped
+		view.Dropped = pendingDropped
+		if !view.Paused {
+			view.Failed = failedExecs()
+			if view.Failed >= 0 && failedBase >= 0 {
+				view.Failed -= failedBase
+			}
+		}
+
+		var b strings.Builder
+		b.WriteString(ansiHome)
+		for i, line := range stats.Render(view) {
+			if i > 0 {
+				// The terminal is in raw mode, so newlines don't return the
+				// cursor.
+				b.WriteString("\r\n")
+			}
+			b.WriteString(line)
+			b.WriteString(ansiClearLine)
+		}
+		b.WriteString(ansiClearBelow)
+		_, _ = os.Stdout.WriteString(b.String())
+	}
+	draw()
+
+	for {
+		select {
+		case event := <-events:
+			if view.Paused {
+				if len(pending) >= topMaxPending {
+					pendingDropped++
+					continue
+				}
+				pending = append(pending, event)
+				pendingTimes = append(pendingTimes, time.Now())
+				continue
+			}
+			stats.Add(event, time.Now())
+		case err := <-readErr:
+			return err
+		case sig := <-signals:
+			if sig == os.Interrupt || sig == syscall.SIGTERM {
+				return nil
+			}
+			draw()
+		case <-ticker.C:
+			draw()
+		case input, ok := <-keys:
+			if !ok {
+				return nil
+			}
+			for _, key := range input {
+				if view.Typing {
+					switch key {
+					case 0x03:
+						return nil
+					case '\r', '\n':
+						view.Typing = false
+					case 0x1b:
+						view.Typing, view.Search = false, ""
+					case 0x7f, '\b':
+						if view.Search != "" {
+							_, size := utf8.DecodeLastRuneInString(view.Search)
+							view.Search = view.Search[:len(view.Search)-size]
+						}
+					default:
+						if key >= ' ' {
+							view.Search += string([]byte{key})
+						}
+					}
+				} else {
+					switch key {
+					case 'q', 0x03:
+						return nil
+					case 'p', ' ':
+						view.Paused = !view.Paused
+						if !view.Paused {
+							for i, event := range pending {
+								stats.Add(event, pendingTimes[i])
+							}
+							pending, pendingTimes, pendingDropped = nil, nil, 0
+						}
+					case '/':
+						view.Typing, view.Search = true, ""
+					case 0x1b:
+						view.Search = ""
+					case 'c':
+						stats = newTopStats(time.Now())
+						pending, pendingTimes, pendingDropped = nil, nil, 0
+						failedBase = failedExecs()
+					}
+				}
+				if key == 0x1b {
+					// Ignore the rest of escape sequences such as arrow
+					// keys.
+					break
+				}
+			}
+			draw()
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/coder/exectrace"
+)
+
+func TestTopStats(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	newStats := func() *topStats {
+		stats := newTopStats(start)
+		for i, event := range []*exectrace.Event{
+			{PID: 10, PPID: 1, Filename: "/usr/bin/npm", Argv: []string{"npm", "install"}},
+			{PID: 11, PPID: 10, Filename: "/bin/sh", Argv: []string{"sh", "-c", "node-gyp rebuild"}},
+			{PID: 12, PPID: 11, Filename: "/usr/bin/node-gyp", Argv: []string{"node-gyp", "rebuild"}},
+			{PID: 13, PPID: 12, Filename: "/usr/bin/make", Argv: []string{"make", "\x1b[2J"}, Truncated: true},
+			// execvp() tries each directory in PATH.
+			{PID: 14, PPID: 10, Filename: "/usr/local/bin/git", Argv: []string{"git", "--version"}},
+			{PID: 14, PPID: 10, Filename: "/usr/bin/git", Argv: []string{"git", "--version"}},
+			{PID: 15, PPID: 10, Filename: "/bin/sh", Argv: []string{"sh", "-c", "true"}},
+			{PID: 20, PPID: 2, Filename: "/usr/bin/git", Argv: []string{"git", "status"}},
+		} {
+			stats.Add(event, start.Add(time.Duration(i)*time.Second))
+		}
+		return stats
+	}
+
+	t.Run("Render", func(t *testing.T) {
+		t.Parallel()
+
+		lines := newStats().Render(topView{
+			Width:  60,
+			Height: 24,
+			Now:    start.Add(8 * time.Second),
+			Failed: 2,
+		})
+		require.Equal(t, `exectrace top: 8 execs, 0.8/s, 1 truncated, 2 failed
+
+Top binaries
+      2  /bin/sh
+      2  /usr/bin/git
+      1  /usr/bin/make
+      1  /usr/bin/node-gyp
+      1  /usr/bin/npm
+
+Exec tree
+      7  [10] npm install
+      3  ├── [11] sh -c 'node-gyp rebuild'
+      2  │   └── [12] node-gyp rebuild
+      1  │       └── [13] make \x1b\[2J...
+      2  ├── [14] git --version
+      1  └── [15] sh -c true
+      1  [20] git status
+
+Recently truncated
+  [13] make \x1b\[2J...
+
+
+
+q quit  p pause  / search  c clear`, strings.Join(lines, "\n"))
+	})
+
+	t.Run("Search", func(t *testing.T) {
+		t.Parallel()
+
+		lines := newStats().Render(topView{
+			Width:  70,
+			Height: 14,
+			Now:    start.Add(time.Minute),
+			Failed: -1,
+			Paused: true,
+			Search: "GIT",
+		})
+		require.Equal(t, `exectrace top: 8 execs, 0.0/s, 1 truncated [paused, 0 new]
+
+Top binaries
+      2  /usr/bin/git
+      1  /usr/local/bin/git
+
+Exec tree
+      7  [10] npm install
+      2  └── [14] git --version
+      1  [20] git status
+
+
+
+q quit  p pause  / search  c clear  search: "GIT" (esc clears)`, strings.Join(lines, "\n"))
+	})
+
+	t.Run("Rate", func(t *testing.T) {
+		t.Parallel()
+
+		stats := newTopStats(start)
+		for i := 0; i < 10; i++ {
+			stats.Add(&exectrace.Event{PID: uint32(i)}, start.Add(time.Duration(i)*time.Second/2))
+		}
+		// Only the last 5 seconds are counted.
+		require.InDelta(t, 1.8, stats.Rate(start.Add(5*time.Second)), 0.01)
+		require.InDelta(t, 0.8, stats.Rate(start.Add(7500*time.Millisecond)), 0.01)
+		// The rate is averaged over a shorter window right after starting.
+		stats = newTopStats(start)
+		stats.Add(&exectrace.Event{PID: 1}, start)
+		stats.Add(&exectrace.Event{PID: 2}, start)
+		require.InDelta(t, 1.0, stats.Rate(start.Add(2*time.Second)), 0.01)
+	})
+	t.Run("Limits", func(t *testing.T) {
+		t.Parallel()
+
+		stats := newTopStats(start)
+		stats.maxProcesses = 3
+		stats.maxBinaries = 4
+		for i, event := range []*exectrace.Event{
+			{PID: 10, PPID: 1, Filename: "/bin/a"},
+			{PID: 11, PPID: 10, Filename: "/bin/a"},
+			{PID: 12, PPID: 11, Filename: "/bin/b"},
+			{PID: 13, PPID: 10, Filename: "/bin/c"},
+			{PID: 14, PPID: 12, Filename: "/bin/d"},
+			{PID: 15, PPID: 1, Filename: "/bin/e"},
+		} {
+			stats.Add(event, start.Add(time.Duration(i)*time.Second))
+		}
+
+		// The oldest processes are removed and their children are moved to
+		// the top level.
+		require.Len(t, stats.byPID, 3)
+		require.Equal(t, []string{
+			"      1  [13] ",
+			"      1  [15] ",
+			"      1  [14] ",
+		}, stats.treeLines("", 10))
+		// The least exec'd binaries are removed, except the new one.
+		require.Equal(t, map[string]int{"/bin/a": 2, "/bin/e": 1}, stats.binaries)
+		require.Equal(t, 6, stats.count)
+	})
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// resizeSignals are sent when the terminal is resized, so top redraws
+// immediately.
+var resizeSignals = []os.Signal{syscall.SIGWINCH}
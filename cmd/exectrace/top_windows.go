@@ -0,0 +1,10 @@
+//go:build windows
+// +build windows
+
+package main
+
+import "os"
+
+// resizeSignals is empty as Windows doesn't signal terminal resizes, so top
+// picks up the new size on the next refresh.
+var resizeSignals []os.Signal
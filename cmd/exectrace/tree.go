@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/kballard/go-shellquote"
+
+	"github.com/coder/exectrace"
+)
+
+// processTree builds a tree of exec events from their PIDs and parent PIDs.
+// Processes that exec again are shown as children of their previous exec.
+// Events whose parent didn't exec (e.g. a shell that only forked) are added at
+// the top level, as the parent can't be shown.
+type processTree struct {
+	roots []*processNode
+	// byPID contains the latest node for each PID.
+	byPID map[uint32]*processNode
+	count int
+}
+
+type processNode struct {
+	event    *exectrace.Event
+	children []*processNode
+}
+
+func newProcessTree() *processTree {
+	return &processTree{
+		byPID: map[uint32]*processNode{},
+	}
+}
+
+// Add adds an event to the tree. Events must be added in the order they
+// happened.
+func (t *processTree) Add(event *exectrace.Event) {
+	node := &processNode{event: event}
+	t.count++
+
+	parent, ok := t.byPID[event.PID]
+	if !ok && event.PPID != 0 {
+		parent, ok = t.byPID[event.PPID]
+	}
+	if ok {
+		parent.children = append(parent.children, node)
+	} else {
+		t.roots = append(t.roots, node)
+	}
+	t.byPID[event.PID] = node
+}
+
+// Write writes the tree to w, with one line per event.
+func (t *processTree) Write(w io.Writer) error {
+	for _, node := range t.roots {
+		err := node.write(w, "", "")
+		if err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%d execs, %d processes\n", t.count, len(t.byPID))
+	return err
+}
+
+func (n *processNode) write(w io.Writer, prefix, childPrefix string) error {
+	ellipsis := ""
+	if n.event.Truncated {
+		ellipsis = "..."
+	}
+	_, err := fmt.Fprintf(w, "%s[%d] %s%s\n", prefix, n.event.PID, escapeControl(shellquote.Join(n.event.Argv...)), ellipsis)
+	if err != nil {
+		return err
+	}
+
+	for i, child := range n.children {
+		if i == len(n.children)-1 {
+			err = child.write(w, childPrefix+"└── ", childPrefix+"    ")
+		} else {
+			err = child.write(w, childPrefix+"├── ", childPrefix+"│   ")
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
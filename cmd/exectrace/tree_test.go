@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/coder/exectrace"
+)
+
+func TestProcessTree(t *testing.T) {
+	t.Parallel()
+
+	tree := newProcessTree()
+	for _, event := range []*exectrace.Event{
+		{PID: 10, PPID: 1, Argv: []string{"npm", "install"}},
+		{PID: 11, PPID: 10, Argv: []string{"sh", "-c", "node-gyp rebuild"}},
+		{PID: 12, PPID: 11, Argv: []string{"node-gyp", "rebuild"}},
+		// Re-exec of the same process.
+		{PID: 12, PPID: 11, Argv: []string{"make"}, Truncated: true},
+		{PID: 13, PPID: 10, Argv: []string{"git", "--version"}},
+		// The parent only forked, so it's not in the tree.
+		{PID: 15, PPID: 14, Argv: []string{"true"}},
+	} {
+		tree.Add(event)
+	}
+
+	var buf bytes.Buffer
+	err := tree.Write(&buf)
+	require.NoError(t, err)
+	require.Equal(t, `[10] npm install
+├── [11] sh -c 'node-gyp rebuild'
+│   └── [12] node-gyp rebuild
+│       └── [12] make...
+└── [13] git --version
+[15] true
+6 execs, 5 processes
+`, buf.String())
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"log"
+
+	"github.com/spf13/cobra"
+
+	"github.com/coder/exectrace"
+)
+
+func unpinCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "unpin <pin path>",
+		Short: "Remove the eBPF program and maps pinned by \"exectrace --pin-path\".",
+		Long: "Remove the eBPF program and maps pinned by \"exectrace --pin-path\". " +
+			"Execs are no longer traced once all tracers using them have exited, and unread events are discarded.",
+		Args: cobra.ExactArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			err := exectrace.Unpin(args[0])
+			if err != nil {
+				//nolint:revive
+				log.Fatalf("unpin: %+v", err)
+			}
+		},
+	}
+
+	return cmd
+}
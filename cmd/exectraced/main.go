@@ -0,0 +1,205 @@
+// Command exectraced is a long-running daemon that owns the exectrace kernel
+// program and streams exec events to local clients over a unix socket.
+//
+// Clients connect with remote.Dial. Root clients receive all events, while
+// other clients only receive events from processes running as their own UID in
+// their own PID namespace, as identified by the socket's peer credentials.
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/xerrors"
+	"google.golang.org/grpc"
+
+	"github.com/coder/exectrace"
+	"github.com/coder/exectrace/remote"
+)
+
+func main() {
+	err := rootCmd().Execute()
+	if err != nil {
+		log.Fatalf("failed to run command: %+v", err)
+	}
+}
+
+func rootCmd() *cobra.Command {
+	var (
+		socketPath string
+		socketMode string
+		backend    string
+		kernelBTF  string
+		pinPath    string
+		redact     bool
+		bufferSize int
+	)
+
+	cmd := &cobra.Command{
+		Use:   "exectraced",
+		Short: "exectraced streams exec events to local clients over a unix socket.",
+		Long: "exectraced loads the exectrace kernel program once and streams exec events to local clients over a " +
+			"unix socket, so tools don't need to be root or load their own eBPF program.\n\n" +
+			"Clients can use the github.com/coder/exectrace/remote package with a \"unix://\" target. Root clients " +
+			"receive all events. Other clients only receive events from processes running as their own UID in their " +
+			"own PID namespace (or its descendants), as identified by the socket's peer credentials.\n\n" +
+			"exectraced must run as root in the host PID namespace.",
+		Run: func(_ *cobra.Command, _ []string) {
+			mode, err := strconv.ParseUint(socketMode, 8, 32)
+			if err != nil {
+				//nolint:revive
+				log.Fatalf("invalid --socket-mode %q: %+v", socketMode, err)
+			}
+
+			b := exectrace.Backend(backend)
+			switch b {
+			case exectrace.BackendAuto, exectrace.BackendEBPF, exectrace.BackendProcConnector:
+			default:
+				//nolint:revive
+				log.Fatalf("invalid backend %q", backend)
+			}
+
+			var redactOpts *exectrace.RedactOpts
+			if redact {
+				redactOpts = &exectrace.RedactOpts{}
+			}
+
+			err = serve(serveOptions{
+				SocketPath: socketPath,
+				SocketMode: fs.FileMode(mode),
+				TracerOpts: &exectrace.TracerOpts{
+					Backend:       b,
+					KernelBTFPath: kernelBTF,
+					PinPath:       pinPath,
+					Redact:        redactOpts,
+				},
+				ServerOpts: &remote.ServerOpts{
+					BufferSize: bufferSize,
+				},
+			})
+			if err != nil {
+				//nolint:revive
+				log.Fatalf("serve: %+v", err)
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&socketPath, "socket", remote.DefaultSocketPath, "Path of the unix socket to listen on, an existing socket at this path is replaced")
+	cmd.Flags().StringVar(&socketMode, "socket-mode", "0666", "File mode of the unix socket in octal, restrict it to limit which users can connect")
+	cmd.Flags().StringVar(&backend, "backend", string(exectrace.BackendEBPF), `Tracer backend, one of "ebpf", "proc-connector" or "auto" (auto falls back to proc-connector if eBPF is unavailable)`)
+	cmd.Flags().StringVar(&kernelBTF, "kernel-btf", "", "Path to an external BTF file for the running kernel, for kernels without /sys/kernel/btf/vmlinux (defaults to searching "+exectrace.DefaultKernelBTFDir+")")
+	cmd.Flags().StringVar(&pinPath, "pin-path", "", "Pin the eBPF program and maps to this directory on a BPF filesystem so unread events survive restarts, e.g. "+exectrace.DefaultPinPath+"/exectraced")
+	cmd.Flags().BoolVar(&redact, "redact", false, "Redact secrets such as passwords and tokens from argv")
+	cmd.Flags().IntVar(&bufferSize, "buffer-size", remote.DefaultBufferSize, "Number of events buffered for each client before events are dropped for that client")
+
+	return cmd
+}
+
+type serveOptions struct {
+	SocketPath string
+	SocketMode fs.FileMode
+	TracerOpts *exectrace.TracerOpts
+	// ServerOpts configures the server. RestrictPeers is always enabled.
+	ServerOpts *remote.ServerOpts
+}
+
+func serve(opts serveOptions) error {
+	l, err := listen(opts.SocketPath, opts.SocketMode)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	t, err := exectrace.New(opts.TracerOpts)
+	if err != nil {
+		return xerrors.Errorf("start tracer: %w", err)
+	}
+	defer t.Close()
+	closeOnSignal(t)
+
+	log.Printf("Serving on unix://%s", opts.SocketPath)
+	return serveTracer(t, l, opts.ServerOpts)
+}
+
+// serveTracer streams events from t to the clients connecting to l until t is
+// closed. Clients are identified by their peer credentials, and clients that
+// aren't root only receive their own events.
+func serveTracer(t exectrace.Tracer, l net.Listener, serverOpts *remote.ServerOpts) error {
+	opts := remote.ServerOpts{}
+	if serverOpts != nil {
+		opts = *serverOpts
+	}
+	// The socket is usually accessible to all users, so this must never be
+	// disabled.
+	opts.RestrictPeers = true
+	srv := remote.NewServer(t, &opts)
+	defer srv.Close()
+
+	gs := grpc.NewServer(grpc.Creds(remote.PeerCredentials()))
+	srv.RegisterGRPC(gs)
+	defer gs.Stop()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- gs.Serve(l)
+	}()
+
+	// Wait until the tracer is closed or the server fails.
+	select {
+	case <-srv.Done():
+		return nil
+	case err := <-errs:
+		return xerrors.Errorf("serve: %w", err)
+	}
+}
+
+// listen listens on a unix socket at path with the given mode. A socket left
+// behind by a previous run is removed first, but other files are not.
+func listen(path string, mode fs.FileMode) (net.Listener, error) {
+	info, err := os.Lstat(path)
+	if err == nil {
+		if info.Mode().Type() != fs.ModeSocket {
+			return nil, xerrors.Errorf("%q exists and is not a socket", path)
+		}
+		err = os.Remove(path)
+		if err != nil {
+			return nil, xerrors.Errorf("remove stale socket %q: %w", path, err)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, xerrors.Errorf("stat %q: %w", path, err)
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, xerrors.Errorf("listen on %q: %w", path, err)
+	}
+	err = os.Chmod(path, mode)
+	if err != nil {
+		_ = l.Close()
+		return nil, xerrors.Errorf("chmod %q: %w", path, err)
+	}
+	return l, nil
+}
+
+// closeOnSignal closes the tracer when a SIGINT or SIGTERM is received, which
+// stops the server.
+func closeOnSignal(t exectrace.Tracer) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+
+		log.Print("signal received, closing tracer")
+		err := t.Close()
+		if err != nil {
+			//nolint:revive
+			log.Fatalf("error closing tracer: %+v", err)
+		}
+	}()
+}
@@ -0,0 +1,144 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/coder/exectrace"
+	"github.com/coder/exectrace/exectracetest"
+	"github.com/coder/exectrace/remote"
+)
+
+// testClientEnv is set to the socket path when the test binary is re-executed
+// as an unprivileged client.
+const testClientEnv = "EXECTRACED_TEST_CLIENT_SOCKET"
+
+// nobody is the UID and GID the unprivileged client runs as.
+const nobody = 65534
+
+// runTestClient prints "ready" once it's streaming and then the filename of
+// each event until it receives "/done".
+func runTestClient(socketPath string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	t, err := remote.Dial(ctx, "unix://"+socketPath, nil)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+
+	fmt.Println("ready")
+	for {
+		event, err := t.Read()
+		if err != nil {
+			return xerrors.Errorf("read: %w", err)
+		}
+		fmt.Println(event.Filename)
+		if event.Filename == "/done" {
+			return nil
+		}
+	}
+}
+
+//nolint:paralleltest
+func TestDaemonRestrictsPeers(t *testing.T) {
+	if socketPath := os.Getenv(testClientEnv); socketPath != "" {
+		err := runTestClient(socketPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "client: %+v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// This test must be run as root so the client can run as another user in
+	// a new PID namespace.
+	if os.Geteuid() != 0 {
+		t.Fatal("must be run as root")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	// The client runs as nobody, so the socket, its directory and a copy of
+	// the test binary must be accessible to it.
+	dir, err := os.MkdirTemp("", "exectraced-test")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+	require.NoError(t, os.Chmod(dir, 0o755))
+	self, err := os.Executable()
+	require.NoError(t, err)
+	bin, err := os.ReadFile(self)
+	require.NoError(t, err)
+	binPath := filepath.Join(dir, "exectraced.test")
+	require.NoError(t, os.WriteFile(binPath, bin, 0o755)) //nolint:gosec
+
+	socketPath := filepath.Join(dir, "exectraced.sock")
+	l, err := listen(socketPath, 0o666)
+	require.NoError(t, err)
+	defer l.Close()
+
+	tracer := exectracetest.New()
+	served := make(chan error, 1)
+	go func() {
+		served <- serveTracer(tracer, l, nil)
+	}()
+
+	//nolint:gosec
+	cmd := exec.CommandContext(ctx, binPath, "-test.run=^TestDaemonRestrictsPeers$")
+	cmd.Env = append(os.Environ(), testClientEnv+"="+socketPath)
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWPID,
+		Credential: &syscall.Credential{Uid: nobody, Gid: nobody},
+	}
+	stdout, err := cmd.StdoutPipe()
+	require.NoError(t, err)
+	require.NoError(t, cmd.Start())
+	lines := bufio.NewReader(stdout)
+	line, err := lines.ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "ready\n", line)
+
+	// The client's own process is in its PID namespace, while this process is
+	// in the parent namespace.
+	client := uint32(cmd.Process.Pid)
+	host := uint32(os.Getpid())
+	tracer.Push(
+		&exectrace.Event{PID: client, UID: nobody, Filename: "/allowed"},
+		&exectrace.Event{PID: client, UID: 0, Filename: "/other-user"},
+		&exectrace.Event{PID: host, UID: nobody, Filename: "/other-namespace"},
+		&exectrace.Event{PID: client, UID: nobody, Filename: "/done"},
+	)
+
+	rest, err := io.ReadAll(lines)
+	require.NoError(t, err)
+	require.NoError(t, cmd.Wait())
+	require.Equal(t, []string{"/allowed", "/done"}, strings.Fields(string(rest)))
+
+	tracer.Finish()
+	select {
+	case err := <-served:
+		require.NoError(t, err)
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for the server to stop")
+	}
+}
@@ -58,6 +58,17 @@ func root() *cli.App {
 					"and requires the workspace and exectrace container to " +
 					"share a PidNS.",
 			},
+			&cli.StringFlag{
+				Name: "filter",
+				Usage: "Only log events matching this filter expression, e.g. " +
+					`'uid >= 1000 && !(comm in ["code-server", "node"])'. ` +
+					"See exectrace.ParseFilter for the syntax.",
+			},
+			&cli.BoolFlag{
+				Name: "redact",
+				Usage: "Redact secrets such as passwords and tokens from the " +
+					"argv of logged events.",
+			},
 			&cli.StringSliceFlag{
 				Name: "label",
 				Usage: "Add these labels to all logged events. Labels are in " +
@@ -86,6 +97,8 @@ func root() *cli.App {
 				UseLocalPidNS:     ctx.Bool("use-local-pidns"),
 				InitListenAddress: ctx.String("init-address"),
 				StartupTimeout:    ctx.Duration("startup-timeout"),
+				Redact:            ctx.Bool("redact"),
+				Filter:            ctx.String("filter"),
 			})
 			if err != nil {
 				return xerrors.Errorf("run exectrace: %w", err)
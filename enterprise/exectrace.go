@@ -33,13 +33,26 @@ type Options struct {
 	UseLocalPidNS     bool
 	InitListenAddress string
 	StartupTimeout    time.Duration
+	// Redact enables redaction of secrets from event argv using the built-in
+	// exectrace rules.
+	Redact bool
+	// Filter is an optional exectrace filter expression. Only events matching
+	// the expression are logged.
+	Filter string
 }
 
 func Run(ctx context.Context, log slog.Logger, opts Options) error {
 	var (
-		err   error
-		pidNS uint32
+		err    error
+		pidNS  uint32
+		filter *exectrace.Filter
 	)
+	if opts.Filter != "" {
+		filter, err = exectrace.ParseFilter(opts.Filter)
+		if err != nil {
+			return xerrors.Errorf("parse filter: %w", err)
+		}
+	}
 	if opts.UseLocalPidNS {
 		log.Debug(ctx, "using local PidNS")
 		pidNS, err = exectrace.GetPidNS()
@@ -71,14 +84,49 @@ func Run(ctx context.Context, log slog.Logger, opts Options) error {
 		return xerrors.Errorf("ensure tracefs mounted: %w", err)
 	}
 
+	var redactOpts *exectrace.RedactOpts
+	if opts.Redact {
+		redactOpts = &exectrace.RedactOpts{}
+	}
+
 	log.Debug(ctx, "starting tracer")
 	tracer, err := exectrace.New(&exectrace.TracerOpts{
-		PidNS: pidNS,
-		LogFn: func(uid, gid, pid uint32, logLine string) {
-			log.Error(ctx, "tracer error log: "+logLine, slog.F("uid", uid), slog.F("gid", gid), slog.F("pid", pid))
+		PidNS:  pidNS,
+		Redact: redactOpts,
+		Filter: filter,
+		LogHandler: func(entry exectrace.LogEntry) {
+			fields := []slog.Field{
+				slog.F("code", entry.Code.String()),
+				slog.F("program", entry.Program),
+				slog.F("uid", entry.UID),
+				slog.F("gid", entry.GID),
+				slog.F("pid", entry.PID),
+			}
+			msg := "tracer log: " + entry.Message()
+			switch entry.Level {
+			case exectrace.LogLevelDebug:
+				log.Debug(ctx, msg, fields...)
+			case exectrace.LogLevelInfo:
+				log.Info(ctx, msg, fields...)
+			case exectrace.LogLevelWarn:
+				log.Warn(ctx, msg, fields...)
+			default:
+				log.Error(ctx, msg, fields...)
+			}
 		},
 	})
 	if err != nil {
+		var verr *exectrace.VerifierError
+		switch {
+		case xerrors.Is(err, exectrace.ErrPermission):
+			log.Error(ctx, "exectrace must run as a privileged container or with CAP_BPF and CAP_PERFMON")
+		case xerrors.Is(err, exectrace.ErrUnsupportedKernel), xerrors.Is(err, exectrace.ErrBTFUnavailable):
+			log.Error(ctx, "the node's kernel is not supported, run `exectrace check` on the node for details")
+		case xerrors.Is(err, exectrace.ErrTracepointMissing):
+			log.Error(ctx, "the execve tracepoint is unavailable, check that tracefs is mounted on the node")
+		case xerrors.As(err, &verr):
+			log.Error(ctx, "the kernel rejected the exectrace eBPF program", slog.F("verifier_log", verr.Log))
+		}
 		return xerrors.Errorf("create tracer: %w", err)
 	}
 	defer func() {
@@ -0,0 +1,79 @@
+package exectrace
+
+import (
+	"fmt"
+
+	"golang.org/x/xerrors"
+)
+
+// These errors are returned (wrapped) by New when a Tracer can't be started,
+// so callers can pick a fallback or show a precise message. Use errors.Is to
+// check for them. The wrapping error describes the specific failure and still
+// wraps the underlying error from the kernel or eBPF library.
+var (
+	// ErrPermission means the process lacks the privileges required by the
+	// backend, usually root or CAP_BPF and CAP_PERFMON for the eBPF backend.
+	ErrPermission = xerrors.New("insufficient permissions")
+	// ErrUnsupportedKernel means the running kernel is too old or was built
+	// without a feature required by the backend.
+	ErrUnsupportedKernel = xerrors.New("unsupported kernel")
+	// ErrBTFUnavailable means no BTF could be found for the running kernel.
+	// See TracerOpts.KernelBTFPath.
+	ErrBTFUnavailable = xerrors.New("kernel BTF unavailable")
+	// ErrTracepointMissing means the syscalls:sys_enter_execve tracepoint
+	// doesn't exist, either because tracefs isn't mounted or the kernel was
+	// built without CONFIG_FTRACE_SYSCALLS.
+	ErrTracepointMissing = xerrors.New("tracepoint missing")
+)
+
+// kindError tags an error with one of the sentinel errors above, while still
+// wrapping the original error.
+type kindError struct {
+	kind   error
+	detail string
+	err    error
+}
+
+// withKind returns an error that matches kind with errors.Is and wraps err.
+func withKind(kind error, detail string, err error) error {
+	return &kindError{kind: kind, detail: detail, err: err}
+}
+
+func (e *kindError) Error() string {
+	if e.err == nil {
+		return fmt.Sprintf("%v: %s", e.kind, e.detail)
+	}
+	return fmt.Sprintf("%v: %s: %v", e.kind, e.detail, e.err)
+}
+
+func (e *kindError) Is(target error) bool {
+	return target == e.kind
+}
+
+func (e *kindError) Unwrap() error {
+	return e.err
+}
+
+// VerifierError is returned (wrapped) by New when the kernel's eBPF verifier
+// rejects the program, which usually means the kernel is missing a helper or
+// behaves differently from the kernels exectrace was tested on. Use errors.As
+// to extract it.
+type VerifierError struct {
+	// Program is the name of the rejected program.
+	Program string
+	// Log is the verifier log, one line per entry. The start of the log may
+	// be missing if Truncated is true.
+	Log       []string
+	Truncated bool
+
+	err error
+}
+
+func (e *VerifierError) Error() string {
+	// Use %+v so the error contains all lines from the verifier log.
+	return fmt.Sprintf("verifier rejected program %q: %+v", e.Program, e.err)
+}
+
+func (e *VerifierError) Unwrap() error {
+	return e.err
+}
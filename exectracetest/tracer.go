@@ -0,0 +1,325 @@
+// Package exectracetest provides a scriptable in-memory exectrace.Tracer for
+// testing code that consumes tracers without root or eBPF support.
+//
+//	tracer := exectracetest.New()
+//	defer tracer.Close()
+//
+//	tracer.Push(&exectrace.Event{Filename: "/bin/sh", Argv: []string{"sh"}})
+//	tracer.PushError(errors.New("read failed"))
+//	tracer.Finish() // Read returns io.EOF once everything above is read.
+//
+//	go consume(tracer)
+//	tracer.AssertAllConsumed(t)
+package exectracetest
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/coder/exectrace"
+)
+
+// DefaultWaitTimeout is how long the Assert methods wait for the consumer.
+const DefaultWaitTimeout = 10 * time.Second
+
+// ErrClosed is returned when closing a Tracer that is already closed.
+var ErrClosed = xerrors.New("tracer is closed")
+
+// item is a single scripted result of Read.
+type item struct {
+	event *exectrace.Event
+	err   error
+}
+
+// Tracer is a scriptable in-memory exectrace.Tracer. Events and errors pushed
+// to the tracer are returned from Read in order. Read blocks while nothing is
+// queued, like a real tracer waiting for exec calls.
+//
+// Like a real tracer, closing the Tracer makes all blocked and future Read
+// calls return an error that wraps io.EOF. Use Finish to instead end the
+// stream after everything queued has been read.
+//
+// All methods are safe for concurrent use.
+type Tracer struct {
+	mu        sync.Mutex
+	queue     []item
+	consumed  []*exectrace.Event
+	errors    int
+	finished  bool
+	closed    bool
+	closeErr  error
+	reloads   []*exectrace.TracerOpts
+	reloadErr error
+	// changed is closed and replaced whenever the state changes, to wake up
+	// blocked readers and waiters.
+	changed chan struct{}
+}
+
+var _ exectrace.Tracer = &Tracer{}
+
+// New creates an empty Tracer.
+func New() *Tracer {
+	return &Tracer{
+		changed: make(chan struct{}),
+	}
+}
+
+// notifyLocked wakes up everything waiting for a state change. t.mu must be
+// held.
+func (t *Tracer) notifyLocked() {
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+// Push queues events to be returned from Read.
+func (t *Tracer) Push(events ...*exectrace.Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, ev := range events {
+		t.queue = append(t.queue, item{event: ev})
+	}
+	t.notifyLocked()
+}
+
+// PushError queues an error to be returned from Read, after any events
+// already queued. Errors that wrap io.EOF behave like the tracer was closed
+// from the consumer's point of view, but the Tracer keeps working.
+func (t *Tracer) PushError(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.queue = append(t.queue, item{err: err})
+	t.notifyLocked()
+}
+
+// Finish makes Read return an error that wraps io.EOF once all queued events
+// and errors have been read, instead of blocking. Unlike Close, queued items
+// are not discarded.
+func (t *Tracer) Finish() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.finished = true
+	t.notifyLocked()
+}
+
+// SetReloadError sets the error returned by Reload. Options passed to a
+// failed Reload are not recorded.
+func (t *Tracer) SetReloadError(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.reloadErr = err
+}
+
+// SetCloseError sets the error returned by the first call to Close.
+func (t *Tracer) SetCloseError(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.closeErr = err
+}
+
+// Read blocks until an event or error is queued, then returns it. If the
+// tracer is closed, or finished and nothing is queued, an error that wraps
+// io.EOF is returned.
+func (t *Tracer) Read() (*exectrace.Event, error) {
+	t.mu.Lock()
+	for {
+		if t.closed {
+			t.mu.Unlock()
+			return nil, xerrors.Errorf("tracer closed: %w", io.EOF)
+		}
+		if len(t.queue) > 0 {
+			it := t.queue[0]
+			t.queue = t.queue[1:]
+			if it.err != nil {
+				t.errors++
+			} else {
+				t.consumed = append(t.consumed, it.event)
+			}
+			t.notifyLocked()
+			t.mu.Unlock()
+
+			if it.err != nil {
+				return nil, it.err
+			}
+			return it.event, nil
+		}
+		if t.finished {
+			t.mu.Unlock()
+			return nil, xerrors.Errorf("tracer finished: %w", io.EOF)
+		}
+
+		changed := t.changed
+		t.mu.Unlock()
+		<-changed
+		t.mu.Lock()
+	}
+}
+
+// FD returns -1 as there is no eBPF program.
+func (*Tracer) FD() int {
+	return -1
+}
+
+// Reload records opts, see Reloads. It returns the error set with
+// SetReloadError, or ErrClosed if the tracer is closed.
+func (t *Tracer) Reload(opts *exectrace.TracerOpts) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return ErrClosed
+	}
+	if t.reloadErr != nil {
+		return t.reloadErr
+	}
+	t.reloads = append(t.reloads, opts)
+	t.notifyLocked()
+	return nil
+}
+
+// Reloads returns the options passed to each successful Reload call so far.
+func (t *Tracer) Reloads() []*exectrace.TracerOpts {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	reloads := make([]*exectrace.TracerOpts, len(t.reloads))
+	copy(reloads, t.reloads)
+	return reloads
+}
+
+// Close closes the tracer. All blocked and future Read calls return an error
+// that wraps io.EOF and queued items are discarded. Closing an already closed
+// tracer returns ErrClosed.
+func (t *Tracer) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return ErrClosed
+	}
+	t.closed = true
+	t.queue = nil
+	t.notifyLocked()
+	return t.closeErr
+}
+
+// Closed returns true if Close has been called.
+func (t *Tracer) Closed() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.closed
+}
+
+// Consumed returns the events that have been returned from Read so far.
+func (t *Tracer) Consumed() []*exectrace.Event {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	consumed := make([]*exectrace.Event, len(t.consumed))
+	copy(consumed, t.consumed)
+	return consumed
+}
+
+// ErrorsConsumed returns the number of pushed errors that have been returned
+// from Read so far.
+func (t *Tracer) ErrorsConsumed() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.errors
+}
+
+// Pending returns the number of queued events and errors that haven't been
+// read yet.
+func (t *Tracer) Pending() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.queue)
+}
+
+// wait blocks until cond returns true or ctx is done. cond is called with
+// t.mu held.
+func (t *Tracer) wait(ctx context.Context, cond func() bool) error {
+	t.mu.Lock()
+	for !cond() {
+		changed := t.changed
+		t.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+		t.mu.Lock()
+	}
+	t.mu.Unlock()
+	return nil
+}
+
+// WaitConsumed blocks until at least n events have been returned from Read.
+func (t *Tracer) WaitConsumed(ctx context.Context, n int) error {
+	return t.wait(ctx, func() bool {
+		return len(t.consumed) >= n
+	})
+}
+
+// WaitDrained blocks until all queued events and errors have been read, or
+// the tracer is closed.
+func (t *Tracer) WaitDrained(ctx context.Context) error {
+	return t.wait(ctx, func() bool {
+		return len(t.queue) == 0
+	})
+}
+
+// WaitClosed blocks until the tracer is closed.
+func (t *Tracer) WaitClosed(ctx context.Context) error {
+	return t.wait(ctx, func() bool {
+		return t.closed
+	})
+}
+
+// AssertConsumed waits up to DefaultWaitTimeout for at least n events to be
+// read, and fails the test if they aren't.
+func (t *Tracer) AssertConsumed(tb testing.TB, n int) {
+	tb.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultWaitTimeout)
+	defer cancel()
+	err := t.WaitConsumed(ctx, n)
+	if err != nil {
+		tb.Fatalf("expected at least %d events to be consumed, got %d: %v", n, len(t.Consumed()), err)
+	}
+}
+
+// AssertAllConsumed waits up to DefaultWaitTimeout for all queued events and
+// errors to be read, and fails the test if they aren't.
+func (t *Tracer) AssertAllConsumed(tb testing.TB) {
+	tb.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultWaitTimeout)
+	defer cancel()
+	err := t.WaitDrained(ctx)
+	if err != nil {
+		tb.Fatalf("expected all events to be consumed, %d still pending: %v", t.Pending(), err)
+	}
+}
+
+// AssertClosed waits up to DefaultWaitTimeout for the tracer to be closed,
+// and fails the test if it isn't.
+func (t *Tracer) AssertClosed(tb testing.TB) {
+	tb.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultWaitTimeout)
+	defer cancel()
+	err := t.WaitClosed(ctx)
+	if err != nil {
+		tb.Fatalf("expected tracer to be closed: %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+package exectracetest_test
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/coder/exectrace"
+	"github.com/coder/exectrace/exectracetest"
+)
+
+var (
+	event1 = &exectrace.Event{Filename: "/bin/true", Argv: []string{"true"}, PID: 1}
+	event2 = &exectrace.Event{Filename: "/bin/false", Argv: []string{"false"}, PID: 2}
+)
+
+func TestTracer(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Script", func(t *testing.T) {
+		t.Parallel()
+
+		tracer := exectracetest.New()
+		defer tracer.Close()
+		require.Equal(t, -1, tracer.FD())
+
+		readErr := xerrors.New("read failed")
+		tracer.Push(event1)
+		tracer.PushError(readErr)
+		tracer.Push(event2)
+		tracer.Finish()
+		require.Equal(t, 3, tracer.Pending())
+
+		ev, err := tracer.Read()
+		require.NoError(t, err)
+		require.Equal(t, event1, ev)
+		_, err = tracer.Read()
+		require.ErrorIs(t, err, readErr)
+		ev, err = tracer.Read()
+		require.NoError(t, err)
+		require.Equal(t, event2, ev)
+		_, err = tracer.Read()
+		require.ErrorIs(t, err, io.EOF)
+
+		require.Equal(t, []*exectrace.Event{event1, event2}, tracer.Consumed())
+		require.Equal(t, 1, tracer.ErrorsConsumed())
+		require.Zero(t, tracer.Pending())
+		require.False(t, tracer.Closed())
+	})
+
+	t.Run("BlockingRead", func(t *testing.T) {
+		t.Parallel()
+
+		tracer := exectracetest.New()
+		defer tracer.Close()
+
+		done := make(chan *exectrace.Event)
+		go func() {
+			ev, err := tracer.Read()
+			require.NoError(t, err)
+			done <- ev
+		}()
+
+		select {
+		case <-done:
+			t.Fatal("read returned before an event was pushed")
+		case <-time.After(10 * time.Millisecond):
+		}
+		tracer.Push(event1)
+		require.Equal(t, event1, <-done)
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		t.Parallel()
+
+		tracer := exectracetest.New()
+		closeErr := xerrors.New("close failed")
+		tracer.SetCloseError(closeErr)
+
+		errs := make(chan error)
+		go func() {
+			_, err := tracer.Read()
+			errs <- err
+		}()
+
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			require.ErrorIs(t, tracer.Close(), closeErr)
+		}()
+		require.ErrorIs(t, <-errs, io.EOF)
+		tracer.AssertClosed(t)
+		require.ErrorIs(t, tracer.Close(), exectracetest.ErrClosed)
+
+		// Events pushed after closing are never returned.
+		tracer.Push(event1)
+		_, err := tracer.Read()
+		require.ErrorIs(t, err, io.EOF)
+	})
+
+	t.Run("Reload", func(t *testing.T) {
+		t.Parallel()
+
+		tracer := exectracetest.New()
+		opts := &exectrace.TracerOpts{PidNS: 1}
+		err := tracer.Reload(opts)
+		require.NoError(t, err)
+		require.Equal(t, []*exectrace.TracerOpts{opts}, tracer.Reloads())
+
+		reloadErr := xerrors.New("reload failed")
+		tracer.SetReloadError(reloadErr)
+		err = tracer.Reload(&exectrace.TracerOpts{})
+		require.ErrorIs(t, err, reloadErr)
+		require.Len(t, tracer.Reloads(), 1)
+
+		require.NoError(t, tracer.Close())
+		err = tracer.Reload(opts)
+		require.ErrorIs(t, err, exectracetest.ErrClosed)
+	})
+
+	t.Run("Assertions", func(t *testing.T) {
+		t.Parallel()
+
+		tracer := exectracetest.New()
+		defer tracer.Close()
+
+		var got []*exectrace.Event
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for {
+				ev, err := tracer.Read()
+				if err != nil {
+					return
+				}
+				got = append(got, ev)
+			}
+		}()
+
+		tracer.Push(event1, event2)
+		tracer.AssertConsumed(t, 2)
+		tracer.AssertAllConsumed(t)
+		tracer.Finish()
+		<-done
+		require.Equal(t, []*exectrace.Event{event1, event2}, got)
+
+		// Waiting respects the context.
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		err := tracer.WaitConsumed(ctx, 3)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
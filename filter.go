@@ -0,0 +1,656 @@
+package exectrace
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// Filter is a compiled filter expression that can be evaluated against events
+// in userspace. Filters are safe for concurrent use.
+//
+// The expression language supports the following:
+//
+//   - Fields: filename, argv, argc, cmdline (argv joined by spaces), comm, pid,
+//     ppid, uid, gid, truncated, redacted and reliability. Elements of argv can be
+//     accessed by index, e.g. argv[0]. Out of range indexes evaluate to "".
+//   - Literals: "strings" (with Go escapes), `raw strings`, numbers, true,
+//     false and lists, e.g. ["sh", "bash"] or [0, 1000].
+//   - Comparison: ==, !=, <, <=, >, >= (numbers only for ordering).
+//   - String operators: matches (RE2 regex), contains, startsWith, endsWith.
+//     When the left side is argv, the operator matches if any element
+//     matches.
+//   - Membership: x in [list], argv contains "x", "x" in argv.
+//   - Boolean logic: &&, ||, ! and parentheses.
+//
+// Example:
+//
+//	uid >= 1000 && filename matches "^/tmp/" && !(comm in ["code-server", "node"])
+type Filter struct {
+	expr string
+	root filterNode
+}
+
+// ParseFilter parses and type checks the given filter expression.
+func ParseFilter(expr string) (*Filter, error) {
+	tokens, err := lexFilter(expr)
+	if err != nil {
+		return nil, xerrors.Errorf("parse filter %q: %w", expr, err)
+	}
+
+	p := &filterParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, xerrors.Errorf("parse filter %q: %w", expr, err)
+	}
+	if tok := p.peek(); tok.kind != filterTokenEOF {
+		return nil, xerrors.Errorf("parse filter %q: unexpected %s at offset %d", expr, tok, tok.pos)
+	}
+	if root.kind() != filterKindBool {
+		return nil, xerrors.Errorf("parse filter %q: expression must evaluate to a bool, got %s", expr, root.kind())
+	}
+
+	return &Filter{
+		expr: expr,
+		root: root,
+	}, nil
+}
+
+// MustParseFilter is like ParseFilter but panics if the expression is invalid.
+func MustParseFilter(expr string) *Filter {
+	f, err := ParseFilter(expr)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// Match returns true if the event matches the filter expression.
+func (f *Filter) Match(ev *Event) bool {
+	v, _ := f.root.eval(ev).(bool)
+	return v
+}
+
+// String returns the original filter expression.
+func (f *Filter) String() string {
+	return f.expr
+}
+
+type filterKind int
+
+const (
+	filterKindString filterKind = iota
+	filterKindNumber
+	filterKindBool
+	filterKindStringList
+	filterKindNumberList
+	// filterKindEmptyList is the kind of `[]`, which is compatible with both
+	// list kinds.
+	filterKindEmptyList
+)
+
+func (k filterKind) String() string {
+	switch k {
+	case filterKindString:
+		return "string"
+	case filterKindNumber:
+		return "number"
+	case filterKindBool:
+		return "bool"
+	case filterKindStringList:
+		return "list of strings"
+	case filterKindNumberList:
+		return "list of numbers"
+	case filterKindEmptyList:
+		return "empty list"
+	default:
+		return fmt.Sprintf("filterKind(%d)", int(k))
+	}
+}
+
+func (k filterKind) isList() bool {
+	return k == filterKindStringList || k == filterKindNumberList || k == filterKindEmptyList
+}
+
+// elem returns the kind of the elements of a list kind.
+func (k filterKind) elem() filterKind {
+	if k == filterKindNumberList {
+		return filterKindNumber
+	}
+	return filterKindString
+}
+
+// filterField describes a field of Event that can be referenced in a filter
+// expression.
+type filterField struct {
+	kind filterKind
+	get  func(ev *Event) interface{}
+}
+
+var filterFields = map[string]filterField{
+	"filename":    {filterKindString, func(ev *Event) interface{} { return ev.Filename }},
+	"argv":        {filterKindStringList, func(ev *Event) interface{} { return ev.Argv }},
+	"argc":        {filterKindNumber, func(ev *Event) interface{} { return int64(len(ev.Argv)) }},
+	"cmdline":     {filterKindString, func(ev *Event) interface{} { return strings.Join(ev.Argv, " ") }},
+	"comm":        {filterKindString, func(ev *Event) interface{} { return ev.Comm }},
+	"pid":         {filterKindNumber, func(ev *Event) interface{} { return int64(ev.PID) }},
+	"ppid":        {filterKindNumber, func(ev *Event) interface{} { return int64(ev.PPID) }},
+	"uid":         {filterKindNumber, func(ev *Event) interface{} { return int64(ev.UID) }},
+	"gid":         {filterKindNumber, func(ev *Event) interface{} { return int64(ev.GID) }},
+	"truncated":   {filterKindBool, func(ev *Event) interface{} { return ev.Truncated }},
+	"redacted":    {filterKindBool, func(ev *Event) interface{} { return ev.Redacted }},
+	"reliability": {filterKindString, func(ev *Event) interface{} { return string(ev.Reliability) }},
+}
+
+type filterTokenKind int
+
+const (
+	filterTokenEOF filterTokenKind = iota
+	filterTokenIdent
+	filterTokenString
+	filterTokenNumber
+	filterTokenOp
+)
+
+type filterToken struct {
+	kind filterTokenKind
+	text string
+	pos  int
+}
+
+func (t filterToken) String() string {
+	if t.kind == filterTokenEOF {
+		return "end of expression"
+	}
+	return strconv.Quote(t.text)
+}
+
+// filterOps are the symbolic operators, longest first so they're matched
+// greedily.
+var filterOps = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", "[", "]", ","}
+
+func lexFilter(expr string) ([]filterToken, error) {
+	var tokens []filterToken
+	i := 0
+outer:
+	for i < len(expr) {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"' || c == '`':
+			end := i + 1
+			for ; end < len(expr); end++ {
+				if c == '"' && expr[end] == '\\' {
+					end++
+					continue
+				}
+				if expr[end] == c {
+					break
+				}
+			}
+			if end >= len(expr) {
+				return nil, xerrors.Errorf("unterminated string at offset %d", i)
+			}
+			str, err := strconv.Unquote(expr[i : end+1])
+			if err != nil {
+				return nil, xerrors.Errorf("invalid string at offset %d: %w", i, err)
+			}
+			tokens = append(tokens, filterToken{kind: filterTokenString, text: str, pos: i})
+			i = end + 1
+		case isFilterDigit(c):
+			start := i
+			for i < len(expr) && isFilterDigit(expr[i]) {
+				i++
+			}
+			tokens = append(tokens, filterToken{kind: filterTokenNumber, text: expr[start:i], pos: start})
+		case isFilterIdent(c):
+			start := i
+			for i < len(expr) && (isFilterIdent(expr[i]) || isFilterDigit(expr[i])) {
+				i++
+			}
+			tokens = append(tokens, filterToken{kind: filterTokenIdent, text: expr[start:i], pos: start})
+		default:
+			for _, op := range filterOps {
+				if strings.HasPrefix(expr[i:], op) {
+					tokens = append(tokens, filterToken{kind: filterTokenOp, text: op, pos: i})
+					i += len(op)
+					continue outer
+				}
+			}
+			return nil, xerrors.Errorf("unexpected character %q at offset %d", c, i)
+		}
+	}
+
+	return append(tokens, filterToken{kind: filterTokenEOF, text: "", pos: len(expr)}), nil
+}
+
+func isFilterDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isFilterIdent(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+func (p *filterParser) peek() filterToken {
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() filterToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != filterTokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// accept consumes the next token if it's an operator or identifier with the
+// given text.
+func (p *filterParser) accept(text string) bool {
+	tok := p.peek()
+	if (tok.kind == filterTokenOp || tok.kind == filterTokenIdent) && tok.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) expect(text string) error {
+	if !p.accept(text) {
+		tok := p.peek()
+		return xerrors.Errorf("expected %q, got %s at offset %d", text, tok, tok.pos)
+	}
+	return nil
+}
+
+func (p *filterParser) parseOr() (filterNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		if left.kind() != filterKindBool || right.kind() != filterKindBool {
+			return nil, xerrors.Errorf("operands of || must be bools, got %s and %s", left.kind(), right.kind())
+		}
+		left = &filterOrNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if left.kind() != filterKindBool || right.kind() != filterKindBool {
+			return nil, xerrors.Errorf("operands of && must be bools, got %s and %s", left.kind(), right.kind())
+		}
+		left = &filterAndNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (filterNode, error) {
+	pos := p.peek().pos
+	if p.accept("!") {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if operand.kind() != filterKindBool {
+			return nil, xerrors.Errorf("operand of ! at offset %d must be a bool, got %s", pos, operand.kind())
+		}
+		return &filterNotNode{operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (filterNode, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	tok := p.peek()
+	switch tok.text {
+	case "==", "!=", "<", "<=", ">", ">=", "matches", "contains", "startsWith", "endsWith", "in":
+		if tok.kind != filterTokenOp && tok.kind != filterTokenIdent {
+			return left, nil
+		}
+	default:
+		return left, nil
+	}
+	p.next()
+
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	node, err := newFilterCompareNode(tok.text, left, right)
+	if err != nil {
+		return nil, xerrors.Errorf("operator %q at offset %d: %w", tok.text, tok.pos, err)
+	}
+	return node, nil
+}
+
+func (p *filterParser) parseOperand() (filterNode, error) {
+	tok := p.next()
+	switch tok.kind {
+	case filterTokenString:
+		return &filterLiteralNode{k: filterKindString, value: tok.text}, nil
+	case filterTokenNumber:
+		n, err := strconv.ParseInt(tok.text, 10, 64)
+		if err != nil {
+			return nil, xerrors.Errorf("invalid number %s at offset %d: %w", tok, tok.pos, err)
+		}
+		return &filterLiteralNode{k: filterKindNumber, value: n}, nil
+	case filterTokenIdent:
+		switch tok.text {
+		case "true", "false":
+			return &filterLiteralNode{k: filterKindBool, value: tok.text == "true"}, nil
+		}
+		field, ok := filterFields[tok.text]
+		if !ok {
+			return nil, xerrors.Errorf("unknown field %s at offset %d", tok, tok.pos)
+		}
+		var node filterNode = &filterFieldNode{name: tok.text, field: field}
+		if p.peek().kind == filterTokenOp && p.peek().text == "[" {
+			if !field.kind.isList() {
+				return nil, xerrors.Errorf("cannot index field %s of type %s at offset %d", tok, field.kind, tok.pos)
+			}
+			p.next()
+			idx := p.next()
+			if idx.kind != filterTokenNumber {
+				return nil, xerrors.Errorf("expected index, got %s at offset %d", idx, idx.pos)
+			}
+			i, err := strconv.Atoi(idx.text)
+			if err != nil {
+				return nil, xerrors.Errorf("invalid index %s at offset %d: %w", idx, idx.pos, err)
+			}
+			err = p.expect("]")
+			if err != nil {
+				return nil, err
+			}
+			node = &filterIndexNode{list: node, index: i}
+		}
+		return node, nil
+	case filterTokenOp:
+		switch tok.text {
+		case "(":
+			node, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return node, p.expect(")")
+		case "[":
+			return p.parseList(tok)
+		}
+	}
+
+	return nil, xerrors.Errorf("unexpected %s at offset %d", tok, tok.pos)
+}
+
+func (p *filterParser) parseList(open filterToken) (filterNode, error) {
+	list := &filterLiteralNode{k: filterKindEmptyList}
+	var (
+		strs []string
+		nums []int64
+	)
+	for !p.accept("]") {
+		if len(strs)+len(nums) > 0 {
+			err := p.expect(",")
+			if err != nil {
+				return nil, err
+			}
+		}
+		tok := p.next()
+		switch tok.kind {
+		case filterTokenString:
+			strs = append(strs, tok.text)
+		case filterTokenNumber:
+			n, err := strconv.ParseInt(tok.text, 10, 64)
+			if err != nil {
+				return nil, xerrors.Errorf("invalid number %s at offset %d: %w", tok, tok.pos, err)
+			}
+			nums = append(nums, n)
+		default:
+			return nil, xerrors.Errorf("expected string or number in list starting at offset %d, got %s at offset %d", open.pos, tok, tok.pos)
+		}
+		if len(strs) > 0 && len(nums) > 0 {
+			return nil, xerrors.Errorf("list starting at offset %d mixes strings and numbers", open.pos)
+		}
+	}
+
+	switch {
+	case len(strs) > 0:
+		list.k, list.value = filterKindStringList, strs
+	case len(nums) > 0:
+		list.k, list.value = filterKindNumberList, nums
+	default:
+		list.value = []string{}
+	}
+	return list, nil
+}
+
+type filterNode interface {
+	kind() filterKind
+	eval(ev *Event) interface{}
+}
+
+type filterLiteralNode struct {
+	k     filterKind
+	value interface{}
+}
+
+func (n *filterLiteralNode) kind() filterKind        { return n.k }
+func (n *filterLiteralNode) eval(*Event) interface{} { return n.value }
+
+type filterFieldNode struct {
+	name  string
+	field filterField
+}
+
+func (n *filterFieldNode) kind() filterKind           { return n.field.kind }
+func (n *filterFieldNode) eval(ev *Event) interface{} { return n.field.get(ev) }
+
+type filterIndexNode struct {
+	list  filterNode
+	index int
+}
+
+func (*filterIndexNode) kind() filterKind { return filterKindString }
+
+func (n *filterIndexNode) eval(ev *Event) interface{} {
+	list, _ := n.list.eval(ev).([]string)
+	if n.index >= len(list) {
+		return ""
+	}
+	return list[n.index]
+}
+
+type filterNotNode struct {
+	operand filterNode
+}
+
+func (*filterNotNode) kind() filterKind { return filterKindBool }
+
+func (n *filterNotNode) eval(ev *Event) interface{} {
+	v, _ := n.operand.eval(ev).(bool)
+	return !v
+}
+
+type filterAndNode struct {
+	left, right filterNode
+}
+
+func (*filterAndNode) kind() filterKind { return filterKindBool }
+
+func (n *filterAndNode) eval(ev *Event) interface{} {
+	l, _ := n.left.eval(ev).(bool)
+	if !l {
+		return false
+	}
+	r, _ := n.right.eval(ev).(bool)
+	return r
+}
+
+type filterOrNode struct {
+	left, right filterNode
+}
+
+func (*filterOrNode) kind() filterKind { return filterKindBool }
+
+func (n *filterOrNode) eval(ev *Event) interface{} {
+	l, _ := n.left.eval(ev).(bool)
+	if l {
+		return true
+	}
+	r, _ := n.right.eval(ev).(bool)
+	return r
+}
+
+type filterCompareNode struct {
+	op          string
+	left, right filterNode
+	// re is set for the "matches" operator.
+	re *regexp.Regexp
+}
+
+func newFilterCompareNode(op string, left, right filterNode) (*filterCompareNode, error) {
+	n := &filterCompareNode{op: op, left: left, right: right}
+	lk, rk := left.kind(), right.kind()
+
+	switch op {
+	case "==", "!=":
+		if lk != rk || lk.isList() {
+			return nil, xerrors.Errorf("cannot compare %s with %s", lk, rk)
+		}
+	case "<", "<=", ">", ">=":
+		if lk != filterKindNumber || rk != filterKindNumber {
+			return nil, xerrors.Errorf("operands must be numbers, got %s and %s", lk, rk)
+		}
+	case "matches":
+		if lk != filterKindString && lk != filterKindStringList {
+			return nil, xerrors.Errorf("left operand must be a string or list of strings, got %s", lk)
+		}
+		lit, ok := right.(*filterLiteralNode)
+		if !ok || rk != filterKindString {
+			return nil, xerrors.New("right operand must be a string literal")
+		}
+		re, err := regexp.Compile(lit.value.(string))
+		if err != nil {
+			return nil, xerrors.Errorf("compile regex: %w", err)
+		}
+		n.re = re
+	case "startsWith", "endsWith":
+		if (lk != filterKindString && lk != filterKindStringList) || rk != filterKindString {
+			return nil, xerrors.Errorf("operands must be strings, got %s and %s", lk, rk)
+		}
+	case "contains":
+		switch {
+		case lk == filterKindString && rk == filterKindString:
+		case lk.isList() && !rk.isList() && (lk == filterKindEmptyList || lk.elem() == rk):
+		default:
+			return nil, xerrors.Errorf("cannot check if %s contains %s", lk, rk)
+		}
+	case "in":
+		if !rk.isList() || lk.isList() || (rk != filterKindEmptyList && rk.elem() != lk) {
+			return nil, xerrors.Errorf("cannot check if %s is in %s", lk, rk)
+		}
+		// Normalize to contains so evaluation is shared.
+		n.op, n.left, n.right = "contains", right, left
+	default:
+		return nil, xerrors.Errorf("unknown operator %q", op)
+	}
+
+	return n, nil
+}
+
+func (*filterCompareNode) kind() filterKind { return filterKindBool }
+
+func (n *filterCompareNode) eval(ev *Event) interface{} {
+	l, r := n.left.eval(ev), n.right.eval(ev)
+
+	switch n.op {
+	case "==":
+		return l == r
+	case "!=":
+		return l != r
+	case "<", "<=", ">", ">=":
+		ln, _ := l.(int64)
+		rn, _ := r.(int64)
+		switch n.op {
+		case "<":
+			return ln < rn
+		case "<=":
+			return ln <= rn
+		case ">":
+			return ln > rn
+		default:
+			return ln >= rn
+		}
+	case "matches":
+		return anyString(l, n.re.MatchString)
+	case "startsWith":
+		rs, _ := r.(string)
+		return anyString(l, func(s string) bool { return strings.HasPrefix(s, rs) })
+	case "endsWith":
+		rs, _ := r.(string)
+		return anyString(l, func(s string) bool { return strings.HasSuffix(s, rs) })
+	case "contains":
+		switch lv := l.(type) {
+		case string:
+			rs, _ := r.(string)
+			return strings.Contains(lv, rs)
+		case []string:
+			for _, s := range lv {
+				if s == r {
+					return true
+				}
+			}
+		case []int64:
+			for _, v := range lv {
+				if v == r {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	return false
+}
+
+// anyString returns true if v is a string matching fn, or a list of strings
+// where any element matches fn.
+func anyString(v interface{}, fn func(string) bool) bool {
+	switch val := v.(type) {
+	case string:
+		return fn(val)
+	case []string:
+		for _, s := range val {
+			if fn(s) {
+				return true
+			}
+		}
+	}
+	return false
+}
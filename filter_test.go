@@ -0,0 +1,97 @@
+package exectrace_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/coder/exectrace"
+)
+
+func TestFilter(t *testing.T) {
+	t.Parallel()
+
+	ev := &exectrace.Event{
+		Filename:  "/tmp/evil/payload",
+		Argv:      []string{"payload", "--connect", "10.0.0.1"},
+		Truncated: false,
+		PID:       1234,
+		UID:       1000,
+		GID:       1000,
+		Comm:      "bash",
+	}
+
+	cases := []struct {
+		expr     string
+		expected bool
+	}{
+		{`uid >= 1000`, true},
+		{`uid < 1000`, false},
+		{`uid == 1000 && gid != 0`, true},
+		{`pid == 1 || pid == 1234`, true},
+		{`filename matches "^/tmp/"`, true},
+		{"filename matches `^/usr/`", false},
+		{`comm in ["code-server", "node"]`, false},
+		{`!(comm in ["code-server", "node"])`, true},
+		{`uid >= 1000 && filename matches "^/tmp/" && !(comm in ["code-server","node"])`, true},
+		{`argv contains "--connect"`, true},
+		{`"10.0.0.1" in argv`, true},
+		{`argv matches "^10\\."`, true},
+		{`argv[0] == "payload"`, true},
+		{`argv[10] == ""`, true},
+		{`argc == 3`, true},
+		{`cmdline contains "--connect 10.0.0.1"`, true},
+		{`filename startsWith "/tmp" && filename endsWith "payload"`, true},
+		{`truncated`, false},
+		{`!truncated && !redacted`, true},
+		{`uid in [0, 1000]`, true},
+		{`uid in []`, false},
+		{`truncated == false`, true},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.expr, func(t *testing.T) {
+			t.Parallel()
+
+			f, err := exectrace.ParseFilter(c.expr)
+			require.NoError(t, err)
+			require.Equal(t, c.expr, f.String())
+			require.Equal(t, c.expected, f.Match(ev))
+		})
+	}
+}
+
+func TestFilterErrors(t *testing.T) {
+	t.Parallel()
+
+	cases := []string{
+		``,
+		`uid`,
+		`uid >=`,
+		`unknown == 1`,
+		`uid == "1000"`,
+		`comm < "a"`,
+		`comm matches "("`,
+		`comm matches comm`,
+		`uid in ["a"]`,
+		`[1, "a"] contains 1`,
+		`(uid == 1`,
+		`uid == 1)`,
+		`comm[0] == "a"`,
+		`comm == "unterminated`,
+		`uid == 1 @`,
+		`!uid`,
+		`uid && truncated`,
+	}
+
+	for _, expr := range cases {
+		expr := expr
+		t.Run(expr, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := exectrace.ParseFilter(expr)
+			require.Error(t, err)
+		})
+	}
+}
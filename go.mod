@@ -5,8 +5,6 @@ go 1.21.0
 require (
 	github.com/cilium/ebpf v0.14.0
 	github.com/hashicorp/go-multierror v1.1.1
-	github.com/kballard/go-shellquote v0.0.0-20180428030007-95032a82bc51
-	github.com/spf13/cobra v1.8.0
 	github.com/stretchr/testify v1.9.0
 	golang.org/x/sys v0.19.0
 	golang.org/x/xerrors v0.0.0-20231012003039-104605ab7028
@@ -15,9 +13,7 @@ require (
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/hashicorp/errwrap v1.1.0 // indirect
-	github.com/inconshreveable/mousetrap v1.1.0 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/spf13/pflag v1.0.5 // indirect
 	golang.org/x/exp v0.0.0-20240409090435-93d18d7e34b8 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
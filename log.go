@@ -0,0 +1,317 @@
+package exectrace
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LogLevel is the severity of a LogEntry. The values must be kept in sync with
+// `enum log_level` in `bpf/handler.c`.
+type LogLevel uint32
+
+const (
+	// LogLevelDebug entries are only logged by debug builds of the eBPF
+	// program.
+	LogLevelDebug LogLevel = 0
+	LogLevelInfo  LogLevel = 1
+	// LogLevelWarn entries mean an event is incomplete, e.g. because one of
+	// the arguments couldn't be read.
+	LogLevelWarn LogLevel = 2
+	// LogLevelError entries mean an event was dropped or the tracer isn't
+	// working correctly.
+	LogLevelError LogLevel = 3
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogLevelDebug:
+		return "debug"
+	case LogLevelInfo:
+		return "info"
+	case LogLevelWarn:
+		return "warn"
+	case LogLevelError:
+		return "error"
+	default:
+		return "level(" + strconv.FormatUint(uint64(l), 10) + ")"
+	}
+}
+
+// SlogLevel returns the equivalent log/slog level. Unknown levels are treated
+// as errors.
+func (l LogLevel) SlogLevel() slog.Level {
+	switch l {
+	case LogLevelDebug:
+		return slog.LevelDebug
+	case LogLevelInfo:
+		return slog.LevelInfo
+	case LogLevelWarn:
+		return slog.LevelWarn
+	default:
+		return slog.LevelError
+	}
+}
+
+// LogCode identifies the message of a LogEntry. Codes are stable, so they can
+// be used to match specific messages, e.g. in alerts. Codes below 1000 are
+// logged by the eBPF program and must be kept in sync with `enum log_code` in
+// `bpf/handler.c`, codes from 1000 are logged by the tracer in userspace.
+type LogCode uint32
+
+const (
+	LogCodeUnknown LogCode = 0
+
+	// LogCodeReadPidNS means the PID namespace of the current task couldn't
+	// be read, so the event was dropped.
+	LogCodeReadPidNS LogCode = 1
+	// LogCodeReadParentPidNS means a parent PID namespace couldn't be read,
+	// so the event was dropped.
+	LogCodeReadParentPidNS LogCode = 2
+	// LogCodePidNSEnd is a debug message logged when the root PID namespace
+	// is reached without matching the PID namespace filter.
+	LogCodePidNSEnd LogCode = 3
+	// LogCodeReadPidNSInum means the inum of a PID namespace couldn't be
+	// read, so the event was dropped.
+	LogCodeReadPidNSInum LogCode = 4
+	// LogCodePidNSIteration is a debug message logged for each PID namespace
+	// checked by the PID namespace filter.
+	LogCodePidNSIteration LogCode = 5
+	// LogCodePidNSNoMatch is a debug message logged when the maximum PID
+	// namespace depth is reached without matching the filter.
+	LogCodePidNSNoMatch LogCode = 6
+	// LogCodeReadForkPIDs means the PIDs of a forked task couldn't be read, so
+	// the child may not be followed by the PID filter.
+	LogCodeReadForkPIDs LogCode = 7
+	// LogCodeTrackChild means a child couldn't be added to the processes
+	// followed by the PID filter, usually because too many are tracked.
+	LogCodeTrackChild LogCode = 8
+	// LogCodeReadExitPIDs means the PIDs of an exiting task couldn't be read.
+	LogCodeReadExitPIDs LogCode = 9
+	// LogCodeReserveEvent means an event was dropped because the events
+	// ringbuf was full.
+	LogCodeReserveEvent LogCode = 10
+	// LogCodeZeroEvent means an event couldn't be initialized and was
+	// dropped.
+	LogCodeZeroEvent LogCode = 11
+	// LogCodeReadPPID means the parent PID couldn't be read, so Event.PPID is
+	// 0.
+	LogCodeReadPPID LogCode = 12
+	// LogCodeGetComm means the comm of the calling process couldn't be read,
+	// so the event was dropped.
+	LogCodeGetComm LogCode = 13
+	// LogCodeReadFilename means the filename couldn't be read, so the event
+	// was dropped.
+	LogCodeReadFilename LogCode = 14
+	// LogCodeReadArgv means an argument couldn't be read, so Event.Argv only
+	// contains the arguments before it.
+	LogCodeReadArgv LogCode = 15
+	// LogCodeReadEventPidNS means the PID namespace of the calling process
+	// couldn't be read, so Event.PidNS is 0.
+	LogCodeReadEventPidNS LogCode = 16
+
+	// LogCodeReadLog means a log entry couldn't be read from the kernel.
+	LogCodeReadLog LogCode = 1000
+	// LogCodeParseLog means a log entry from the kernel couldn't be parsed.
+	LogCodeParseLog LogCode = 1001
+	// LogCodePanic means the goroutine reading logs panicked, so the tracer
+	// was closed.
+	LogCodePanic LogCode = 1002
+	// LogCodeProcConnectorOverflow means the proc connector socket buffer
+	// overflowed, so events were dropped.
+	LogCodeProcConnectorOverflow LogCode = 1003
+	// LogCodeBackendFallback is a warning that the eBPF backend couldn't be
+	// loaded, so BackendAuto fell back to the proc connector backend.
+	LogCodeBackendFallback LogCode = 1004
+)
+
+// logArgSpec describes an argument of a log code. Kernel arguments are sent as
+// uint32s and converted to int32 if signed is true.
+type logArgSpec struct {
+	name   string
+	signed bool
+}
+
+// logCodeInfo describes the message of a log code. format contains a %v
+// directive for each argument.
+type logCodeInfo struct {
+	name   string
+	format string
+	args   []logArgSpec
+}
+
+var (
+	argIteration = logArgSpec{name: "iteration"}
+	argRet       = logArgSpec{name: "ret", signed: true}
+	argErr       = logArgSpec{name: "error"}
+)
+
+var logCodes = map[LogCode]logCodeInfo{
+	LogCodeReadPidNS:             {"read_pidns", "could not read current task pidns: %v", []logArgSpec{argRet}},
+	LogCodeReadParentPidNS:       {"read_parent_pidns", "could not read parent pidns on iteration %v: %v", []logArgSpec{argIteration, argRet}},
+	LogCodePidNSEnd:              {"pidns_end", "no more pidns after %v iterations", []logArgSpec{argIteration}},
+	LogCodeReadPidNSInum:         {"read_pidns_inum", "could not read pidns common on iteration %v: %v", []logArgSpec{argIteration, argRet}},
+	LogCodePidNSIteration:        {"pidns_iteration", "got pidns on iteration %v: %v (target=%v)", []logArgSpec{argIteration, {name: "inum"}, {name: "target"}}},
+	LogCodePidNSNoMatch:          {"pidns_no_match", "does not match pidns filter after %v iterations", []logArgSpec{argIteration}},
+	LogCodeReadForkPIDs:          {"read_fork_pids", "could not read forked task pids", nil},
+	LogCodeTrackChild:            {"track_child", "could not track child %v: %v", []logArgSpec{{name: "child_pid"}, argRet}},
+	LogCodeReadExitPIDs:          {"read_exit_pids", "could not read exiting task pids", nil},
+	LogCodeReserveEvent:          {"reserve_event", "could not reserve events ringbuf memory", nil},
+	LogCodeZeroEvent:             {"zero_event", "zero out event: %v", []logArgSpec{argRet}},
+	LogCodeReadPPID:              {"read_ppid", "could not read parent pid: %v", []logArgSpec{argRet}},
+	LogCodeGetComm:               {"get_comm", "could not get current comm: %v", []logArgSpec{argRet}},
+	LogCodeReadFilename:          {"read_filename", "could not read filename into event struct: %v", []logArgSpec{argRet}},
+	LogCodeReadArgv:              {"read_argv", "read argv %v: %v", []logArgSpec{{name: "index"}, argRet}},
+	LogCodeReadEventPidNS:        {"read_event_pidns", "could not read pidns: %v", []logArgSpec{argRet}},
+	LogCodeReadLog:               {"read_log", "read log: %+v", []logArgSpec{argErr}},
+	LogCodeParseLog:              {"parse_log", "parse raw log entry: %+v", []logArgSpec{argErr}},
+	LogCodePanic:                 {"panic", "panic in (*tracer).readLogs() goroutine: %v", []logArgSpec{{name: "panic"}}},
+	LogCodeProcConnectorOverflow: {"proc_connector_overflow", "proc connector socket buffer overflowed, exec events were dropped", nil},
+	LogCodeBackendFallback:       {"backend_fallback", "falling back to the proc connector backend as the eBPF backend is unavailable: %v", []logArgSpec{argErr}},
+}
+
+// String returns the stable name of the code, e.g. "reserve_event".
+func (c LogCode) String() string {
+	if info, ok := logCodes[c]; ok {
+		return info.name
+	}
+	return "code(" + strconv.FormatUint(uint64(c), 10) + ")"
+}
+
+// logPrograms are the names of the eBPF programs by their `enum log_prog`
+// value in `bpf/handler.c`.
+var logPrograms = []string{
+	1: "enter_execve",
+	2: "exit_execve",
+	3: "sched_process_fork",
+	4: "sched_process_exit",
+}
+
+// LogArg is a named argument of a LogEntry.
+type LogArg struct {
+	Name string
+	// Value is a uint32 or an int32 for entries logged by the eBPF program.
+	// Negative int32s are usually kernel error codes. Entries logged in
+	// userspace may have other types, e.g. error.
+	Value interface{}
+}
+
+// LogEntry is a structured log entry from the tracer.
+type LogEntry struct {
+	Level LogLevel
+	Code  LogCode
+	// Program is the name of the eBPF program that logged the entry, e.g.
+	// "enter_execve". It's empty for entries logged in userspace.
+	Program string
+	// Args contains the arguments of the message in the order they appear in
+	// it.
+	Args []LogArg
+	// KernelTime is the CLOCK_MONOTONIC time the entry was logged by the eBPF
+	// program. It's 0 for entries logged in userspace.
+	KernelTime time.Duration
+	// Time is when the entry was logged, converted from KernelTime for
+	// entries logged by the eBPF program.
+	Time time.Time
+	// UID, GID and PID are of the task that was running when the entry was
+	// logged by the eBPF program. They are 0 for entries logged in
+	// userspace.
+	UID uint32
+	GID uint32
+	PID uint32
+}
+
+// Message formats the message of the entry with its arguments, e.g. "could not
+// track child 123: -7".
+func (e LogEntry) Message() string {
+	values := make([]interface{}, len(e.Args))
+	for i, arg := range e.Args {
+		values[i] = arg.Value
+	}
+	info, ok := logCodes[e.Code]
+	if !ok || len(info.args) != len(values) {
+		// Entries from newer eBPF programs may have unknown codes.
+		var b strings.Builder
+		b.WriteString("unknown log entry " + e.Code.String())
+		for _, arg := range e.Args {
+			fmt.Fprintf(&b, " %s=%v", arg.Name, arg.Value)
+		}
+		return b.String()
+	}
+	if len(values) == 0 {
+		return info.format
+	}
+	return fmt.Sprintf(info.format, values...)
+}
+
+// newLogEntry returns an error entry logged in userspace with the arguments of
+// the given code.
+func newLogEntry(code LogCode, values ...interface{}) LogEntry {
+	entry := LogEntry{
+		Level: LogLevelError,
+		Code:  code,
+		Time:  time.Now(),
+	}
+	specs := logCodes[code].args
+	for i, value := range values {
+		name := "arg" + strconv.Itoa(i)
+		if i < len(specs) {
+			name = specs[i].name
+		}
+		entry.Args = append(entry.Args, LogArg{Name: name, Value: value})
+	}
+	return entry
+}
+
+// LogHandler is called with each log entry from the tracer, see
+// TracerOpts.LogHandler.
+type LogHandler func(entry LogEntry)
+
+// DefaultLogHandler is used when neither TracerOpts.LogHandler nor
+// TracerOpts.LogFn are set. It logs entries to the standard logger.
+func DefaultLogHandler(entry LogEntry) {
+	log.Printf("%s log from exectrace tracer (code=%s, program=%s, uid=%v, gid=%v, pid=%v): %s",
+		entry.Level, entry.Code, entry.Program, entry.UID, entry.GID, entry.PID, entry.Message())
+}
+
+// logFnHandler adapts a TracerOpts.LogFn to a LogHandler.
+func logFnHandler(logFn func(uid, gid, pid uint32, logLine string)) LogHandler {
+	return func(entry LogEntry) {
+		logFn(entry.UID, entry.GID, entry.PID, entry.Message())
+	}
+}
+
+// NewSlogLogHandler returns a LogHandler that logs entries to logger with the
+// equivalent slog level and the entry time. The code, program, uid, gid and
+// pid are added as attributes, and the arguments are added in an "args" group.
+func NewSlogLogHandler(logger *slog.Logger) LogHandler {
+	return func(entry LogEntry) {
+		ctx := context.Background()
+		level := entry.Level.SlogLevel()
+		if !logger.Enabled(ctx, level) {
+			return
+		}
+
+		r := slog.NewRecord(entry.Time, level, entry.Message(), 0)
+		r.AddAttrs(slog.String("code", entry.Code.String()))
+		if entry.Program != "" {
+			r.AddAttrs(
+				slog.String("program", entry.Program),
+				slog.Uint64("uid", uint64(entry.UID)),
+				slog.Uint64("gid", uint64(entry.GID)),
+				slog.Uint64("pid", uint64(entry.PID)),
+			)
+		}
+		if len(entry.Args) > 0 {
+			args := make([]interface{}, 0, len(entry.Args))
+			for _, arg := range entry.Args {
+				args = append(args, slog.Any(arg.Name, arg.Value))
+			}
+			r.AddAttrs(slog.Group("args", args...))
+		}
+		_ = logger.Handler().Handle(ctx, r)
+	}
+}
@@ -0,0 +1,88 @@
+package exectrace_test
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/coder/exectrace"
+)
+
+func TestLogEntry(t *testing.T) {
+	t.Parallel()
+
+	entry := exectrace.LogEntry{
+		Level:   exectrace.LogLevelError,
+		Code:    exectrace.LogCodeTrackChild,
+		Program: "sched_process_fork",
+		Args: []exectrace.LogArg{
+			{Name: "child_pid", Value: uint32(1234)},
+			{Name: "ret", Value: int32(-7)},
+		},
+		KernelTime: 42 * time.Second,
+		Time:       time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC),
+		UID:        1000,
+		GID:        1001,
+		PID:        99,
+	}
+
+	t.Run("Message", func(t *testing.T) {
+		t.Parallel()
+
+		require.Equal(t, "could not track child 1234: -7", entry.Message())
+		require.Equal(t, "track_child", entry.Code.String())
+		require.Equal(t, "error", entry.Level.String())
+
+		noArgs := exectrace.LogEntry{Code: exectrace.LogCodeReserveEvent}
+		require.Equal(t, "could not reserve events ringbuf memory", noArgs.Message())
+
+		// Entries from newer eBPF programs may have unknown codes.
+		unknown := exectrace.LogEntry{
+			Code: 999,
+			Args: []exectrace.LogArg{{Name: "arg0", Value: uint32(5)}},
+		}
+		require.Equal(t, "code(999)", unknown.Code.String())
+		require.Equal(t, "unknown log entry code(999) arg0=5", unknown.Message())
+		require.Equal(t, "level(7)", exectrace.LogLevel(7).String())
+	})
+
+	t.Run("SlogLevel", func(t *testing.T) {
+		t.Parallel()
+
+		require.Equal(t, slog.LevelDebug, exectrace.LogLevelDebug.SlogLevel())
+		require.Equal(t, slog.LevelInfo, exectrace.LogLevelInfo.SlogLevel())
+		require.Equal(t, slog.LevelWarn, exectrace.LogLevelWarn.SlogLevel())
+		require.Equal(t, slog.LevelError, exectrace.LogLevelError.SlogLevel())
+		require.Equal(t, slog.LevelError, exectrace.LogLevel(7).SlogLevel())
+	})
+
+	t.Run("Slog", func(t *testing.T) {
+		t.Parallel()
+
+		var buf bytes.Buffer
+		handler := exectrace.NewSlogLogHandler(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{
+			Level: slog.LevelWarn,
+		})))
+		handler(entry)
+		require.Equal(t, `time=2024-05-06T07:08:09.000Z level=ERROR msg="could not track child 1234: -7" code=track_child program=sched_process_fork uid=1000 gid=1001 pid=99 args.child_pid=1234 args.ret=-7`+"\n", buf.String())
+
+		// Entries below the logger's level are skipped.
+		buf.Reset()
+		handler(exectrace.LogEntry{Level: exectrace.LogLevelDebug, Code: exectrace.LogCodePidNSEnd})
+		require.Empty(t, buf.String())
+
+		// Userspace entries don't have a program or task.
+		buf.Reset()
+		handler(exectrace.LogEntry{
+			Level: exectrace.LogLevelError,
+			Code:  exectrace.LogCodeReadLog,
+			Args:  []exectrace.LogArg{{Name: "error", Value: errors.New("boom")}},
+			Time:  entry.Time,
+		})
+		require.Equal(t, `time=2024-05-06T07:08:09.000Z level=ERROR msg="read log: boom" code=read_log args.error=boom`+"\n", buf.String())
+	})
+}
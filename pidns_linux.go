@@ -0,0 +1,54 @@
+//go:build linux
+// +build linux
+
+package exectrace
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+	"golang.org/x/xerrors"
+)
+
+// nsGetParent is the NS_GET_PARENT ioctl from `linux/nsfs.h`.
+const nsGetParent = 0xb702
+
+// maxPidNSDepth is the maximum nesting depth of PID namespaces, see
+// MAX_PID_NS_LEVEL in `kernel/pid_namespace.c`.
+const maxPidNSDepth = 32
+
+// PidInNS returns true if the process is in the given PID namespace or one of
+// its descendants, matching the PidNS filter in the eBPF program. The process
+// must still be running.
+func PidInNS(pid, pidNS uint32) (bool, error) {
+	fd, err := unix.Open(fmt.Sprintf("/proc/%d/ns/pid", pid), unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return false, xerrors.Errorf("open PID namespace: %w", err)
+	}
+
+	for i := 0; i < maxPidNSDepth; i++ {
+		var st unix.Stat_t
+		err = unix.Fstat(fd, &st)
+		if err != nil {
+			_ = unix.Close(fd)
+			return false, xerrors.Errorf("stat PID namespace: %w", err)
+		}
+		if st.Ino == uint64(pidNS) {
+			_ = unix.Close(fd)
+			return true, nil
+		}
+
+		// Walk up to the parent namespace. This fails with EPERM once we
+		// reach the namespace of the current process, as its parents aren't
+		// visible to us.
+		parent, err := unix.IoctlRetInt(fd, nsGetParent)
+		_ = unix.Close(fd)
+		if err != nil {
+			return false, nil //nolint:nilerr
+		}
+		fd = parent
+	}
+
+	_ = unix.Close(fd)
+	return false, nil
+}
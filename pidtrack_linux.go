@@ -0,0 +1,147 @@
+//go:build linux
+// +build linux
+
+package exectrace
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	"golang.org/x/xerrors"
+)
+
+// processTree returns the given process and all of its running descendants,
+// read from /proc. It's used to seed the PID filter, which only sees processes
+// forked after it was enabled.
+func processTree(pid uint32) (map[uint32]struct{}, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, xerrors.Errorf("read /proc: %w", err)
+	}
+
+	found := false
+	children := map[uint32][]uint32{}
+	for _, entry := range entries {
+		child, err := strconv.ParseUint(entry.Name(), 10, 32)
+		if err != nil {
+			continue
+		}
+		if uint32(child) == pid {
+			found = true
+		}
+		ppid, err := readProcPPID(uint32(child))
+		if err != nil {
+			// The process exited while we were reading.
+			continue
+		}
+		children[ppid] = append(children[ppid], uint32(child))
+	}
+	if !found {
+		return nil, xerrors.Errorf("process %d does not exist", pid)
+	}
+
+	tree := map[uint32]struct{}{pid: {}}
+	queue := []uint32{pid}
+	for len(queue) > 0 {
+		parent := queue[0]
+		queue = queue[1:]
+		for _, child := range children[parent] {
+			if _, ok := tree[child]; ok {
+				continue
+			}
+			tree[child] = struct{}{}
+			queue = append(queue, child)
+		}
+	}
+	return tree, nil
+}
+
+// readProcPPID reads the parent PID of a process from /proc/<pid>/stat.
+func readProcPPID(pid uint32) (uint32, error) {
+	stat, err := os.ReadFile("/proc/" + strconv.FormatUint(uint64(pid), 10) + "/stat")
+	if err != nil {
+		return 0, err
+	}
+	// The comm can contain spaces and parentheses, so the fields are read
+	// after the last parenthesis: "pid (comm) state ppid ...".
+	i := strings.LastIndexByte(string(stat), ')')
+	if i == -1 {
+		return 0, xerrors.Errorf("invalid stat for process %d", pid)
+	}
+	fields := strings.Fields(string(stat[i+1:]))
+	if len(fields) < 2 {
+		return 0, xerrors.Errorf("invalid stat for process %d", pid)
+	}
+	ppid, err := strconv.ParseUint(fields[1], 10, 32)
+	if err != nil {
+		return 0, xerrors.Errorf("parse ppid %q of process %d: %w", fields[1], pid, err)
+	}
+	return uint32(ppid), nil
+}
+
+// trackPIDs enables the PID filter in the eBPF program for the given process
+// and its descendants, or disables it if pid is 0. Processes tracked
+// previously, e.g. in a pinned map or before a Reload, are removed.
+func (o *bpfObjects) trackPIDs(pid uint32) error {
+	// Keys can't be deleted while iterating, so the previously tracked
+	// processes are collected first. Processes forked while the map is
+	// updated are added by the eBPF program and must be kept.
+	var (
+		key      uint32
+		value    uint8
+		previous []uint32
+	)
+	iter := o.TrackedPidsMap.Iterate()
+	for iter.Next(&key, &value) {
+		previous = append(previous, key)
+	}
+	if err := iter.Err(); err != nil {
+		return xerrors.Errorf("iterate tracked processes in eBPF map: %w", err)
+	}
+
+	var tree map[uint32]struct{}
+	if pid != 0 {
+		// Enable the filter first so the eBPF program tracks children of
+		// the processes as soon as they're added. Processes forked before
+		// their parent was added are found by a second pass over /proc.
+		err := o.FiltersMap.Update(filterTrackedIdx, uint32(1), ebpf.UpdateAny)
+		if err != nil {
+			return xerrors.Errorf("enable PID filter in eBPF map: %w", err)
+		}
+		tree = map[uint32]struct{}{}
+		for i := 0; i < 2; i++ {
+			pass, err := processTree(pid)
+			if err != nil {
+				return err
+			}
+			for p := range pass {
+				if _, ok := tree[p]; ok {
+					continue
+				}
+				err = o.TrackedPidsMap.Update(p, uint8(1), ebpf.UpdateAny)
+				if err != nil {
+					return xerrors.Errorf("track process %d in eBPF map: %w", p, err)
+				}
+				tree[p] = struct{}{}
+			}
+		}
+	} else {
+		err := o.FiltersMap.Update(filterTrackedIdx, uint32(0), ebpf.UpdateAny)
+		if err != nil {
+			return xerrors.Errorf("disable PID filter in eBPF map: %w", err)
+		}
+	}
+
+	for _, key := range previous {
+		if _, ok := tree[key]; ok {
+			continue
+		}
+		err := o.TrackedPidsMap.Delete(key)
+		if err != nil && !xerrors.Is(err, ebpf.ErrKeyNotExist) {
+			return xerrors.Errorf("untrack process %d in eBPF map: %w", key, err)
+		}
+	}
+	return nil
+}
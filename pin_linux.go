@@ -0,0 +1,158 @@
+//go:build linux
+// +build linux
+
+package exectrace
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+	"github.com/hashicorp/go-multierror"
+	"golang.org/x/xerrors"
+)
+
+// Names of the pinned program and tracepoint link under TracerOpts.PinPath.
+// Maps are pinned under their own names.
+const (
+	pinnedProgramName = "enter_execve"
+	pinnedLinkName    = "enter_execve_link"
+)
+
+// pinMaps configures spec so all maps are pinned under pinPath when loaded,
+// or reuse the maps already pinned there.
+func pinMaps(spec *ebpf.CollectionSpec, opts *ebpf.CollectionOptions, pinPath string) error {
+	err := os.MkdirAll(pinPath, 0o700)
+	if err != nil {
+		return xerrors.Errorf("create pin directory %q: %w", pinPath, err)
+	}
+	for name, m := range spec.Maps {
+		// Internal maps such as .rodata are frozen and recreated on load.
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		m.Pinning = ebpf.PinByName
+	}
+	opts.Maps.PinPath = pinPath
+	return nil
+}
+
+// pinProgram pins the loaded program under pinPath, or replaces it with the
+// program already pinned there so the pinned link and program match.
+func (o *bpfObjects) pinProgram(pinPath string) error {
+	path := filepath.Join(pinPath, pinnedProgramName)
+	prog, err := ebpf.LoadPinnedProgram(path, nil)
+	if err == nil {
+		_ = o.EnterExecveProg.Close()
+		o.EnterExecveProg = prog
+		o.pinnedProgram = true
+		return nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return xerrors.Errorf("load pinned program %q: %w", path, err)
+	}
+
+	err = o.EnterExecveProg.Pin(path)
+	if err != nil {
+		return xerrors.Errorf("pin program to %q: %w", path, err)
+	}
+	return nil
+}
+
+// repin replaces the program and tracepoint link pinned under pinPath after
+// the program was reloaded. The link is only pinned if it could be pinned
+// before.
+func repin(pinPath string, prog *ebpf.Program, l link.Link) error {
+	path := filepath.Join(pinPath, pinnedProgramName)
+	err := os.Remove(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return xerrors.Errorf("unpin program %q: %w", path, err)
+	}
+	err = prog.Pin(path)
+	if err != nil {
+		return xerrors.Errorf("pin program to %q: %w", path, err)
+	}
+
+	path = filepath.Join(pinPath, pinnedLinkName)
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return xerrors.Errorf("unpin tracepoint link %q: %w", path, err)
+	}
+	err = l.Pin(path)
+	if err != nil {
+		return xerrors.Errorf("pin tracepoint link to %q: %w", path, err)
+	}
+	return nil
+}
+
+// attachTracepoint attaches the program to the `sys_enter_execve` tracepoint.
+// If pinPath is set, a link already pinned there is reused, and the new link
+// is pinned if the kernel supports it.
+func attachTracepoint(prog *ebpf.Program, pinPath string) (link.Link, error) {
+	if pinPath == "" {
+		return link.Tracepoint("syscalls", "sys_enter_execve", prog, nil)
+	}
+
+	path := filepath.Join(pinPath, pinnedLinkName)
+	l, err := link.LoadPinnedLink(path, nil)
+	if err == nil {
+		return l, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, xerrors.Errorf("load pinned tracepoint link %q: %w", path, err)
+	}
+
+	l, err = link.Tracepoint("syscalls", "sys_enter_execve", prog, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = l.Pin(path)
+	if errors.Is(err, ebpf.ErrNotSupported) {
+		// Tracepoint links backed by a perf event can't be pinned. The pinned
+		// maps still keep unread events across restarts.
+		return l, nil
+	}
+	if err != nil {
+		_ = l.Close()
+		return nil, xerrors.Errorf("pin tracepoint link to %q: %w", path, err)
+	}
+	return l, nil
+}
+
+// Unpin removes the eBPF program, tracepoint link and maps pinned under
+// pinPath by a tracer created with TracerOpts.PinPath, as well as pinPath
+// itself. The kernel frees the objects once no tracer is using them. It's not
+// an error if nothing is pinned under pinPath.
+func Unpin(pinPath string) error {
+	entries, err := os.ReadDir(pinPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return xerrors.Errorf("read pin directory %q: %w", pinPath, err)
+	}
+
+	var merr error
+	for _, entry := range entries {
+		path := filepath.Join(pinPath, entry.Name())
+		err := os.Remove(path)
+		if err != nil {
+			merr = multierror.Append(merr, xerrors.Errorf("unpin %q: %w", path, err))
+		}
+	}
+	if merr != nil {
+		return merr
+	}
+
+	err = os.Remove(pinPath)
+	if err != nil {
+		return xerrors.Errorf("remove pin directory %q: %w", pinPath, err)
+	}
+	return nil
+}
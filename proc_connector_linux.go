@@ -0,0 +1,542 @@
+//go:build linux
+// +build linux
+
+package exectrace
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+	"golang.org/x/xerrors"
+)
+
+// These constants are defined in `linux/connector.h` and `linux/cn_proc.h`.
+const (
+	cnIdxProc = 1
+	cnValProc = 1
+
+	procCnMcastListen = 1
+	procCnMcastIgnore = 2
+
+	procEventNone = 0x00000000
+	procEventFork = 0x00000001
+	procEventExec = 0x00000002
+	procEventExit = 0x80000000
+
+	// cnMsgSize is the size of `struct cn_msg` without the trailing data.
+	cnMsgSize = 20
+	// procEventHeaderSize is the size of `struct proc_event` up to the
+	// event_data union.
+	procEventHeaderSize = 16
+)
+
+// procConnectorRcvBuf is the receive buffer size requested for the netlink
+// socket. The kernel drops notifications when the buffer is full, so it's much
+// larger than the default to handle bursts of forks.
+const procConnectorRcvBuf = 4 << 20
+
+// procConnectorTracer is a Tracer that receives process notifications from the
+// netlink process connector and reads process details from /proc. It is used
+// when the eBPF program can't be loaded, e.g. on kernels older than 5.8.
+//
+// As the kernel only tells us that a process exec'd and not what it exec'd,
+// all events are read from /proc after the fact. See ReliabilityBestEffort and
+// ReliabilityPartial for what that means for consumers.
+type procConnectorTracer struct {
+	// configLock guards opts and redactor, which are replaced by Reload.
+	configLock sync.RWMutex
+	opts       *TracerOpts
+	redactor   *Redactor
+
+	fd   int
+	file *os.File
+	conn syscall.RawConn
+
+	// readLock serializes reads and protects the fields below.
+	readLock sync.Mutex
+	buf      []byte
+	pending  []*Event
+	// comms tracks the comm of each live process, as the comm has already
+	// been changed to the new executable by the time the exec notification
+	// is received. Processes are added on fork and removed on exit.
+	comms map[uint32]string
+
+	// trackLock guards tracked, which contains the processes matching the
+	// PID filter. It's nil if the filter is disabled. Children are added on
+	// fork and processes are removed on exit.
+	trackLock sync.Mutex
+	tracked   map[uint32]struct{}
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+var _ Tracer = &procConnectorTracer{}
+
+// newProcConnectorTracer creates a tracer using the proc connector backend.
+// The netlink socket requires CAP_NET_ADMIN.
+func newProcConnectorTracer(opts *TracerOpts, redactor *Redactor) (*procConnectorTracer, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC|unix.SOCK_NONBLOCK, unix.NETLINK_CONNECTOR)
+	if err != nil {
+		if xerrors.Is(err, unix.EPROTONOSUPPORT) {
+			return nil, withKind(ErrUnsupportedKernel, "create netlink connector socket, the kernel may be built without CONFIG_CONNECTOR", err)
+		}
+		return nil, xerrors.Errorf("create netlink connector socket: %w", err)
+	}
+
+	// Use SO_RCVBUFFORCE if we're allowed to as it ignores rmem_max.
+	err = unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUFFORCE, procConnectorRcvBuf)
+	if err != nil {
+		_ = unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUF, procConnectorRcvBuf)
+	}
+
+	err = unix.Bind(fd, &unix.SockaddrNetlink{
+		Family: unix.AF_NETLINK,
+		Groups: cnIdxProc,
+	})
+	if err != nil {
+		_ = unix.Close(fd)
+		if xerrors.Is(err, os.ErrPermission) {
+			return nil, withKind(ErrPermission, "bind netlink connector socket, run as root or with CAP_NET_ADMIN", err)
+		}
+		return nil, xerrors.Errorf("bind netlink connector socket: %w", err)
+	}
+
+	t := &procConnectorTracer{
+		opts:     opts,
+		redactor: redactor,
+		fd:       fd,
+		// The file takes ownership of the FD. As the FD is non-blocking, reads
+		// go through the runtime poller and are interrupted by Close.
+		file:   os.NewFile(uintptr(fd), "netlink-proc-connector"),
+		buf:    make([]byte, os.Getpagesize()),
+		comms:  map[uint32]string{},
+		closed: make(chan struct{}),
+	}
+	t.conn, err = t.file.SyscallConn()
+	if err != nil {
+		_ = t.file.Close()
+		return nil, xerrors.Errorf("get raw netlink connector socket: %w", err)
+	}
+
+	err = t.setListen(true)
+	if err != nil {
+		_ = t.file.Close()
+		if xerrors.Is(err, os.ErrPermission) {
+			return nil, withKind(ErrPermission, "subscribe to proc connector events, run as root or with CAP_NET_ADMIN", err)
+		}
+		return nil, xerrors.Errorf("subscribe to proc connector events: %w", err)
+	}
+
+	// Processes forked from now on are tracked from the notifications.
+	if opts.PID != 0 {
+		t.tracked, err = processTree(opts.PID)
+		if err != nil {
+			_ = t.Close()
+			return nil, xerrors.Errorf("apply PID filter: %w", err)
+		}
+	}
+
+	return t, nil
+}
+
+// setListen subscribes to or unsubscribes from proc connector events.
+func (t *procConnectorTracer) setListen(listen bool) error {
+	op := uint32(procCnMcastIgnore)
+	if listen {
+		op = procCnMcastListen
+	}
+
+	msg := make([]byte, unix.NLMSG_HDRLEN+cnMsgSize+4)
+	// struct nlmsghdr
+	NativeEndian.PutUint32(msg[0:], uint32(len(msg)))
+	NativeEndian.PutUint16(msg[4:], unix.NLMSG_DONE)
+	// struct cn_msg
+	cn := msg[unix.NLMSG_HDRLEN:]
+	NativeEndian.PutUint32(cn[0:], cnIdxProc)
+	NativeEndian.PutUint32(cn[4:], cnValProc)
+	NativeEndian.PutUint16(cn[16:], 4)
+	// enum proc_cn_mcast_op
+	NativeEndian.PutUint32(cn[cnMsgSize:], op)
+
+	var err error
+	werr := t.conn.Write(func(fd uintptr) bool {
+		err = unix.Sendto(int(fd), msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK})
+		return err != unix.EAGAIN
+	})
+	if werr != nil {
+		return werr
+	}
+	return err
+}
+
+// FD returns the FD of the netlink socket.
+func (t *procConnectorTracer) FD() int {
+	return t.fd
+}
+
+// Read blocks until an exec notification is received, reads the process
+// details from /proc and returns the event. If the tracer is closed during the
+// blocked call, an error that wraps io.EOF will be returned.
+//
+// Events that don't match the PidNS filter or the userspace filter are
+// skipped, and secrets are redacted from the returned event if configured.
+func (t *procConnectorTracer) Read() (*Event, error) {
+	t.readLock.Lock()
+	defer t.readLock.Unlock()
+
+	for {
+		for len(t.pending) == 0 {
+			err := t.receive()
+			if err != nil {
+				return nil, err
+			}
+		}
+		ev := t.pending[0]
+		t.pending = t.pending[1:]
+
+		opts, redactor := t.config()
+		if opts.Filter != nil && !opts.Filter.Match(ev) {
+			continue
+		}
+		if redactor != nil {
+			redactor.RedactEvent(ev)
+		}
+
+		return ev, nil
+	}
+}
+
+// config returns the current options and redactor.
+func (t *procConnectorTracer) config() (*TracerOpts, *Redactor) {
+	t.configLock.RLock()
+	defer t.configLock.RUnlock()
+	return t.opts, t.redactor
+}
+
+// Reload applies the userspace options in opts, which are all of the options
+// supported by the proc connector backend. Events that were received but not
+// read yet are filtered with the new options, except for the PID and PidNS
+// filters.
+func (t *procConnectorTracer) Reload(opts *TracerOpts) error {
+	if opts == nil {
+		opts = &TracerOpts{}
+	}
+	switch opts.Backend {
+	case "", BackendAuto, BackendProcConnector:
+	default:
+		return xerrors.Errorf("can't change the backend of a proc connector tracer to %q", opts.Backend)
+	}
+	redactor, err := prepareOpts(opts)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-t.closed:
+		return errTracerClosed
+	default:
+	}
+
+	t.configLock.Lock()
+	defer t.configLock.Unlock()
+	if opts.PID != t.opts.PID {
+		var tracked map[uint32]struct{}
+		if opts.PID != 0 {
+			tracked, err = processTree(opts.PID)
+			if err != nil {
+				return xerrors.Errorf("apply PID filter: %w", err)
+			}
+		}
+		t.trackLock.Lock()
+		t.tracked = tracked
+		t.trackLock.Unlock()
+	}
+	t.opts, t.redactor = opts, redactor
+	return nil
+}
+
+// receive reads a single datagram from the netlink socket and handles all of
+// the proc events in it. Exec events are appended to t.pending. t.readLock
+// must be held.
+func (t *procConnectorTracer) receive() error {
+	var (
+		n    int
+		from unix.Sockaddr
+		err  error
+	)
+	rerr := t.conn.Read(func(fd uintptr) bool {
+		n, from, err = unix.Recvfrom(int(fd), t.buf, 0)
+		return err != unix.EAGAIN
+	})
+	if rerr != nil {
+		select {
+		case <-t.closed:
+			return xerrors.Errorf("tracer closed: %w", io.EOF)
+		default:
+		}
+		return xerrors.Errorf("read from netlink connector socket: %w", rerr)
+	}
+	if err != nil {
+		if xerrors.Is(err, unix.ENOBUFS) {
+			// The socket buffer overflowed and the kernel dropped
+			// notifications. Nothing can be done to recover them.
+			opts, _ := t.config()
+			opts.LogHandler(newLogEntry(LogCodeProcConnectorOverflow))
+			return nil
+		}
+		return xerrors.Errorf("read from netlink connector socket: %w", err)
+	}
+
+	// Only trust messages from the kernel.
+	if sa, ok := from.(*unix.SockaddrNetlink); !ok || sa.Pid != 0 {
+		return nil
+	}
+
+	msgs, err := syscall.ParseNetlinkMessage(t.buf[:n])
+	if err != nil {
+		return xerrors.Errorf("parse netlink message: %w", err)
+	}
+	for _, msg := range msgs {
+		if msg.Header.Type != unix.NLMSG_DONE {
+			continue
+		}
+		data := msg.Data
+		if len(data) < cnMsgSize+procEventHeaderSize {
+			continue
+		}
+		if NativeEndian.Uint32(data[0:]) != cnIdxProc || NativeEndian.Uint32(data[4:]) != cnValProc {
+			continue
+		}
+		t.handleProcEvent(data[cnMsgSize:])
+	}
+
+	return nil
+}
+
+// handleProcEvent handles a single `struct proc_event`.
+func (t *procConnectorTracer) handleProcEvent(data []byte) {
+	what := NativeEndian.Uint32(data[0:])
+	body := data[procEventHeaderSize:]
+
+	switch what {
+	case procEventFork:
+		if len(body) < 16 {
+			return
+		}
+		parentTgid := NativeEndian.Uint32(body[4:])
+		childPid := NativeEndian.Uint32(body[8:])
+		childTgid := NativeEndian.Uint32(body[12:])
+		if childPid != childTgid {
+			// New thread, not a new process.
+			return
+		}
+		comm, ok := t.comms[parentTgid]
+		if !ok {
+			comm, _ = readProcComm(parentTgid)
+		}
+		t.comms[childTgid] = comm
+
+		t.trackLock.Lock()
+		if _, ok := t.tracked[parentTgid]; ok {
+			t.tracked[childTgid] = struct{}{}
+		}
+		t.trackLock.Unlock()
+
+	case procEventExec:
+		if len(body) < 8 {
+			return
+		}
+		// After an exec the calling thread always becomes the thread group
+		// leader, so the TGID is the PID of the new process.
+		tgid := NativeEndian.Uint32(body[4:])
+		ev := t.buildEvent(tgid)
+		if ev != nil {
+			t.pending = append(t.pending, ev)
+		}
+
+	case procEventExit:
+		if len(body) < 8 {
+			return
+		}
+		pid := NativeEndian.Uint32(body[0:])
+		tgid := NativeEndian.Uint32(body[4:])
+		if pid == tgid {
+			delete(t.comms, tgid)
+			t.trackLock.Lock()
+			delete(t.tracked, tgid)
+			t.trackLock.Unlock()
+		}
+
+	case procEventNone:
+		// Acknowledgement of the subscription message.
+	}
+}
+
+// isTracked returns true if the process matches the PID filter, or if the
+// filter is disabled.
+func (t *procConnectorTracer) isTracked(pid uint32) bool {
+	t.trackLock.Lock()
+	defer t.trackLock.Unlock()
+	if t.tracked == nil {
+		return true
+	}
+	_, ok := t.tracked[pid]
+	return ok
+}
+
+// buildEvent reads the details of a process that just exec'd from /proc. It
+// returns nil if the event should be skipped because of the PID or PidNS
+// filters.
+func (t *procConnectorTracer) buildEvent(pid uint32) *Event {
+	ev := &Event{
+		Argv:        []string{},
+		PID:         pid,
+		Reliability: ReliabilityBestEffort,
+	}
+
+	comm, ok := t.comms[pid]
+	if !ok {
+		// The process was started before the tracer, so we don't know what
+		// it was called before the exec.
+		ev.Reliability = ReliabilityPartial
+	}
+	ev.Comm = comm
+	newComm, err := readProcComm(pid)
+	if err == nil {
+		t.comms[pid] = newComm
+	}
+
+	if !t.isTracked(pid) {
+		return nil
+	}
+	if opts, _ := t.config(); opts.PidNS != 0 {
+		in, err := PidInNS(pid, opts.PidNS)
+		if err != nil || !in {
+			// If the process is gone we can't tell which namespace it was
+			// in, so it's skipped to avoid leaking events from outside the
+			// namespace.
+			return nil
+		}
+	}
+
+	procDir := "/proc/" + strconv.FormatUint(uint64(pid), 10)
+	ev.Filename, err = os.Readlink(procDir + "/exe")
+	if err != nil {
+		ev.Reliability = ReliabilityPartial
+	}
+
+	cmdline, err := os.ReadFile(procDir + "/cmdline")
+	if err != nil || len(cmdline) == 0 {
+		ev.Reliability = ReliabilityPartial
+	} else {
+		ev.Argv, ev.Truncated = parseCmdline(cmdline)
+	}
+
+	ev.UID, ev.GID, ev.PPID, err = readProcStatus(procDir + "/status")
+	if err != nil {
+		ev.Reliability = ReliabilityPartial
+	}
+
+	ev.PidNS, err = GetPidNSOf(pid)
+	if err != nil {
+		ev.Reliability = ReliabilityPartial
+	}
+
+	return ev
+}
+
+// parseCmdline splits the contents of /proc/<pid>/cmdline into arguments,
+// applying the same limits as the eBPF program so events from both backends
+// look the same.
+func parseCmdline(cmdline []byte) ([]string, bool) {
+	cmdline = bytes.TrimSuffix(cmdline, []byte{0})
+	args := strings.Split(string(cmdline), "\x00")
+
+	truncated := false
+	if len(args) > arglen {
+		args = args[:arglen]
+		truncated = true
+	}
+	argv := make([]string, 0, len(args))
+	for _, arg := range args {
+		if len(arg) >= argsize-1 {
+			truncated = true
+			arg = arg[:argsize-3] + "..."
+		}
+		if strings.TrimSpace(arg) != "" {
+			argv = append(argv, arg)
+		}
+	}
+	return argv, truncated
+}
+
+// readProcComm reads /proc/<pid>/comm.
+func readProcComm(pid uint32) (string, error) {
+	comm, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(comm), "\n"), nil
+}
+
+// readProcStatus reads the real UID and GID and the parent PID of a process
+// from the given /proc/<pid>/status file.
+func readProcStatus(path string) (uid, gid, ppid uint32, err error) {
+	status, err := os.ReadFile(path)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	var foundUID, foundGID, foundPPID bool
+	for _, line := range strings.Split(string(status), "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok || (key != "Uid" && key != "Gid" && key != "PPid") {
+			continue
+		}
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			continue
+		}
+		id, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil {
+			return 0, 0, 0, xerrors.Errorf("parse %s %q: %w", key, fields[0], err)
+		}
+		switch key {
+		case "Uid":
+			uid, foundUID = uint32(id), true
+		case "Gid":
+			gid, foundGID = uint32(id), true
+		default:
+			ppid, foundPPID = uint32(id), true
+		}
+	}
+	if !foundUID || !foundGID || !foundPPID {
+		return 0, 0, 0, xerrors.Errorf("missing Uid, Gid or PPid in %s", path)
+	}
+	return uid, gid, ppid, nil
+}
+
+// Close unsubscribes from proc connector events and closes the socket. Any
+// blocked `Read()` operations will return an error that wraps `io.EOF`.
+func (t *procConnectorTracer) Close() error {
+	err := errTracerClosed
+	t.closeOnce.Do(func() {
+		close(t.closed)
+		// Best effort, the kernel unsubscribes when the socket is closed.
+		_ = t.setListen(false)
+
+		err = t.file.Close()
+		if err != nil {
+			err = xerrors.Errorf("close netlink connector socket: %w", err)
+		}
+	})
+	return err
+}
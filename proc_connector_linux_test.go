@@ -0,0 +1,196 @@
+//go:build linux
+// +build linux
+
+package exectrace_test
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/coder/exectrace"
+)
+
+//nolint:paralleltest
+func TestProcConnector(t *testing.T) {
+	// This test must be run as root so we can open the netlink socket.
+	if os.Geteuid() != 0 {
+		t.Fatal("must be run as root")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	tracer, err := exectrace.New(&exectrace.TracerOpts{
+		Backend: exectrace.BackendProcConnector,
+		LogFn: func(uid, gid, pid uint32, logLine string) {
+			t.Errorf("tracer error log (uid=%v, gid=%v, pid=%v): %s", uid, gid, pid, logLine)
+		},
+	})
+	require.NoError(t, err)
+	defer tracer.Close()
+	require.Positive(t, tracer.FD())
+
+	// Launch processes. Process details are read from /proc after the exec,
+	// so the process needs to live long enough to be read.
+	const (
+		expected = "hello exectrace proc connector test"
+		uid      = 1000
+		gid      = 2000
+	)
+	args := []string{"sh", "-c", "sleep 0.5; true # " + expected}
+	filename, err := exec.LookPath(args[0])
+	require.NoError(t, err)
+	filename, err = filepath.EvalSymlinks(filename)
+	require.NoError(t, err)
+	processDone := spamProcess(ctx, t, args, func(cmd *exec.Cmd) {
+		cmd.SysProcAttr = &syscall.SysProcAttr{
+			Credential: &syscall.Credential{
+				Uid: uid,
+				Gid: gid,
+			},
+		}
+	})
+
+	pidNS, err := exectrace.GetPidNS()
+	require.NoError(t, err)
+	event := getLogEntry(ctx, t, tracer, expected)
+	require.Equal(t, filename, event.Filename, "event.Filename")
+	require.Equal(t, args, event.Argv, "event.Argv")
+	require.False(t, event.Truncated, "event.Truncated is true")
+	require.Equal(t, exectrace.ReliabilityBestEffort, event.Reliability, "event.Reliability")
+	require.NotEqual(t, event.PID, os.Getpid(), "event.PID should not be the parent PID")
+	require.EqualValues(t, os.Getpid(), event.PPID, "event.PPID should be the parent PID")
+	require.Equal(t, pidNS, event.PidNS, "event.PidNS should be our PidNS")
+	require.EqualValues(t, event.UID, uid, "event.UID should match custom UID")
+	require.EqualValues(t, event.GID, gid, "event.GID should match custom GID")
+
+	// Comm is the name of the process that forked, i.e. us.
+	comm, err := os.ReadFile("/proc/self/comm")
+	require.NoError(t, err)
+	require.Equal(t, strings.TrimSpace(string(comm)), event.Comm, "event.Comm")
+
+	cancel()
+	<-processDone
+}
+
+//nolint:paralleltest
+func TestProcConnectorPIDNS(t *testing.T) {
+	// This test must be run as root so we can open the netlink socket.
+	if os.Geteuid() != 0 {
+		t.Fatal("must be run as root")
+	}
+
+	//nolint:paralleltest
+	t.Run("Child", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+		pidNS, err := exectrace.GetPidNS()
+		require.NoError(t, err)
+		tracer, err := exectrace.New(&exectrace.TracerOpts{
+			Backend: exectrace.BackendProcConnector,
+			PidNS:   pidNS,
+		})
+		require.NoError(t, err)
+		defer tracer.Close()
+
+		const expected = "hello exectrace proc connector pidns test child"
+		args := []string{"sh", "-c", "sleep 0.5; true # " + expected}
+		processDone := spamProcess(ctx, t, args, func(cmd *exec.Cmd) {
+			cmd.SysProcAttr = &syscall.SysProcAttr{
+				// Subprocess will be in a child PID namespace.
+				Cloneflags: syscall.CLONE_NEWPID,
+			}
+		})
+
+		_ = getLogEntry(ctx, t, tracer, expected)
+
+		cancel()
+		<-processDone
+	})
+
+	//nolint:paralleltest
+	t.Run("Different", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+		pidNS, err := exectrace.GetPidNS()
+		require.NoError(t, err)
+		tracer, err := exectrace.New(&exectrace.TracerOpts{
+			Backend: exectrace.BackendProcConnector,
+			PidNS:   pidNS + 1,
+		})
+		require.NoError(t, err)
+		defer tracer.Close()
+
+		const expected = "hello exectrace proc connector pidns test different"
+		args := []string{"sh", "-c", "sleep 0.5; true # " + expected}
+		processDone := spamProcess(ctx, t, args, nil)
+
+		// We should not see any events. Read events for up to 3 seconds.
+		go func() {
+			ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+			defer cancel()
+			<-ctx.Done()
+			_ = tracer.Close()
+		}()
+		event, err := tracer.Read()
+		if err == nil {
+			t.Fatalf("unexpected event: %+v", event)
+		}
+		if !xerrors.Is(err, io.EOF) {
+			t.Fatalf("tracer.Read: %v", err)
+		}
+
+		cancel()
+		<-processDone
+	})
+}
+
+//nolint:paralleltest
+func TestBackendUnknown(t *testing.T) {
+	_, err := exectrace.New(&exectrace.TracerOpts{Backend: "dtrace"})
+	require.Error(t, err)
+}
+
+//nolint:paralleltest
+func TestBackendFallback(t *testing.T) {
+	// This test must be run as root so we can open the netlink socket.
+	if os.Geteuid() != 0 {
+		t.Fatal("must be run as root")
+	}
+
+	// An invalid external BTF file makes the eBPF backend fail to load.
+	path := filepath.Join(t.TempDir(), "invalid.btf")
+	err := os.WriteFile(path, []byte("not btf"), 0o600)
+	require.NoError(t, err)
+
+	// The default backend doesn't fall back.
+	_, err = exectrace.New(&exectrace.TracerOpts{KernelBTFPath: path})
+	require.ErrorIs(t, err, exectrace.ErrBTFUnavailable)
+
+	var entries []exectrace.LogEntry
+	tracer, err := exectrace.New(&exectrace.TracerOpts{
+		Backend:       exectrace.BackendAuto,
+		KernelBTFPath: path,
+		LogHandler: func(entry exectrace.LogEntry) {
+			entries = append(entries, entry)
+		},
+	})
+	require.NoError(t, err)
+	defer tracer.Close()
+
+	require.Len(t, entries, 1)
+	require.Equal(t, exectrace.LogLevelWarn, entries[0].Level)
+	require.Equal(t, exectrace.LogCodeBackendFallback, entries[0].Code)
+	require.Contains(t, entries[0].Message(), "falling back to the proc connector backend")
+	require.Contains(t, entries[0].Message(), path)
+}
@@ -0,0 +1,359 @@
+package exectrace
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// TraceVersion is the current version of the trace container format written
+// by TraceWriter. TraceReader can read all versions up to and including this
+// one.
+//
+// Version 2 added Event.PPID and Event.PidNS to binary records. Version 1
+// binary records don't have them, so they're 0 when they're read.
+const TraceVersion = 2
+
+// TraceFormat is the encoding of a trace container.
+type TraceFormat string
+
+const (
+	// TraceFormatBinary is a compact binary encoding. The file starts with
+	// the magic bytes "EXECTRACE\x00" and a little endian uint16 version,
+	// followed by records. Each record is a uvarint length followed by the
+	// encoded record, so readers can skip fields added by newer versions.
+	TraceFormatBinary TraceFormat = "binary"
+	// TraceFormatNDJSON is newline delimited JSON. The first line is a header
+	// object containing the format name and version, and each following line
+	// is a record object containing the time and event.
+	TraceFormatNDJSON TraceFormat = "ndjson"
+)
+
+const (
+	traceMagic      = "EXECTRACE\x00"
+	traceNDJSONName = "exectrace-trace"
+	// maxTraceRecordSize is the maximum size of a binary record. It is much
+	// larger than the largest possible event to catch corrupt files before
+	// allocating huge buffers.
+	maxTraceRecordSize = 1 << 20
+
+	traceFlagTruncated = 1 << 0
+	traceFlagRedacted  = 1 << 1
+)
+
+// TraceRecord is a single event in a trace with the time it was read.
+type TraceRecord struct {
+	Time  time.Time `json:"time"`
+	Event *Event    `json:"event"`
+}
+
+type traceNDJSONHeader struct {
+	Format  string `json:"format"`
+	Version int    `json:"version"`
+}
+
+// TraceWriter writes events to a trace container that can be read by
+// TraceReader and replayed with ReplayTracer.
+type TraceWriter struct {
+	w      *bufio.Writer
+	format TraceFormat
+	buf    []byte
+}
+
+// NewTraceWriter writes the container header to w and returns a writer for
+// records. Records are buffered, so Flush must be called when finished.
+func NewTraceWriter(w io.Writer, format TraceFormat) (*TraceWriter, error) {
+	tw := &TraceWriter{
+		w:      bufio.NewWriter(w),
+		format: format,
+	}
+
+	switch format {
+	case TraceFormatBinary:
+		var header [len(traceMagic) + 2]byte
+		copy(header[:], traceMagic)
+		binary.LittleEndian.PutUint16(header[len(traceMagic):], TraceVersion)
+		_, err := tw.w.Write(header[:])
+		if err != nil {
+			return nil, xerrors.Errorf("write header: %w", err)
+		}
+	case TraceFormatNDJSON:
+		err := tw.writeJSONLine(traceNDJSONHeader{
+			Format:  traceNDJSONName,
+			Version: TraceVersion,
+		})
+		if err != nil {
+			return nil, xerrors.Errorf("write header: %w", err)
+		}
+	default:
+		return nil, xerrors.Errorf("unknown trace format %q", format)
+	}
+
+	return tw, nil
+}
+
+// WriteRecord writes a single record.
+func (tw *TraceWriter) WriteRecord(rec TraceRecord) error {
+	if rec.Event == nil {
+		return xerrors.New("record has no event")
+	}
+
+	if tw.format == TraceFormatNDJSON {
+		return tw.writeJSONLine(rec)
+	}
+
+	b := tw.buf[:0]
+	b = binary.AppendVarint(b, rec.Time.UnixNano())
+	b = binary.AppendUvarint(b, uint64(rec.Event.PID))
+	b = binary.AppendUvarint(b, uint64(rec.Event.UID))
+	b = binary.AppendUvarint(b, uint64(rec.Event.GID))
+	var flags uint64
+	if rec.Event.Truncated {
+		flags |= traceFlagTruncated
+	}
+	if rec.Event.Redacted {
+		flags |= traceFlagRedacted
+	}
+	b = binary.AppendUvarint(b, flags)
+	b = appendTraceString(b, rec.Event.Filename)
+	b = appendTraceString(b, rec.Event.Comm)
+	b = binary.AppendUvarint(b, uint64(len(rec.Event.Argv)))
+	for _, arg := range rec.Event.Argv {
+		b = appendTraceString(b, arg)
+	}
+	b = appendTraceString(b, string(rec.Event.Reliability))
+	// Added in version 2.
+	b = binary.AppendUvarint(b, uint64(rec.Event.PPID))
+	b = binary.AppendUvarint(b, uint64(rec.Event.PidNS))
+	tw.buf = b
+
+	var length [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(length[:], uint64(len(b)))
+	_, err := tw.w.Write(length[:n])
+	if err != nil {
+		return xerrors.Errorf("write record: %w", err)
+	}
+	_, err = tw.w.Write(b)
+	if err != nil {
+		return xerrors.Errorf("write record: %w", err)
+	}
+	return nil
+}
+
+// Flush writes any buffered records to the underlying writer.
+func (tw *TraceWriter) Flush() error {
+	return tw.w.Flush()
+}
+
+func (tw *TraceWriter) writeJSONLine(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return xerrors.Errorf("marshal: %w", err)
+	}
+	_, err = tw.w.Write(append(b, '\n'))
+	return err
+}
+
+func appendTraceString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// TraceReader reads records from a trace container written by TraceWriter.
+// The format is detected automatically.
+type TraceReader struct {
+	r       *bufio.Reader
+	format  TraceFormat
+	version int
+	buf     []byte
+}
+
+// NewTraceReader reads and validates the container header from r.
+func NewTraceReader(r io.Reader) (*TraceReader, error) {
+	tr := &TraceReader{
+		r: bufio.NewReader(r),
+	}
+
+	magic, err := tr.r.Peek(len(traceMagic))
+	if err == nil && string(magic) == traceMagic {
+		var header [len(traceMagic) + 2]byte
+		_, err = io.ReadFull(tr.r, header[:])
+		if err != nil {
+			return nil, xerrors.Errorf("read header: %w", err)
+		}
+		tr.format = TraceFormatBinary
+		tr.version = int(binary.LittleEndian.Uint16(header[len(traceMagic):]))
+	} else {
+		line, err := tr.r.ReadBytes('\n')
+		if err != nil && !xerrors.Is(err, io.EOF) {
+			return nil, xerrors.Errorf("read header: %w", err)
+		}
+		var header traceNDJSONHeader
+		err = json.Unmarshal(line, &header)
+		if err != nil || header.Format != traceNDJSONName {
+			return nil, xerrors.New("not an exectrace trace file")
+		}
+		tr.format = TraceFormatNDJSON
+		tr.version = header.Version
+	}
+
+	if tr.version < 1 || tr.version > TraceVersion {
+		return nil, xerrors.Errorf("unsupported trace version %d, this version of exectrace supports up to version %d", tr.version, TraceVersion)
+	}
+	return tr, nil
+}
+
+// Format returns the detected format of the container.
+func (tr *TraceReader) Format() TraceFormat {
+	return tr.format
+}
+
+// Version returns the version of the container.
+func (tr *TraceReader) Version() int {
+	return tr.version
+}
+
+// Next reads the next record. It returns io.EOF when there are no more
+// records.
+func (tr *TraceReader) Next() (*TraceRecord, error) {
+	if tr.format == TraceFormatNDJSON {
+		return tr.nextJSON()
+	}
+	return tr.nextBinary()
+}
+
+func (tr *TraceReader) nextJSON() (*TraceRecord, error) {
+	for {
+		line, err := tr.r.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) == 0 {
+			if err != nil {
+				if xerrors.Is(err, io.EOF) {
+					return nil, io.EOF
+				}
+				return nil, xerrors.Errorf("read record: %w", err)
+			}
+			continue
+		}
+
+		var rec TraceRecord
+		jsonErr := json.Unmarshal(line, &rec)
+		if jsonErr != nil {
+			return nil, xerrors.Errorf("parse record: %w", jsonErr)
+		}
+		if rec.Event == nil {
+			return nil, xerrors.New("parse record: record has no event")
+		}
+		if rec.Event.Argv == nil {
+			rec.Event.Argv = []string{}
+		}
+		return &rec, nil
+	}
+}
+
+func (tr *TraceReader) nextBinary() (*TraceRecord, error) {
+	length, err := binary.ReadUvarint(tr.r)
+	if err != nil {
+		if xerrors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+		return nil, xerrors.Errorf("read record length: %w", err)
+	}
+	if length > maxTraceRecordSize {
+		return nil, xerrors.Errorf("record length %d exceeds maximum of %d", length, maxTraceRecordSize)
+	}
+	if uint64(cap(tr.buf)) < length {
+		tr.buf = make([]byte, length)
+	}
+	b := tr.buf[:length]
+	_, err = io.ReadFull(tr.r, b)
+	if err != nil {
+		return nil, xerrors.Errorf("read record: %w", io.ErrUnexpectedEOF)
+	}
+
+	d := traceDecoder{b: b}
+	ts := d.varint()
+	ev := &Event{
+		PID: uint32(d.uvarint()),
+		UID: uint32(d.uvarint()),
+		GID: uint32(d.uvarint()),
+	}
+	flags := d.uvarint()
+	ev.Truncated = flags&traceFlagTruncated != 0
+	ev.Redacted = flags&traceFlagRedacted != 0
+	ev.Filename = d.string()
+	ev.Comm = d.string()
+	argc := d.uvarint()
+	if argc > uint64(len(b)) {
+		return nil, xerrors.New("parse record: invalid argc")
+	}
+	ev.Argv = make([]string, 0, argc)
+	for i := uint64(0); i < argc; i++ {
+		ev.Argv = append(ev.Argv, d.string())
+	}
+	ev.Reliability = Reliability(d.string())
+	if tr.version >= 2 {
+		ev.PPID = uint32(d.uvarint())
+		ev.PidNS = uint32(d.uvarint())
+	}
+	// Any remaining bytes are fields from a newer version and are ignored.
+	if d.err != nil {
+		return nil, xerrors.Errorf("parse record: %w", d.err)
+	}
+
+	return &TraceRecord{
+		Time:  time.Unix(0, ts),
+		Event: ev,
+	}, nil
+}
+
+// traceDecoder decodes fields from a binary record. The first error is kept
+// and all following reads return zero values.
+type traceDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *traceDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		d.err = xerrors.New("invalid uvarint")
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *traceDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.b)
+	if n <= 0 {
+		d.err = xerrors.New("invalid varint")
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *traceDecoder) string() string {
+	length := d.uvarint()
+	if d.err != nil {
+		return ""
+	}
+	if length > uint64(len(d.b)) {
+		d.err = xerrors.New("string length exceeds record length")
+		return ""
+	}
+	s := string(d.b[:length])
+	d.b = d.b[length:]
+	return s
+}
@@ -0,0 +1,262 @@
+package exectrace_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/coder/exectrace"
+)
+
+var traceRecords = []exectrace.TraceRecord{
+	{
+		Time: time.Unix(1700000000, 1),
+		Event: &exectrace.Event{
+			Filename: "/usr/bin/echo",
+			Argv:     []string{"echo", "hello", "wörld\n"},
+			PID:      1,
+			PPID:     1,
+			PidNS:    4026531836,
+			UID:      1000,
+			GID:      1001,
+			Comm:     "bash",
+
+			Reliability: exectrace.ReliabilityBestEffort,
+		},
+	},
+	{
+		Time: time.Unix(1700000000, 50*int64(time.Millisecond)),
+		Event: &exectrace.Event{
+			Filename:  "/usr/bin/curl",
+			Argv:      []string{"curl", "--password=hunter2", "example.com"},
+			Truncated: true,
+			PID:       2,
+			PPID:      1,
+			PidNS:     4026531836,
+			UID:       0,
+			GID:       0,
+			Comm:      "sh",
+		},
+	},
+	{
+		Time: time.Unix(1700000000, 100*int64(time.Millisecond)),
+		Event: &exectrace.Event{
+			Filename: "/usr/bin/true",
+			Argv:     []string{},
+			Redacted: true,
+			PID:      4294967295,
+			UID:      65534,
+			GID:      65534,
+			Comm:     "",
+		},
+	},
+}
+
+func writeTrace(t *testing.T, format exectrace.TraceFormat) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w, err := exectrace.NewTraceWriter(&buf, format)
+	require.NoError(t, err)
+	for _, rec := range traceRecords {
+		err = w.WriteRecord(rec)
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Flush())
+	return buf.Bytes()
+}
+
+func TestTraceRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	for _, format := range []exectrace.TraceFormat{exectrace.TraceFormatBinary, exectrace.TraceFormatNDJSON} {
+		format := format
+		t.Run(string(format), func(t *testing.T) {
+			t.Parallel()
+
+			r, err := exectrace.NewTraceReader(bytes.NewReader(writeTrace(t, format)))
+			require.NoError(t, err)
+			require.Equal(t, format, r.Format())
+			require.Equal(t, exectrace.TraceVersion, r.Version())
+
+			for _, expected := range traceRecords {
+				rec, err := r.Next()
+				require.NoError(t, err)
+				require.True(t, expected.Time.Equal(rec.Time), "%s != %s", expected.Time, rec.Time)
+				require.Equal(t, expected.Event, rec.Event)
+			}
+			_, err = r.Next()
+			require.ErrorIs(t, err, io.EOF)
+		})
+	}
+}
+
+func TestTraceReaderVersion1(t *testing.T) {
+	t.Parallel()
+
+	// Version 1 binary records don't have the PPID or PidNS.
+	data := append([]byte("EXECTRACE\x00"), 1, 0)
+	var rec []byte
+	rec = binary.AppendVarint(rec, 1700000000000000001)
+	for _, v := range []uint64{123, 1000, 1001, 0} {
+		rec = binary.AppendUvarint(rec, v)
+	}
+	for _, s := range []string{"/usr/bin/echo", "bash"} {
+		rec = binary.AppendUvarint(rec, uint64(len(s)))
+		rec = append(rec, s...)
+	}
+	rec = binary.AppendUvarint(rec, 1)
+	for _, s := range []string{"echo", string(exectrace.ReliabilityExact)} {
+		rec = binary.AppendUvarint(rec, uint64(len(s)))
+		rec = append(rec, s...)
+	}
+	data = binary.AppendUvarint(data, uint64(len(rec)))
+	data = append(data, rec...)
+
+	r, err := exectrace.NewTraceReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, 1, r.Version())
+	got, err := r.Next()
+	require.NoError(t, err)
+	require.True(t, time.Unix(1700000000, 1).Equal(got.Time))
+	require.Equal(t, &exectrace.Event{
+		Filename:    "/usr/bin/echo",
+		Argv:        []string{"echo"},
+		PID:         123,
+		UID:         1000,
+		GID:         1001,
+		Comm:        "bash",
+		Reliability: exectrace.ReliabilityExact,
+	}, got.Event)
+	_, err = r.Next()
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestTraceReaderErrors(t *testing.T) {
+	t.Parallel()
+
+	_, err := exectrace.NewTraceWriter(io.Discard, "xml")
+	require.Error(t, err)
+
+	for name, data := range map[string][]byte{
+		"Empty":         nil,
+		"NotATrace":     []byte("hello world\n"),
+		"OtherJSON":     []byte(`{"format":"something-else","version":1}` + "\n"),
+		"FutureNDJSON":  []byte(`{"format":"exectrace-trace","version":999}` + "\n"),
+		"FutureBinary":  append([]byte("EXECTRACE\x00"), 0xe7, 0x03),
+		"InvalidBinary": append([]byte("EXECTRACE\x00"), 0, 0),
+	} {
+		_, err := exectrace.NewTraceReader(bytes.NewReader(data))
+		require.Error(t, err, name)
+	}
+
+	// A truncated record is an error rather than a clean EOF.
+	data := writeTrace(t, exectrace.TraceFormatBinary)
+	r, err := exectrace.NewTraceReader(bytes.NewReader(data[:len(data)-5]))
+	require.NoError(t, err)
+	for i := 0; i < len(traceRecords)-1; i++ {
+		_, err = r.Next()
+		require.NoError(t, err)
+	}
+	_, err = r.Next()
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestReplayTracer(t *testing.T) {
+	t.Parallel()
+
+	t.Run("File", func(t *testing.T) {
+		t.Parallel()
+
+		path := filepath.Join(t.TempDir(), "trace.bin")
+		err := os.WriteFile(path, writeTrace(t, exectrace.TraceFormatBinary), 0o600)
+		require.NoError(t, err)
+
+		tracer, err := exectrace.OpenReplayTracer(path, nil)
+		require.NoError(t, err)
+		require.Equal(t, -1, tracer.FD())
+
+		var tr exectrace.Tracer = tracer
+		for _, expected := range traceRecords {
+			ev, err := tr.Read()
+			require.NoError(t, err)
+			require.Equal(t, expected.Event, ev)
+		}
+		_, err = tr.Read()
+		require.ErrorIs(t, err, io.EOF)
+
+		require.NoError(t, tr.Close())
+		require.Error(t, tr.Close())
+		_, err = tr.Read()
+		require.ErrorIs(t, err, io.EOF)
+	})
+
+	t.Run("FilterAndRedact", func(t *testing.T) {
+		t.Parallel()
+
+		tracer, err := exectrace.NewReplayTracer(bytes.NewReader(writeTrace(t, exectrace.TraceFormatNDJSON)), &exectrace.ReplayOpts{
+			Filter: exectrace.MustParseFilter(`comm == "sh"`),
+			Redact: &exectrace.RedactOpts{},
+		})
+		require.NoError(t, err)
+		defer tracer.Close()
+
+		rec, err := tracer.ReadRecord()
+		require.NoError(t, err)
+		require.Equal(t, "/usr/bin/curl", rec.Event.Filename)
+		require.True(t, rec.Event.Redacted)
+		require.NotContains(t, rec.Event.Argv, "--password=hunter2")
+		_, err = tracer.Read()
+		require.ErrorIs(t, err, io.EOF)
+	})
+
+	t.Run("Speed", func(t *testing.T) {
+		t.Parallel()
+
+		tracer, err := exectrace.NewReplayTracer(bytes.NewReader(writeTrace(t, exectrace.TraceFormatBinary)), &exectrace.ReplayOpts{
+			Speed: 2,
+		})
+		require.NoError(t, err)
+		defer tracer.Close()
+
+		// The records span 100ms, so at 2x speed replay takes at least 50ms.
+		start := time.Now()
+		for range traceRecords {
+			_, err = tracer.Read()
+			require.NoError(t, err)
+		}
+		require.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	})
+
+	t.Run("CloseWhileWaiting", func(t *testing.T) {
+		t.Parallel()
+
+		var buf bytes.Buffer
+		w, err := exectrace.NewTraceWriter(&buf, exectrace.TraceFormatBinary)
+		require.NoError(t, err)
+		for _, ts := range []time.Time{time.Unix(0, 0), time.Unix(3600, 0)} {
+			err = w.WriteRecord(exectrace.TraceRecord{Time: ts, Event: traceRecords[0].Event})
+			require.NoError(t, err)
+		}
+		require.NoError(t, w.Flush())
+
+		tracer, err := exectrace.NewReplayTracer(&buf, &exectrace.ReplayOpts{Speed: 1})
+		require.NoError(t, err)
+		_, err = tracer.Read()
+		require.NoError(t, err)
+
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			_ = tracer.Close()
+		}()
+		// The second record is an hour later, Close must unblock the read.
+		_, err = tracer.Read()
+		require.ErrorIs(t, err, io.EOF)
+	})
+}
@@ -0,0 +1,204 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"strconv"
+	"sync"
+
+	"golang.org/x/xerrors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/coder/exectrace"
+	"github.com/coder/exectrace/remote/remotepb"
+)
+
+var errTracerClosed = xerrors.New("tracer is closed")
+
+// ClientOpts contains the configuration options for the client. All are
+// optional.
+type ClientOpts struct {
+	// Filter is a filter expression evaluated by the server, only matching
+	// events are sent to the client. See exectrace.ParseFilter for the
+	// expression syntax.
+	Filter string
+
+	// Token is sent to the server as a bearer token. See ServerOpts.Token.
+	Token string
+
+	// DialOptions are passed to grpc.NewClient. Insecure transport
+	// credentials are used unless overridden by these options.
+	DialOptions []grpc.DialOption
+
+	// DroppedFn is called when the server reports that events were dropped
+	// because the client wasn't reading fast enough. If unspecified, a
+	// warning is logged to stderr.
+	DroppedFn func(count uint64)
+}
+
+type client struct {
+	opts   *ClientOpts
+	conn   *grpc.ClientConn
+	stream remotepb.Exectrace_StreamEventsClient
+	cancel context.CancelFunc
+
+	closeLock sync.Mutex
+	closed    bool
+}
+
+var _ exectrace.Tracer = &client{}
+
+// Dial connects to an exectrace server at target and starts streaming events.
+// The returned Tracer reads events from the server instead of the kernel.
+// Use a "unix://" target such as "unix://"+DefaultSocketPath to connect to
+// the exectraced daemon, which only sends clients that aren't root the events
+// of their own processes.
+//
+// The context is only used while connecting. The returned Tracer MUST be
+// closed to avoid leaking the connection.
+func Dial(ctx context.Context, target string, opts *ClientOpts) (exectrace.Tracer, error) {
+	if opts == nil {
+		opts = &ClientOpts{}
+	}
+	if opts.DroppedFn == nil {
+		opts.DroppedFn = func(count uint64) {
+			log.Printf("exectrace server dropped %d events because the client was too slow", count)
+		}
+	}
+
+	dialOpts := append([]grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	}, opts.DialOptions...)
+	conn, err := grpc.NewClient(target, dialOpts...)
+	if err != nil {
+		return nil, xerrors.Errorf("create gRPC client: %w", err)
+	}
+
+	// The stream outlives ctx, so it gets its own context.
+	streamCtx, cancel := context.WithCancel(context.Background())
+	if opts.Token != "" {
+		streamCtx = metadata.AppendToOutgoingContext(streamCtx, "authorization", "Bearer "+opts.Token)
+	}
+	c := &client{
+		opts:   opts,
+		conn:   conn,
+		cancel: cancel,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		stream, err := remotepb.NewExectraceClient(conn).StreamEvents(streamCtx, &remotepb.StreamEventsRequest{
+			Filter: opts.Filter,
+		}, grpc.WaitForReady(true))
+		if err == nil {
+			c.stream = stream
+			// Wait for the server to accept the stream so errors such as an
+			// invalid filter are returned from Dial.
+			var md metadata.MD
+			md, err = stream.Header()
+			if err == nil && len(md.Get(acceptedHeader)) == 0 {
+				// The server responded with an error (or closed the stream)
+				// before accepting it, which Recv returns.
+				_, err = stream.Recv()
+				if err == nil || errors.Is(err, io.EOF) {
+					err = xerrors.New("server did not accept the stream")
+				}
+			}
+			if err == nil {
+				err = checkVersion(md.Get(versionHeader))
+			}
+		}
+		errCh <- err
+	}()
+
+	select {
+	case <-ctx.Done():
+		_ = c.Close()
+		<-errCh
+		return nil, xerrors.Errorf("connect to %q: %w", target, ctx.Err())
+	case err := <-errCh:
+		if err != nil {
+			_ = c.Close()
+			return nil, xerrors.Errorf("stream events from %q: %w", target, err)
+		}
+	}
+
+	return c, nil
+}
+
+// checkVersion returns an error if the protocol version sent by the server
+// isn't supported. Servers that don't send a version speak version 1.
+func checkVersion(values []string) error {
+	if len(values) == 0 {
+		return nil
+	}
+	version, err := strconv.Atoi(values[0])
+	if err != nil {
+		return xerrors.Errorf("invalid protocol version %q from server: %w", values[0], err)
+	}
+	if version != ProtocolVersion {
+		return xerrors.Errorf("server uses protocol version %d, but this client only supports version %d", version, ProtocolVersion)
+	}
+	return nil
+}
+
+// Read blocks until an event is received from the server. If the client is
+// closed or the server ends the stream, an error that wraps io.EOF is
+// returned.
+func (c *client) Read() (*exectrace.Event, error) {
+	ev, err := c.stream.Recv()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, xerrors.Errorf("server closed stream: %w", io.EOF)
+		}
+		if status.Code(err) == codes.Canceled && c.isClosed() {
+			return nil, xerrors.Errorf("tracer closed: %w", io.EOF)
+		}
+		return nil, xerrors.Errorf("receive event: %w", err)
+	}
+
+	if dropped := ev.GetDropped(); dropped > 0 {
+		c.opts.DroppedFn(dropped)
+	}
+	return fromProto(ev), nil
+}
+
+// FD returns -1 as there is no local eBPF program.
+func (*client) FD() int {
+	return -1
+}
+
+// Reload always returns an error, as the tracer is configured by the server.
+func (*client) Reload(_ *exectrace.TracerOpts) error {
+	return xerrors.New("remote tracers can't be reloaded, the tracer is configured by the server")
+}
+
+func (c *client) isClosed() bool {
+	c.closeLock.Lock()
+	defer c.closeLock.Unlock()
+	return c.closed
+}
+
+// Close ends the stream and closes the connection. Any blocked Read calls
+// will return an error that wraps io.EOF.
+func (c *client) Close() error {
+	c.closeLock.Lock()
+	defer c.closeLock.Unlock()
+	if c.closed {
+		return errTracerClosed
+	}
+	c.closed = true
+
+	c.cancel()
+	err := c.conn.Close()
+	if err != nil {
+		return xerrors.Errorf("close gRPC connection: %w", err)
+	}
+	return nil
+}
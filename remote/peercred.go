@@ -0,0 +1,92 @@
+package remote
+
+import (
+	"context"
+	"net"
+
+	"google.golang.org/grpc/credentials"
+
+	"github.com/coder/exectrace"
+)
+
+// peerCredAuthType is the AuthType of PeerCredAuthInfo.
+const peerCredAuthType = "peercred"
+
+// PeerCredAuthInfo identifies the process on the other end of a unix socket
+// connection accepted with PeerCredentials. The IDs are the ones the process
+// had when it connected, as reported by SO_PEERCRED.
+type PeerCredAuthInfo struct {
+	credentials.CommonAuthInfo
+
+	PID uint32
+	UID uint32
+	GID uint32
+}
+
+var _ credentials.AuthInfo = PeerCredAuthInfo{}
+
+// AuthType implements credentials.AuthInfo.
+func (PeerCredAuthInfo) AuthType() string {
+	return peerCredAuthType
+}
+
+// PeerCredentials returns gRPC transport credentials for unix sockets that
+// identify the process on the other end of each connection with SO_PEERCRED.
+// On the server, the credentials are available to handlers as a
+// PeerCredAuthInfo and are used by ServerOpts.RestrictPeers. Connections that
+// aren't over a unix socket are rejected.
+//
+// The connection itself is not encrypted, as unix sockets never leave the
+// host.
+func PeerCredentials() credentials.TransportCredentials {
+	return peerCredentials{}
+}
+
+type peerCredentials struct{}
+
+func (peerCredentials) handshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	info, err := peerCred(conn)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Like the gRPC local credentials, unix sockets are considered private.
+	info.CommonAuthInfo = credentials.CommonAuthInfo{SecurityLevel: credentials.PrivacyAndIntegrity}
+	return conn, info, nil
+}
+
+func (c peerCredentials) ClientHandshake(_ context.Context, _ string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return c.handshake(conn)
+}
+
+func (c peerCredentials) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return c.handshake(conn)
+}
+
+func (peerCredentials) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{SecurityProtocol: peerCredAuthType}
+}
+
+func (peerCredentials) Clone() credentials.TransportCredentials {
+	return peerCredentials{}
+}
+
+func (peerCredentials) OverrideServerName(string) error {
+	return nil
+}
+
+// peerScope restricts the events sent to an unprivileged client to processes
+// running as its UID in its PID namespace or one of its descendants.
+type peerScope struct {
+	uid   uint32
+	pidNS uint32
+}
+
+func (p *peerScope) allows(ev *exectrace.Event) bool {
+	if ev.UID != p.uid {
+		return false
+	}
+	// Processes that already exited can't be checked, so they're skipped to
+	// avoid leaking events from other namespaces.
+	in, err := exectrace.PidInNS(ev.PID, p.pidNS)
+	return err == nil && in
+}
@@ -0,0 +1,42 @@
+//go:build linux
+// +build linux
+
+package remote
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+	"golang.org/x/xerrors"
+)
+
+func peerCred(conn net.Conn) (PeerCredAuthInfo, error) {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return PeerCredAuthInfo{}, xerrors.Errorf("peer credentials are only available for unix sockets, got %T", conn)
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return PeerCredAuthInfo{}, xerrors.Errorf("get raw unix socket: %w", err)
+	}
+
+	var (
+		cred    *unix.Ucred
+		credErr error
+	)
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	})
+	if err != nil {
+		return PeerCredAuthInfo{}, xerrors.Errorf("control unix socket: %w", err)
+	}
+	if credErr != nil {
+		return PeerCredAuthInfo{}, xerrors.Errorf("get SO_PEERCRED: %w", credErr)
+	}
+
+	return PeerCredAuthInfo{
+		PID: uint32(cred.Pid),
+		UID: cred.Uid,
+		GID: cred.Gid,
+	}, nil
+}
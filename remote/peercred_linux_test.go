@@ -0,0 +1,155 @@
+package remote_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	"github.com/coder/exectrace"
+	"github.com/coder/exectrace/exectracetest"
+	"github.com/coder/exectrace/remote"
+)
+
+const peerCredHelperEnv = "EXECTRACE_PEERCRED_HELPER"
+
+//nolint:paralleltest
+func TestPeerCredentials(t *testing.T) {
+	if socketPath := os.Getenv(peerCredHelperEnv); socketPath != "" {
+		peerCredHelper(t, socketPath)
+		return
+	}
+
+	// This test must be run as root so it can start a client as another user.
+	if os.Geteuid() != 0 {
+		t.Fatal("must be run as root")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	// The test binary and socket are put somewhere nobody can reach them.
+	dir, err := os.MkdirTemp("", "exectrace-peercred")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	err = os.Chmod(dir, 0o755)
+	require.NoError(t, err)
+	bin, err := os.ReadFile(os.Args[0])
+	require.NoError(t, err)
+	binPath := filepath.Join(dir, "remote.test")
+	//nolint:gosec
+	err = os.WriteFile(binPath, bin, 0o755)
+	require.NoError(t, err)
+
+	socketPath := filepath.Join(dir, "exectraced.sock")
+	l, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+	defer l.Close()
+	err = os.Chmod(socketPath, 0o666)
+	require.NoError(t, err)
+
+	tracer := exectracetest.New()
+	defer tracer.Close()
+	srv := remote.NewServer(tracer, &remote.ServerOpts{RestrictPeers: true})
+	defer srv.Close()
+	gs := grpc.NewServer(grpc.Creds(remote.PeerCredentials()))
+	srv.RegisterGRPC(gs)
+	go func() {
+		_ = gs.Serve(l)
+	}()
+	defer gs.Stop()
+
+	root, err := remote.Dial(ctx, "unix://"+socketPath, nil)
+	require.NoError(t, err)
+	defer root.Close()
+
+	// Run the unprivileged client in its own PID namespace so events from
+	// processes outside of it can be told apart.
+	//nolint:gosec
+	cmd := exec.Command(binPath, "-test.run=^TestPeerCredentials$", "-test.count=1")
+	cmd.Env = append(os.Environ(), peerCredHelperEnv+"="+socketPath)
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWPID,
+		Credential: &syscall.Credential{Uid: 65534, Gid: 65534},
+	}
+	stdout, err := cmd.StdoutPipe()
+	require.NoError(t, err)
+	require.NoError(t, cmd.Start())
+	defer func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}()
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	readLine := func() string {
+		select {
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for helper output")
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatal("helper exited early")
+			}
+			return line
+		}
+		return ""
+	}
+	require.Equal(t, "connected", readLine())
+
+	helperPID := uint32(cmd.Process.Pid)
+	events := []*exectrace.Event{
+		{Filename: "/allowed", PID: helperPID, UID: 65534},
+		{Filename: "/other-uid", PID: helperPID, UID: 1000},
+		{Filename: "/other-pidns", PID: 1, UID: 65534},
+		{Filename: "/allowed-again", PID: helperPID, UID: 65534},
+	}
+	tracer.Push(events...)
+
+	// Root clients receive all events.
+	for _, expected := range events {
+		ev, err := root.Read()
+		require.NoError(t, err)
+		require.Equal(t, expected.Filename, ev.Filename)
+	}
+
+	// Other clients only receive their own events.
+	require.Equal(t, "event: /allowed", readLine())
+	require.Equal(t, "event: /allowed-again", readLine())
+	require.NoError(t, cmd.Wait())
+}
+
+func peerCredHelper(t *testing.T, socketPath string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tracer, err := remote.Dial(ctx, "unix://"+socketPath, nil)
+	if err != nil {
+		t.Fatalf("dial: %+v", err)
+	}
+	defer tracer.Close()
+	fmt.Println("connected")
+
+	for i := 0; i < 2; i++ {
+		ev, err := tracer.Read()
+		if err != nil {
+			t.Fatalf("read: %+v", err)
+		}
+		fmt.Println("event: " + ev.Filename)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package remote
+
+import (
+	"net"
+	"runtime"
+
+	"golang.org/x/xerrors"
+)
+
+func peerCred(_ net.Conn) (PeerCredAuthInfo, error) {
+	return PeerCredAuthInfo{}, xerrors.Errorf(`peer credentials are not supported on %q, only "linux" is supported`, runtime.GOOS)
+}
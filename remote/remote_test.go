@@ -0,0 +1,244 @@
+package remote_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/coder/exectrace"
+	"github.com/coder/exectrace/exectracetest"
+	"github.com/coder/exectrace/remote"
+)
+
+var testEvents = []*exectrace.Event{
+	{Filename: "/usr/bin/echo", Argv: []string{"echo", "hello"}, PID: 1, UID: 1000, GID: 1000, Comm: "bash"},
+	{Filename: "/usr/bin/curl", Argv: []string{"curl", "-s", "example.com"}, PID: 2, UID: 0, GID: 0, Comm: "sh", Truncated: true},
+	{Filename: "/usr/bin/true", Argv: []string{"true"}, PID: 3, UID: 1000, GID: 1000, Comm: "bash", Redacted: true},
+}
+
+func startGRPC(t *testing.T, srv *remote.Server) func(context.Context, string) (net.Conn, error) {
+	t.Helper()
+
+	l := bufconn.Listen(1 << 20)
+	gs := grpc.NewServer()
+	srv.RegisterGRPC(gs)
+	go func() {
+		_ = gs.Serve(l)
+	}()
+	t.Cleanup(gs.Stop)
+
+	return func(ctx context.Context, _ string) (net.Conn, error) {
+		return l.DialContext(ctx)
+	}
+}
+
+func TestGRPC(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Stream", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		tracer := exectracetest.New()
+		srv := remote.NewServer(tracer, &remote.ServerOpts{Token: "secret"})
+		defer srv.Close()
+		dialer := startGRPC(t, srv)
+
+		all, err := remote.Dial(ctx, "passthrough:///bufnet", &remote.ClientOpts{
+			Token:       "secret",
+			DialOptions: []grpc.DialOption{grpc.WithContextDialer(dialer)},
+		})
+		require.NoError(t, err)
+		defer all.Close()
+		require.Equal(t, -1, all.FD())
+
+		filtered, err := remote.Dial(ctx, "passthrough:///bufnet", &remote.ClientOpts{
+			Token:       "secret",
+			Filter:      "uid == 1000",
+			DialOptions: []grpc.DialOption{grpc.WithContextDialer(dialer)},
+		})
+		require.NoError(t, err)
+		defer filtered.Close()
+
+		tracer.Push(testEvents...)
+
+		for _, expected := range testEvents {
+			ev, err := all.Read()
+			require.NoError(t, err)
+			require.Equal(t, expected, ev)
+		}
+		for _, expected := range []*exectrace.Event{testEvents[0], testEvents[2]} {
+			ev, err := filtered.Read()
+			require.NoError(t, err)
+			require.Equal(t, expected, ev)
+		}
+
+		// Closing the client makes Read return io.EOF.
+		require.NoError(t, filtered.Close())
+		_, err = filtered.Read()
+		require.ErrorIs(t, err, io.EOF)
+		require.Error(t, filtered.Close())
+
+		// Closing the tracer ends the stream.
+		require.NoError(t, tracer.Close())
+		_, err = all.Read()
+		require.ErrorIs(t, err, io.EOF)
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		tracer := exectracetest.New()
+		defer tracer.Close()
+		srv := remote.NewServer(tracer, &remote.ServerOpts{Token: "secret"})
+		dialer := startGRPC(t, srv)
+		dialOpts := []grpc.DialOption{grpc.WithContextDialer(dialer)}
+
+		_, err := remote.Dial(ctx, "passthrough:///bufnet", &remote.ClientOpts{
+			Token:       "wrong",
+			DialOptions: dialOpts,
+		})
+		require.ErrorContains(t, err, "Unauthenticated")
+
+		_, err = remote.Dial(ctx, "passthrough:///bufnet", &remote.ClientOpts{
+			Token:       "secret",
+			Filter:      "uid ==",
+			DialOptions: dialOpts,
+		})
+		require.ErrorContains(t, err, "InvalidArgument")
+
+		require.NoError(t, srv.Close())
+		_, err = remote.Dial(ctx, "passthrough:///bufnet", &remote.ClientOpts{
+			Token:       "secret",
+			DialOptions: dialOpts,
+		})
+		require.ErrorContains(t, err, "Unavailable")
+
+		// Dial respects the context if the server can't be reached.
+		shortCtx, shortCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer shortCancel()
+		_, err = remote.Dial(shortCtx, "passthrough:///bufnet", &remote.ClientOpts{
+			DialOptions: []grpc.DialOption{grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+				return nil, xerrors.New("unreachable")
+			})},
+		})
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("RestrictPeers", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		tracer := exectracetest.New()
+		defer tracer.Close()
+		srv := remote.NewServer(tracer, &remote.ServerOpts{RestrictPeers: true})
+		defer srv.Close()
+		dialer := startGRPC(t, srv)
+
+		// Clients without peer credentials are rejected.
+		_, err := remote.Dial(ctx, "passthrough:///bufnet", &remote.ClientOpts{
+			DialOptions: []grpc.DialOption{grpc.WithContextDialer(dialer)},
+		})
+		require.ErrorContains(t, err, "PermissionDenied")
+	})
+}
+
+func TestSSE(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tracer := exectracetest.New()
+	srv := remote.NewServer(tracer, &remote.ServerOpts{Token: "secret"})
+	defer srv.Close()
+	hs := httptest.NewServer(srv)
+	defer hs.Close()
+
+	get := func(query, token string) *http.Response {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, hs.URL+query, nil)
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return res
+	}
+
+	res := get("", "")
+	res.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+	res = get("?filter=uid+%3D%3D", "secret")
+	res.Body.Close()
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	res = get("?filter=comm+%3D%3D+%22sh%22", "secret")
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+	require.Equal(t, "1", res.Header.Get("Exectrace-Protocol-Version"))
+
+	tracer.Push(testEvents...)
+
+	r := bufio.NewReader(res.Body)
+	var lines []string
+	for len(lines) < 2 {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	require.Equal(t, "event: exec", lines[0])
+	data, ok := strings.CutPrefix(lines[1], "data: ")
+	require.True(t, ok)
+	var ev exectrace.Event
+	err := json.Unmarshal([]byte(data), &ev)
+	require.NoError(t, err)
+	require.Equal(t, testEvents[1], &ev)
+
+	// SSE clients can't be identified, so they're rejected by servers that
+	// restrict peers.
+	restrictedTracer := exectracetest.New()
+	defer restrictedTracer.Close()
+	restricted := httptest.NewServer(remote.NewServer(restrictedTracer, &remote.ServerOpts{RestrictPeers: true}))
+	defer restricted.Close()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, restricted.URL, nil)
+	require.NoError(t, err)
+	res, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusForbidden, res.StatusCode)
+
+	// Closing the tracer ends the stream.
+	require.NoError(t, tracer.Close())
+	_, err = io.ReadAll(r)
+	require.NoError(t, err)
+	select {
+	case <-srv.Done():
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for server to finish")
+	}
+}
@@ -0,0 +1,3 @@
+// Package remotepb contains the generated protobuf and gRPC code for the
+// exectrace remote streaming service. Run `make gen` to regenerate it.
+package remotepb
@@ -0,0 +1,153 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             (unknown)
+// source: remote/remotepb/exectrace.proto
+
+package remotepb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	Exectrace_StreamEvents_FullMethodName = "/exectrace.v1.Exectrace/StreamEvents"
+)
+
+// ExectraceClient is the client API for Exectrace service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Exectrace streams exec events from a single tracer to remote consumers.
+//
+// The version in the package name is the protocol version, which servers also
+// send in the "exectrace-protocol-version" response header. Incompatible
+// changes must use a new package version.
+type ExectraceClient interface {
+	// StreamEvents streams exec events until the client cancels the stream or
+	// the server's tracer is closed.
+	StreamEvents(ctx context.Context, in *StreamEventsRequest, opts ...grpc.CallOption) (Exectrace_StreamEventsClient, error)
+}
+
+type exectraceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewExectraceClient(cc grpc.ClientConnInterface) ExectraceClient {
+	return &exectraceClient{cc}
+}
+
+func (c *exectraceClient) StreamEvents(ctx context.Context, in *StreamEventsRequest, opts ...grpc.CallOption) (Exectrace_StreamEventsClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Exectrace_ServiceDesc.Streams[0], Exectrace_StreamEvents_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &exectraceStreamEventsClient{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Exectrace_StreamEventsClient interface {
+	Recv() (*Event, error)
+	grpc.ClientStream
+}
+
+type exectraceStreamEventsClient struct {
+	grpc.ClientStream
+}
+
+func (x *exectraceStreamEventsClient) Recv() (*Event, error) {
+	m := new(Event)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ExectraceServer is the server API for Exectrace service.
+// All implementations must embed UnimplementedExectraceServer
+// for forward compatibility
+//
+// Exectrace streams exec events from a single tracer to remote consumers.
+//
+// The version in the package name is the protocol version, which servers also
+// send in the "exectrace-protocol-version" response header. Incompatible
+// changes must use a new package version.
+type ExectraceServer interface {
+	// StreamEvents streams exec events until the client cancels the stream or
+	// the server's tracer is closed.
+	StreamEvents(*StreamEventsRequest, Exectrace_StreamEventsServer) error
+	mustEmbedUnimplementedExectraceServer()
+}
+
+// UnimplementedExectraceServer must be embedded to have forward compatible implementations.
+type UnimplementedExectraceServer struct {
+}
+
+func (UnimplementedExectraceServer) StreamEvents(*StreamEventsRequest, Exectrace_StreamEventsServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamEvents not implemented")
+}
+func (UnimplementedExectraceServer) mustEmbedUnimplementedExectraceServer() {}
+
+// UnsafeExectraceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ExectraceServer will
+// result in compilation errors.
+type UnsafeExectraceServer interface {
+	mustEmbedUnimplementedExectraceServer()
+}
+
+func RegisterExectraceServer(s grpc.ServiceRegistrar, srv ExectraceServer) {
+	s.RegisterService(&Exectrace_ServiceDesc, srv)
+}
+
+func _Exectrace_StreamEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamEventsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ExectraceServer).StreamEvents(m, &exectraceStreamEventsServer{ServerStream: stream})
+}
+
+type Exectrace_StreamEventsServer interface {
+	Send(*Event) error
+	grpc.ServerStream
+}
+
+type exectraceStreamEventsServer struct {
+	grpc.ServerStream
+}
+
+func (x *exectraceStreamEventsServer) Send(m *Event) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Exectrace_ServiceDesc is the grpc.ServiceDesc for Exectrace service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Exectrace_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "exectrace.v1.Exectrace",
+	HandlerType: (*ExectraceServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamEvents",
+			Handler:       _Exectrace_StreamEvents_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "remote/remotepb/exectrace.proto",
+}
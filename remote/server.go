@@ -0,0 +1,499 @@
+// Package remote streams the events from a single exectrace.Tracer to many
+// remote consumers over gRPC and Server-Sent Events (SSE), and provides a gRPC
+// client that implements exectrace.Tracer.
+//
+// This allows many tools on a host to consume exec events without each of
+// them loading their own eBPF program or needing root. Served over a unix
+// socket with PeerCredentials and ServerOpts.RestrictPeers, unprivileged
+// clients can safely be given access to their own events, which is what the
+// exectraced daemon does.
+package remote
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/xerrors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/coder/exectrace"
+	"github.com/coder/exectrace/remote/remotepb"
+)
+
+const (
+	// DefaultBufferSize is the default number of events buffered for each
+	// client.
+	DefaultBufferSize = 1024
+
+	// ProtocolVersion is the version of the wire format, which matches the
+	// version in the protobuf package name. Backwards compatible changes,
+	// such as new fields, don't change the version. The server sends it in
+	// the versionHeader and clients refuse to stream from servers with a
+	// different version.
+	ProtocolVersion = 1
+
+	// DefaultSocketPath is the unix socket the exectraced daemon listens on
+	// by default. Clients can connect with the "unix://" target scheme, e.g.
+	// Dial(ctx, "unix://"+DefaultSocketPath, nil).
+	DefaultSocketPath = "/run/exectraced.sock"
+
+	// acceptedHeader is sent in the gRPC headers once a stream has been
+	// accepted, so clients can tell an accepted stream apart from an error
+	// response.
+	acceptedHeader = "exectrace-accepted"
+	// versionHeader is sent in the gRPC and HTTP headers with the
+	// ProtocolVersion.
+	versionHeader = "exectrace-protocol-version"
+
+	// sseKeepaliveInterval is how often a comment is sent to idle SSE clients
+	// so proxies don't close the connection.
+	sseKeepaliveInterval = 15 * time.Second
+)
+
+// ErrServerClosed is returned when subscribing to a closed server.
+var ErrServerClosed = xerrors.New("server closed")
+
+// ServerOpts contains the configuration options for the server. All are
+// optional.
+type ServerOpts struct {
+	// BufferSize is the number of events buffered for each client. When a
+	// client's buffer is full, new events are dropped for that client instead
+	// of blocking other clients, and the number of dropped events is reported
+	// to the client with the next event.
+	//
+	// Defaults to DefaultBufferSize.
+	BufferSize int
+
+	// Token is a shared secret that clients must send as a bearer token in
+	// the "authorization" gRPC metadata or HTTP header. If unset, all clients
+	// are allowed.
+	Token string
+
+	// RestrictPeers requires gRPC clients to connect over a unix socket
+	// served with PeerCredentials. Clients that aren't root only receive
+	// events from processes running as their own UID in their own PID
+	// namespace (or its descendants), in addition to their filter. SSE
+	// clients are rejected as they can't be identified.
+	//
+	// The server must run in the same PID namespace as the tracer, usually
+	// the host's, so event PIDs can be checked. Events from processes that
+	// exit before they are checked are not sent to restricted clients.
+	RestrictPeers bool
+
+	// LogFn is called with errors reading from the tracer. If unspecified,
+	// errors are logged to stderr.
+	LogFn func(err error)
+}
+
+// Server reads events from a tracer and broadcasts them to all connected
+// clients. It implements the gRPC Exectrace service and serves SSE streams
+// over HTTP.
+type Server struct {
+	remotepb.UnimplementedExectraceServer
+
+	tracer exectrace.Tracer
+	opts   *ServerOpts
+
+	mu          sync.Mutex
+	subscribers map[*subscriber]struct{}
+	closed      bool
+
+	// done is closed when the tracer returns io.EOF.
+	done chan struct{}
+}
+
+var (
+	_ remotepb.ExectraceServer = &Server{}
+	_ http.Handler             = &Server{}
+)
+
+// subscriber is a single client stream.
+type subscriber struct {
+	filter *exectrace.Filter
+	// scope is set for clients restricted by ServerOpts.RestrictPeers.
+	scope *peerScope
+	// events is closed by the server when the server is closed or the tracer
+	// returns io.EOF.
+	events  chan *exectrace.Event
+	dropped atomic.Uint64
+}
+
+// NewServer creates a server and starts reading events from t. The server
+// does not take ownership of the tracer, when the tracer is closed all client
+// streams end.
+func NewServer(t exectrace.Tracer, opts *ServerOpts) *Server {
+	if opts == nil {
+		opts = &ServerOpts{}
+	}
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = DefaultBufferSize
+	}
+	if opts.LogFn == nil {
+		opts.LogFn = func(err error) {
+			log.Printf("error reading from exectrace tracer: %+v", err)
+		}
+	}
+
+	s := &Server{
+		tracer:      t,
+		opts:        opts,
+		subscribers: map[*subscriber]struct{}{},
+		done:        make(chan struct{}),
+	}
+	go s.broadcast()
+	return s
+}
+
+// RegisterGRPC registers the Exectrace service on the given gRPC server.
+func (s *Server) RegisterGRPC(gs *grpc.Server) {
+	remotepb.RegisterExectraceServer(gs, s)
+}
+
+// Close ends all client streams and rejects new ones. The tracer is not
+// closed.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closeLocked()
+	return nil
+}
+
+// Done returns a channel that is closed once the tracer has been closed and
+// all client streams have ended.
+func (s *Server) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Server) closeLocked() {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	for sub := range s.subscribers {
+		close(sub.events)
+		delete(s.subscribers, sub)
+	}
+}
+
+func (s *Server) broadcast() {
+	defer close(s.done)
+
+	for {
+		ev, err := s.tracer.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				s.mu.Lock()
+				s.closeLocked()
+				s.mu.Unlock()
+				return
+			}
+			s.opts.LogFn(err)
+			continue
+		}
+
+		// Peer scopes read /proc, so subscribers are matched without holding
+		// the lock to not block subscribing and unsubscribing.
+		s.mu.Lock()
+		subs := make([]*subscriber, 0, len(s.subscribers))
+		for sub := range s.subscribers {
+			subs = append(subs, sub)
+		}
+		s.mu.Unlock()
+
+		matched := subs[:0]
+		allowed := map[peerScope]bool{}
+		for _, sub := range subs {
+			if sub.filter != nil && !sub.filter.Match(ev) {
+				continue
+			}
+			if sub.scope != nil {
+				// Clients in the same namespace as the same user share the
+				// result.
+				ok, checked := allowed[*sub.scope]
+				if !checked {
+					ok = sub.scope.allows(ev)
+					allowed[*sub.scope] = ok
+				}
+				if !ok {
+					continue
+				}
+			}
+			matched = append(matched, sub)
+		}
+		if len(matched) == 0 {
+			continue
+		}
+
+		s.mu.Lock()
+		for _, sub := range matched {
+			// The subscriber's channel is closed once it's removed.
+			if _, ok := s.subscribers[sub]; !ok {
+				continue
+			}
+			select {
+			case sub.events <- ev:
+			default:
+				sub.dropped.Add(1)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *Server) subscribe(filterExpr string, scope *peerScope) (*subscriber, error) {
+	var filter *exectrace.Filter
+	if filterExpr != "" {
+		var err error
+		filter, err = exectrace.ParseFilter(filterExpr)
+		if err != nil {
+			return nil, xerrors.Errorf("parse filter: %w", err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, ErrServerClosed
+	}
+	sub := &subscriber{
+		filter: filter,
+		scope:  scope,
+		events: make(chan *exectrace.Event, s.opts.BufferSize),
+	}
+	s.subscribers[sub] = struct{}{}
+	return sub, nil
+}
+
+func (s *Server) unsubscribe(sub *subscriber) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.subscribers[sub]; ok {
+		close(sub.events)
+		delete(s.subscribers, sub)
+	}
+}
+
+// authorized checks the value of an authorization header against the token.
+func (s *Server) authorized(header string) bool {
+	if s.opts.Token == "" {
+		return true
+	}
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.opts.Token)) == 1
+}
+
+// peerScope returns the scope a gRPC client is restricted to, or nil if it
+// isn't restricted.
+func (s *Server) peerScope(ctx context.Context) (*peerScope, error) {
+	if !s.opts.RestrictPeers {
+		return nil, nil
+	}
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, xerrors.New("peer credentials are required")
+	}
+	info, ok := p.AuthInfo.(PeerCredAuthInfo)
+	if !ok {
+		return nil, xerrors.New("peer credentials are required, connect over the unix socket")
+	}
+	if info.UID == 0 {
+		return nil, nil
+	}
+
+	pidNS, err := exectrace.GetPidNSOf(info.PID)
+	if err != nil {
+		return nil, xerrors.Errorf("get PID namespace of client: %w", err)
+	}
+	return &peerScope{uid: info.UID, pidNS: pidNS}, nil
+}
+
+// StreamEvents implements remotepb.ExectraceServer.
+func (s *Server) StreamEvents(req *remotepb.StreamEventsRequest, stream remotepb.Exectrace_StreamEventsServer) error {
+	var header string
+	if md, ok := metadata.FromIncomingContext(stream.Context()); ok {
+		if v := md.Get("authorization"); len(v) > 0 {
+			header = v[0]
+		}
+	}
+	if !s.authorized(header) {
+		return status.Error(codes.Unauthenticated, "invalid or missing token")
+	}
+
+	scope, err := s.peerScope(stream.Context())
+	if err != nil {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	sub, err := s.subscribe(req.GetFilter(), scope)
+	if err != nil {
+		if errors.Is(err, ErrServerClosed) {
+			return status.Error(codes.Unavailable, err.Error())
+		}
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	defer s.unsubscribe(sub)
+
+	// Send the headers immediately so the client knows the stream was
+	// accepted without waiting for the first event.
+	err = stream.SendHeader(metadata.Pairs(
+		acceptedHeader, "true",
+		versionHeader, strconv.Itoa(ProtocolVersion),
+	))
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case ev, ok := <-sub.events:
+			if !ok {
+				return nil
+			}
+			err := stream.Send(toProto(ev, sub.dropped.Swap(0)))
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// ServeHTTP streams events as Server-Sent Events. An optional filter
+// expression can be given in the "filter" query parameter.
+//
+// Each event is sent as an "exec" SSE event with the JSON encoded
+// exectrace.Event as the data. If events were dropped because the client
+// wasn't reading fast enough, a "dropped" event with the data
+// {"dropped": <count>} is sent before the next exec event.
+func (s *Server) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorized(r.Header.Get("Authorization")) {
+		http.Error(rw, "invalid or missing token", http.StatusUnauthorized)
+		return
+	}
+	if s.opts.RestrictPeers {
+		http.Error(rw, "peer credentials are required, connect over the gRPC unix socket", http.StatusForbidden)
+		return
+	}
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		http.Error(rw, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	sub, err := s.subscribe(r.URL.Query().Get("filter"), nil)
+	if err != nil {
+		code := http.StatusBadRequest
+		if errors.Is(err, ErrServerClosed) {
+			code = http.StatusServiceUnavailable
+		}
+		http.Error(rw, err.Error(), code)
+		return
+	}
+	defer s.unsubscribe(sub)
+
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.Header().Set("Connection", "keep-alive")
+	rw.Header().Set(versionHeader, strconv.Itoa(ProtocolVersion))
+	rw.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(sseKeepaliveInterval)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			_, err = io.WriteString(rw, ": keepalive\n\n")
+		case ev, ok := <-sub.events:
+			if !ok {
+				return
+			}
+			err = writeSSEEvent(rw, ev, sub.dropped.Swap(0))
+		}
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func writeSSEEvent(w io.Writer, ev *exectrace.Event, dropped uint64) error {
+	if dropped > 0 {
+		_, err := fmt.Fprintf(w, "event: dropped\ndata: {\"dropped\":%d}\n\n", dropped)
+		if err != nil {
+			return err
+		}
+	}
+
+	// JSON never contains raw newlines, so the data fits on one line.
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return xerrors.Errorf("marshal event: %w", err)
+	}
+	_, err = fmt.Fprintf(w, "event: exec\ndata: %s\n\n", data)
+	return err
+}
+
+func toProto(ev *exectrace.Event, dropped uint64) *remotepb.Event {
+	return &remotepb.Event{
+		Filename:    ev.Filename,
+		Argv:        ev.Argv,
+		Truncated:   ev.Truncated,
+		Redacted:    ev.Redacted,
+		Pid:         ev.PID,
+		Ppid:        ev.PPID,
+		PidNs:       ev.PidNS,
+		Uid:         ev.UID,
+		Gid:         ev.GID,
+		Comm:        ev.Comm,
+		Dropped:     dropped,
+		Reliability: string(ev.Reliability),
+	}
+}
+
+func fromProto(ev *remotepb.Event) *exectrace.Event {
+	argv := ev.GetArgv()
+	if argv == nil {
+		argv = []string{}
+	}
+	return &exectrace.Event{
+		Filename:    ev.GetFilename(),
+		Argv:        argv,
+		Truncated:   ev.GetTruncated(),
+		Redacted:    ev.GetRedacted(),
+		PID:         ev.GetPid(),
+		PPID:        ev.GetPpid(),
+		PidNS:       ev.GetPidNs(),
+		UID:         ev.GetUid(),
+		GID:         ev.GetGid(),
+		Comm:        ev.GetComm(),
+		Reliability: exectrace.Reliability(ev.GetReliability()),
+	}
+}
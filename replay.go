@@ -0,0 +1,192 @@
+package exectrace
+
+import (
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// ReplayOpts contains the configuration options for a ReplayTracer. All are
+// optional.
+type ReplayOpts struct {
+	// Speed controls how fast events are replayed relative to the time they
+	// were recorded. 1 replays in real time, 2 replays twice as fast, and so
+	// on. Zero (the default) replays events as fast as they are read.
+	Speed float64
+
+	// Redact and Filter behave the same as in TracerOpts.
+	Redact *RedactOpts
+	Filter *Filter
+}
+
+// ReplayTracer is a Tracer that reads events from a trace recorded with
+// TraceWriter instead of the kernel. It doesn't require root or eBPF support,
+// which makes it useful for reproducing incidents, building test fixtures and
+// developing consumers.
+type ReplayTracer struct {
+	opts     *ReplayOpts
+	redactor *Redactor
+	reader   *TraceReader
+	closer   io.Closer
+
+	// mu serializes reads.
+	mu sync.Mutex
+	// first and start are the time of the first record and the wall clock
+	// time it was replayed, used to pace replay.
+	first time.Time
+	start time.Time
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+var _ Tracer = &ReplayTracer{}
+
+// NewReplayTracer creates a ReplayTracer that reads a trace from r. If r is
+// an io.Closer it is closed when the tracer is closed.
+func NewReplayTracer(r io.Reader, opts *ReplayOpts) (*ReplayTracer, error) {
+	if opts == nil {
+		opts = &ReplayOpts{}
+	}
+	if opts.Speed < 0 {
+		return nil, xerrors.New("replay speed must not be negative")
+	}
+
+	var redactor *Redactor
+	if opts.Redact != nil {
+		var err error
+		redactor, err = NewRedactor(opts.Redact)
+		if err != nil {
+			return nil, xerrors.Errorf("create redactor: %w", err)
+		}
+	}
+
+	reader, err := NewTraceReader(r)
+	if err != nil {
+		return nil, xerrors.Errorf("read trace: %w", err)
+	}
+
+	t := &ReplayTracer{
+		opts:     opts,
+		redactor: redactor,
+		reader:   reader,
+		closed:   make(chan struct{}),
+	}
+	if c, ok := r.(io.Closer); ok {
+		t.closer = c
+	}
+	return t, nil
+}
+
+// OpenReplayTracer creates a ReplayTracer that reads the trace file at path.
+func OpenReplayTracer(path string, opts *ReplayOpts) (*ReplayTracer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, xerrors.Errorf("open trace file: %w", err)
+	}
+	t, err := NewReplayTracer(f, opts)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+// Read returns the next event in the trace. When the end of the trace is
+// reached, or the tracer is closed, an error that wraps io.EOF is returned.
+func (t *ReplayTracer) Read() (*Event, error) {
+	rec, err := t.ReadRecord()
+	if err != nil {
+		return nil, err
+	}
+	return rec.Event, nil
+}
+
+// ReadRecord is like Read but also returns the time the event was recorded.
+func (t *ReplayTracer) ReadRecord() (*TraceRecord, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for {
+		select {
+		case <-t.closed:
+			return nil, xerrors.Errorf("tracer closed: %w", io.EOF)
+		default:
+		}
+
+		rec, err := t.reader.Next()
+		if err != nil {
+			if xerrors.Is(err, io.EOF) {
+				return nil, xerrors.Errorf("end of trace: %w", io.EOF)
+			}
+			return nil, xerrors.Errorf("read trace record: %w", err)
+		}
+
+		if t.opts.Filter != nil && !t.opts.Filter.Match(rec.Event) {
+			continue
+		}
+		if t.redactor != nil {
+			t.redactor.RedactEvent(rec.Event)
+		}
+
+		err = t.wait(rec.Time)
+		if err != nil {
+			return nil, err
+		}
+		return rec, nil
+	}
+}
+
+// wait sleeps until the record should be replayed based on the replay speed.
+func (t *ReplayTracer) wait(recorded time.Time) error {
+	if t.opts.Speed == 0 {
+		return nil
+	}
+	if t.start.IsZero() {
+		t.first = recorded
+		t.start = time.Now()
+		return nil
+	}
+
+	offset := time.Duration(float64(recorded.Sub(t.first)) / t.opts.Speed)
+	delay := time.Until(t.start.Add(offset))
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-t.closed:
+		return xerrors.Errorf("tracer closed: %w", io.EOF)
+	case <-timer.C:
+		return nil
+	}
+}
+
+// FD returns -1 as there is no eBPF program.
+func (*ReplayTracer) FD() int {
+	return -1
+}
+
+// Reload always returns an error, as a trace can't be replayed with different
+// options after it has been read. Create a new ReplayTracer instead.
+func (*ReplayTracer) Reload(_ *TracerOpts) error {
+	return xerrors.New("replay tracers can't be reloaded")
+}
+
+// Close closes the tracer and the underlying reader if it is an io.Closer.
+// Any blocked Read calls will return an error that wraps io.EOF.
+func (t *ReplayTracer) Close() error {
+	err := errTracerClosed
+	t.closeOnce.Do(func() {
+		close(t.closed)
+		err = nil
+		if t.closer != nil {
+			err = t.closer.Close()
+		}
+	})
+	return err
+}
@@ -0,0 +1,40 @@
+package rules
+
+import (
+	"embed"
+	"io/fs"
+	"sort"
+
+	"golang.org/x/xerrors"
+)
+
+// builtinFS contains the starter rule set.
+//
+//go:embed builtin/*.yaml
+var builtinFS embed.FS
+
+// Builtin returns the starter rule set that ships with exectrace. The rules
+// cover common suspicious exec patterns such as piping downloads to a shell,
+// reverse shells and executing binaries from world-writable directories.
+func Builtin() ([]*Rule, error) {
+	paths, err := fs.Glob(builtinFS, "builtin/*.yaml")
+	if err != nil {
+		return nil, xerrors.Errorf("glob builtin rules: %w", err)
+	}
+	sort.Strings(paths)
+
+	var rules []*Rule
+	for _, path := range paths {
+		data, err := builtinFS.ReadFile(path)
+		if err != nil {
+			return nil, xerrors.Errorf("read builtin rule file %q: %w", path, err)
+		}
+		fileRules, err := Parse(data)
+		if err != nil {
+			return nil, xerrors.Errorf("parse builtin rule file %q: %w", path, err)
+		}
+		rules = append(rules, fileRules...)
+	}
+
+	return rules, nil
+}
@@ -0,0 +1,217 @@
+package rules
+
+import (
+	"path"
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	"github.com/coder/exectrace"
+)
+
+// conditionNode is a node in a parsed detection condition.
+type conditionNode interface {
+	eval(selections map[string]selection, ev *exectrace.Event) bool
+}
+
+type conditionSelection struct {
+	name string
+}
+
+func (c conditionSelection) eval(selections map[string]selection, ev *exectrace.Event) bool {
+	return selections[c.name].match(ev)
+}
+
+type conditionNot struct {
+	operand conditionNode
+}
+
+func (c conditionNot) eval(selections map[string]selection, ev *exectrace.Event) bool {
+	return !c.operand.eval(selections, ev)
+}
+
+type conditionAnd struct {
+	left, right conditionNode
+}
+
+func (c conditionAnd) eval(selections map[string]selection, ev *exectrace.Event) bool {
+	return c.left.eval(selections, ev) && c.right.eval(selections, ev)
+}
+
+type conditionOr struct {
+	left, right conditionNode
+}
+
+func (c conditionOr) eval(selections map[string]selection, ev *exectrace.Event) bool {
+	return c.left.eval(selections, ev) || c.right.eval(selections, ev)
+}
+
+// conditionOf implements "1 of x*" and "all of x*".
+type conditionOf struct {
+	all   bool
+	names []string
+}
+
+func (c conditionOf) eval(selections map[string]selection, ev *exectrace.Event) bool {
+	for _, name := range c.names {
+		matched := selections[name].match(ev)
+		if matched && !c.all {
+			return true
+		}
+		if !matched && c.all {
+			return false
+		}
+	}
+	return c.all
+}
+
+// parseCondition parses a Sigma-style condition expression. names contains the
+// names of all selections in the rule.
+func parseCondition(expr string, names []string) (conditionNode, error) {
+	p := &conditionParser{
+		tokens: tokenizeCondition(expr),
+		pos:    0,
+		names:  names,
+	}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, xerrors.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+	return node, nil
+}
+
+func tokenizeCondition(expr string) []string {
+	expr = strings.ReplaceAll(expr, "(", " ( ")
+	expr = strings.ReplaceAll(expr, ")", " ) ")
+	return strings.Fields(expr)
+}
+
+type conditionParser struct {
+	tokens []string
+	pos    int
+	names  []string
+}
+
+func (p *conditionParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *conditionParser) next() string {
+	tok := p.peek()
+	if tok != "" {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *conditionParser) parseOr() (conditionNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for strings.EqualFold(p.peek(), "or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = conditionOr{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *conditionParser) parseAnd() (conditionNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for strings.EqualFold(p.peek(), "and") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = conditionAnd{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *conditionParser) parseNot() (conditionNode, error) {
+	if strings.EqualFold(p.peek(), "not") {
+		p.next()
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return conditionNot{operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *conditionParser) parsePrimary() (conditionNode, error) {
+	tok := p.next()
+	switch {
+	case tok == "":
+		return nil, xerrors.New("unexpected end of condition")
+	case tok == "(":
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, xerrors.New(`expected ")"`)
+		}
+		return node, nil
+	case tok == "1" || strings.EqualFold(tok, "all") || strings.EqualFold(tok, "any"):
+		if !strings.EqualFold(p.next(), "of") {
+			return nil, xerrors.Errorf(`expected "of" after %q`, tok)
+		}
+		pattern := p.next()
+		if pattern == "" {
+			return nil, xerrors.Errorf(`expected selection pattern after "%s of"`, tok)
+		}
+		names, err := p.expandPattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		return conditionOf{all: strings.EqualFold(tok, "all"), names: names}, nil
+	case tok == ")" || strings.EqualFold(tok, "and") || strings.EqualFold(tok, "or"):
+		return nil, xerrors.Errorf("unexpected %q", tok)
+	}
+
+	for _, name := range p.names {
+		if name == tok {
+			return conditionSelection{name: tok}, nil
+		}
+	}
+	return nil, xerrors.Errorf("unknown selection %q", tok)
+}
+
+// expandPattern returns the selection names matching the given glob pattern,
+// or all selections if the pattern is "them".
+func (p *conditionParser) expandPattern(pattern string) ([]string, error) {
+	if strings.EqualFold(pattern, "them") {
+		return p.names, nil
+	}
+
+	var names []string
+	for _, name := range p.names {
+		ok, err := path.Match(pattern, name)
+		if err != nil {
+			return nil, xerrors.Errorf("invalid selection pattern %q: %w", pattern, err)
+		}
+		if ok {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, xerrors.Errorf("selection pattern %q does not match any selections", pattern)
+	}
+	return names, nil
+}
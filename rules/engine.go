@@ -0,0 +1,110 @@
+package rules
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/coder/exectrace"
+)
+
+// Alert is emitted when an event matches a rule.
+type Alert struct {
+	RuleID   string           `json:"rule_id"`
+	Title    string           `json:"title"`
+	Severity Severity         `json:"severity"`
+	Tags     []string         `json:"tags,omitempty"`
+	Time     time.Time        `json:"time"`
+	Event    *exectrace.Event `json:"event"`
+}
+
+// Engine matches events against a set of rules. It is safe for concurrent use.
+type Engine struct {
+	rules       []*Rule
+	minSeverity Severity
+}
+
+// EngineOpts contains options for the engine. All are optional.
+type EngineOpts struct {
+	// MinSeverity causes rules with a lower severity to be skipped. Defaults to
+	// SeverityInformational (all rules).
+	MinSeverity Severity
+}
+
+// NewEngine creates an engine from the given rules. Rule IDs must be unique.
+func NewEngine(rules []*Rule, opts *EngineOpts) (*Engine, error) {
+	if opts == nil {
+		opts = &EngineOpts{}
+	}
+	if opts.MinSeverity == "" {
+		opts.MinSeverity = SeverityInformational
+	}
+	if !opts.MinSeverity.Valid() {
+		return nil, xerrors.Errorf("invalid minimum severity %q", opts.MinSeverity)
+	}
+
+	seen := make(map[string]struct{}, len(rules))
+	e := &Engine{
+		rules:       make([]*Rule, 0, len(rules)),
+		minSeverity: opts.MinSeverity,
+	}
+	for _, rule := range rules {
+		if _, ok := seen[rule.ID]; ok {
+			return nil, xerrors.Errorf("duplicate rule ID %q", rule.ID)
+		}
+		seen[rule.ID] = struct{}{}
+
+		if rule.Severity.AtLeast(opts.MinSeverity) {
+			e.rules = append(e.rules, rule)
+		}
+	}
+
+	return e, nil
+}
+
+// Rules returns the rules that are evaluated by the engine.
+func (e *Engine) Rules() []*Rule {
+	return append([]*Rule{}, e.rules...)
+}
+
+// Match returns an alert for each rule that matches the event.
+func (e *Engine) Match(ev *exectrace.Event) []Alert {
+	var (
+		alerts []Alert
+		now    = time.Now()
+	)
+	for _, rule := range e.rules {
+		if !rule.Match(ev) {
+			continue
+		}
+		alerts = append(alerts, Alert{
+			RuleID:   rule.ID,
+			Title:    rule.Title,
+			Severity: rule.Severity,
+			Tags:     rule.Tags,
+			Time:     now,
+			Event:    ev,
+		})
+	}
+	return alerts
+}
+
+// Watch reads events from the tracer until it is closed and calls alertFn for
+// each alert. Returns nil when the tracer is closed, or the first read error.
+func (e *Engine) Watch(t exectrace.Tracer, alertFn func(Alert)) error {
+	for {
+		ev, err := t.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return xerrors.Errorf("read event: %w", err)
+		}
+
+		for _, alert := range e.Match(ev) {
+			alertFn(alert)
+		}
+	}
+}
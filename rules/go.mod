@@ -0,0 +1,20 @@
+module github.com/coder/exectrace/rules
+
+go 1.21.0
+
+require (
+	github.com/coder/exectrace v0.0.0-20261018205557-97e1969965b6
+	github.com/stretchr/testify v1.9.0
+	golang.org/x/xerrors v0.0.0-20231012003039-104605ab7028
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/cilium/ebpf v0.14.0 // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/hashicorp/errwrap v1.1.0 // indirect
+	github.com/hashicorp/go-multierror v1.1.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	golang.org/x/exp v0.0.0-20240409090435-93d18d7e34b8 // indirect
+	golang.org/x/sys v0.19.0 // indirect
+)
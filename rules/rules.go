@@ -0,0 +1,468 @@
+// Package rules implements a detection rule engine for exectrace events. Rules
+// are written in a Sigma-style YAML format and produce Alerts when an event
+// matches.
+//
+// Example rule:
+//
+//	id: exectrace-exec-from-dev-shm
+//	title: Execution from /dev/shm
+//	level: high
+//	tags: [attack.execution, attack.defense_evasion]
+//	detection:
+//	  selection:
+//	    filename|startswith: /dev/shm/
+//	  condition: selection
+//
+// Each named selection under `detection` is a map of field names (with
+// optional modifiers separated by "|") to a value or list of values. All
+// fields in a selection must match (AND), and a field matches if any of its
+// values match (OR), unless the "all" modifier is used. A selection can also
+// be a list of maps, in which case any of the maps may match.
+//
+// Supported fields are filename, argv, cmdline, comm, pid, uid, gid and
+// truncated. For argv, a value matches if any element of argv matches.
+//
+// Supported modifiers are contains, startswith, endswith, re, all and cased.
+// String matching is case insensitive unless the cased modifier is used, as in
+// Sigma. Regular expressions are case sensitive unless they contain (?i).
+//
+// The condition combines selection names with and, or, not and parentheses.
+// "1 of sel*", "all of sel*", "1 of them" and "all of them" are also
+// supported.
+package rules
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/xerrors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/coder/exectrace"
+)
+
+// Severity is the severity level of a rule. It is called "level" in rule
+// files to match Sigma.
+type Severity string
+
+const (
+	SeverityInformational Severity = "informational"
+	SeverityLow           Severity = "low"
+	SeverityMedium        Severity = "medium"
+	SeverityHigh          Severity = "high"
+	SeverityCritical      Severity = "critical"
+)
+
+// severityRanks is used for comparing severities.
+var severityRanks = map[Severity]int{
+	SeverityInformational: 0,
+	SeverityLow:           1,
+	SeverityMedium:        2,
+	SeverityHigh:          3,
+	SeverityCritical:      4,
+}
+
+// Valid returns true if the severity is a known level.
+func (s Severity) Valid() bool {
+	_, ok := severityRanks[s]
+	return ok
+}
+
+// AtLeast returns true if s is as severe or more severe than other.
+func (s Severity) AtLeast(other Severity) bool {
+	return severityRanks[s] >= severityRanks[other]
+}
+
+// Rule is a parsed and compiled detection rule.
+type Rule struct {
+	ID             string   `yaml:"id"`
+	Title          string   `yaml:"title"`
+	Description    string   `yaml:"description"`
+	Severity       Severity `yaml:"level"`
+	Tags           []string `yaml:"tags"`
+	FalsePositives []string `yaml:"falsepositives"`
+
+	selections map[string]selection
+	condition  conditionNode
+}
+
+// rawRule is the YAML representation of a rule.
+type rawRule struct {
+	ID             string               `yaml:"id"`
+	Title          string               `yaml:"title"`
+	Description    string               `yaml:"description"`
+	Level          Severity             `yaml:"level"`
+	Tags           []string             `yaml:"tags"`
+	FalsePositives []string             `yaml:"falsepositives"`
+	Detection      map[string]yaml.Node `yaml:"detection"`
+}
+
+// Match returns true if the event matches the rule.
+func (r *Rule) Match(ev *exectrace.Event) bool {
+	return r.condition.eval(r.selections, ev)
+}
+
+// Parse parses one or more YAML documents, each containing a single rule.
+func Parse(data []byte) ([]*Rule, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+
+	var rules []*Rule
+	for i := 0; ; i++ {
+		var raw rawRule
+		err := dec.Decode(&raw)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, xerrors.Errorf("decode rule document %d: %w", i, err)
+		}
+
+		rule, err := compileRule(raw)
+		if err != nil {
+			if raw.ID != "" {
+				return nil, xerrors.Errorf("compile rule %q: %w", raw.ID, err)
+			}
+			return nil, xerrors.Errorf("compile rule document %d: %w", i, err)
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// LoadFile loads all rules from the given YAML file.
+func LoadFile(path string) ([]*Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, xerrors.Errorf("read rule file %q: %w", path, err)
+	}
+
+	rules, err := Parse(data)
+	if err != nil {
+		return nil, xerrors.Errorf("parse rule file %q: %w", path, err)
+	}
+	return rules, nil
+}
+
+// LoadPath loads rules from the given path. If the path is a directory, all
+// files ending in .yml or .yaml in the directory (not recursively) are loaded
+// in lexical order.
+func LoadPath(path string) ([]*Rule, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, xerrors.Errorf("stat %q: %w", path, err)
+	}
+	if !info.IsDir() {
+		return LoadFile(path)
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, xerrors.Errorf("read rule dir %q: %w", path, err)
+	}
+
+	var rules []*Rule
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if entry.IsDir() || (ext != ".yml" && ext != ".yaml") {
+			continue
+		}
+
+		fileRules, err := LoadFile(filepath.Join(path, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, fileRules...)
+	}
+
+	return rules, nil
+}
+
+func compileRule(raw rawRule) (*Rule, error) {
+	if raw.ID == "" {
+		return nil, xerrors.New("rule is missing an id")
+	}
+	if raw.Title == "" {
+		return nil, xerrors.New("rule is missing a title")
+	}
+	if raw.Level == "" {
+		raw.Level = SeverityMedium
+	}
+	if !raw.Level.Valid() {
+		return nil, xerrors.Errorf("invalid level %q", raw.Level)
+	}
+
+	rule := &Rule{
+		ID:             raw.ID,
+		Title:          raw.Title,
+		Description:    raw.Description,
+		Severity:       raw.Level,
+		Tags:           raw.Tags,
+		FalsePositives: raw.FalsePositives,
+		selections:     map[string]selection{},
+		condition:      nil,
+	}
+
+	var conditionExpr string
+	for name, node := range raw.Detection {
+		node := node
+		if name == "condition" {
+			err := node.Decode(&conditionExpr)
+			if err != nil {
+				return nil, xerrors.Errorf("decode condition: %w", err)
+			}
+			continue
+		}
+
+		sel, err := compileSelection(&node)
+		if err != nil {
+			return nil, xerrors.Errorf("compile selection %q: %w", name, err)
+		}
+		rule.selections[name] = sel
+	}
+	if len(rule.selections) == 0 {
+		return nil, xerrors.New("detection must contain at least one selection")
+	}
+	if conditionExpr == "" {
+		return nil, xerrors.New("detection is missing a condition")
+	}
+
+	names := make([]string, 0, len(rule.selections))
+	for name := range rule.selections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	cond, err := parseCondition(conditionExpr, names)
+	if err != nil {
+		return nil, xerrors.Errorf("parse condition %q: %w", conditionExpr, err)
+	}
+	rule.condition = cond
+
+	return rule, nil
+}
+
+// selection is a list of field groups. The selection matches if any group
+// matches, and a group matches if all of its field matchers match.
+type selection [][]fieldMatcher
+
+func (s selection) match(ev *exectrace.Event) bool {
+	for _, group := range s {
+		ok := true
+		for _, m := range group {
+			if !m.match(ev) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+func compileSelection(node *yaml.Node) (selection, error) {
+	var groups []map[string]yaml.Node
+	switch node.Kind {
+	case yaml.MappingNode:
+		var group map[string]yaml.Node
+		err := node.Decode(&group)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	case yaml.SequenceNode:
+		err := node.Decode(&groups)
+		if err != nil {
+			return nil, xerrors.Errorf("selection must be a map or a list of maps: %w", err)
+		}
+	default:
+		return nil, xerrors.New("selection must be a map or a list of maps")
+	}
+
+	sel := make(selection, 0, len(groups))
+	for _, group := range groups {
+		if len(group) == 0 {
+			return nil, xerrors.New("selection maps must not be empty")
+		}
+
+		// Sort the keys so matching order (and errors) are deterministic.
+		keys := make([]string, 0, len(group))
+		for key := range group {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		matchers := make([]fieldMatcher, 0, len(group))
+		for _, key := range keys {
+			valueNode := group[key]
+			m, err := compileFieldMatcher(key, &valueNode)
+			if err != nil {
+				return nil, xerrors.Errorf("field %q: %w", key, err)
+			}
+			matchers = append(matchers, m)
+		}
+		sel = append(sel, matchers)
+	}
+
+	return sel, nil
+}
+
+// ruleFields maps rule field names to functions that return the field's
+// values for an event.
+var ruleFields = map[string]func(ev *exectrace.Event) []string{
+	"filename": func(ev *exectrace.Event) []string { return []string{ev.Filename} },
+	"argv":     func(ev *exectrace.Event) []string { return ev.Argv },
+	"cmdline":  func(ev *exectrace.Event) []string { return []string{strings.Join(ev.Argv, " ")} },
+	"comm":     func(ev *exectrace.Event) []string { return []string{ev.Comm} },
+	"pid":      func(ev *exectrace.Event) []string { return []string{strconv.FormatUint(uint64(ev.PID), 10)} },
+	"uid":      func(ev *exectrace.Event) []string { return []string{strconv.FormatUint(uint64(ev.UID), 10)} },
+	"gid":      func(ev *exectrace.Event) []string { return []string{strconv.FormatUint(uint64(ev.GID), 10)} },
+	"truncated": func(ev *exectrace.Event) []string {
+		return []string{strconv.FormatBool(ev.Truncated)}
+	},
+}
+
+type matchMode int
+
+const (
+	matchEquals matchMode = iota
+	matchContains
+	matchStartsWith
+	matchEndsWith
+	matchRegex
+)
+
+type fieldMatcher struct {
+	field  string
+	get    func(ev *exectrace.Event) []string
+	mode   matchMode
+	all    bool
+	cased  bool
+	values []string
+	res    []*regexp.Regexp
+}
+
+func compileFieldMatcher(key string, node *yaml.Node) (fieldMatcher, error) {
+	parts := strings.Split(key, "|")
+	get, ok := ruleFields[parts[0]]
+	if !ok {
+		return fieldMatcher{}, xerrors.Errorf("unknown field %q", parts[0])
+	}
+
+	m := fieldMatcher{
+		field:  parts[0],
+		get:    get,
+		mode:   matchEquals,
+		all:    false,
+		cased:  false,
+		values: nil,
+		res:    nil,
+	}
+	for _, mod := range parts[1:] {
+		switch mod {
+		case "contains":
+			m.mode = matchContains
+		case "startswith":
+			m.mode = matchStartsWith
+		case "endswith":
+			m.mode = matchEndsWith
+		case "re":
+			m.mode = matchRegex
+		case "all":
+			m.all = true
+		case "cased":
+			m.cased = true
+		default:
+			return fieldMatcher{}, xerrors.Errorf("unknown modifier %q", mod)
+		}
+	}
+
+	switch node.Kind {
+	case yaml.ScalarNode:
+		m.values = []string{node.Value}
+	case yaml.SequenceNode:
+		for _, child := range node.Content {
+			if child.Kind != yaml.ScalarNode {
+				return fieldMatcher{}, xerrors.New("list values must be scalars")
+			}
+			m.values = append(m.values, child.Value)
+		}
+	default:
+		return fieldMatcher{}, xerrors.New("value must be a scalar or a list of scalars")
+	}
+	if len(m.values) == 0 {
+		return fieldMatcher{}, xerrors.New("at least one value is required")
+	}
+
+	if m.mode == matchRegex {
+		for _, v := range m.values {
+			re, err := regexp.Compile(v)
+			if err != nil {
+				return fieldMatcher{}, xerrors.Errorf("compile regex %q: %w", v, err)
+			}
+			m.res = append(m.res, re)
+		}
+	} else if !m.cased {
+		for i, v := range m.values {
+			m.values[i] = strings.ToLower(v)
+		}
+	}
+
+	return m, nil
+}
+
+func (m fieldMatcher) match(ev *exectrace.Event) bool {
+	fieldValues := m.get(ev)
+	for i := range m.values {
+		matched := false
+		for _, fv := range fieldValues {
+			if m.matchValue(i, fv) {
+				matched = true
+				break
+			}
+		}
+		if matched && !m.all {
+			return true
+		}
+		if !matched && m.all {
+			return false
+		}
+	}
+	return m.all
+}
+
+func (m fieldMatcher) matchValue(i int, fv string) bool {
+	if m.mode == matchRegex {
+		return m.res[i].MatchString(fv)
+	}
+
+	if !m.cased {
+		fv = strings.ToLower(fv)
+	}
+	v := m.values[i]
+	switch m.mode {
+	case matchContains:
+		return strings.Contains(fv, v)
+	case matchStartsWith:
+		return strings.HasPrefix(fv, v)
+	case matchEndsWith:
+		return strings.HasSuffix(fv, v)
+	default:
+		return fv == v
+	}
+}
+
+// String returns a short description of the rule.
+func (r *Rule) String() string {
+	return fmt.Sprintf("%s (%s)", r.ID, r.Title)
+}
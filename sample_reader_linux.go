@@ -0,0 +1,107 @@
+//go:build linux
+// +build linux
+
+package exectrace
+
+import (
+	"sync/atomic"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/perf"
+	"github.com/cilium/ebpf/ringbuf"
+	"golang.org/x/xerrors"
+)
+
+// perfBufferSize is the size of each per-CPU perf buffer. Events are ~35KiB
+// each, so this fits around 30 events per CPU before samples are lost.
+const perfBufferSize = 1 << 20
+
+// sampleReader reads raw samples sent to userspace by the eBPF program through
+// either a ringbuf or a perf event array.
+type sampleReader interface {
+	// Read blocks until a sample is available and returns it. Once the reader
+	// is closed, an error that wraps os.ErrClosed is returned.
+	Read() ([]byte, error)
+	Close() error
+}
+
+// ringbufReader is a sampleReader for BPF_MAP_TYPE_RINGBUF maps.
+type ringbufReader struct {
+	rd *ringbuf.Reader
+}
+
+var _ sampleReader = ringbufReader{}
+
+func newRingbufReader(m *ebpf.Map) (ringbufReader, error) {
+	rd, err := ringbuf.NewReader(m)
+	if err != nil {
+		return ringbufReader{}, err
+	}
+	return ringbufReader{rd: rd}, nil
+}
+
+func (r ringbufReader) Read() ([]byte, error) {
+	record, err := r.rd.Read()
+	if err != nil {
+		return nil, err
+	}
+	return record.RawSample, nil
+}
+
+func (r ringbufReader) Close() error {
+	return r.rd.Close()
+}
+
+// perfReader is a sampleReader for BPF_MAP_TYPE_PERF_EVENT_ARRAY maps. Unlike
+// ringbufs, the kernel tells us how many samples were lost on each CPU when a
+// perf buffer is full, which are counted.
+type perfReader struct {
+	rd   *perf.Reader
+	lost []atomic.Uint64
+}
+
+var _ sampleReader = &perfReader{}
+
+func newPerfReader(m *ebpf.Map) (*perfReader, error) {
+	cpus, err := ebpf.PossibleCPU()
+	if err != nil {
+		return nil, xerrors.Errorf("get possible CPUs: %w", err)
+	}
+	rd, err := perf.NewReader(m, perfBufferSize)
+	if err != nil {
+		return nil, err
+	}
+	return &perfReader{
+		rd:   rd,
+		lost: make([]atomic.Uint64, cpus),
+	}, nil
+}
+
+func (r *perfReader) Read() ([]byte, error) {
+	for {
+		record, err := r.rd.Read()
+		if err != nil {
+			return nil, err
+		}
+		if record.LostSamples > 0 {
+			if record.CPU >= 0 && record.CPU < len(r.lost) {
+				r.lost[record.CPU].Add(record.LostSamples)
+			}
+			continue
+		}
+		return record.RawSample, nil
+	}
+}
+
+// lostSamples returns the number of lost samples so far for each CPU.
+func (r *perfReader) lostSamples() []uint64 {
+	lost := make([]uint64, len(r.lost))
+	for i := range r.lost {
+		lost[i] = r.lost[i].Load()
+	}
+	return lost
+}
+
+func (r *perfReader) Close() error {
+	return r.rd.Close()
+}
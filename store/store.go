@@ -0,0 +1,536 @@
+// Package store persists exectrace events in an embedded bbolt database so they
+// can be queried later without scanning log files.
+//
+// Events are stored with the time they were read and the PID namespace of the
+// process, and are indexed by time, PID, UID, PID namespace and filename. A
+// query uses the most selective index for its filters and checks the other
+// filters on each event, and always returns events in time order.
+//
+// The database file never shrinks, but space freed by retention is reused for
+// new events, so the file stays close to the configured maximum size.
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"time"
+
+	"go.etcd.io/bbolt"
+	"golang.org/x/xerrors"
+
+	"github.com/coder/exectrace"
+)
+
+var (
+	bucketEvents   = []byte("events")
+	bucketTime     = []byte("idx_time")
+	bucketPID      = []byte("idx_pid")
+	bucketUID      = []byte("idx_uid")
+	bucketPidNS    = []byte("idx_pid_ns")
+	bucketFilename = []byte("idx_filename")
+	bucketMeta     = []byte("meta")
+
+	allBuckets = [][]byte{bucketEvents, bucketTime, bucketPID, bucketUID, bucketPidNS, bucketFilename, bucketMeta}
+
+	// metaSize is the key of the approximate size of all records and index
+	// keys in the meta bucket.
+	metaSize = []byte("size")
+)
+
+// idLen and timeLen are the lengths of the encoded ID and time at the end of
+// every index key.
+const (
+	idLen   = 8
+	timeLen = 8
+)
+
+// Record is an event in the store.
+type Record struct {
+	// ID is assigned by the store when the record is added. IDs increase
+	// with each added record.
+	ID uint64 `json:"-"`
+	// Time is when the event was read.
+	Time  time.Time        `json:"time"`
+	Event *exectrace.Event `json:"event"`
+}
+
+// Options configures a Store.
+type Options struct {
+	// MaxAge is the maximum age of records, older records are deleted by
+	// Prune. 0 keeps records regardless of their age.
+	MaxAge time.Duration
+	// MaxSize is the approximate maximum size in bytes of all records and
+	// their indexes, the oldest records are deleted by Prune until the size
+	// is below it. 0 disables the limit.
+	MaxSize int64
+	// ReadOnly opens the database without write access. Any number of
+	// read-only stores can be open at the same time, but not while a
+	// writable store is open.
+	ReadOnly bool
+	// Timeout is how long Open waits for other processes to close the
+	// database. 0 waits indefinitely.
+	Timeout time.Duration
+}
+
+// Store is an embedded database of events.
+type Store struct {
+	db   *bbolt.DB
+	opts Options
+}
+
+// Open opens or creates the database at path. The returned store must be
+// closed when finished.
+func Open(path string, opts *Options) (*Store, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	if opts.MaxAge < 0 || opts.MaxSize < 0 {
+		return nil, xerrors.New("MaxAge and MaxSize must not be negative")
+	}
+
+	if opts.ReadOnly {
+		// bbolt creates missing files even in read-only mode.
+		_, err := os.Stat(path)
+		if err != nil {
+			return nil, xerrors.Errorf("stat database: %w", err)
+		}
+	}
+	db, err := bbolt.Open(path, 0o640, &bbolt.Options{
+		Timeout:  opts.Timeout,
+		ReadOnly: opts.ReadOnly,
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("open database %q: %w", path, err)
+	}
+
+	if !opts.ReadOnly {
+		err = db.Update(func(tx *bbolt.Tx) error {
+			for _, name := range allBuckets {
+				_, err := tx.CreateBucketIfNotExists(name)
+				if err != nil {
+					return xerrors.Errorf("create bucket %q: %w", name, err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			_ = db.Close()
+			return nil, xerrors.Errorf("initialize database: %w", err)
+		}
+	}
+
+	return &Store{db: db, opts: *opts}, nil
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Add adds records to the store in a single transaction and sets their IDs.
+func (s *Store) Add(records ...*Record) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		events := tx.Bucket(bucketEvents)
+		if events == nil {
+			return xerrors.New("database is not initialized")
+		}
+		size := getSize(tx)
+		for _, r := range records {
+			if r.Event == nil {
+				return xerrors.New("record has no event")
+			}
+			id, err := events.NextSequence()
+			if err != nil {
+				return xerrors.Errorf("get next ID: %w", err)
+			}
+			value, err := json.Marshal(r)
+			if err != nil {
+				return xerrors.Errorf("encode record: %w", err)
+			}
+
+			key := encodeID(id)
+			err = events.Put(key, value)
+			if err != nil {
+				return xerrors.Errorf("put record: %w", err)
+			}
+			size += int64(len(key) + len(value))
+			for _, idx := range indexKeys(r, id) {
+				err = tx.Bucket(idx.bucket).Put(idx.key, nil)
+				if err != nil {
+					return xerrors.Errorf("put %q index key: %w", idx.bucket, err)
+				}
+				size += int64(len(idx.key))
+			}
+			r.ID = id
+		}
+		return putSize(tx, size)
+	})
+}
+
+// Prune deletes records older than MaxAge relative to now and then the oldest
+// records until the size is below MaxSize. It returns the number of deleted
+// records.
+func (s *Store) Prune(now time.Time) (int, error) {
+	if s.opts.MaxAge == 0 && s.opts.MaxSize == 0 {
+		return 0, nil
+	}
+
+	deleted := 0
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		size := getSize(tx)
+		cutoff := now.Add(-s.opts.MaxAge)
+
+		// IDs are collected before deleting them as deleting while
+		// iterating a bbolt cursor skips keys. They are copied as keys are
+		// only valid until the transaction is modified.
+		var ids [][]byte
+		c := tx.Bucket(bucketTime).Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			expired := s.opts.MaxAge > 0 && decodeTime(k[:timeLen]).Before(cutoff)
+			if !expired && (s.opts.MaxSize == 0 || size <= s.opts.MaxSize) {
+				break
+			}
+			id := append([]byte{}, k[timeLen:]...)
+			n, err := recordSize(tx, id)
+			if err != nil {
+				return err
+			}
+			size -= n
+			ids = append(ids, id)
+		}
+
+		for _, id := range ids {
+			err := deleteRecord(tx, id)
+			if err != nil {
+				return err
+			}
+		}
+		deleted = len(ids)
+		return putSize(tx, size)
+	})
+	if err != nil {
+		return 0, xerrors.Errorf("prune records: %w", err)
+	}
+	return deleted, nil
+}
+
+// Stats contains information about the records in a store.
+type Stats struct {
+	// Records is the number of records.
+	Records int
+	// Size is the approximate size in bytes of all records and indexes, which
+	// is compared to MaxSize.
+	Size int64
+	// Oldest and Newest are the times of the oldest and newest records, or
+	// zero if the store is empty.
+	Oldest time.Time
+	Newest time.Time
+}
+
+// Stats returns information about the records in the store.
+func (s *Store) Stats() (Stats, error) {
+	var stats Stats
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		events := tx.Bucket(bucketEvents)
+		if events == nil {
+			return nil
+		}
+		stats.Records = events.Stats().KeyN
+		stats.Size = getSize(tx)
+		c := tx.Bucket(bucketTime).Cursor()
+		if k, _ := c.First(); k != nil {
+			stats.Oldest = decodeTime(k[:timeLen])
+		}
+		if k, _ := c.Last(); k != nil {
+			stats.Newest = decodeTime(k[:timeLen])
+		}
+		return nil
+	})
+	if err != nil {
+		return Stats{}, xerrors.Errorf("read stats: %w", err)
+	}
+	return stats, nil
+}
+
+// Query selects records from a store. All set filters must match.
+type Query struct {
+	// Since and Until limit the records to the ones read in [Since, Until).
+	// Zero values are unbounded.
+	Since time.Time
+	Until time.Time
+	// PID matches records of the process with this PID if non-zero.
+	PID uint32
+	// UID matches records of processes run by this user if non-nil.
+	UID *uint32
+	// PidNS matches records of processes in the PID namespace with this inum
+	// if non-zero.
+	PidNS uint32
+	// Filename matches records with exactly this filename if non-empty.
+	Filename string
+	// Reverse returns the newest records first.
+	Reverse bool
+	// Limit is the maximum number of records to return, 0 returns all
+	// records.
+	Limit int
+}
+
+// Query calls fn with each record matching the query in time order. If fn
+// returns an error, the query stops and the error is returned.
+func (s *Store) Query(q Query, fn func(*Record) error) error {
+	return s.db.View(func(tx *bbolt.Tx) error {
+		if tx.Bucket(bucketEvents) == nil {
+			// The database was created but never written to.
+			return nil
+		}
+
+		// Pick the index that is likely the most selective.
+		bucket, prefix := bucketTime, []byte(nil)
+		switch {
+		case q.Filename != "":
+			bucket, prefix = bucketFilename, filenamePrefix(q.Filename)
+		case q.PID != 0:
+			bucket, prefix = bucketPID, encodeUint32(q.PID)
+		case q.PidNS != 0:
+			bucket, prefix = bucketPidNS, encodeUint32(q.PidNS)
+		case q.UID != nil:
+			bucket, prefix = bucketUID, encodeUint32(*q.UID)
+		}
+
+		events := tx.Bucket(bucketEvents)
+		count := 0
+		it := newIndexIterator(tx.Bucket(bucket).Cursor(), prefix, q)
+		for id := it.next(); id != nil; id = it.next() {
+			value := events.Get(id)
+			if value == nil {
+				return xerrors.Errorf("index %q refers to missing record %d", bucket, decodeID(id))
+			}
+			r, err := decodeRecord(id, value)
+			if err != nil {
+				return err
+			}
+			if !q.matches(r) {
+				continue
+			}
+			err = fn(r)
+			if err != nil {
+				return err
+			}
+			count++
+			if q.Limit > 0 && count >= q.Limit {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+func (q Query) matches(r *Record) bool {
+	switch {
+	case !q.Since.IsZero() && r.Time.Before(q.Since),
+		!q.Until.IsZero() && !r.Time.Before(q.Until),
+		q.PID != 0 && r.Event.PID != q.PID,
+		q.UID != nil && r.Event.UID != *q.UID,
+		q.PidNS != 0 && r.Event.PidNS != q.PidNS,
+		q.Filename != "" && r.Event.Filename != q.Filename:
+		return false
+	}
+	return true
+}
+
+// indexIterator iterates the IDs in an index with keys of a prefix, followed
+// by the time and the ID, within the time range of a query.
+type indexIterator struct {
+	c       *bbolt.Cursor
+	prefix  []byte
+	start   []byte
+	end     []byte
+	reverse bool
+	started bool
+}
+
+func newIndexIterator(c *bbolt.Cursor, prefix []byte, q Query) *indexIterator {
+	it := &indexIterator{c: c, prefix: prefix, reverse: q.Reverse}
+	if !q.Since.IsZero() {
+		it.start = append(append([]byte{}, prefix...), encodeTime(q.Since)...)
+	}
+	if !q.Until.IsZero() {
+		it.end = append(append([]byte{}, prefix...), encodeTime(q.Until)...)
+	}
+	return it
+}
+
+// next returns the next ID, or nil when done.
+func (it *indexIterator) next() []byte {
+	var k []byte
+	switch {
+	case it.started && !it.reverse:
+		k, _ = it.c.Next()
+	case it.started:
+		k, _ = it.c.Prev()
+	case !it.reverse:
+		seek := it.start
+		if seek == nil {
+			seek = it.prefix
+		}
+		k, _ = it.c.Seek(seek)
+	default:
+		// Seek to the first key after the range and step back.
+		seek := it.end
+		if seek == nil {
+			seek = prefixEnd(it.prefix)
+		}
+		if seek == nil {
+			k, _ = it.c.Last()
+		} else if k, _ = it.c.Seek(seek); k == nil {
+			k, _ = it.c.Last()
+		} else {
+			k, _ = it.c.Prev()
+		}
+	}
+	it.started = true
+
+	if k == nil || !bytes.HasPrefix(k, it.prefix) || len(k) != len(it.prefix)+timeLen+idLen {
+		return nil
+	}
+	if it.start != nil && bytes.Compare(k, it.start) < 0 {
+		return nil
+	}
+	if it.end != nil && bytes.Compare(k, it.end) >= 0 {
+		return nil
+	}
+	return k[len(it.prefix)+timeLen:]
+}
+
+// prefixEnd returns the smallest key greater than all keys with the prefix,
+// or nil if there is none.
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte{}, prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+type indexKey struct {
+	bucket []byte
+	key    []byte
+}
+
+// indexKeys returns the index keys of a record.
+func indexKeys(r *Record, id uint64) []indexKey {
+	suffix := append(encodeTime(r.Time), encodeID(id)...)
+	key := func(prefix []byte) []byte {
+		return append(prefix, suffix...)
+	}
+	return []indexKey{
+		{bucketTime, key(nil)},
+		{bucketPID, key(encodeUint32(r.Event.PID))},
+		{bucketUID, key(encodeUint32(r.Event.UID))},
+		{bucketPidNS, key(encodeUint32(r.Event.PidNS))},
+		{bucketFilename, key(filenamePrefix(r.Event.Filename))},
+	}
+}
+
+// recordSize returns the size of a record and its index keys as counted in
+// the meta size.
+func recordSize(tx *bbolt.Tx, id []byte) (int64, error) {
+	value := tx.Bucket(bucketEvents).Get(id)
+	if value == nil {
+		return 0, xerrors.Errorf("record %d not found", decodeID(id))
+	}
+	r, err := decodeRecord(id, value)
+	if err != nil {
+		return 0, err
+	}
+	size := int64(len(id) + len(value))
+	for _, idx := range indexKeys(r, r.ID) {
+		size += int64(len(idx.key))
+	}
+	return size, nil
+}
+
+// deleteRecord deletes a record and its index keys.
+func deleteRecord(tx *bbolt.Tx, id []byte) error {
+	events := tx.Bucket(bucketEvents)
+	r, err := decodeRecord(id, events.Get(id))
+	if err != nil {
+		return err
+	}
+	for _, idx := range indexKeys(r, r.ID) {
+		err = tx.Bucket(idx.bucket).Delete(idx.key)
+		if err != nil {
+			return xerrors.Errorf("delete %q index key: %w", idx.bucket, err)
+		}
+	}
+	err = events.Delete(id)
+	if err != nil {
+		return xerrors.Errorf("delete record: %w", err)
+	}
+	return nil
+}
+
+func decodeRecord(id, value []byte) (*Record, error) {
+	var r Record
+	err := json.Unmarshal(value, &r)
+	if err != nil {
+		return nil, xerrors.Errorf("decode record %d: %w", decodeID(id), err)
+	}
+	if r.Event == nil {
+		return nil, xerrors.Errorf("record %d has no event", decodeID(id))
+	}
+	r.ID = decodeID(id)
+	return &r, nil
+}
+
+func getSize(tx *bbolt.Tx) int64 {
+	v := tx.Bucket(bucketMeta).Get(metaSize)
+	if len(v) != 8 {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(v))
+}
+
+func putSize(tx *bbolt.Tx, size int64) error {
+	if size < 0 {
+		size = 0
+	}
+	err := tx.Bucket(bucketMeta).Put(metaSize, binary.BigEndian.AppendUint64(nil, uint64(size)))
+	if err != nil {
+		return xerrors.Errorf("put size: %w", err)
+	}
+	return nil
+}
+
+// filenamePrefix returns the index key prefix of a filename. Filenames can't
+// contain NUL bytes, so the terminator ensures a filename doesn't match
+// longer filenames.
+func filenamePrefix(filename string) []byte {
+	return append([]byte(filename), 0)
+}
+
+func encodeID(id uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, id)
+}
+
+func decodeID(b []byte) uint64 {
+	return binary.BigEndian.Uint64(b)
+}
+
+func encodeUint32(v uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, v)
+}
+
+// encodeTime encodes a time so that encoded times sort in time order,
+// including times before 1970.
+func encodeTime(t time.Time) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(t.UnixNano())^(1<<63))
+}
+
+func decodeTime(b []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(b)^(1<<63))).UTC()
+}
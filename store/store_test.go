@@ -0,0 +1,190 @@
+package store_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/coder/exectrace"
+	"github.com/coder/exectrace/store"
+)
+
+var testStart = time.Date(2024, 5, 6, 7, 0, 0, 0, time.UTC)
+
+// testRecords returns a record every minute, alternating between two users,
+// PID namespaces and filenames.
+func testRecords(n int) []*store.Record {
+	records := make([]*store.Record, n)
+	for i := range records {
+		filename := "/usr/bin/git"
+		if i%2 == 1 {
+			filename = "/usr/bin/gitk"
+		}
+		records[i] = &store.Record{
+			Time: testStart.Add(time.Duration(i) * time.Minute),
+			Event: &exectrace.Event{
+				Filename: filename,
+				Argv:     []string{filepath.Base(filename), "status"},
+				PID:      uint32(100 + i),
+				UID:      uint32(1000 + i%2),
+				PidNS:    4026531836 + uint32(i%2),
+				Comm:     "bash",
+			},
+		}
+	}
+	return records
+}
+
+func openTestStore(t *testing.T, opts *store.Options, records []*store.Record) *store.Store {
+	t.Helper()
+
+	s, err := store.Open(filepath.Join(t.TempDir(), "events.db"), opts)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = s.Close()
+	})
+	require.NoError(t, s.Add(records...))
+	return s
+}
+
+func queryPIDs(t *testing.T, s *store.Store, q store.Query) []uint32 {
+	t.Helper()
+
+	pids := []uint32{}
+	err := s.Query(q, func(r *store.Record) error {
+		pids = append(pids, r.Event.PID)
+		return nil
+	})
+	require.NoError(t, err)
+	return pids
+}
+
+func TestStoreQuery(t *testing.T) {
+	t.Parallel()
+
+	records := testRecords(10)
+	s := openTestStore(t, nil, records)
+	for i, r := range records {
+		require.Equal(t, uint64(i+1), r.ID)
+	}
+
+	uid := uint32(1001)
+	for _, tc := range []struct {
+		name     string
+		query    store.Query
+		expected []uint32
+	}{
+		{"All", store.Query{}, []uint32{100, 101, 102, 103, 104, 105, 106, 107, 108, 109}},
+		{"Reverse", store.Query{Reverse: true, Limit: 3}, []uint32{109, 108, 107}},
+		{"TimeRange", store.Query{Since: testStart.Add(2 * time.Minute), Until: testStart.Add(5 * time.Minute)}, []uint32{102, 103, 104}},
+		{"TimeRangeReverse", store.Query{Since: testStart.Add(2 * time.Minute), Until: testStart.Add(5 * time.Minute), Reverse: true}, []uint32{104, 103, 102}},
+		{"PID", store.Query{PID: 105}, []uint32{105}},
+		{"UID", store.Query{UID: &uid}, []uint32{101, 103, 105, 107, 109}},
+		{"UIDReverse", store.Query{UID: &uid, Reverse: true, Limit: 2}, []uint32{109, 107}},
+		{"PidNS", store.Query{PidNS: 4026531836, Until: testStart.Add(5 * time.Minute)}, []uint32{100, 102, 104}},
+		// The filename must match exactly, not only as a prefix.
+		{"Filename", store.Query{Filename: "/usr/bin/git", Since: testStart.Add(5 * time.Minute)}, []uint32{106, 108}},
+		{"FilenameReverse", store.Query{Filename: "/usr/bin/gitk", Reverse: true}, []uint32{109, 107, 105, 103, 101}},
+		// Filters without an index used are checked on each record.
+		{"Combined", store.Query{Filename: "/usr/bin/git", UID: &uid}, []uint32{}},
+		{"NoMatch", store.Query{Filename: "/usr/bin/gi"}, []uint32{}},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			require.Equal(t, tc.expected, queryPIDs(t, s, tc.query))
+		})
+	}
+
+	t.Run("Record", func(t *testing.T) {
+		t.Parallel()
+
+		var got []*store.Record
+		err := s.Query(store.Query{PID: 101}, func(r *store.Record) error {
+			got = append(got, r)
+			return nil
+		})
+		require.NoError(t, err)
+		require.Len(t, got, 1)
+		require.Equal(t, records[1].ID, got[0].ID)
+		require.True(t, records[1].Time.Equal(got[0].Time))
+		require.Equal(t, records[1].Event, got[0].Event)
+	})
+}
+
+func TestStoreReadOnly(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "events.db")
+	_, err := store.Open(path, &store.Options{ReadOnly: true})
+	require.Error(t, err)
+
+	s, err := store.Open(path, nil)
+	require.NoError(t, err)
+	require.NoError(t, s.Add(testRecords(3)...))
+
+	// The database is locked while it's open for writing.
+	_, err = store.Open(path, &store.Options{ReadOnly: true, Timeout: 10 * time.Millisecond})
+	require.Error(t, err)
+	require.NoError(t, s.Close())
+
+	s, err = store.Open(path, &store.Options{ReadOnly: true})
+	require.NoError(t, err)
+	defer s.Close()
+	require.Equal(t, []uint32{100, 101, 102}, queryPIDs(t, s, store.Query{}))
+	require.Error(t, s.Add(testRecords(1)...))
+}
+
+func TestStorePrune(t *testing.T) {
+	t.Parallel()
+
+	t.Run("MaxAge", func(t *testing.T) {
+		t.Parallel()
+
+		s := openTestStore(t, &store.Options{MaxAge: 3 * time.Minute}, testRecords(10))
+		deleted, err := s.Prune(testStart.Add(10 * time.Minute))
+		require.NoError(t, err)
+		require.Equal(t, 7, deleted)
+		require.Equal(t, []uint32{107, 108, 109}, queryPIDs(t, s, store.Query{}))
+		// The indexes of deleted records are removed too.
+		require.Equal(t, []uint32{107, 109}, queryPIDs(t, s, store.Query{Filename: "/usr/bin/gitk"}))
+
+		stats, err := s.Stats()
+		require.NoError(t, err)
+		require.Equal(t, 3, stats.Records)
+		require.Equal(t, testStart.Add(7*time.Minute), stats.Oldest)
+		require.Equal(t, testStart.Add(9*time.Minute), stats.Newest)
+	})
+
+	t.Run("MaxSize", func(t *testing.T) {
+		t.Parallel()
+
+		s := openTestStore(t, nil, testRecords(10))
+		stats, err := s.Stats()
+		require.NoError(t, err)
+		require.Equal(t, 10, stats.Records)
+		require.Positive(t, stats.Size)
+		// Nothing is pruned without limits.
+		deleted, err := s.Prune(testStart.Add(24 * time.Hour))
+		require.NoError(t, err)
+		require.Zero(t, deleted)
+
+		// The oldest records are deleted until the size fits.
+		s = openTestStore(t, &store.Options{MaxSize: stats.Size - 1}, testRecords(10))
+		deleted, err = s.Prune(testStart)
+		require.NoError(t, err)
+		require.Equal(t, 1, deleted)
+		require.Equal(t, []uint32{101, 102, 103, 104, 105, 106, 107, 108, 109}, queryPIDs(t, s, store.Query{}))
+
+		s = openTestStore(t, &store.Options{MaxSize: stats.Size / 2}, testRecords(10))
+		deleted, err = s.Prune(testStart)
+		require.NoError(t, err)
+		require.Equal(t, 6, deleted)
+		pruned, err := s.Stats()
+		require.NoError(t, err)
+		require.Equal(t, 4, pruned.Records)
+		require.LessOrEqual(t, pruned.Size, stats.Size/2)
+	})
+}
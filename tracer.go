@@ -13,6 +13,53 @@ const pidNSPath = "/proc/self/ns/pid"
 
 var nonNumericRegex = regexp.MustCompile(`[^\d]`)
 
+var errTracerClosed = xerrors.New("tracer is closed")
+
+// DefaultKernelBTFDir is searched for an external BTF file for the running
+// kernel when the kernel doesn't expose its own BTF at /sys/kernel/btf/vmlinux.
+// Files must be named after the kernel release (`uname -r`) with a .btf
+// extension, e.g. "5.4.0-150-generic.btf", which matches the files in BTFHub
+// archives.
+const DefaultKernelBTFDir = "/var/lib/exectrace/btf"
+
+// DefaultPinPath is the directory under which TracerOpts.PinPath is usually
+// placed, e.g. "/sys/fs/bpf/exectrace/sidecar".
+const DefaultPinPath = "/sys/fs/bpf/exectrace"
+
+// Backend selects the kernel interface used by a Tracer.
+type Backend string
+
+const (
+	// BackendAuto uses the eBPF backend if it can be loaded, and falls back
+	// to the proc connector backend otherwise. Check Event.Reliability or the
+	// LogCodeBackendFallback warning to tell whether it fell back.
+	BackendAuto Backend = "auto"
+	// BackendEBPF traces execve() syscalls with an eBPF program. It requires
+	// a 5.8+ kernel with BTF support.
+	BackendEBPF Backend = "ebpf"
+	// BackendProcConnector receives exec notifications from the netlink
+	// process connector (CN_PROC) and reads process details from /proc. It
+	// works on much older kernels but is less reliable, see Reliability.
+	BackendProcConnector Backend = "proc-connector"
+)
+
+// Reliability describes how trustworthy the data in an Event is.
+type Reliability string
+
+const (
+	// ReliabilityExact means the event was captured in the kernel during the
+	// exec call, so all fields reflect the exec exactly.
+	ReliabilityExact Reliability = "exact"
+	// ReliabilityBestEffort means the event details were read from /proc
+	// after the exec completed. The process may have changed its own argv or
+	// exec'd again in the meantime.
+	ReliabilityBestEffort Reliability = "best_effort"
+	// ReliabilityPartial means some event details could not be read, usually
+	// because the process exited before /proc could be read. Missing fields
+	// are left empty.
+	ReliabilityPartial Reliability = "partial"
+)
+
 // TracerOpts contains all of the configuration options for the tracer. All are
 // optional.
 type TracerOpts struct {
@@ -27,12 +74,88 @@ type TracerOpts struct {
 	// This filter runs in the kernel for high performance.
 	PidNS uint32
 
-	// LogFn is called for each log line that is read from the kernel. All logs
-	// are considered error logs unless running a debug version of the eBPF
-	// program.
+	// PID filters processes that are the given process or its descendants,
+	// including descendants that are started after the tracer. This is
+	// useful to follow long-running processes such as an IDE server or an
+	// SSH session.
 	//
-	// If unspecified, a default log function is used that logs to stderr.
+	// The eBPF backend tracks forks and exits in the kernel, so short-lived
+	// intermediate processes are followed reliably. The proc connector
+	// backend tracks them in userspace from fork notifications.
+	PID uint32
+
+	// LogHandler is called for each structured log entry from the tracer,
+	// which includes the level, a stable code, typed arguments and the eBPF
+	// program that logged it. Use NewSlogLogHandler to log entries with
+	// log/slog.
+	//
+	// If unspecified, LogFn is used instead.
+	LogHandler LogHandler
+
+	// LogFn is called with the formatted message of each log entry if
+	// LogHandler is unspecified. Entries of all levels are passed to it,
+	// debug entries are only logged by debug versions of the eBPF program.
+	//
+	// If both are unspecified, DefaultLogHandler is used, which logs to
+	// stderr.
 	LogFn func(uid, gid, pid uint32, logLine string)
+
+	// Redact enables redaction of secrets (passwords, tokens, etc.) from the
+	// argv of each event before it is returned from Read. Events that had
+	// anything removed will have Event.Redacted set to true.
+	//
+	// If unspecified, events are not redacted. Use &RedactOpts{} to enable the
+	// built-in rules.
+	Redact *RedactOpts
+
+	// Filter is evaluated in userspace against each event before it is
+	// returned from Read. Events that don't match are dropped. The filter is
+	// evaluated before redaction. See ParseFilter for the expression syntax.
+	//
+	// Prefer kernel filters such as PidNS where possible as they are much
+	// cheaper.
+	Filter *Filter
+
+	// Backend selects how exec events are collected. If unspecified,
+	// BackendEBPF is used, so New fails if the eBPF program can't be loaded.
+	// Use BackendAuto to fall back to the proc connector backend instead.
+	//
+	// The proc connector backend applies the PID and PidNS filters in
+	// userspace and only logs when events are dropped.
+	Backend Backend
+
+	// PerfEventArray forces the eBPF backend to load the variant of the eBPF
+	// program that sends events through a perf event array instead of a
+	// ringbuf. This variant is used automatically on kernels without ringbuf
+	// support (older than 5.8), so this is mostly useful for testing.
+	PerfEventArray bool
+
+	// KernelBTFPath is the path to an external BTF file describing the running
+	// kernel, used by the eBPF backend for CO-RE relocations on kernels built
+	// without CONFIG_DEBUG_INFO_BTF. BTFHub
+	// (https://github.com/aquasecurity/btfhub) provides these files for many
+	// distribution kernels.
+	//
+	// If unspecified and /sys/kernel/btf/vmlinux doesn't exist, a file named
+	// after the kernel release is looked for in DefaultKernelBTFDir.
+	KernelBTFPath string
+
+	// PinPath is a directory on a BPF filesystem, usually a subdirectory of
+	// DefaultPinPath, where the eBPF backend pins its program, tracepoint link
+	// and maps. Pinned objects stay in the kernel after the tracer is closed
+	// or the process exits, so a new tracer with the same PinPath continues
+	// reading from the pinned ringbuf where the last one stopped. Only one
+	// tracer should use a PinPath at a time, and PinPath is ignored by the
+	// proc connector backend.
+	//
+	// The tracepoint link is only pinned if the kernel and eBPF library
+	// support it, in which case execs keep being recorded while no tracer is
+	// running. Tracepoint links backed by a perf event can't be pinned, so the
+	// program is detached when the tracer is closed.
+	//
+	// Pinned objects are reused as-is, so call Unpin after upgrading exectrace
+	// or when the tracer is torn down for good.
+	PinPath string
 }
 
 // Tracer allows consumers to read exec events from the kernel via an eBPF
@@ -47,6 +170,32 @@ type Tracer interface {
 	// FD returns the FD of the loaded eBPF program. This is useful for
 	// benchmarking.
 	FD() int
+
+	// Reload applies new options without closing the tracer. The eBPF
+	// backend loads and attaches a new copy of the eBPF program and switches
+	// over to it without detaching first, so there's no blind window, but
+	// execs that happen during the switch may be missed or returned twice.
+	// Events that weren't read yet are still returned by Read. Options that
+	// select the backend or its kernel resources can't be changed.
+	Reload(opts *TracerOpts) error
+}
+
+// LostSampleCounter is implemented by tracers that can report how many events
+// were lost in the kernel before they could be read because the consumer wasn't
+// keeping up.
+type LostSampleCounter interface {
+	// LostSamples returns the total number of events lost so far on each CPU,
+	// indexed by CPU number. It returns nil if losses aren't tracked per CPU.
+	LostSamples() []uint64
+}
+
+// ExecFailureCounter is implemented by tracers that can report how many exec
+// calls failed, e.g. because the file doesn't exist or isn't executable. Events
+// are sent when the exec starts, so failed execs are also returned by Read.
+type ExecFailureCounter interface {
+	// FailedExecs returns the total number of failed exec calls so far that
+	// passed the kernel filters. The userspace Filter isn't applied.
+	FailedExecs() (uint64, error)
 }
 
 // Event contains data about each exec event with many fields for easy
@@ -63,23 +212,48 @@ type Event struct {
 	// It may indicate that the user or process is trying to hide arguments from
 	// the tracer.
 	Truncated bool `json:"truncated"`
+	// Redacted is true if one or more secrets were removed from Argv by the
+	// tracer's redaction rules. See TracerOpts.Redact.
+	Redacted bool `json:"redacted"`
 
 	// These values are of the new process. Keep in mind that the exec call may
 	// fail and the PID will be released in such a case.
 	PID uint32 `json:"pid"`
 	UID uint32 `json:"uid"`
 	GID uint32 `json:"gid"`
+	// PPID is the PID of the parent of the process that called exec, which
+	// is the parent of the new process. It is 0 if unknown, e.g. for events
+	// recorded by older versions.
+	PPID uint32 `json:"ppid,omitempty"`
+	// PidNS is the inum of the PID namespace of the process that called exec,
+	// as used by the PidNS filter. It is 0 if unknown, e.g. for events
+	// recorded by older versions.
+	PidNS uint32 `json:"pid_ns,omitempty"`
 
 	// Comm is the "name" of the parent process, usually the filename of the
 	// executable (but not always).
 	Comm string `json:"comm"`
+
+	// Reliability indicates how trustworthy the fields above are, which
+	// depends on the tracer backend. It is empty if unknown, e.g. for events
+	// recorded by older versions.
+	Reliability Reliability `json:"reliability,omitempty"`
 }
 
 // GetPidNS returns the inum of the PidNS used by the current process.
 func GetPidNS() (uint32, error) {
-	rawPidNS, err := os.Readlink(pidNSPath)
+	return readPidNS(pidNSPath)
+}
+
+// GetPidNSOf returns the inum of the PidNS used by the given process.
+func GetPidNSOf(pid uint32) (uint32, error) {
+	return readPidNS("/proc/" + strconv.FormatUint(uint64(pid), 10) + "/ns/pid")
+}
+
+func readPidNS(path string) (uint32, error) {
+	rawPidNS, err := os.Readlink(path)
 	if err != nil {
-		return 0, xerrors.Errorf("readlink %v: %w", pidNSPath, err)
+		return 0, xerrors.Errorf("readlink %v: %w", path, err)
 	}
 
 	rawPidNS = nonNumericRegex.ReplaceAllString(rawPidNS, "")
@@ -10,14 +10,16 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"os"
 	"runtime"
 	"runtime/debug"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/cilium/ebpf"
 	"github.com/cilium/ebpf/link"
-	"github.com/cilium/ebpf/ringbuf"
 	"github.com/cilium/ebpf/rlimit"
 	"github.com/hashicorp/go-multierror"
 	"golang.org/x/sys/unix"
@@ -26,17 +28,22 @@ import (
 
 // These constants are defined in `bpf/handler.c` and must be kept in sync.
 const (
-	arglen     = 32
-	argsize    = 1024
-	logfmtsize = 1024
-	logarglen  = 3
+	arglen    = 32
+	argsize   = 1024
+	logarglen = 3
 )
 
-var errTracerClosed = xerrors.New("tracer is closed")
+// Indexes in the filters map. These must be kept in sync with
+// `bpf/handler.c`.
+const (
+	filterPidNSIdx      uint32 = 0
+	filterGenerationIdx uint32 = 1
+	filterTrackedIdx    uint32 = 2
+)
 
 // event contains details about each exec call, sent from the eBPF program to
-// userspace through a perf ring buffer. This type must be kept in sync with
-// `event_t` in `bpf/handler.c`.
+// userspace through a ringbuf or perf event array. This type must be kept in
+// sync with `event_t` in `bpf/handler.c`.
 type event struct {
 	// Details about the process being launched.
 	Filename [argsize]byte
@@ -45,64 +52,196 @@ type event struct {
 	UID      uint32
 	GID      uint32
 	PID      uint32
+	PPID     uint32
+	PidNS    uint32
 
 	// Name of the calling process.
 	Comm [argsize]byte
 }
 
-// logEntry contains each kernel log entry from the logs ringbuf. This type must
-// be kept in sync with `log_entry_t` in `bpf/handler.c`.
+// logEntry contains each kernel log entry from the logs ringbuf or perf event
+// array. This type must be kept in sync with `log_entry_t` in `bpf/handler.c`.
 type logEntry struct {
-	UID uint32
-	GID uint32
-	PID uint32
-	Fmt [logfmtsize]byte
-	// Args are uint32s but depending on the format string, they may be
-	// interpreted as int32s instead.
+	// KTime is from bpf_ktime_get_ns(), which uses CLOCK_MONOTONIC.
+	KTime uint64
+	UID   uint32
+	GID   uint32
+	PID   uint32
+	Level uint32
+	Code  uint32
+	Prog  uint32
+	Argc  uint32
+	// Args are uint32s but depending on the code, they may be interpreted as
+	// int32s instead.
 	Arg [logarglen]uint32
 }
 
-type tracer struct {
-	opts *TracerOpts
+// toLogEntry converts the raw entry. monotonicNow and now are the current
+// CLOCK_MONOTONIC and wall clock times, which are used to convert the kernel
+// time.
+func (e *logEntry) toLogEntry(monotonicNow time.Duration, now time.Time) LogEntry {
+	entry := LogEntry{
+		Level:      LogLevel(e.Level),
+		Code:       LogCode(e.Code),
+		Program:    "program(" + strconv.FormatUint(uint64(e.Prog), 10) + ")",
+		KernelTime: time.Duration(e.KTime),
+		UID:        e.UID,
+		GID:        e.GID,
+		PID:        e.PID,
+	}
+	entry.Time = now.Add(entry.KernelTime - monotonicNow)
+	if int(e.Prog) < len(logPrograms) && logPrograms[e.Prog] != "" {
+		entry.Program = logPrograms[e.Prog]
+	}
 
+	specs := logCodes[entry.Code].args
+	for i := 0; i < int(e.Argc) && i < logarglen; i++ {
+		arg := LogArg{Name: "arg" + strconv.Itoa(i), Value: e.Arg[i]}
+		if i < len(specs) {
+			arg.Name = specs[i].name
+			if specs[i].signed {
+				arg.Value = int32(e.Arg[i])
+			}
+		}
+		entry.Args = append(entry.Args, arg)
+	}
+	return entry
+}
+
+type tracer struct {
+	// configLock guards opts and redactor, which are replaced by Reload.
+	configLock sync.RWMutex
+	opts       *TracerOpts
+	redactor   *Redactor
+
+	// perf is true if the perf event array variant of the eBPF program is
+	// loaded instead of the ringbuf variant.
+	perf bool
 	objs *bpfObjects
 	tp   link.Link
+	// exitTp attaches the program that counts failed execs. It's attached
+	// once and kept when reloading.
+	exitTp link.Link
+	// schedLinks attach the programs that maintain the tracked PIDs map.
+	schedLinks []link.Link
+	// generation of the attached program, incremented by each Reload.
+	generation uint32
 
-	rbEvents *ringbuf.Reader
-	rbLogs   *ringbuf.Reader
+	events sampleReader
+	logs   sampleReader
 
 	closeLock sync.Mutex
 	closed    chan struct{}
 }
 
-var _ Tracer = &tracer{}
+var (
+	_ Tracer             = &tracer{}
+	_ LostSampleCounter  = &tracer{}
+	_ ExecFailureCounter = &tracer{}
+)
 
 // New instantiates all of the BPF objects into the running kernel, starts
 // tracing, and returns the created Tracer. After calling this successfully, the
 // caller should immediately attach a for loop running `h.Read()`.
 //
+// If opts.Backend is BackendAuto and the eBPF program can't be loaded, the proc
+// connector backend is used instead and a LogCodeBackendFallback warning is
+// sent to the log handler.
+//
 // The returned Tracer MUST be closed to avoid leaking kernel resources.
 func New(opts *TracerOpts) (Tracer, error) {
 	if opts == nil {
 		opts = &TracerOpts{}
 	}
-	if opts.LogFn == nil {
-		opts.LogFn = func(uid, gid, pid uint32, logLine string) {
-			log.Printf("error log from exectrace tracer (uid=%v, gid=%v, pid=%v): %s", uid, gid, pid, logLine)
+	redactor, err := prepareOpts(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	switch opts.Backend {
+	case BackendProcConnector:
+		t, err := newProcConnectorTracer(opts, redactor)
+		if err != nil {
+			return nil, err
+		}
+		return t, nil
+	case BackendEBPF, "":
+		t, err := newEBPFTracer(opts, redactor)
+		if err != nil {
+			return nil, err
+		}
+		return t, nil
+	case BackendAuto:
+		t, err := newEBPFTracer(opts, redactor)
+		if err == nil {
+			return t, nil
+		}
+		pt, pcErr := newProcConnectorTracer(opts, redactor)
+		if pcErr != nil {
+			return nil, xerrors.Errorf("proc connector fallback failed (%v) after eBPF backend failed: %w", pcErr, err)
+		}
+		entry := newLogEntry(LogCodeBackendFallback, err)
+		entry.Level = LogLevelWarn
+		opts.LogHandler(entry)
+		return pt, nil
+	default:
+		return nil, xerrors.Errorf("unknown tracer backend %q", opts.Backend)
+	}
+}
+
+// prepareOpts sets defaults in opts and creates the redactor, if any.
+func prepareOpts(opts *TracerOpts) (*Redactor, error) {
+	if opts.LogHandler == nil {
+		if opts.LogFn != nil {
+			opts.LogHandler = logFnHandler(opts.LogFn)
+		} else {
+			opts.LogHandler = DefaultLogHandler
 		}
 	}
 
-	objs, err := loadBPFObjects()
+	if opts.Redact == nil {
+		return nil, nil
+	}
+	redactor, err := NewRedactor(opts.Redact)
+	if err != nil {
+		return nil, xerrors.Errorf("create redactor: %w", err)
+	}
+	return redactor, nil
+}
+
+// newEBPFTracer creates a tracer using the eBPF backend. The perf event array
+// variant of the eBPF program is used if the kernel doesn't support ringbufs.
+func newEBPFTracer(opts *TracerOpts, redactor *Redactor) (*tracer, error) {
+	release, err := kernelRelease()
+	if err != nil {
+		return nil, err
+	}
+	if !kernelAtLeast(release, 5, 4) {
+		return nil, withKind(ErrUnsupportedKernel, fmt.Sprintf("kernel %s is older than 5.4", release), nil)
+	}
+
+	perf := opts.PerfEventArray || !haveRingbuf()
+	objs, err := loadBPFObjects(bpfLoadOpts{
+		Perf:          perf,
+		KernelBTFPath: opts.KernelBTFPath,
+		PinPath:       opts.PinPath,
+	})
 	if err != nil {
 		return nil, xerrors.Errorf("load BPF objects: %w", err)
 	}
 
 	t := &tracer{
-		opts:     opts,
-		objs:     objs,
-		tp:       nil,
-		rbEvents: nil,
-		rbLogs:   nil,
+		configLock: sync.RWMutex{},
+		opts:       opts,
+		redactor:   redactor,
+		perf:       perf,
+		objs:       objs,
+		tp:         nil,
+		exitTp:     nil,
+		schedLinks: nil,
+		generation: 0,
+		events:     nil,
+		logs:       nil,
 
 		closeLock: sync.Mutex{},
 		closed:    make(chan struct{}),
@@ -134,9 +273,45 @@ func New(opts *TracerOpts) (Tracer, error) {
 }
 
 func (t *tracer) FD() int {
+	t.closeLock.Lock()
+	defer t.closeLock.Unlock()
 	return t.objs.EnterExecveProg.FD()
 }
 
+// LostSamples returns the number of events lost so far on each CPU because the
+// perf buffer was full. It returns nil when the ringbuf variant of the eBPF
+// program is loaded, which reports each dropped event to the LogHandler as a
+// LogCodeReserveEvent entry instead.
+func (t *tracer) LostSamples() []uint64 {
+	if pr, ok := t.events.(*perfReader); ok {
+		return pr.lostSamples()
+	}
+	return nil
+}
+
+// FailedExecs returns the number of execve() calls that failed since the tracer
+// started, or since the maps were pinned if PinPath is set.
+func (t *tracer) FailedExecs() (uint64, error) {
+	t.closeLock.Lock()
+	defer t.closeLock.Unlock()
+	select {
+	case <-t.closed:
+		return 0, errTracerClosed
+	default:
+	}
+
+	var perCPU []uint64
+	err := t.objs.ExecFailuresMap.Lookup(uint32(0), &perCPU)
+	if err != nil {
+		return 0, xerrors.Errorf("read exec failures from eBPF map: %w", err)
+	}
+	var total uint64
+	for _, n := range perCPU {
+		total += n
+	}
+	return total, nil
+}
+
 func (t *tracer) start() error {
 	// If we don't startup successfully, we need to make sure all of the stuff
 	// is cleaned up properly or we'll be leaking kernel resources.
@@ -152,66 +327,191 @@ func (t *tracer) start() error {
 	// nothing on 5.11+ kernels which don't need this.
 	err := rlimit.RemoveMemlock()
 	if err != nil {
+		if xerrors.Is(err, os.ErrPermission) {
+			return withKind(ErrPermission, "remove memlock", err)
+		}
 		return xerrors.Errorf("remove memlock: %w", err)
 	}
 
-	// Set filter options on the filters map.
-	if t.opts.PidNS != 0 {
-		err = t.objs.FiltersMap.Update(uint32(0), t.opts.PidNS, ebpf.UpdateAny)
+	// Set filter options on the filters map. Pinned maps may have been
+	// configured by a previous tracer, so they're always overwritten.
+	if t.opts.PidNS != 0 || t.opts.PinPath != "" {
+		err = t.objs.FiltersMap.Update(filterPidNSIdx, t.opts.PidNS, ebpf.UpdateAny)
 		if err != nil {
 			return xerrors.Errorf("apply PID NS filter to eBPF map: %w", err)
 		}
 	}
 
+	// The PID filter relies on the programs that track forks, so they're
+	// attached first. Pinned maps may also contain processes tracked by a
+	// previous tracer.
+	t.schedLinks, err = attachSchedTracepoints(t.objs)
+	if err != nil {
+		return err
+	}
+	if t.opts.PID != 0 || t.opts.PinPath != "" {
+		err = t.objs.trackPIDs(t.opts.PID)
+		if err != nil {
+			return xerrors.Errorf("apply PID filter: %w", err)
+		}
+	}
+
+	// Activate the program. A program reused from a pin is already active,
+	// and its generation is only known from the filters map.
+	if t.objs.pinnedProgram {
+		err = t.objs.FiltersMap.Lookup(filterGenerationIdx, &t.generation)
+	} else {
+		err = t.objs.FiltersMap.Update(filterGenerationIdx, t.generation, ebpf.UpdateAny)
+	}
+	if err != nil {
+		return xerrors.Errorf("set active program generation in eBPF map: %w", err)
+	}
+
 	// Attach the eBPF program to the `sys_enter_execve` tracepoint, which
 	// is triggered at the beginning of each `execve()` syscall.
-	t.tp, err = link.Tracepoint("syscalls", "sys_enter_execve", t.objs.EnterExecveProg, nil)
+	t.tp, err = attachTracepoint(t.objs.EnterExecveProg, t.opts.PinPath)
 	if err != nil {
-		return xerrors.Errorf("open tracepoint: %w", err)
+		return xerrors.Errorf("open tracepoint: %w", classifyTracepointError(err))
 	}
-
-	// Create the reader for the event ringbuf.
-	t.rbEvents, err = ringbuf.NewReader(t.objs.EventsMap)
+	t.exitTp, err = link.Tracepoint("syscalls", "sys_exit_execve", t.objs.ExitExecveProg, nil)
 	if err != nil {
-		return xerrors.Errorf("open events ringbuf reader: %w", err)
+		return xerrors.Errorf("open exit tracepoint: %w", classifyTracepointError(err))
 	}
 
-	// Create the reader for the log ringbuf.
-	t.rbLogs, err = ringbuf.NewReader(t.objs.LogsMap)
+	// Create the readers for the events and logs.
+	t.events, err = t.newSampleReader(t.objs.EventsMap)
+	if err != nil {
+		return xerrors.Errorf("open events reader: %w", err)
+	}
+	t.logs, err = t.newSampleReader(t.objs.LogsMap)
 	if err != nil {
-		return xerrors.Errorf("open logs ringbuf reader: %w", err)
+		return xerrors.Errorf("open logs reader: %w", err)
 	}
 
 	// Start slurping up logs.
-	go t.readLogs(t.rbLogs, t.opts.LogFn)
+	go t.readLogs(t.logs)
 
 	ok = true
 	return nil
 }
 
-// Read reads an event from the eBPF program via the ringbuf, parses it and
-// returns it. If the *tracer is closed during the blocked call, and error that
-// wraps io.EOF will be returned.
+// attachSchedTracepoints attaches the programs that track forked and exited
+// processes for the PID filter. They do nothing while the filter is disabled.
+func attachSchedTracepoints(objs *bpfObjects) ([]link.Link, error) {
+	var links []link.Link
+	for _, tp := range []struct {
+		name string
+		prog *ebpf.Program
+	}{
+		{"sched_process_fork", objs.SchedProcessForkProg},
+		{"sched_process_exit", objs.SchedProcessExitProg},
+	} {
+		l, err := link.AttachRawTracepoint(link.RawTracepointOptions{
+			Name:    tp.name,
+			Program: tp.prog,
+		})
+		if err != nil {
+			closeLinks(links)
+			return nil, xerrors.Errorf("open raw tracepoint %q: %w", tp.name, classifyTracepointError(err))
+		}
+		links = append(links, l)
+	}
+	return links, nil
+}
+
+// closeLinks closes all of the given links and returns the errors.
+func closeLinks(links []link.Link) error {
+	var merr error
+	for _, l := range links {
+		err := l.Close()
+		if err != nil {
+			merr = multierror.Append(merr, err)
+		}
+	}
+	return merr
+}
+
+// classifyTracepointError wraps an error from attaching to the
+// sys_enter_execve tracepoint with the matching exported error, if any.
+func classifyTracepointError(err error) error {
+	if xerrors.Is(err, os.ErrPermission) {
+		return withKind(ErrPermission, "attach to syscalls:sys_enter_execve", err)
+	}
+	if xerrors.Is(err, os.ErrNotExist) {
+		return withKind(ErrTracepointMissing, "syscalls:sys_enter_execve does not exist, the kernel may be built without CONFIG_FTRACE_SYSCALLS", err)
+	}
+	if _, tracefs := checkTracefs(); tracefs == "" {
+		return withKind(ErrTracepointMissing, "tracefs is not mounted at /sys/kernel/tracing or /sys/kernel/debug/tracing", err)
+	}
+	return err
+}
+
+// newSampleReader creates a reader for the given events or logs map depending
+// on the loaded variant of the eBPF program.
+func (t *tracer) newSampleReader(m *ebpf.Map) (sampleReader, error) {
+	if t.perf {
+		rd, err := newPerfReader(m)
+		if err != nil {
+			return nil, xerrors.Errorf("create perf reader: %w", err)
+		}
+		return rd, nil
+	}
+	rd, err := newRingbufReader(m)
+	if err != nil {
+		return nil, xerrors.Errorf("create ringbuf reader: %w", err)
+	}
+	return rd, nil
+}
+
+// Read reads an event from the eBPF program via the ringbuf (or perf event
+// array), parses it and returns it. If the *tracer is closed during the blocked
+// call, and error that wraps io.EOF will be returned.
+//
+// Events that don't match the userspace filter are skipped, and secrets are
+// redacted from the returned event if configured.
 func (t *tracer) Read() (*Event, error) {
-	rb := t.rbEvents
-	if rb == nil {
-		return nil, xerrors.Errorf("events ringbuf reader is not initialized: %w", io.EOF)
+	for {
+		ev, err := t.readEvent()
+		if err != nil {
+			return nil, err
+		}
+
+		t.configLock.RLock()
+		filter, redactor := t.opts.Filter, t.redactor
+		t.configLock.RUnlock()
+
+		if filter != nil && !filter.Match(ev) {
+			continue
+		}
+		if redactor != nil {
+			redactor.RedactEvent(ev)
+		}
+
+		return ev, nil
 	}
+}
 
-	record, err := rb.Read()
+// readEvent reads a single raw event from the events reader and parses it.
+func (t *tracer) readEvent() (*Event, error) {
+	rd := t.events
+	if rd == nil {
+		return nil, xerrors.Errorf("events reader is not initialized: %w", io.EOF)
+	}
+
+	sample, err := rd.Read()
 	if err != nil {
-		if errors.Is(err, ringbuf.ErrClosed) {
+		if errors.Is(err, os.ErrClosed) {
 			return nil, xerrors.Errorf("tracer closed: %w", io.EOF)
 		}
 
-		return nil, xerrors.Errorf("read from ringbuf: %w", err)
+		return nil, xerrors.Errorf("read event: %w", err)
 	}
 
-	// Parse the ringbuf event entry into an event structure.
+	// Parse the raw event entry into an event structure.
 	var rawEvent event
-	err = binary.Read(bytes.NewBuffer(record.RawSample), NativeEndian, &rawEvent)
+	err = binary.Read(bytes.NewBuffer(sample), NativeEndian, &rawEvent)
 	if err != nil {
-		return nil, xerrors.Errorf("parse raw ringbuf entry into event struct: %w", err)
+		return nil, xerrors.Errorf("parse raw event entry into event struct: %w", err)
 	}
 
 	ev := &Event{
@@ -219,9 +519,13 @@ func (t *tracer) Read() (*Event, error) {
 		Argv:      []string{}, // populated below
 		Truncated: rawEvent.Argc == arglen+1,
 		PID:       rawEvent.PID,
+		PPID:      rawEvent.PPID,
+		PidNS:     rawEvent.PidNS,
 		UID:       rawEvent.UID,
 		GID:       rawEvent.GID,
 		Comm:      unix.ByteSliceToString(rawEvent.Comm[:]),
+
+		Reliability: ReliabilityExact,
 	}
 
 	// Copy only the args we're allowed to read from the array. If we read more
@@ -246,58 +550,181 @@ func (t *tracer) Read() (*Event, error) {
 	return ev, nil
 }
 
-func (t *tracer) readLogs(rbLogs *ringbuf.Reader, logFn func(uid, gid, pid uint32, logLine string)) {
+// log calls the current LogHandler, which may be replaced by Reload.
+func (t *tracer) log(entry LogEntry) {
+	t.configLock.RLock()
+	handler := t.opts.LogHandler
+	t.configLock.RUnlock()
+	handler(entry)
+}
+
+func (t *tracer) readLogs(logs sampleReader) {
 	defer func() {
 		if r := recover(); r != nil {
-			logFn(0, 0, 0, fmt.Sprintf("panic in (*tracer).readLogs() goroutine: %v", r))
+			t.log(newLogEntry(LogCodePanic, r))
 			_ = t.Close()
 		}
 	}()
 
 	for {
-		record, err := rbLogs.Read()
+		sample, err := logs.Read()
 		if err != nil {
-			if errors.Is(err, ringbuf.ErrClosed) {
+			if errors.Is(err, os.ErrClosed) {
 				return
 			}
 
-			logFn(0, 0, 0, fmt.Sprintf("read from logs ringbuf: %+v", err))
+			t.log(newLogEntry(LogCodeReadLog, err))
 			continue
 		}
 
-		var logEntry logEntry
-		err = binary.Read(bytes.NewBuffer(record.RawSample), NativeEndian, &logEntry)
+		var raw logEntry
+		err = binary.Read(bytes.NewBuffer(sample), NativeEndian, &raw)
 		if err != nil {
-			logFn(0, 0, 0, fmt.Sprintf("parse raw ringbuf entry into logEntry struct: %+v", err))
+			t.log(newLogEntry(LogCodeParseLog, err))
 			continue
 		}
 
-		// Format the log line.
-		// 1. Find all %u and %d directives in the string (this is all we
-		//    support).
-		// 2. For each:
-		//    1. If it's a %u, replace it with the next uint32 in the args.
-		//    2. If it's a %d, cast the next uint32 to an int32 and replace.
-		logLine := unix.ByteSliceToString(logEntry.Fmt[:])
-		for i := 0; i < logarglen; i++ {
-			arg := logEntry.Arg[i]
-
-			// Find the next %u or %d in the log line.
-			uIndex := strings.Index(logLine, `%u`)
-			dIndex := strings.Index(logLine, `%d`)
-			if uIndex == -1 && dIndex == -1 {
-				break
-			}
-			if uIndex < dIndex || dIndex == -1 {
-				logLine = strings.Replace(logLine, `%u`, fmt.Sprint(arg), 1)
-			}
-			if dIndex < uIndex || uIndex == -1 {
-				logLine = strings.Replace(logLine, `%d`, fmt.Sprint(int32(arg)), 1)
-			}
+		var ts unix.Timespec
+		err = unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts)
+		if err != nil {
+			t.log(newLogEntry(LogCodeParseLog, xerrors.Errorf("get monotonic time: %w", err)))
+			continue
 		}
+		t.log(raw.toLogEntry(time.Duration(ts.Nano()), time.Now()))
+	}
+}
 
-		logFn(logEntry.UID, logEntry.GID, logEntry.PID, logLine)
+// Reload loads a new copy of the eBPF program and swaps it in for the attached
+// one, then applies opts. The events ringbuf is kept, so events that weren't
+// read yet are still returned by Read. The new program is attached alongside
+// the old one before the old one is detached, and each program only emits
+// events while its generation is active. Both programs check the generation
+// separately for each exec, so an exec that runs while it changes may be
+// missed or emitted by both, depending on the order they run in.
+//
+// Backend, PerfEventArray and PinPath can't be changed by reloading. If Reload
+// fails, the old program stays attached and the old options are kept.
+func (t *tracer) Reload(opts *TracerOpts) error {
+	if opts == nil {
+		opts = &TracerOpts{}
 	}
+	switch opts.Backend {
+	case "", BackendAuto, BackendEBPF:
+	default:
+		return xerrors.Errorf("can't change the backend of an eBPF tracer to %q", opts.Backend)
+	}
+	if (opts.PerfEventArray || !haveRingbuf()) != t.perf {
+		return xerrors.New("can't change PerfEventArray when reloading")
+	}
+	redactor, err := prepareOpts(opts)
+	if err != nil {
+		return err
+	}
+
+	t.closeLock.Lock()
+	defer t.closeLock.Unlock()
+	select {
+	case <-t.closed:
+		return errTracerClosed
+	default:
+	}
+	if opts.PinPath != t.opts.PinPath {
+		return xerrors.New("can't change PinPath when reloading")
+	}
+
+	generation := t.generation + 1
+	objs, err := loadBPFObjects(bpfLoadOpts{
+		Perf:          t.perf,
+		KernelBTFPath: opts.KernelBTFPath,
+		PinPath:       opts.PinPath,
+		Generation:    generation,
+		Maps:          t.objs,
+	})
+	if err != nil {
+		return xerrors.Errorf("load BPF objects: %w", err)
+	}
+	// The new program uses clones of the current maps. Keep using the
+	// current maps so the readers stay valid.
+	for _, m := range []*ebpf.Map{objs.EventsMap, objs.LogsMap, objs.FiltersMap, objs.TrackedPidsMap, objs.ExecFailuresMap} {
+		_ = m.Close()
+	}
+	objs.EventsMap, objs.LogsMap, objs.FiltersMap, objs.TrackedPidsMap, objs.ExecFailuresMap = t.objs.EventsMap, t.objs.LogsMap, t.objs.FiltersMap, t.objs.TrackedPidsMap, t.objs.ExecFailuresMap
+	closeObjs := func(o *bpfObjects) {
+		o.EventsMap, o.LogsMap, o.FiltersMap, o.TrackedPidsMap, o.ExecFailuresMap = nil, nil, nil, nil, nil
+		_ = o.Close()
+	}
+
+	// The new program doesn't emit anything until its generation is active.
+	tp, err := link.Tracepoint("syscalls", "sys_enter_execve", objs.EnterExecveProg, nil)
+	if err != nil {
+		closeObjs(objs)
+		return xerrors.Errorf("open tracepoint: %w", classifyTracepointError(err))
+	}
+	schedLinks, err := attachSchedTracepoints(objs)
+	if err != nil {
+		_ = tp.Close()
+		closeObjs(objs)
+		return err
+	}
+
+	err = t.objs.FiltersMap.Update(filterPidNSIdx, opts.PidNS, ebpf.UpdateAny)
+	if err != nil {
+		_ = closeLinks(schedLinks)
+		_ = tp.Close()
+		closeObjs(objs)
+		return xerrors.Errorf("apply PID NS filter to eBPF map: %w", err)
+	}
+	if opts.PID != t.opts.PID {
+		err = t.objs.trackPIDs(opts.PID)
+		if err != nil {
+			// Best effort.
+			_ = t.objs.FiltersMap.Update(filterPidNSIdx, t.opts.PidNS, ebpf.UpdateAny)
+			_ = t.objs.trackPIDs(t.opts.PID)
+			_ = closeLinks(schedLinks)
+			_ = tp.Close()
+			closeObjs(objs)
+			return xerrors.Errorf("apply PID filter: %w", err)
+		}
+	}
+	// This is the handover, the old program stops emitting events and the
+	// new program starts, which isn't atomic for execs that are already
+	// running either program.
+	err = t.objs.FiltersMap.Update(filterGenerationIdx, generation, ebpf.UpdateAny)
+	if err != nil {
+		// Best effort.
+		_ = t.objs.FiltersMap.Update(filterPidNSIdx, t.opts.PidNS, ebpf.UpdateAny)
+		if opts.PID != t.opts.PID {
+			_ = t.objs.trackPIDs(t.opts.PID)
+		}
+		_ = closeLinks(schedLinks)
+		_ = tp.Close()
+		closeObjs(objs)
+		return xerrors.Errorf("set active program generation in eBPF map: %w", err)
+	}
+
+	var merr error
+	err = t.tp.Close()
+	if err != nil {
+		merr = multierror.Append(merr, xerrors.Errorf("close old tracepoint: %w", err))
+	}
+	err = closeLinks(t.schedLinks)
+	if err != nil {
+		merr = multierror.Append(merr, xerrors.Errorf("close old raw tracepoints: %w", err))
+	}
+	closeObjs(t.objs)
+	t.objs, t.tp, t.schedLinks, t.generation = objs, tp, schedLinks, generation
+	if opts.PinPath != "" {
+		err = repin(opts.PinPath, objs.EnterExecveProg, tp)
+		if err != nil {
+			merr = multierror.Append(merr, err)
+		}
+	}
+
+	t.configLock.Lock()
+	t.opts, t.redactor = opts, redactor
+	t.configLock.Unlock()
+
+	return merr
 }
 
 // Close gracefully closes and frees all resources associated with the eBPF
@@ -316,16 +743,16 @@ func (t *tracer) Close() error {
 
 	// Close everything started in h.Start() in reverse order.
 	var merr error
-	if t.rbLogs != nil {
-		err := t.rbLogs.Close()
+	if t.logs != nil {
+		err := t.logs.Close()
 		if err != nil {
-			merr = multierror.Append(merr, xerrors.Errorf("close logs ringbuf reader: %w", err))
+			merr = multierror.Append(merr, xerrors.Errorf("close logs reader: %w", err))
 		}
 	}
-	if t.rbEvents != nil {
-		err := t.rbEvents.Close()
+	if t.events != nil {
+		err := t.events.Close()
 		if err != nil {
-			merr = multierror.Append(merr, xerrors.Errorf("close events ringbuf reader: %w", err))
+			merr = multierror.Append(merr, xerrors.Errorf("close events reader: %w", err))
 		}
 	}
 	if t.tp != nil {
@@ -334,6 +761,16 @@ func (t *tracer) Close() error {
 			merr = multierror.Append(merr, xerrors.Errorf("close tracepoint: %w", err))
 		}
 	}
+	if t.exitTp != nil {
+		err := t.exitTp.Close()
+		if err != nil {
+			merr = multierror.Append(merr, xerrors.Errorf("close exit tracepoint: %w", err))
+		}
+	}
+	err := closeLinks(t.schedLinks)
+	if err != nil {
+		merr = multierror.Append(merr, xerrors.Errorf("close raw tracepoints: %w", err))
+	}
 	if t.objs != nil {
 		err := t.objs.Close()
 		if err != nil {
@@ -10,12 +10,16 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"syscall"
 	"testing"
 	"time"
+	"unsafe"
 
+	"github.com/cilium/ebpf"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
 	"golang.org/x/xerrors"
 
 	"github.com/coder/exectrace"
@@ -57,12 +61,17 @@ func TestExectrace(t *testing.T) {
 		}
 	})
 
+	pidNS, err := exectrace.GetPidNS()
+	require.NoError(t, err)
 	event := getLogEntry(ctx, t, tracer, expected)
 	require.Equal(t, filename, event.Filename, "event.Filename")
 	require.Equal(t, args, event.Argv, "event.Argv")
 	require.False(t, event.Truncated, "event.Truncated is true")
+	require.Equal(t, exectrace.ReliabilityExact, event.Reliability, "event.Reliability")
 	require.NotEqualValues(t, event.PID, 0, "event.PID should not be 0")
 	require.NotEqual(t, event.PID, os.Getpid(), "event.PID should not be the parent PID")
+	require.EqualValues(t, os.Getpid(), event.PPID, "event.PPID should be the parent PID")
+	require.Equal(t, pidNS, event.PidNS, "event.PidNS should be our PidNS")
 	require.EqualValues(t, event.UID, uid, "event.UID should match custom UID")
 	require.EqualValues(t, event.GID, gid, "event.GID should match custom GID")
 
@@ -77,6 +86,272 @@ func TestExectrace(t *testing.T) {
 	<-processDone
 }
 
+//nolint:paralleltest
+func TestExectracePerfEventArray(t *testing.T) {
+	// This test must be run as root so we can start exectrace.
+	if os.Geteuid() != 0 {
+		t.Fatal("must be run as root")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	tracer, err := exectrace.New(&exectrace.TracerOpts{
+		Backend:        exectrace.BackendEBPF,
+		PerfEventArray: true,
+		LogFn: func(uid, gid, pid uint32, logLine string) {
+			t.Errorf("tracer error log (uid=%v, gid=%v, pid=%v): %s", uid, gid, pid, logLine)
+		},
+	})
+	require.NoError(t, err)
+	defer tracer.Close()
+
+	// Launch processes.
+	const (
+		expected = "hello exectrace perf event array test"
+		uid      = 1000
+		gid      = 2000
+	)
+	args := []string{"sh", "-c", "# " + expected}
+	filename, err := exec.LookPath(args[0])
+	require.NoError(t, err)
+	processDone := spamProcess(ctx, t, args, func(cmd *exec.Cmd) {
+		cmd.SysProcAttr = &syscall.SysProcAttr{
+			Credential: &syscall.Credential{
+				Uid: uid,
+				Gid: gid,
+			},
+		}
+	})
+
+	event := getLogEntry(ctx, t, tracer, expected)
+	require.Equal(t, filename, event.Filename, "event.Filename")
+	require.Equal(t, args, event.Argv, "event.Argv")
+	require.False(t, event.Truncated, "event.Truncated is true")
+	require.Equal(t, exectrace.ReliabilityExact, event.Reliability, "event.Reliability")
+	require.EqualValues(t, os.Getpid(), event.PPID, "event.PPID should be the parent PID")
+	require.EqualValues(t, event.UID, uid, "event.UID should match custom UID")
+	require.EqualValues(t, event.GID, gid, "event.GID should match custom GID")
+
+	// Lost samples are tracked for each CPU.
+	counter, ok := tracer.(exectrace.LostSampleCounter)
+	require.True(t, ok, "tracer should implement LostSampleCounter")
+	cpus, err := ebpf.PossibleCPU()
+	require.NoError(t, err)
+	require.Len(t, counter.LostSamples(), cpus)
+
+	cancel()
+	<-processDone
+}
+
+//nolint:paralleltest
+func TestExectraceKernelBTFPath(t *testing.T) {
+	// This test must be run as root so we can start exectrace.
+	if os.Geteuid() != 0 {
+		t.Fatal("must be run as root")
+	}
+
+	//nolint:paralleltest
+	t.Run("External", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+
+		// Copy the kernel's own BTF to a file to use it as external BTF.
+		btf, err := os.ReadFile("/sys/kernel/btf/vmlinux")
+		require.NoError(t, err)
+		path := filepath.Join(t.TempDir(), "vmlinux.btf")
+		err = os.WriteFile(path, btf, 0o600)
+		require.NoError(t, err)
+
+		tracer, err := exectrace.New(&exectrace.TracerOpts{
+			Backend:       exectrace.BackendEBPF,
+			KernelBTFPath: path,
+			LogFn: func(uid, gid, pid uint32, logLine string) {
+				t.Errorf("tracer error log (uid=%v, gid=%v, pid=%v): %s", uid, gid, pid, logLine)
+			},
+		})
+		require.NoError(t, err)
+		defer tracer.Close()
+
+		const expected = "hello exectrace external btf test"
+		args := []string{"sh", "-c", "# " + expected}
+		processDone := spamProcess(ctx, t, args, nil)
+		_ = getLogEntry(ctx, t, tracer, expected)
+
+		cancel()
+		<-processDone
+	})
+
+	//nolint:paralleltest
+	t.Run("Invalid", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "invalid.btf")
+		err := os.WriteFile(path, []byte("not btf"), 0o600)
+		require.NoError(t, err)
+
+		_, err = exectrace.New(&exectrace.TracerOpts{
+			Backend:       exectrace.BackendEBPF,
+			KernelBTFPath: path,
+		})
+		require.Error(t, err)
+		require.ErrorIs(t, err, exectrace.ErrBTFUnavailable)
+		require.Contains(t, err.Error(), path)
+	})
+}
+
+//nolint:paralleltest
+func TestExectracePinPath(t *testing.T) {
+	// This test must be run as root so we can start exectrace.
+	if os.Geteuid() != 0 {
+		t.Fatal("must be run as root")
+	}
+	var statfs unix.Statfs_t
+	err := unix.Statfs("/sys/fs/bpf", &statfs)
+	if err != nil || statfs.Type != unix.BPF_FS_MAGIC {
+		t.Skip("/sys/fs/bpf is not a BPF filesystem")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	pinPath := filepath.Join(exectrace.DefaultPinPath, fmt.Sprintf("test-%d", os.Getpid()))
+	defer func() {
+		err := exectrace.Unpin(pinPath)
+		require.NoError(t, err)
+		_, err = os.Stat(pinPath)
+		require.ErrorIs(t, err, os.ErrNotExist)
+	}()
+	opts := &exectrace.TracerOpts{
+		Backend: exectrace.BackendEBPF,
+		PinPath: pinPath,
+		LogFn: func(uid, gid, pid uint32, logLine string) {
+			t.Errorf("tracer error log (uid=%v, gid=%v, pid=%v): %s", uid, gid, pid, logLine)
+		},
+	}
+
+	tracer, err := exectrace.New(opts)
+	require.NoError(t, err)
+	for _, name := range []string{"enter_execve", "events", "logs", "filters"} {
+		_, err = os.Stat(filepath.Join(pinPath, name))
+		require.NoError(t, err, "pinned %q", name)
+	}
+
+	// Exec a process but close the tracer without reading the event.
+	const expected = "hello exectrace pin path test"
+	//nolint:gosec
+	err = exec.CommandContext(ctx, "sh", "-c", "# "+expected).Run()
+	require.NoError(t, err)
+	err = tracer.Close()
+	require.NoError(t, err)
+
+	// A new tracer should read the event from the pinned ringbuf.
+	tracer, err = exectrace.New(opts)
+	require.NoError(t, err)
+	defer tracer.Close()
+	_ = getLogEntry(ctx, t, tracer, expected)
+}
+
+//nolint:paralleltest
+func TestExectraceReload(t *testing.T) {
+	// This test must be run as root so we can start exectrace.
+	if os.Geteuid() != 0 {
+		t.Fatal("must be run as root")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	logFn := func(uid, gid, pid uint32, logLine string) {
+		t.Errorf("tracer error log (uid=%v, gid=%v, pid=%v): %s", uid, gid, pid, logLine)
+	}
+	tracer, err := exectrace.New(&exectrace.TracerOpts{
+		Backend: exectrace.BackendEBPF,
+		LogFn:   logFn,
+	})
+	require.NoError(t, err)
+	defer tracer.Close()
+
+	run := func(comment string) {
+		//nolint:gosec
+		err := exec.CommandContext(ctx, "sh", "-c", "# "+comment).Run()
+		require.NoError(t, err)
+	}
+
+	// Events that weren't read before reloading are kept, and events after
+	// reloading are read exactly once.
+	run("exectrace before reload")
+	oldFD := tracer.FD()
+	err = tracer.Reload(&exectrace.TracerOpts{
+		Backend: exectrace.BackendEBPF,
+		Filter:  exectrace.MustParseFilter(`argv contains "-c"`),
+		LogFn:   logFn,
+	})
+	require.NoError(t, err)
+	require.NotEqual(t, oldFD, tracer.FD(), "program should be replaced")
+	run("exectrace after reload")
+	run("exectrace reload sentinel")
+
+	seen := map[string]int{}
+	for {
+		event := getLogEntry(ctx, t, tracer, "exectrace")
+		joined := strings.Join(event.Argv, " ")
+		seen[joined]++
+		if strings.Contains(joined, "sentinel") {
+			break
+		}
+	}
+	require.Equal(t, 1, seen["sh -c # exectrace before reload"])
+	require.Equal(t, 1, seen["sh -c # exectrace after reload"])
+
+	// The backend can't be changed.
+	err = tracer.Reload(&exectrace.TracerOpts{
+		Backend: exectrace.BackendProcConnector,
+	})
+	require.Error(t, err)
+}
+
+//nolint:paralleltest
+func TestExectracePermission(t *testing.T) {
+	if os.Getenv("EXECTRACE_PERMISSION_HELPER") == "1" {
+		_, err := exectrace.New(&exectrace.TracerOpts{
+			Backend: exectrace.BackendEBPF,
+		})
+		if !xerrors.Is(err, exectrace.ErrPermission) {
+			t.Fatalf("expected ErrPermission, got: %+v", err)
+		}
+		return
+	}
+
+	// This test must be run as root so it can drop privileges.
+	if os.Geteuid() != 0 {
+		t.Fatal("must be run as root")
+	}
+
+	// Capabilities are per-thread, so rerun this test as nobody in a
+	// subprocess instead of dropping them in this process. The test binary is
+	// copied somewhere nobody can execute it.
+	dir, err := os.MkdirTemp("", "exectrace-permission")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	err = os.Chmod(dir, 0o755)
+	require.NoError(t, err)
+	bin, err := os.ReadFile(os.Args[0])
+	require.NoError(t, err)
+	binPath := filepath.Join(dir, "exectrace.test")
+	//nolint:gosec
+	err = os.WriteFile(binPath, bin, 0o755)
+	require.NoError(t, err)
+
+	//nolint:gosec
+	cmd := exec.Command(binPath, "-test.run=^TestExectracePermission$", "-test.count=1", "-test.v")
+	cmd.Env = append(os.Environ(), "EXECTRACE_PERMISSION_HELPER=1")
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Credential: &syscall.Credential{Uid: 65534, Gid: 65534},
+	}
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+	require.Contains(t, string(out), "--- PASS: TestExectracePermission")
+}
+
 //nolint:paralleltest
 func TestExectraceTruncatedArgs(t *testing.T) {
 	// This test must be run as root so we can start exectrace.
@@ -330,3 +605,169 @@ func getLogEntry(ctx context.Context, t *testing.T, tracer exectrace.Tracer, exp
 		return event
 	}
 }
+
+//nolint:paralleltest
+func TestExectracePID(t *testing.T) {
+	// This test must be run as root so we can start exectrace.
+	if os.Geteuid() != 0 {
+		t.Fatal("must be run as root")
+	}
+
+	for _, backend := range []exectrace.Backend{exectrace.BackendEBPF, exectrace.BackendProcConnector} {
+		backend := backend
+		//nolint:paralleltest
+		t.Run(string(backend), func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+			defer cancel()
+
+			// The tracked process is already running when the tracer starts
+			// and runs its descendants when its stdin is closed. Process
+			// details are read from /proc by the proc connector backend, so
+			// the processes sleep so they can be read.
+			const (
+				tracked   = "hello exectrace pid test tracked"
+				untracked = "hello exectrace pid test untracked"
+			)
+			//nolint:gosec
+			parent := exec.CommandContext(ctx, "sh", "-c", `read x; sh -c "sleep 0.5; true # `+tracked+`"`)
+			stdin, err := parent.StdinPipe()
+			require.NoError(t, err)
+			require.NoError(t, parent.Start())
+			defer func() {
+				_ = parent.Process.Kill()
+				_ = parent.Wait()
+			}()
+
+			tracer, err := exectrace.New(&exectrace.TracerOpts{
+				Backend: backend,
+				PID:     uint32(parent.Process.Pid),
+				LogFn: func(uid, gid, pid uint32, logLine string) {
+					t.Errorf("tracer error log (uid=%v, gid=%v, pid=%v): %s", uid, gid, pid, logLine)
+				},
+			})
+			require.NoError(t, err)
+			defer tracer.Close()
+
+			processDone := spamProcess(ctx, t, []string{"sh", "-c", "sleep 0.5; true # " + untracked}, nil)
+			require.NoError(t, stdin.Close())
+
+			for {
+				event, err := tracer.Read()
+				require.NoError(t, err)
+				cmdline := strings.Join(event.Argv, " ")
+				require.NotContains(t, cmdline, untracked)
+				if strings.Contains(cmdline, tracked) {
+					break
+				}
+			}
+
+			cancel()
+			<-processDone
+		})
+	}
+
+	t.Run("NotExist", func(t *testing.T) {
+		_, err := exectrace.New(&exectrace.TracerOpts{
+			Backend: exectrace.BackendEBPF,
+			PID:     1 << 30,
+		})
+		require.ErrorContains(t, err, "does not exist")
+	})
+}
+
+func TestExectraceFailedExecs(t *testing.T) {
+	t.Parallel()
+
+	// This test must be run as root so we can start exectrace.
+	if os.Geteuid() != 0 {
+		t.Fatal("must be run as root")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	// Only the execs of this shell and its children are counted, which
+	// fail as the files don't exist.
+	parent := exec.CommandContext(ctx, "sh", "-c", "read x; /nonexistent/exectrace-a; /nonexistent/exectrace-b; true")
+	stdin, err := parent.StdinPipe()
+	require.NoError(t, err)
+	require.NoError(t, parent.Start())
+
+	tracer, err := exectrace.New(&exectrace.TracerOpts{
+		Backend: exectrace.BackendEBPF,
+		PID:     uint32(parent.Process.Pid),
+		LogFn: func(uid, gid, pid uint32, logLine string) {
+			t.Errorf("tracer error log (uid=%v, gid=%v, pid=%v): %s", uid, gid, pid, logLine)
+		},
+	})
+	require.NoError(t, err)
+	defer tracer.Close()
+
+	counter, ok := tracer.(exectrace.ExecFailureCounter)
+	require.True(t, ok)
+	failed, err := counter.FailedExecs()
+	require.NoError(t, err)
+	require.Zero(t, failed)
+
+	require.NoError(t, stdin.Close())
+	require.NoError(t, parent.Wait())
+
+	failed, err = counter.FailedExecs()
+	require.NoError(t, err)
+	require.EqualValues(t, 2, failed)
+}
+
+func TestExectraceLogHandler(t *testing.T) {
+	t.Parallel()
+
+	// This test must be run as root so we can start exectrace.
+	if os.Geteuid() != 0 {
+		t.Fatal("must be run as root")
+	}
+
+	entries := make(chan exectrace.LogEntry, 16)
+	tracer, err := exectrace.New(&exectrace.TracerOpts{
+		Backend: exectrace.BackendEBPF,
+		PID:     uint32(os.Getpid()),
+		LogHandler: func(entry exectrace.LogEntry) {
+			entries <- entry
+		},
+	})
+	require.NoError(t, err)
+	defer tracer.Close()
+
+	// argv[1] is an invalid pointer, so the eBPF program can't read it and
+	// logs a warning. The exec fails as the file doesn't exist, so it doesn't
+	// replace the test process.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	filename, err := unix.BytePtrFromString("/nonexistent/exectrace-log")
+	require.NoError(t, err)
+	arg0, err := unix.BytePtrFromString("exectrace-log")
+	require.NoError(t, err)
+	argv := []uintptr{uintptr(unsafe.Pointer(arg0)), 1, 0}
+	start := time.Now()
+	_, _, errno := unix.RawSyscall(unix.SYS_EXECVE, uintptr(unsafe.Pointer(filename)), uintptr(unsafe.Pointer(&argv[0])), 0)
+	require.NotZero(t, errno)
+	runtime.KeepAlive(filename)
+	runtime.KeepAlive(arg0)
+
+	select {
+	case entry := <-entries:
+		require.Equal(t, exectrace.LogLevelWarn, entry.Level)
+		require.Equal(t, exectrace.LogCodeReadArgv, entry.Code)
+		require.Equal(t, "read_argv", entry.Code.String())
+		require.Equal(t, "enter_execve", entry.Program)
+		require.Equal(t, []exectrace.LogArg{
+			{Name: "index", Value: uint32(1)},
+			{Name: "ret", Value: -int32(unix.EFAULT)},
+		}, entry.Args)
+		require.Equal(t, "read argv 1: -14", entry.Message())
+		require.EqualValues(t, unix.Gettid(), entry.PID)
+		require.EqualValues(t, os.Getuid(), entry.UID)
+		require.NotZero(t, entry.KernelTime)
+		require.WithinDuration(t, start, entry.Time, time.Second)
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for log entry")
+	}
+}
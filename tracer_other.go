@@ -13,3 +13,13 @@ import (
 func New(_ *TracerOpts) (Tracer, error) {
 	return nil, xerrors.Errorf(`%q is an unsupported OS, only "linux" is supported`, runtime.GOOS)
 }
+
+// Unpin is not supported on OSes other than Linux.
+func Unpin(_ string) error {
+	return xerrors.Errorf(`%q is an unsupported OS, only "linux" is supported`, runtime.GOOS)
+}
+
+// PidInNS is not supported on OSes other than Linux.
+func PidInNS(_, _ uint32) (bool, error) {
+	return false, xerrors.Errorf(`%q is an unsupported OS, only "linux" is supported`, runtime.GOOS)
+}